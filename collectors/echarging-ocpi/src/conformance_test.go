@@ -0,0 +1,317 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noi-techpark/go-opendatahub-ingest/dto"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTokenA = "token-a"
+	testTokenC = "token-c"
+)
+
+// fakeCPO implements the versions, details and credentials endpoints of a CPO
+type fakeCPO struct {
+	*httptest.Server
+	// tokens the CPO accepts, initially token A for registering with it
+	tokens []string
+	// credentials we sent when registering
+	received Credentials
+}
+
+func newFakeCPO(t *testing.T) *fakeCPO {
+	cpo := &fakeCPO{tokens: []string{testTokenA, testTokenC}}
+	mux := http.NewServeMux()
+	respond := func(w http.ResponseWriter, data any) {
+		json.NewEncoder(w).Encode(map[string]any{"data": data, "status_code": 1000, "timestamp": "2024-05-01T10:00:00"})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		authorized := false
+		for _, tok := range cpo.tokens {
+			authorized = authorized || r.Header.Get("Authorization") == authHeader(tok)
+		}
+		if !authorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/ocpi/versions":
+			respond(w, []VersionEntry{{Version: "2.1.1", URL: cpo.URL + "/ocpi/2.1.1"}, {Version: "2.2.1", URL: cpo.URL + "/ocpi/2.2.1"}})
+		case "/ocpi/2.2.1":
+			respond(w, VersionDetails{Version: "2.2.1", Endpoints: []Endpoint{
+				{Identifier: "credentials", Role: "RECEIVER", URL: cpo.URL + "/ocpi/2.2.1/credentials"},
+				{Identifier: "locations", Role: "SENDER", URL: cpo.URL + "/ocpi/2.2.1/locations"},
+			}})
+		case "/ocpi/2.2.1/credentials":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&cpo.received))
+			// token A is only valid for the registration
+			cpo.tokens = []string{testTokenC}
+			respond(w, Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions", Roles: []CredentialsRole{{Role: "CPO", CountryCode: "IT", PartyID: "CPO"}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	cpo.Server = httptest.NewServer(mux)
+	t.Cleanup(cpo.Close)
+	return cpo
+}
+
+type published struct {
+	mu   sync.Mutex
+	msgs []dto.RawAny
+}
+
+func (p *published) publish(raw dto.RawAny) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, raw)
+	return nil
+}
+
+func setupEmsp(t *testing.T) (*httptest.Server, *published) {
+	gin.SetMode(gin.TestMode)
+	cfg.PROVIDER = "test"
+	var err error
+	store, err = newCredentialStore(filepath.Join(t.TempDir(), "state.json"), []string{"static"}, []string{testTokenA})
+	require.NoError(t, err)
+	pub := &published{}
+	srv := httptest.NewServer(newRouter(pub.publish))
+	t.Cleanup(srv.Close)
+	cfg.OCPI_PUBLIC_URL = srv.URL
+	t.Cleanup(func() { cfg.OCPI_PUBLIC_URL = "" })
+	return srv, pub
+}
+
+type testResp struct {
+	HTTPStatus    int
+	Header        http.Header
+	Data          json.RawMessage `json:"data"`
+	StatusCode    int             `json:"status_code"`
+	StatusMessage string          `json:"status_message"`
+}
+
+func call(t *testing.T, method, url, token string, body any) testResp {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", authHeader(token))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	ret := testResp{HTTPStatus: resp.StatusCode, Header: resp.Header}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ret))
+	return ret
+}
+
+func TestVersions(t *testing.T) {
+	srv, _ := setupEmsp(t)
+
+	r := call(t, http.MethodGet, srv.URL+"/ocpi/emsp/versions", "", nil)
+	require.Equal(t, 1000, r.StatusCode)
+	var versions []VersionEntry
+	require.NoError(t, json.Unmarshal(r.Data, &versions))
+	require.Equal(t, []VersionEntry{
+		{Version: "2.2.1", URL: srv.URL + "/ocpi/emsp/2.2.1"},
+		{Version: "2.2", URL: srv.URL + "/ocpi/emsp/2.2"},
+	}, versions)
+
+	r = call(t, http.MethodGet, srv.URL+"/ocpi/emsp/2.2.1", "", nil)
+	var details VersionDetails
+	require.NoError(t, json.Unmarshal(r.Data, &details))
+	require.Equal(t, "2.2.1", details.Version)
+	require.Contains(t, details.Endpoints, Endpoint{Identifier: "credentials", Role: "SENDER", URL: srv.URL + "/ocpi/emsp/2.2.1/credentials"})
+	require.Contains(t, details.Endpoints, Endpoint{Identifier: "cdrs", Role: "RECEIVER", URL: srv.URL + "/ocpi/emsp/2.2.1/cdrs"})
+	require.Equal(t, "req-1", r.Header.Get("X-Request-ID"))
+}
+
+func TestCredentialsHandshakeFromCPO(t *testing.T) {
+	srv, pub := setupEmsp(t)
+	cpo := newFakeCPO(t)
+	credURL := srv.URL + "/ocpi/emsp/2.2.1/credentials"
+
+	// unknown tokens are rejected
+	r := call(t, http.MethodPost, credURL, "wrong", Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions"})
+	require.Equal(t, http.StatusUnauthorized, r.HTTPStatus)
+	require.Equal(t, 2000, r.StatusCode)
+
+	r = call(t, http.MethodPost, credURL, testTokenA, Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions"})
+	require.Equal(t, http.StatusOK, r.HTTPStatus)
+	require.Equal(t, 1000, r.StatusCode)
+	var cred Credentials
+	require.NoError(t, json.Unmarshal(r.Data, &cred))
+	require.NotEmpty(t, cred.Token)
+	require.NotEqual(t, testTokenA, cred.Token)
+	require.Equal(t, srv.URL+"/ocpi/emsp/versions", cred.URL)
+	require.Equal(t, "EMSP", cred.Roles[0].Role)
+
+	reg := store.byVersionsURL(cpo.URL + "/ocpi/versions")
+	require.NotNil(t, reg)
+	require.Equal(t, "2.2.1", reg.Version)
+	require.Equal(t, cpo.URL+"/ocpi/2.2.1/locations", reg.endpoint("locations", "SENDER"))
+
+	// token A is only valid once
+	r = call(t, http.MethodPost, credURL, testTokenA, Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions"})
+	require.Equal(t, http.StatusUnauthorized, r.HTTPStatus)
+	// registered parties have to use PUT
+	r = call(t, http.MethodPost, credURL, cred.Token, Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions"})
+	require.Equal(t, http.StatusMethodNotAllowed, r.HTTPStatus)
+
+	r = call(t, http.MethodGet, credURL, cred.Token, nil)
+	require.Equal(t, 1000, r.StatusCode)
+
+	// the registration survives a restart
+	restarted, err := newCredentialStore(store.path, nil, []string{testTokenA})
+	require.NoError(t, err)
+	_, ok := restarted.authorize(base64.StdEncoding.EncodeToString([]byte(cred.Token)))
+	require.True(t, ok)
+	_, ok = restarted.registrationToken(base64.StdEncoding.EncodeToString([]byte(testTokenA)))
+	require.False(t, ok)
+
+	// updating the credentials rotates token B
+	r = call(t, http.MethodPut, credURL, cred.Token, Credentials{Token: testTokenC, URL: cpo.URL + "/ocpi/versions"})
+	require.Equal(t, 1000, r.StatusCode)
+	var updated Credentials
+	require.NoError(t, json.Unmarshal(r.Data, &updated))
+	require.NotEqual(t, cred.Token, updated.Token)
+	r = call(t, http.MethodGet, credURL, cred.Token, nil)
+	require.Equal(t, http.StatusUnauthorized, r.HTTPStatus)
+
+	// the new token B is accepted by the modules
+	evse := map[string]any{"uid": "E1", "status": "AVAILABLE", "last_updated": "2024-05-01T10:00:00Z"}
+	r = call(t, http.MethodPut, srv.URL+"/ocpi/emsp/2.2.1/locations/IT/CPO/L1/E1", updated.Token, evse)
+	require.Equal(t, 1000, r.StatusCode)
+	require.Len(t, pub.msgs, 1)
+	require.Equal(t, "test-push-evse", pub.msgs[0].Provider)
+
+	r = call(t, http.MethodDelete, credURL, updated.Token, nil)
+	require.Equal(t, 1000, r.StatusCode)
+	r = call(t, http.MethodGet, credURL, updated.Token, nil)
+	require.Equal(t, http.StatusUnauthorized, r.HTTPStatus)
+}
+
+func TestCredentialsHandshakeToCPO(t *testing.T) {
+	srv, _ := setupEmsp(t)
+	cpo := newFakeCPO(t)
+
+	reg, err := registerWithCPO(cpo.URL+"/ocpi/versions", testTokenA, srv.URL+"/ocpi/emsp/versions")
+	require.NoError(t, err)
+	require.Equal(t, testTokenC, reg.TokenC)
+	require.Equal(t, "2.2.1", reg.Version)
+	require.Equal(t, srv.URL+"/ocpi/emsp/versions", cpo.received.URL)
+	require.Equal(t, reg.TokenB, cpo.received.Token)
+
+	// the CPO can call us with the token B it received
+	r := call(t, http.MethodGet, srv.URL+"/ocpi/emsp/2.2.1/credentials", cpo.received.Token, nil)
+	require.Equal(t, 1000, r.StatusCode)
+
+	// the pull job falls back to the registration
+	endpoint, token := pullSource()
+	require.Equal(t, cpo.URL+"/ocpi/2.2.1/locations", endpoint)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte(testTokenC)), token)
+}
+
+func TestReceivers(t *testing.T) {
+	srv, pub := setupEmsp(t)
+	base := srv.URL + "/ocpi/emsp/2.2.1"
+
+	location := map[string]any{
+		"country_code": "IT", "party_id": "CPO", "id": "L1", "publish": true, "address": "Via Volta 13",
+		"city": "Bolzano", "country": "ITA", "coordinates": map[string]any{"latitude": "46.47", "longitude": "11.33"},
+		"time_zone": "Europe/Rome", "last_updated": "2024-05-01T10:00:00Z",
+	}
+	session := map[string]any{
+		"country_code": "IT", "party_id": "CPO", "id": "S1", "start_date_time": "2024-05-01T10:00:00Z", "kwh": 0,
+		"cdr_token": map[string]any{"uid": "T1"}, "auth_method": "WHITELIST", "location_id": "L1", "evse_uid": "E1",
+		"connector_id": "1", "currency": "EUR", "status": "ACTIVE", "last_updated": "2024-05-01T10:00:00Z",
+	}
+	cdr := map[string]any{
+		"country_code": "IT", "party_id": "CPO", "id": "C1", "start_date_time": "2024-05-01T10:00:00Z",
+		"end_date_time": "2024-05-01T11:00:00Z", "cdr_token": map[string]any{"uid": "T1"}, "auth_method": "WHITELIST",
+		"cdr_location": map[string]any{"id": "L1"}, "currency": "EUR", "charging_periods": []any{},
+		"total_cost": map[string]any{"excl_vat": 10}, "total_energy": 20, "total_time": 1, "last_updated": "2024-05-01T11:00:00Z",
+	}
+	tariff := map[string]any{
+		"country_code": "IT", "party_id": "CPO", "id": "T1", "currency": "EUR", "elements": []any{}, "last_updated": "2024-05-01T10:00:00Z",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		status     int
+		statusCode int
+		provider   string
+	}{
+		{"location", http.MethodPut, "/locations/IT/CPO/L1", location, 200, 1000, "test-push-locations"},
+		{"location patch", http.MethodPatch, "/locations/IT/CPO/L1", map[string]any{"publish": false, "last_updated": "2024-05-01T10:00:00Z"}, 200, 1000, "test-push-locations"},
+		{"evse patch", http.MethodPatch, "/locations/IT/CPO/L1/E1", map[string]any{"status": "CHARGING", "last_updated": "2024-05-01T10:00:00Z"}, 200, 1000, "test-push-evse"},
+		{"connector patch", http.MethodPatch, "/locations/IT/CPO/L1/E1/1", map[string]any{"max_voltage": 400, "last_updated": "2024-05-01T10:00:00Z"}, 200, 1000, "test-push-connectors"},
+		{"session", http.MethodPut, "/sessions/IT/CPO/S1", session, 200, 1000, "test-push-sessions"},
+		{"session patch", http.MethodPatch, "/sessions/IT/CPO/S1", map[string]any{"kwh": 3.5, "last_updated": "2024-05-01T10:30:00Z"}, 200, 1000, "test-push-sessions"},
+		{"cdr", http.MethodPost, "/cdrs", cdr, 200, 1000, "test-push-cdrs"},
+		{"tariff", http.MethodPut, "/tariffs/IT/CPO/T1", tariff, 200, 1000, "test-push-tariffs"},
+		{"tariff delete", http.MethodDelete, "/tariffs/IT/CPO/T1", nil, 200, 1000, "test-push-tariffs"},
+
+		{"location incomplete", http.MethodPut, "/locations/IT/CPO/L1", map[string]any{"id": "L1", "last_updated": "2024-05-01T10:00:00Z"}, 400, 2001, ""},
+		{"location id mismatch", http.MethodPut, "/locations/IT/CPO/L2", location, 400, 2001, ""},
+		{"party mismatch", http.MethodPatch, "/sessions/IT/XXX/S1", map[string]any{"party_id": "CPO", "last_updated": "2024-05-01T10:30:00Z"}, 400, 2001, ""},
+		{"patch without last_updated", http.MethodPatch, "/locations/IT/CPO/L1/E1", map[string]any{"status": "CHARGING"}, 400, 2001, ""},
+		{"not json", http.MethodPut, "/tariffs/IT/CPO/T1", "tariff", 400, 2001, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub.msgs = nil
+			r := call(t, tt.method, base+tt.path, "static", tt.body)
+			require.Equal(t, tt.status, r.HTTPStatus, r.StatusMessage)
+			require.Equal(t, tt.statusCode, r.StatusCode, r.StatusMessage)
+			if tt.provider == "" {
+				require.Empty(t, pub.msgs)
+				return
+			}
+			require.Len(t, pub.msgs, 1)
+			require.Equal(t, tt.provider, pub.msgs[0].Provider)
+			raw := pub.msgs[0].Rawdata.(PushRaw)
+			require.Equal(t, tt.method, raw.Method)
+		})
+	}
+
+	// the evse push keeps the format the transformer expects
+	pub.msgs = nil
+	call(t, http.MethodPatch, base+"/locations/IT/CPO/L1/E1", "static", map[string]any{"status": "CHARGING", "last_updated": "2024-05-01T10:00:00Z"})
+	b, err := json.Marshal(pub.msgs[0].Rawdata)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"object":"evse","method":"PATCH",
+		"params":{"country_code":"IT","party_id":"CPO","location_id":"L1","evse_uid":"E1"},
+		"body":{"status":"CHARGING","last_updated":"2024-05-01T10:00:00Z"}}`, string(b))
+
+	pub.msgs = nil
+	r := call(t, http.MethodPost, base+"/cdrs", "static", cdr)
+	require.Empty(t, r.Header.Get("Location"), "CDRs are not kept, there is nothing to fetch")
+
+	r = call(t, http.MethodPut, base+"/locations/IT/CPO/L1", "", location)
+	require.Equal(t, http.StatusUnauthorized, r.HTTPStatus)
+}
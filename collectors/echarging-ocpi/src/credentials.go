@@ -0,0 +1,300 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// supportedVersions in order of preference
+var supportedVersions = []string{"2.2.1", "2.2"}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// authHeader is the Authorization header value for a token, base64 encoded as required by OCPI 2.2
+func authHeader(token string) string {
+	return "Token " + base64.StdEncoding.EncodeToString([]byte(token))
+}
+
+// ocpiRequest calls an endpoint of the CPO and returns the data of the OCPI response
+func ocpiRequest[T any](method, url, token string, body any) (T, error) {
+	var ret T
+	var reqBody io.Reader = http.NoBody
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return ret, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return ret, err
+	}
+	req.Header.Set("Authorization", authHeader(token))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return ret, fmt.Errorf("%s %s failed: %w", method, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ret, fmt.Errorf("%s %s returned status %d", method, url, resp.StatusCode)
+	}
+	oResp := OCPIResp[T]{}
+	if err := json.NewDecoder(resp.Body).Decode(&oResp); err != nil {
+		return ret, fmt.Errorf("%s %s returned an invalid response: %w", method, url, err)
+	}
+	if oResp.StatusCode != 1000 {
+		msg := ""
+		if oResp.StatusMessage != nil {
+			msg = *oResp.StatusMessage
+		}
+		return ret, fmt.Errorf("%s %s returned OCPI status %d: %s", method, url, oResp.StatusCode, msg)
+	}
+	return oResp.Data, nil
+}
+
+// fetchEndpoints negotiates the version with the CPO and returns its endpoints
+func fetchEndpoints(versionsURL, token string) (string, []Endpoint, error) {
+	versions, err := ocpiRequest[[]VersionEntry](http.MethodGet, versionsURL, token, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, v := range supportedVersions {
+		i := slices.IndexFunc(versions, func(e VersionEntry) bool { return e.Version == v })
+		if i < 0 {
+			continue
+		}
+		details, err := ocpiRequest[VersionDetails](http.MethodGet, versions[i].URL, token, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		return v, details.Endpoints, nil
+	}
+	return "", nil, fmt.Errorf("no mutual OCPI version, the CPO supports %v", versions)
+}
+
+func ourRole() CredentialsRole {
+	return CredentialsRole{
+		Role:            "EMSP",
+		CountryCode:     cfg.OCPI_COUNTRY_CODE,
+		PartyID:         cfg.OCPI_PARTY_ID,
+		BusinessDetails: BusinessDetails{Name: cfg.OCPI_BUSINESS_NAME},
+	}
+}
+
+func ourCredentials(c *gin.Context, token string) Credentials {
+	return Credentials{
+		Token: token,
+		URL:   baseURL(c) + "/ocpi/emsp/versions",
+		Roles: []CredentialsRole{ourRole()},
+	}
+}
+
+// credentialsAuth accepts the registration tokens A in addition to the tokens accepted by tokenAuth
+func credentialsAuth(c *gin.Context) {
+	token, ok := headerToken(c)
+	if !ok {
+		ocpiUnauthorized(c)
+		return
+	}
+	if tokenA, ok := store.registrationToken(token); ok {
+		c.Set(ctxTokenA, tokenA)
+		c.Next()
+		return
+	}
+	if reg, ok := store.authorize(token); ok {
+		c.Set(ctxRegistration, reg)
+		c.Set(ctxToken, token)
+		c.Next()
+		return
+	}
+	ocpiUnauthorized(c)
+}
+
+func registration(c *gin.Context) *Registration {
+	reg, _ := c.Get(ctxRegistration)
+	r, _ := reg.(*Registration)
+	return r
+}
+
+// getCredentials returns our credentials to a registered CPO
+func getCredentials(c *gin.Context) {
+	if _, ok := c.Get(ctxTokenA); ok {
+		ocpiError(c, http.StatusMethodNotAllowed, 2000, "not registered yet")
+		return
+	}
+	token := c.GetString(ctxToken)
+	if reg := registration(c); reg != nil {
+		token = reg.TokenB
+	} else if decoded, err := base64.StdEncoding.DecodeString(token); err == nil {
+		token = string(decoded)
+	}
+	ocpiData(c, ourCredentials(c, token))
+}
+
+func bindCredentials(c *gin.Context) (Credentials, bool) {
+	var cred Credentials
+	if err := c.ShouldBindJSON(&cred); err != nil || cred.Token == "" || cred.URL == "" {
+		ocpiError(c, http.StatusBadRequest, 2001, "credentials require token and url")
+		return cred, false
+	}
+	return cred, true
+}
+
+// postCredentials registers a CPO that authenticated with a token A
+func postCredentials(c *gin.Context) {
+	tokenA, ok := c.Get(ctxTokenA)
+	if !ok {
+		// as per spec, registered parties have to use PUT
+		ocpiError(c, http.StatusMethodNotAllowed, 2000, "already registered, use PUT to update the credentials")
+		return
+	}
+	cred, ok := bindCredentials(c)
+	if !ok {
+		return
+	}
+
+	version, endpoints, err := fetchEndpoints(cred.URL, cred.Token)
+	if err != nil {
+		slog.Error("credentials handshake failed", "url", cred.URL, "err", err)
+		ocpiError(c, http.StatusBadGateway, 3001, "unable to use the client's API: "+err.Error())
+		return
+	}
+
+	reg := &Registration{
+		TokenB:      newToken(),
+		TokenC:      cred.Token,
+		VersionsURL: cred.URL,
+		Version:     version,
+		Endpoints:   endpoints,
+		Roles:       cred.Roles,
+	}
+	if err := store.register(reg, tokenA.(string)); err != nil {
+		slog.Error("failed storing registration", "err", err)
+		ocpiError(c, http.StatusInternalServerError, 3000, "unable to store the registration")
+		return
+	}
+	slog.Info("CPO registered", "url", reg.VersionsURL, "version", version, "roles", reg.Roles)
+	ocpiData(c, ourCredentials(c, reg.TokenB))
+}
+
+// putCredentials updates the credentials of a registered CPO, issuing a new token B
+func putCredentials(c *gin.Context) {
+	old := registration(c)
+	if old == nil {
+		ocpiError(c, http.StatusMethodNotAllowed, 2000, "not registered, use POST to register")
+		return
+	}
+	cred, ok := bindCredentials(c)
+	if !ok {
+		return
+	}
+
+	version, endpoints, err := fetchEndpoints(cred.URL, cred.Token)
+	if err != nil {
+		slog.Error("credentials update failed", "url", cred.URL, "err", err)
+		ocpiError(c, http.StatusBadGateway, 3001, "unable to use the client's API: "+err.Error())
+		return
+	}
+	reg := &Registration{
+		TokenB:      newToken(),
+		TokenC:      cred.Token,
+		VersionsURL: cred.URL,
+		Version:     version,
+		Endpoints:   endpoints,
+		Roles:       cred.Roles,
+	}
+	if err := store.update(old, reg); err != nil {
+		slog.Error("failed updating registration", "err", err)
+		ocpiError(c, http.StatusInternalServerError, 3000, "unable to store the registration")
+		return
+	}
+	slog.Info("CPO credentials updated", "url", reg.VersionsURL, "version", version)
+	ocpiData(c, ourCredentials(c, reg.TokenB))
+}
+
+// deleteCredentials unregisters a CPO, its token B is no longer accepted
+func deleteCredentials(c *gin.Context) {
+	reg := registration(c)
+	if reg == nil {
+		ocpiError(c, http.StatusMethodNotAllowed, 2000, "not registered")
+		return
+	}
+	if err := store.unregister(reg); err != nil {
+		slog.Error("failed removing registration", "err", err)
+		ocpiError(c, http.StatusInternalServerError, 3000, "unable to remove the registration")
+		return
+	}
+	slog.Info("CPO unregistered", "url", reg.VersionsURL)
+	ocpiData[any](c, nil)
+}
+
+// registerWithCPO performs the credentials handshake with a CPO that gave us a token A and its versions URL
+func registerWithCPO(versionsURL, tokenA, ourVersionsURL string) (*Registration, error) {
+	_, endpoints, err := fetchEndpoints(versionsURL, tokenA)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(endpoints, func(e Endpoint) bool { return e.Identifier == "credentials" })
+	if i < 0 {
+		return nil, fmt.Errorf("the CPO has no credentials endpoint")
+	}
+
+	// the CPO may call us with token B during the handshake, so it has to be valid already
+	pending := &Registration{TokenB: newToken(), VersionsURL: versionsURL}
+	if err := store.register(pending, ""); err != nil {
+		return nil, err
+	}
+
+	cred, err := ocpiRequest[Credentials](http.MethodPost, endpoints[i].URL, tokenA, Credentials{
+		Token: pending.TokenB,
+		URL:   ourVersionsURL,
+		Roles: []CredentialsRole{ourRole()},
+	})
+	if err == nil && cred.Token == "" {
+		err = fmt.Errorf("the CPO returned no token")
+	}
+	if err != nil {
+		store.unregister(pending)
+		return nil, err
+	}
+
+	// from now on only token C is valid to call the CPO
+	if cred.URL == "" {
+		cred.URL = versionsURL
+	}
+	version, endpoints, err := fetchEndpoints(cred.URL, cred.Token)
+	if err != nil {
+		store.unregister(pending)
+		return nil, err
+	}
+	reg := &Registration{
+		TokenB:      pending.TokenB,
+		TokenC:      cred.Token,
+		VersionsURL: versionsURL,
+		Version:     version,
+		Endpoints:   endpoints,
+		Roles:       cred.Roles,
+	}
+	if err := store.update(pending, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
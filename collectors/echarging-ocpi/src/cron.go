@@ -18,13 +18,13 @@ import (
 	"github.com/noi-techpark/go-opendatahub-ingest/mq"
 )
 
-func getAllLocations(rabbit mq.R, provider string) error {
+func getAllLocations(rabbit mq.R, provider string, endpoint string, token string) error {
 	slog.Info("Pulling all locations")
-	url := cfg.PULL_LOCATIONS_ENDPOINT
+	url := endpoint
 	for url != "" {
 		slog.Info("Requesting locations page at url", "url", url)
 		// Our mongodb cannot handle huge files, hence we push piecewise
-		locations, next, err := getPage(url, cfg.PULL_TOKEN)
+		locations, next, err := getPage(url, token)
 		if err != nil {
 			slog.Error("error getting locations")
 			return err
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -25,3 +26,52 @@ func (t OCPIDateTime) MarshalJSON() ([]byte, error) {
 	f := fmt.Sprintf("\"%s\"", t.Time.UTC().Format("2006-01-02T15:04:05Z"))
 	return []byte(f), nil
 }
+
+func (t *OCPIDateTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	// the timezone is optional, times without one are UTC
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid OCPI DateTime %q", s)
+}
+
+type VersionEntry struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+type Endpoint struct {
+	Identifier string `json:"identifier"`
+	Role       string `json:"role"`
+	URL        string `json:"url"`
+}
+
+type VersionDetails struct {
+	Version   string     `json:"version"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+type BusinessDetails struct {
+	Name    string `json:"name"`
+	Website string `json:"website,omitempty"`
+}
+
+type CredentialsRole struct {
+	Role            string          `json:"role"`
+	BusinessDetails BusinessDetails `json:"business_details"`
+	PartyID         string          `json:"party_id"`
+	CountryCode     string          `json:"country_code"`
+}
+
+type Credentials struct {
+	Token string            `json:"token"`
+	URL   string            `json:"url"`
+	Roles []CredentialsRole `json:"roles"`
+}
@@ -5,15 +5,18 @@
 package main
 
 import (
-	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/noi-techpark/go-opendatahub-ingest/dto"
-	"github.com/noi-techpark/go-opendatahub-ingest/mq"
+)
+
+const (
+	ctxRegistration = "ocpi-registration"
+	ctxToken        = "ocpi-token"
+	ctxTokenA       = "ocpi-token-a"
 )
 
 func health(c *gin.Context) {
@@ -21,6 +24,9 @@ func health(c *gin.Context) {
 }
 
 func baseURL(c *gin.Context) string {
+	if cfg.OCPI_PUBLIC_URL != "" {
+		return strings.TrimSuffix(cfg.OCPI_PUBLIC_URL, "/")
+	}
 	scheme := "https"
 	// if c.Request.TLS == nil {
 	// 	scheme = "http"
@@ -28,56 +34,55 @@ func baseURL(c *gin.Context) string {
 	return scheme + "://" + c.Request.Host
 }
 
-func versions(ver string) gin.HandlerFunc {
-	type versionEntry struct {
-		Version string `json:"version"`
-		URL     string `json:"url"`
-	}
-	return func(c *gin.Context) {
-		c.JSONP(http.StatusOK, OCPIResp[[]versionEntry]{
-			StatusCode: 1000,
-			Timestamp:  OCPIDateTime{time.Now()},
-			Data: []versionEntry{
-				{Version: ver, URL: baseURL(c) + "/ocpi/emsp/" + ver},
-			},
-		})
+func versions(c *gin.Context) {
+	var data []VersionEntry
+	for _, v := range supportedVersions {
+		data = append(data, VersionEntry{Version: v, URL: baseURL(c) + "/ocpi/emsp/" + v})
 	}
+	ocpiData(c, data)
 }
 
 func versionDetails(ver string) gin.HandlerFunc {
-	type endpoint struct {
-		Identifier string `json:"identifier"`
-		Role       string `json:"role"`
-		URL        string `json:"url"`
-	}
-	type details struct {
-		Version   string     `json:"version"`
-		Endpoints []endpoint `json:"endpoints"`
-	}
 	return func(c *gin.Context) {
 		base := baseURL(c) + "/ocpi/emsp/" + ver
-		c.JSONP(http.StatusOK, OCPIResp[details]{
-			StatusCode: 1000,
-			Timestamp:  OCPIDateTime{time.Now()},
-			Data: details{
-				Version: ver,
-				Endpoints: []endpoint{
-					{Identifier: "credentials", Role: "SENDER", URL: base + "/credentials"},
-					{Identifier: "locations", Role: "RECEIVER", URL: base + "/locations"},
-				},
+		ocpiData(c, VersionDetails{
+			Version: ver,
+			Endpoints: []Endpoint{
+				{Identifier: "credentials", Role: "SENDER", URL: base + "/credentials"},
+				{Identifier: "locations", Role: "RECEIVER", URL: base + "/locations"},
+				{Identifier: "sessions", Role: "RECEIVER", URL: base + "/sessions"},
+				{Identifier: "cdrs", Role: "RECEIVER", URL: base + "/cdrs"},
+				{Identifier: "tariffs", Role: "RECEIVER", URL: base + "/tariffs"},
 			},
 		})
 	}
 }
 
-func ocpiOk(c *gin.Context) {
-	slog.Info("unhandled call acknowledged", "method", c.Request.Method, "path", c.Request.URL.Path)
-	c.JSONP(http.StatusOK, OCPIResp[any]{
+func ocpiData[T any](c *gin.Context, data T) {
+	c.JSONP(http.StatusOK, OCPIResp[T]{
+		Data:       data,
 		StatusCode: 1000,
 		Timestamp:  OCPIDateTime{time.Now()},
 	})
 }
 
+func ocpiOk(c *gin.Context) {
+	ocpiData[any](c, nil)
+}
+
+// ocpiError answers with an OCPI client (2xxx) or server (3xxx) error
+func ocpiError(c *gin.Context, httpStatus int, statusCode int, msg string) {
+	c.AbortWithStatusJSON(httpStatus, OCPIResp[any]{
+		StatusCode:    statusCode,
+		Timestamp:     OCPIDateTime{time.Now()},
+		StatusMessage: &msg,
+	})
+}
+
+func ocpiUnauthorized(c *gin.Context) {
+	ocpiError(c, http.StatusUnauthorized, 2000, "invalid or missing token")
+}
+
 func notImplemented(c *gin.Context) {
 	msg := "not implemented"
 	slog.Warn("not implemented endpoint called", "method", c.Request.Method, "path", c.FullPath())
@@ -88,69 +93,36 @@ func notImplemented(c *gin.Context) {
 	})
 }
 
-func handlePush(rabbit mq.R, provider string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var body map[string]any
-		if err := c.BindJSON(&body); err != nil {
-			body, _ := io.ReadAll(c.Request.Body)
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("cannot unmarshal json: %s", body))
-			return
-		}
-
-		params := map[string]string{}
-		for _, p := range c.Params {
-			params[p.Key] = p.Value
-		}
-
-		slog.Debug("Received message", "params", params, "body", body, "path", c.FullPath())
-
-		err := rabbit.Publish(dto.RawAny{
-			Provider:  provider,
-			Timestamp: time.Now(),
-			Rawdata: map[string]any{
-				"params": params,
-				"body":   body,
-				// TODO: once more than one endpoint are implemented, send some details about which endpoint this belongs to. or put it into the routing key
-			},
-		}, cfg.MQ_EXCHANGE)
-
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("cannot publish to rabbitmq: %w", err))
-			return
+// requestIDs echoes the OCPI 2.2 request and correlation IDs, as the spec requires
+func requestIDs(c *gin.Context) {
+	for _, h := range []string{"X-Request-ID", "X-Correlation-ID"} {
+		if v := c.GetHeader(h); v != "" {
+			c.Header(h, v)
 		}
-
-		resp := OCPIResp[any]{
-			StatusCode: 1000,
-			Timestamp:  OCPIDateTime{time.Now()},
-		}
-
-		c.JSONP(http.StatusOK, resp)
 	}
+	c.Next()
 }
 
-func validTokens(tokens []string) map[string]struct{} {
-	ret := map[string]struct{}{}
-	for _, t := range tokens {
-		ret[t] = struct{}{}
-	}
-	return ret
+// headerToken returns the token of the "Authorization: Token <token>" header
+func headerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(c.GetHeader("Authorization")), " ")
+	token = strings.TrimSpace(token)
+	return token, ok && scheme == "Token" && token != ""
 }
 
-func tokenAuth(ts map[string]struct{}) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		header := c.Request.Header.Get("Authorization")
-		slog.Info("Auth header passed", "header", header)
-
-		var token string
-		if _, err := fmt.Sscanf(header, "Token %s", &token); err != nil {
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid authorization header format: %w", err))
-			return
-		}
-		if _, found := ts[token]; !found {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		c.Next()
+// tokenAuth accepts the tokens of registered CPOs and the ones configured in OCPI_TOKENS
+func tokenAuth(c *gin.Context) {
+	token, ok := headerToken(c)
+	if !ok {
+		ocpiUnauthorized(c)
+		return
+	}
+	reg, ok := store.authorize(token)
+	if !ok {
+		ocpiUnauthorized(c)
+		return
 	}
+	c.Set(ctxRegistration, reg)
+	c.Set(ctxToken, token)
+	c.Next()
 }
@@ -4,12 +4,17 @@
 package main
 
 import (
+	"encoding/base64"
 	"log/slog"
+	"net"
+	"net/http"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/noi-techpark/go-opendatahub-ingest/dc"
+	"github.com/noi-techpark/go-opendatahub-ingest/dto"
 	"github.com/noi-techpark/go-opendatahub-ingest/mq"
 	"github.com/noi-techpark/go-opendatahub-ingest/ms"
 	"github.com/robfig/cron/v3"
@@ -23,27 +28,87 @@ var cfg struct {
 	PULL_LOCATIONS_ENDPOINT string
 	PULL_LOCATIONS_CRON     string
 
+	// Tokens B, accepted from CPOs in addition to the ones issued in the credentials handshake
 	OCPI_TOKENS []string
+	// Tokens A, handed out to CPOs to register via the credentials endpoint. Each is valid once
+	OCPI_REGISTRATION_TOKENS []string
+	// Stores the registrations, so that they survive restarts
+	OCPI_STATE_FILE string
+	// Public URL of the service, defaults to https://<Host header>
+	OCPI_PUBLIC_URL    string
+	OCPI_COUNTRY_CODE  string `default:"IT"`
+	OCPI_PARTY_ID      string `default:"ODH"`
+	OCPI_BUSINESS_NAME string `default:"Open Data Hub"`
+	// Register with a CPO at startup, using the token A and versions URL it gave us
+	OCPI_REGISTER_VERSIONS_URL string
+	OCPI_REGISTER_TOKEN        string
+
+	PORT string `default:"8080"`
 }
 
-const ver string = "2.2"
+var store *credentialStore
 
 func main() {
 	envconfig.MustProcess("", &cfg)
 	ms.InitLog(cfg.LOG_LEVEL)
 
+	// registrations issue token B at runtime, it must not get lost on restart
+	if (len(cfg.OCPI_REGISTRATION_TOKENS) > 0 || cfg.OCPI_REGISTER_VERSIONS_URL != "") && cfg.OCPI_STATE_FILE == "" {
+		panic("OCPI_STATE_FILE is required to register with a CPO")
+	}
+
+	var err error
+	store, err = newCredentialStore(cfg.OCPI_STATE_FILE, cfg.OCPI_TOKENS, cfg.OCPI_REGISTRATION_TOKENS)
+	if err != nil {
+		slog.Error("cannot load OCPI state. aborting", "file", cfg.OCPI_STATE_FILE)
+		panic(err)
+	}
+
 	mq := connectMq()
 	defer mq.Close()
 
+	publish := func(raw dto.RawAny) error {
+		return mq.Publish(raw, cfg.MQ_EXCHANGE)
+	}
+
 	// polling jobs run via cron schedule
 	go startCron(mq)
 
 	// data pushes are handled by a REST endpoint
-	go startEndpoint(mq)
+	l, err := net.Listen("tcp", ":"+cfg.PORT)
+	if err != nil {
+		slog.Error("cannot listen. aborting", "port", cfg.PORT)
+		panic(err)
+	}
+	go func() {
+		slog.Info("START GIN")
+		panic(http.Serve(l, newRouter(publish)))
+	}()
+
+	if cfg.OCPI_REGISTER_VERSIONS_URL != "" {
+		register()
+	}
 
 	select {}
 }
 
+// register performs the credentials handshake with the CPO configured in OCPI_REGISTER_*, unless already registered
+func register() {
+	if store.byVersionsURL(cfg.OCPI_REGISTER_VERSIONS_URL) != nil {
+		slog.Info("already registered with CPO", "url", cfg.OCPI_REGISTER_VERSIONS_URL)
+		return
+	}
+	if cfg.OCPI_PUBLIC_URL == "" {
+		panic("OCPI_PUBLIC_URL is required to register with a CPO")
+	}
+	reg, err := registerWithCPO(cfg.OCPI_REGISTER_VERSIONS_URL, cfg.OCPI_REGISTER_TOKEN, strings.TrimSuffix(cfg.OCPI_PUBLIC_URL, "/")+"/ocpi/emsp/versions")
+	if err != nil {
+		slog.Error("cannot register with CPO. aborting", "url", cfg.OCPI_REGISTER_VERSIONS_URL)
+		panic(err)
+	}
+	slog.Info("registered with CPO", "url", reg.VersionsURL, "version", reg.Version, "roles", reg.Roles)
+}
+
 func connectMq() mq.R {
 	rabbit, err := mq.Connect(cfg.MQ_URI, cfg.MQ_CLIENT)
 	if err != nil {
@@ -58,7 +123,12 @@ func startCron(rabbit mq.R) {
 
 	// Poll locations endpoint to get all charging stations and their plugs
 	if _, err := c.AddFunc(cfg.PULL_LOCATIONS_CRON, func() {
-		if err := getAllLocations(rabbit, cfg.PROVIDER+"-pull-locations"); err != nil {
+		endpoint, token := pullSource()
+		if endpoint == "" {
+			slog.Warn("no locations endpoint to pull, set PULL_LOCATIONS_ENDPOINT or register with the CPO")
+			return
+		}
+		if err := getAllLocations(rabbit, cfg.PROVIDER+"-pull-locations", endpoint, token); err != nil {
 			slog.Error("pull locations job failed", "err", err)
 		}
 	}); err != nil {
@@ -67,7 +137,19 @@ func startCron(rabbit mq.R) {
 	c.Start()
 }
 
-func startEndpoint(rabbit mq.R) {
+// pullSource returns the locations endpoint and the Authorization token to pull with.
+// The configured PULL_* settings take precedence over the credentials handshake
+func pullSource() (string, string) {
+	if cfg.PULL_LOCATIONS_ENDPOINT != "" {
+		return cfg.PULL_LOCATIONS_ENDPOINT, cfg.PULL_TOKEN
+	}
+	if reg := store.first(); reg != nil && reg.TokenC != "" {
+		return reg.endpoint("locations", "SENDER"), base64.StdEncoding.EncodeToString([]byte(reg.TokenC))
+	}
+	return "", ""
+}
+
+func newRouter(publish publishFunc) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Recovery())
 	r.Use(cors.Default())
@@ -76,31 +158,50 @@ func startEndpoint(rabbit mq.R) {
 		slog.Default(),
 		// ignore paths that are not /ocpi, such as health or favicon etc.
 		sloggin.AcceptPathPrefix("/ocpi")))
+	r.Use(requestIDs)
 
 	r.GET("/health", health)
-	r.GET("/ocpi/emsp/versions", versions(ver))
-	r.GET("/ocpi/emsp/"+ver, versionDetails(ver))
+	r.GET("/ocpi/emsp/versions", versions)
 
-	rEmsp := r.Group("/ocpi/emsp")
-	rEmsp.Use(tokenAuth(validTokens(cfg.OCPI_TOKENS)))
-	{
-		rVer := rEmsp.Group("/" + ver)
-		{
-			rVer.GET("/credentials", notImplemented)
-			rVer.GET("/locations", notImplemented)
+	for _, ver := range supportedVersions {
+		r.GET("/ocpi/emsp/"+ver, versionDetails(ver))
 
-			rLoc := rVer.Group("/locations")
+		rVer := r.Group("/ocpi/emsp/" + ver)
 
-			// Receive status updates of plugs via push
-			rLoc.PATCH("/:country_code/:party_id/:location_id/:evse_uid", handlePush(rabbit, cfg.PROVIDER+"-push-evse"))
+		rCred := rVer.Group("/credentials", credentialsAuth)
+		{
+			rCred.GET("", getCredentials)
+			rCred.POST("", postCredentials)
+			rCred.PUT("", putCredentials)
+			rCred.DELETE("", deleteCredentials)
+		}
 
-			// Acknowledge all other PUT/PATCH pushes with OCPI OK.
-			rLoc.PUT("/*action", ocpiOk)
-			rLoc.PATCH("/:country_code/:party_id/:location_id", ocpiOk)
-			rLoc.PATCH("/:country_code/:party_id/:location_id/:evse_uid/:connector_id", ocpiOk)
+		rMod := rVer.Group("", tokenAuth)
+		{
+			// we don't keep state, the CPO is the source of truth for its objects
+			rMod.GET("/locations", notImplemented)
+
+			rLoc := rMod.Group("/locations/:country_code/:party_id/:location_id")
+			rLoc.PUT("", handleReceive(publish, locationObject))
+			rLoc.PATCH("", handleReceive(publish, locationObject))
+			// status updates of plugs, published as before with routing key <provider>-push-evse
+			rLoc.PUT("/:evse_uid", handleReceive(publish, evseObject))
+			rLoc.PATCH("/:evse_uid", handleReceive(publish, evseObject))
+			rLoc.PUT("/:evse_uid/:connector_id", handleReceive(publish, connectorObject))
+			rLoc.PATCH("/:evse_uid/:connector_id", handleReceive(publish, connectorObject))
+
+			rSes := rMod.Group("/sessions/:country_code/:party_id/:session_id")
+			rSes.PUT("", handleReceive(publish, sessionObject))
+			rSes.PATCH("", handleReceive(publish, sessionObject))
+
+			// no Location header, the CDRs are not kept to be fetched back
+			rMod.POST("/cdrs", handleReceive(publish, cdrObject))
+
+			rTar := rMod.Group("/tariffs/:country_code/:party_id/:tariff_id")
+			rTar.PUT("", handleReceive(publish, tariffObject))
+			rTar.DELETE("", handleReceive(publish, tariffObject))
 		}
 	}
 
-	slog.Info("START GIN")
-	panic(r.Run())
+	return r
 }
@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noi-techpark/go-opendatahub-ingest/dto"
+)
+
+type publishFunc func(raw dto.RawAny) error
+
+// ocpiObject describes an object the CPO pushes to one of our receiver endpoints
+type ocpiObject struct {
+	// name is appended to the provider to get the routing key
+	name string
+	// idParam is the path parameter identifying the object, it must match idField of the body
+	idParam string
+	idField string
+	// owned objects carry country_code and party_id, which must match the path
+	owned bool
+	// required are the fields a complete object must have, i.e. on PUT and POST
+	required []string
+}
+
+var (
+	locationObject = ocpiObject{name: "locations", idParam: "location_id", idField: "id", owned: true,
+		required: []string{"country_code", "party_id", "id", "publish", "address", "city", "country", "coordinates", "time_zone", "last_updated"}}
+	evseObject = ocpiObject{name: "evse", idParam: "evse_uid", idField: "uid",
+		required: []string{"uid", "status", "last_updated"}}
+	connectorObject = ocpiObject{name: "connectors", idParam: "connector_id", idField: "id",
+		required: []string{"id", "standard", "format", "power_type", "max_voltage", "max_amperage", "last_updated"}}
+	sessionObject = ocpiObject{name: "sessions", idParam: "session_id", idField: "id", owned: true,
+		required: []string{"country_code", "party_id", "id", "start_date_time", "kwh", "cdr_token", "auth_method", "location_id", "evse_uid", "connector_id", "currency", "status", "last_updated"}}
+	cdrObject = ocpiObject{name: "cdrs", idField: "id", owned: true,
+		required: []string{"country_code", "party_id", "id", "start_date_time", "end_date_time", "cdr_token", "auth_method", "cdr_location", "currency", "charging_periods", "total_cost", "total_energy", "total_time", "last_updated"}}
+	tariffObject = ocpiObject{name: "tariffs", idParam: "tariff_id", idField: "id", owned: true,
+		required: []string{"country_code", "party_id", "id", "currency", "elements", "last_updated"}}
+)
+
+// PushRaw is the raw data of a push. Params are the path parameters identifying the object,
+// Method tells if Body is a complete object (PUT, POST), a partial update (PATCH) or absent (DELETE)
+type PushRaw struct {
+	Object string            `json:"object"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+	Body   map[string]any    `json:"body,omitempty"`
+}
+
+// validate checks the body against the PUT/PATCH semantics of OCPI
+func (o ocpiObject) validate(method string, params map[string]string, body map[string]any) error {
+	switch method {
+	case http.MethodPut, http.MethodPost:
+		var missing []string
+		for _, f := range o.required {
+			if _, ok := body[f]; !ok {
+				missing = append(missing, f)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing required fields %s", strings.Join(missing, ", "))
+		}
+	case http.MethodPatch:
+		// as per spec, every PATCH has to contain last_updated
+		if _, ok := body["last_updated"]; !ok {
+			return fmt.Errorf("missing required field last_updated")
+		}
+	}
+
+	// the identifying fields can't differ from the URL, not even on PATCH
+	match := func(field, param string) error {
+		v, ok := body[field]
+		if !ok || param == "" {
+			return nil
+		}
+		if s, isString := v.(string); !isString || s != param {
+			return fmt.Errorf("%s %v doesn't match the URL %s", field, v, param)
+		}
+		return nil
+	}
+	if err := match(o.idField, params[o.idParam]); err != nil {
+		return err
+	}
+	if o.owned {
+		if err := match("country_code", params["country_code"]); err != nil {
+			return err
+		}
+		if err := match("party_id", params["party_id"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleReceive validates a pushed object and publishes it with the routing key of its module
+func handleReceive(publish publishFunc, obj ocpiObject) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+
+		var body map[string]any
+		if method != http.MethodDelete {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				ocpiError(c, http.StatusBadRequest, 2001, "invalid JSON object: "+err.Error())
+				return
+			}
+		}
+
+		params := map[string]string{}
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+		}
+
+		if err := obj.validate(method, params, body); err != nil {
+			slog.Warn("invalid push rejected", "method", method, "path", c.FullPath(), "params", params, "err", err)
+			ocpiError(c, http.StatusBadRequest, 2001, err.Error())
+			return
+		}
+
+		slog.Debug("Received message", "object", obj.name, "method", method, "params", params, "body", body)
+
+		err := publish(dto.RawAny{
+			Provider:  cfg.PROVIDER + "-push-" + obj.name,
+			Timestamp: time.Now(),
+			Rawdata: PushRaw{
+				Object: obj.name,
+				Method: method,
+				Params: params,
+				Body:   body,
+			},
+		})
+		if err != nil {
+			slog.Error("cannot publish to rabbitmq", "object", obj.name, "err", err)
+			ocpiError(c, http.StatusInternalServerError, 3000, "unable to process the request")
+			return
+		}
+
+		ocpiOk(c)
+	}
+}
@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Registration is the result of a credentials handshake with a CPO
+type Registration struct {
+	// TokenB is issued by us, the CPO uses it to call our endpoints
+	TokenB string `json:"token_b"`
+	// TokenC is issued by the CPO, we use it to call their endpoints
+	TokenC      string            `json:"token_c"`
+	VersionsURL string            `json:"versions_url"`
+	Version     string            `json:"version"`
+	Endpoints   []Endpoint        `json:"endpoints"`
+	Roles       []CredentialsRole `json:"roles"`
+}
+
+// endpoint returns the URL of a module the CPO implements in the given role
+func (r *Registration) endpoint(identifier, role string) string {
+	for _, e := range r.Endpoints {
+		if e.Identifier == identifier && e.Role == role {
+			return e.URL
+		}
+	}
+	return ""
+}
+
+// credentialStore holds the tokens accepted from CPOs and the registrations.
+// With a state file, registrations survive restarts. Without, they are lost and must be configured via env
+type credentialStore struct {
+	path string
+
+	mu sync.Mutex
+	// tokens configured via env, in the form they appear in the Authorization header
+	staticTokens []string
+	// tokens A we handed out for the registration. They are only valid until used
+	registrationTokens []string
+	state              storeState
+}
+
+type storeState struct {
+	Registrations []*Registration `json:"registrations"`
+	// registration tokens are only valid once
+	UsedRegistrationTokens []string `json:"used_registration_tokens,omitempty"`
+}
+
+func newCredentialStore(path string, staticTokens, registrationTokens []string) (*credentialStore, error) {
+	s := &credentialStore{
+		path:               path,
+		staticTokens:       nonEmpty(staticTokens),
+		registrationTokens: nonEmpty(registrationTokens),
+	}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return s, nil
+}
+
+func nonEmpty(ss []string) []string {
+	var ret []string
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// save writes the state atomically. Must be called with the lock held
+func (s *credentialStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o750); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// tokenMatches compares the token of an Authorization header with a known token.
+// OCPI 2.2 sends tokens base64 encoded, but some parties send them as is
+func tokenMatches(header, token string) bool {
+	if subtle.ConstantTimeCompare([]byte(header), []byte(token)) == 1 {
+		return true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header)
+	return err == nil && subtle.ConstantTimeCompare(decoded, []byte(token)) == 1
+}
+
+// authorize returns the registration the token belongs to.
+// Static tokens are valid without a registration, in that case the registration is nil
+func (s *credentialStore) authorize(header string) (reg *Registration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.state.Registrations {
+		if tokenMatches(header, r.TokenB) {
+			return r, true
+		}
+	}
+	for _, t := range s.staticTokens {
+		if tokenMatches(header, t) {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// registrationToken returns the token A matching the header, if it wasn't used yet
+func (s *credentialStore) registrationToken(header string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.registrationTokens {
+		if tokenMatches(header, t) && !slices.Contains(s.state.UsedRegistrationTokens, t) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// register stores a new registration. tokenA is invalidated, if given
+func (s *credentialStore) register(reg *Registration, tokenA string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a CPO registering again replaces its previous registration
+	s.state.Registrations = slices.DeleteFunc(s.state.Registrations, func(r *Registration) bool {
+		return r.VersionsURL == reg.VersionsURL
+	})
+	s.state.Registrations = append(s.state.Registrations, reg)
+	if tokenA != "" {
+		s.state.UsedRegistrationTokens = append(s.state.UsedRegistrationTokens, tokenA)
+	}
+	return s.save()
+}
+
+// update replaces an existing registration, e.g. after the CPO updated its credentials
+func (s *credentialStore) update(old, reg *Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.Index(s.state.Registrations, old)
+	if i < 0 {
+		return fmt.Errorf("registration not found")
+	}
+	s.state.Registrations[i] = reg
+	return s.save()
+}
+
+func (s *credentialStore) unregister(reg *Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Registrations = slices.DeleteFunc(s.state.Registrations, func(r *Registration) bool { return r == reg })
+	return s.save()
+}
+
+// byVersionsURL returns the registration with a CPO, or nil
+func (s *credentialStore) byVersionsURL(url string) *Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.state.Registrations {
+		if r.VersionsURL == url {
+			return r
+		}
+	}
+	return nil
+}
+
+// first returns the oldest registration, or nil
+func (s *credentialStore) first() *Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.state.Registrations) == 0 {
+		return nil
+	}
+	return s.state.Registrations[0]
+}
+
+// newToken generates a random credentials token
+func newToken() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// subscription is a topic filter with the QoS it's subscribed with
+type subscription struct {
+	Topic string
+	QoS   byte
+}
+
+// parseSubscriptions parses a list of "topic[:qos]", QoS defaulting to 1
+func parseSubscriptions(topics []string) ([]subscription, error) {
+	subs := []subscription{}
+	for _, t := range topics {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		sub := subscription{Topic: t, QoS: 1}
+		if i := strings.LastIndex(t, ":"); i >= 0 {
+			qos, err := strconv.ParseUint(t[i+1:], 10, 8)
+			if err != nil || qos > 2 {
+				return nil, fmt.Errorf("invalid QoS in topic %q, must be 0, 1 or 2", t)
+			}
+			sub.Topic, sub.QoS = t[:i], byte(qos)
+		}
+		if sub.Topic == "" {
+			return nil, fmt.Errorf("empty topic in %q", t)
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("no topics configured")
+	}
+	return subs, nil
+}
+
+type route struct {
+	pattern  string
+	provider string
+}
+
+// router maps topics to the provider, and thus the routing key, their raw data is published with
+type router struct {
+	routes   []route
+	fallback string
+}
+
+// newRouter parses a list of "topic pattern=provider". Patterns are matched in order, with MQTT wildcards
+func newRouter(routes []string, fallback string) (*router, error) {
+	r := &router{fallback: fallback}
+	for _, s := range routes {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		pattern, provider, ok := strings.Cut(s, "=")
+		if !ok || pattern == "" || provider == "" {
+			return nil, fmt.Errorf("invalid route %q, expected 'topic pattern=provider'", s)
+		}
+		r.routes = append(r.routes, route{pattern: pattern, provider: provider})
+	}
+	return r, nil
+}
+
+func (r *router) provider(topic string) string {
+	for _, rt := range r.routes {
+		if topicMatches(rt.pattern, topic) {
+			return rt.provider
+		}
+	}
+	return r.fallback
+}
+
+// topicMatches reports if topic matches the MQTT topic filter, supporting + and # wildcards and shared subscriptions
+func topicMatches(filter, topic string) bool {
+	if rest, ok := strings.CutPrefix(filter, "$share/"); ok {
+		if _, f, ok := strings.Cut(rest, "/"); ok {
+			filter = f
+		}
+	}
+	// wildcards don't match topics starting with $ like $SYS
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// tlsConfig returns the TLS configuration from the MQTT_TLS_* settings, or nil if TLS is not configured
+func tlsConfig() (*tls.Config, error) {
+	if env.MQTT_TLS_CA == "" && env.MQTT_TLS_CERT == "" && !env.MQTT_TLS_INSECURE && !isTLSURI(env.MQTT_URI) {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: env.MQTT_TLS_INSECURE}
+	if env.MQTT_TLS_CA != "" {
+		pem, err := os.ReadFile(env.MQTT_TLS_CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", env.MQTT_TLS_CA)
+		}
+	}
+	if env.MQTT_TLS_CERT != "" || env.MQTT_TLS_KEY != "" {
+		cert, err := tls.LoadX509KeyPair(env.MQTT_TLS_CERT, env.MQTT_TLS_KEY)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func isTLSURI(uri string) bool {
+	for _, scheme := range []string{"ssl://", "tls://", "mqtts://", "mqtt+ssl://", "tcps://", "wss://"} {
+		if strings.HasPrefix(uri, scheme) {
+			return true
+		}
+	}
+	return false
+}
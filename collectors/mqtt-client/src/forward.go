@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+)
+
+type Rawdata struct {
+	MsgId    uint16
+	Topic    string
+	Payload  string
+	QoS      byte
+	Retained bool
+
+	// MQTT v5 only
+	ContentType    string         `json:",omitempty"`
+	UserProperties []UserProperty `json:",omitempty"`
+}
+
+// UserProperty is a MQTT v5 user property. Keys may repeat, so they are kept as list in message order
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// received is a MQTT message of either protocol version
+type received struct {
+	data Rawdata
+	// ack acknowledges the message to the MQTT broker, which redelivers it otherwise
+	ack func() error
+}
+
+type publisher func(ctx context.Context, raw *rdb.RawAny) error
+
+// forwarder publishes MQTT messages to the ingress and acknowledges them once published
+type forwarder struct {
+	router  *router
+	publish publisher
+	retries int
+	backoff time.Duration
+}
+
+// forward publishes the message, retrying with exponential backoff.
+// The message is only acknowledged after a successful publish, so that the broker redelivers it otherwise
+func (f *forwarder) forward(ctx context.Context, m received) error {
+	slog.Debug("got MQTT message", "id", m.data.MsgId, "topic", m.data.Topic, "payload", m.data.Payload)
+
+	// We assume the payload is a string (json probably)
+	raw := &rdb.RawAny{
+		Provider:  f.router.provider(m.data.Topic),
+		Timestamp: time.Now(),
+		Rawdata:   m.data,
+	}
+
+	delay := f.backoff
+	for attempt := 0; ; attempt++ {
+		err := f.publish(ctx, raw)
+		if err == nil {
+			break
+		}
+		if attempt >= f.retries {
+			return fmt.Errorf("failed to publish message of topic %s after %d attempts: %w", m.data.Topic, attempt+1, err)
+		}
+		slog.Warn("failed to publish message, retrying", "topic", m.data.Topic, "attempt", attempt+1, "retry_in", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+
+	if err := m.ack(); err != nil {
+		// published already, at worst the broker delivers it again
+		slog.Error("failed to acknowledge MQTT message", "id", m.data.MsgId, "topic", m.data.Topic, "err", err)
+	}
+	return nil
+}
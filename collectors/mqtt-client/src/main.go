@@ -5,100 +5,93 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-amqp/v2/pkg/amqp"
-	"github.com/ThreeDotsLabs/watermill/message"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/kelseyhightower/envconfig"
-	"github.com/noi-techpark/go-opendatahub-ingest/dc"
-	"github.com/noi-techpark/go-opendatahub-ingest/dto"
-	"github.com/noi-techpark/go-opendatahub-ingest/ms"
-	"github.com/rabbitmq/amqp091-go"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/dc"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel"
 )
 
-type Rawdata struct {
-	MsgId   uint16
-	Topic   string
-	Payload string
-}
-
-var cfg struct {
+var env struct {
 	dc.Env
-	MQTT_user     string
-	MQTT_pass     string
-	MQTT_uri      string
-	MQTT_clientid string
-	MQTT_topic    string
+	MQTT_USER     string
+	MQTT_PASS     string
+	MQTT_URI      string
+	MQTT_CLIENTID string
+	// Single topic, kept for compatibility. Use MQTT_TOPICS
+	MQTT_TOPIC  string
+	MQTT_TOPICS []string
+	MQTT_ROUTES []string
+
+	MQTT_VERSION        int `default:"3"`
+	MQTT_CLEAN_SESSION  bool
+	MQTT_SESSION_EXPIRY uint32 `default:"86400"`
+
+	MQTT_TLS_CA       string
+	MQTT_TLS_CERT     string
+	MQTT_TLS_KEY      string
+	MQTT_TLS_INSECURE bool
+
+	PUBLISH_RETRIES int `default:"5"`
 }
 
+type connector func(ctx context.Context, subs []subscription, tlsCfg *tls.Config, handle func(received)) (func(), error)
+
 func main() {
-	envconfig.MustProcess("", &cfg)
-	ms.InitLog(cfg.LOG_LEVEL)
-
-	slog.Info("Started with config", "cfg", cfg)
-
-	rabbit := NewRabbitPublisher(cfg.MQ_URI, cfg.MQ_EXCHANGE, cfg.MQ_CLIENT, cfg.PROVIDER)
-
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(cfg.MQTT_uri)
-	opts.SetClientID(cfg.MQTT_clientid)
-	opts.SetUsername(cfg.MQTT_user)
-	opts.SetPassword(cfg.MQTT_pass)
-	opts.SetAutoReconnect(true)
-
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
-		c.Subscribe(cfg.MQTT_topic, 1, func(c mqtt.Client, m mqtt.Message) {
-			// We assume the payload is a string (json probably)
-			slog.Debug("got MQTT message", "id", m.MessageID(), "topic", m.Topic(), "payload", string(m.Payload()))
-			msg := dto.RawAny{
-				Provider:  cfg.PROVIDER,
-				Timestamp: time.Now(),
-				Rawdata: Rawdata{
-					MsgId:   m.MessageID(),
-					Topic:   m.Topic(),
-					Payload: string(m.Payload()),
-				},
-			}
-			rabbit <- msg
-		})
-	})
-
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
-	}
+	ms.InitWithEnv(context.Background(), "", &env)
+	slog.Info("Starting data collector...")
 
-	select {}
-}
+	defer tel.FlushOnPanic()
+
+	topics := env.MQTT_TOPICS
+	if len(topics) == 0 && env.MQTT_TOPIC != "" {
+		topics = []string{env.MQTT_TOPIC}
+	}
+	subs, err := parseSubscriptions(topics)
+	ms.FailOnError(context.Background(), err, "invalid MQTT_TOPICS")
+
+	rt, err := newRouter(env.MQTT_ROUTES, env.PROVIDER)
+	ms.FailOnError(context.Background(), err, "invalid MQTT_ROUTES")
+
+	tlsCfg, err := tlsConfig()
+	ms.FailOnError(context.Background(), err, "invalid TLS configuration")
+
+	var connect connector
+	switch env.MQTT_VERSION {
+	case 3:
+		connect = connectV3
+	case 5:
+		connect = connectV5
+	default:
+		ms.FailOnError(context.Background(), fmt.Errorf("unsupported MQTT_VERSION %d, use 3 or 5", env.MQTT_VERSION), "invalid MQTT_VERSION")
+	}
 
-func NewRabbitPublisher(uri string, exchange string, client string, routingkey string) chan<- dto.RawAny {
-	pubConfig := amqp.NewDurablePubSubConfig(uri, nil)
-	pubConfig.Connection.AmqpConfig = &amqp091.Config{}
-	pubConfig.Connection.AmqpConfig.Properties = amqp091.Table{}
-	pubConfig.Connection.AmqpConfig.Properties.SetClientConnectionName(client)
-	pubConfig.Exchange.GenerateName = amqp.GenerateQueueNameConstant(exchange)
+	collector := dc.NewDc[dc.EmptyData](context.Background(), env.Env)
+	ctx, col := collector.StartCollection(context.Background())
 
-	pub, err := amqp.NewPublisher(pubConfig, watermill.NewSlogLogger(slog.Default()))
-	if err != nil {
-		panic(err)
+	fwd := &forwarder{router: rt, publish: col.Publish, retries: env.PUBLISH_RETRIES, backoff: time.Second}
+	handle := func(m received) {
+		// without acknowledgement the broker delivers the message again after the restart
+		err := fwd.forward(ctx, m)
+		ms.FailOnError(ctx, err, "failed to publish MQTT message, exiting without acknowledging it", "topic", m.data.Topic)
 	}
 
-	ch := make(chan dto.RawAny)
+	disconnect, err := connect(ctx, subs, tlsCfg, handle)
+	ms.FailOnError(ctx, err, "failed to connect to MQTT broker", "uri", env.MQTT_URI)
+	slog.Info("Setup complete. Forwarding MQTT messages", "topics", subs, "version", env.MQTT_VERSION)
 
-	go func() {
-		for msg := range ch {
-			payload, err := json.Marshal(msg)
-			if err != nil {
-				slog.Error("can't marshal msg", "err", err, "msg", msg)
-				panic(err)
-			}
-			pub.Publish(routingkey, message.NewMessage(watermill.NewUUID(), payload))
-		}
-	}()
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
 
-	return ch
+	slog.Info("Shutting down")
+	disconnect()
+	col.End(ctx)
 }
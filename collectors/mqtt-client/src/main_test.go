@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscriptions(t *testing.T) {
+	subs, err := parseSubscriptions([]string{"AirQuino/RawData", " parking/+/status:0", "events/#:2"})
+	require.NoError(t, err)
+	require.Equal(t, []subscription{
+		{Topic: "AirQuino/RawData", QoS: 1},
+		{Topic: "parking/+/status", QoS: 0},
+		{Topic: "events/#", QoS: 2},
+	}, subs)
+
+	_, err = parseSubscriptions([]string{"a/b:3"})
+	require.Error(t, err)
+	_, err = parseSubscriptions([]string{":1"})
+	require.Error(t, err)
+	_, err = parseSubscriptions(nil)
+	require.Error(t, err)
+}
+
+func TestTopicMatches(t *testing.T) {
+	for _, c := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"a/b", "a/b/c", false},
+		{"$share/group/a/+", "a/b", true},
+	} {
+		require.Equal(t, c.match, topicMatches(c.filter, c.topic), "%s %s", c.filter, c.topic)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r, err := newRouter([]string{"parking/merano/#=parking-on/merano", "parking/+/#=parking-on/bz"}, "a22/environment")
+	require.NoError(t, err)
+	require.Equal(t, "parking-on/merano", r.provider("parking/merano/lot1"))
+	require.Equal(t, "parking-on/bz", r.provider("parking/bz/lot1"))
+	require.Equal(t, "a22/environment", r.provider("AirQuino/RawData"))
+
+	_, err = newRouter([]string{"parking/#"}, "")
+	require.Error(t, err)
+}
+
+func TestForwardAcknowledgesAfterPublish(t *testing.T) {
+	r, err := newRouter([]string{"a/#=provider/a"}, "provider/default")
+	require.NoError(t, err)
+
+	var published []*rdb.RawAny
+	failures := 2
+	f := &forwarder{router: r, retries: 2, publish: func(ctx context.Context, raw *rdb.RawAny) error {
+		if failures > 0 {
+			failures--
+			return errors.New("connection refused")
+		}
+		published = append(published, raw)
+		return nil
+	}}
+
+	acked := false
+	data := Rawdata{MsgId: 7, Topic: "a/b", Payload: `{"v":1}`, QoS: 1, UserProperties: []UserProperty{{Key: "site", Value: "bz"}}}
+	require.NoError(t, f.forward(context.Background(), received{data: data, ack: func() error {
+		acked = true
+		return nil
+	}}))
+	require.True(t, acked)
+	require.Len(t, published, 1)
+	require.Equal(t, "provider/a", published[0].Provider)
+	require.Equal(t, data, published[0].Rawdata)
+}
+
+func TestForwardDoesNotAcknowledgeFailedPublish(t *testing.T) {
+	r, err := newRouter(nil, "provider/default")
+	require.NoError(t, err)
+
+	attempts := 0
+	f := &forwarder{router: r, retries: 2, publish: func(ctx context.Context, raw *rdb.RawAny) error {
+		attempts++
+		return errors.New("connection refused")
+	}}
+
+	err = f.forward(context.Background(), received{data: Rawdata{Topic: "a"}, ack: func() error {
+		t.Fatal("failed message acknowledged")
+		return nil
+	}})
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+}
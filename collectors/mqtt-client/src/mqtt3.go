@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
+)
+
+// connectV3 connects with MQTT 3.1.1 and subscribes to all topics on each (re)connect.
+// It returns a function disconnecting the client
+func connectV3(ctx context.Context, subs []subscription, tlsCfg *tls.Config, handle func(received)) (func(), error) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(env.MQTT_URI)
+	opts.SetClientID(env.MQTT_CLIENTID)
+	opts.SetUsername(env.MQTT_USER)
+	opts.SetPassword(env.MQTT_PASS)
+	opts.SetAutoReconnect(true)
+	// the broker only redelivers unacknowledged messages within a persistent session
+	opts.SetCleanSession(env.MQTT_CLEAN_SESSION)
+	opts.SetAutoAckDisabled(true)
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+
+	filters := map[string]byte{}
+	for _, s := range subs {
+		filters[s.Topic] = s.QoS
+	}
+
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		slog.Info("Connected to MQTT broker, subscribing", "topics", subs)
+		token := c.SubscribeMultiple(filters, func(c mqtt.Client, m mqtt.Message) {
+			handle(received{
+				data: Rawdata{
+					MsgId:    m.MessageID(),
+					Topic:    m.Topic(),
+					Payload:  string(m.Payload()),
+					QoS:      m.Qos(),
+					Retained: m.Retained(),
+				},
+				ack: func() error {
+					m.Ack()
+					return nil
+				},
+			})
+		})
+		// the handler must not block waiting for the subscription
+		go func() {
+			token.Wait()
+			ms.FailOnError(ctx, token.Error(), "failed to subscribe to MQTT topics", "topics", subs)
+		}()
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		slog.Warn("Lost MQTT connection, reconnecting", "err", err)
+	})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	return func() { client.Disconnect(1000) }, nil
+}
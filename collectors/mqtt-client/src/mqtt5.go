@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
+)
+
+// connectV5 connects with MQTT v5 and subscribes to all topics on each (re)connect.
+// It returns a function disconnecting the client
+func connectV5(ctx context.Context, subs []subscription, tlsCfg *tls.Config, handle func(received)) (func(), error) {
+	u, err := url.Parse(env.MQTT_URI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT_URI: %w", err)
+	}
+
+	subscribe := &paho.Subscribe{}
+	for _, s := range subs {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: s.Topic, QoS: s.QoS})
+	}
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls: []*url.URL{u},
+		TlsCfg:     tlsCfg,
+		KeepAlive:  30,
+		// the broker only redelivers unacknowledged messages within a persistent session
+		CleanStartOnInitialConnection: env.MQTT_CLEAN_SESSION,
+		SessionExpiryInterval:         env.MQTT_SESSION_EXPIRY,
+		ConnectUsername:               env.MQTT_USER,
+		ConnectPassword:               []byte(env.MQTT_PASS),
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			slog.Info("Connected to MQTT broker, subscribing", "topics", subs)
+			// the callback must not block waiting for the subscription
+			go func() {
+				_, err := cm.Subscribe(ctx, subscribe)
+				ms.FailOnError(ctx, err, "failed to subscribe to MQTT topics", "topics", subs)
+			}()
+		},
+		OnConnectionDown: func() bool {
+			slog.Warn("Lost MQTT connection, reconnecting")
+			return true
+		},
+		OnConnectError: func(err error) {
+			slog.Error("MQTT connection attempt failed", "err", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:                   env.MQTT_CLIENTID,
+			EnableManualAcknowledgment: true,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					p := pr.Packet
+					data := Rawdata{
+						MsgId:    p.PacketID,
+						Topic:    p.Topic,
+						Payload:  string(p.Payload),
+						QoS:      p.QoS,
+						Retained: p.Retain,
+					}
+					if p.Properties != nil {
+						data.ContentType = p.Properties.ContentType
+						for _, up := range p.Properties.User {
+							data.UserProperties = append(data.UserProperties, UserProperty{Key: up.Key, Value: up.Value})
+						}
+					}
+					handle(received{
+						data: data,
+						ack:  func() error { return pr.Client.Ack(p) },
+					})
+					return true, nil
+				},
+			},
+			OnClientError: func(err error) {
+				slog.Error("MQTT client error", "err", err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				slog.Warn("MQTT broker disconnected", "reason", d.ReasonCode)
+			},
+		},
+	}
+
+	cm, err := autopaho.NewConnection(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MQTT connection: %w", err)
+	}
+	// fail at startup like MQTT 3.1.1 instead of retrying forever
+	awaitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := cm.AwaitConnection(awaitCtx); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cm.Disconnect(ctx); err != nil {
+			slog.Warn("failed to disconnect from MQTT broker", "err", err)
+		}
+	}, nil
+}
@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package auth authenticates outgoing requests. Each call of the poller can configure its own strategy.
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"opendatahub.com/multi-rest-poller/oauth"
+)
+
+// HTTPClient sends the requests needed to obtain credentials, e.g. logins
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Authenticator adds credentials to a request before it is sent
+type Authenticator interface {
+	// Apply authenticates the request, body is the already encoded request payload
+	Apply(req *http.Request, body []byte) error
+	// Invalidate drops cached credentials after the server rejected them, so that they are renewed on the next Apply
+	Invalidate()
+}
+
+// Config selects and configures an authentication strategy.
+// All string values may reference environment variables as ${NAME}, so that secrets stay out of the config file.
+type Config struct {
+	Type string `yaml:"type"` // basic | bearer | api_key | oauth2 | hmac | login
+
+	// basic
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// bearer
+	Token string `yaml:"token,omitempty"`
+
+	// api_key, and placement of the token obtained by login
+	In     string `yaml:"in,omitempty"`     // header | query, defaults to header
+	Name   string `yaml:"name,omitempty"`   // header or query param name
+	Value  string `yaml:"value,omitempty"`  // api key
+	Prefix string `yaml:"prefix,omitempty"` // e.g. "Bearer "
+
+	OAuth *oauth.Config `yaml:"oauth,omitempty"`
+	Hmac  *HmacConfig   `yaml:"hmac,omitempty"`
+	Login *LoginConfig  `yaml:"login,omitempty"`
+}
+
+// New creates the authenticator for the given config
+func New(c Config) (Authenticator, error) {
+	expandConfig(&c)
+
+	switch c.Type {
+	case "basic":
+		return &basicAuth{username: c.Username, password: c.Password}, nil
+	case "bearer":
+		return &apiKeyAuth{in: "header", name: "Authorization", value: "Bearer " + c.Token}, nil
+	case "api_key":
+		if c.Name == "" {
+			return nil, fmt.Errorf("api_key auth requires name")
+		}
+		return &apiKeyAuth{in: c.In, name: c.Name, value: c.Prefix + c.Value}, nil
+	case "oauth2":
+		if c.OAuth == nil {
+			return nil, fmt.Errorf("oauth2 auth requires the oauth section")
+		}
+		provider, err := oauth.New(*c.OAuth)
+		if err != nil {
+			return nil, err
+		}
+		return &oauthAuth{provider: provider}, nil
+	case "hmac":
+		if c.Hmac == nil {
+			return nil, fmt.Errorf("hmac auth requires the hmac section")
+		}
+		return newHmacAuth(*c.Hmac)
+	case "login":
+		if c.Login == nil {
+			return nil, fmt.Errorf("login auth requires the login section")
+		}
+		return newLoginAuth(c)
+	}
+	return nil, fmt.Errorf("unsupported auth type %q", c.Type)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) Apply(req *http.Request, body []byte) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) Invalidate() {}
+
+type apiKeyAuth struct {
+	in    string
+	name  string
+	value string
+}
+
+func (a *apiKeyAuth) Apply(req *http.Request, body []byte) error {
+	setCredential(req, a.in, a.name, a.value)
+	return nil
+}
+
+func (a *apiKeyAuth) Invalidate() {}
+
+type oauthAuth struct {
+	provider *oauth.OAuthProvider
+}
+
+func (a *oauthAuth) Apply(req *http.Request, body []byte) error {
+	token, err := a.provider.GetToken()
+	if err != nil {
+		return fmt.Errorf("could not get oauth token: %s", err.Error())
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+func (a *oauthAuth) Invalidate() {
+	a.provider.Invalidate()
+}
+
+// NewOAuth wraps an existing provider, e.g. the one configured via environment
+func NewOAuth(provider *oauth.OAuthProvider) Authenticator {
+	return &oauthAuth{provider: provider}
+}
+
+func setCredential(req *http.Request, in, name, value string) {
+	if in == "query" {
+		q := req.URL.Query()
+		q.Set(name, value)
+		req.URL.RawQuery = q.Encode()
+		return
+	}
+	req.Header.Set(name, value)
+}
+
+var envRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${NAME} references with the value of the environment variable.
+// Plain $ are left untouched, they are common in selectors.
+func expandEnv(s string) string {
+	return envRegex.ReplaceAllStringFunc(s, func(m string) string {
+		return os.Getenv(envRegex.FindStringSubmatch(m)[1])
+	})
+}
+
+func expandConfig(c *Config) {
+	for _, s := range []*string{&c.Username, &c.Password, &c.Token, &c.Value} {
+		*s = expandEnv(*s)
+	}
+	if c.OAuth != nil {
+		o := *c.OAuth
+		for _, s := range []*string{&o.TokenURL, &o.ClientID, &o.ClientSecret, &o.Username, &o.Password, &o.RefreshToken, &o.Audience} {
+			*s = expandEnv(*s)
+		}
+		c.OAuth = &o
+	}
+	if c.Hmac != nil {
+		h := *c.Hmac
+		h.Secret = expandEnv(h.Secret)
+		h.KeyID = expandEnv(h.KeyID)
+		c.Hmac = &h
+	}
+	if c.Login != nil {
+		l := *c.Login
+		l.URL = expandEnv(l.URL)
+		l.Body = expandValue(l.Body)
+		headers := make(map[string]string, len(l.Headers))
+		for k, v := range l.Headers {
+			headers[k] = expandEnv(v)
+		}
+		l.Headers = headers
+		c.Login = &l
+	}
+}
+
+// expandValue expands environment references in all strings of a body, returning a copy
+func expandValue(v any) any {
+	switch vt := v.(type) {
+	case string:
+		return expandEnv(vt)
+	case map[string]any:
+		m := make(map[string]any, len(vt))
+		for k, child := range vt {
+			m[k] = expandValue(child)
+		}
+		return m
+	case []any:
+		a := make([]any, len(vt))
+		for i, child := range vt {
+			a[i] = expandValue(child)
+		}
+		return a
+	}
+	return v
+}
@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"opendatahub.com/multi-rest-poller/oauth"
+)
+
+func TestApiKey(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "s3cr3t")
+
+	a, err := New(Config{Type: "api_key", In: "query", Name: "apikey", Value: "${TEST_API_KEY}"})
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "http://example.com/data?page=1", nil)
+	require.NoError(t, a.Apply(req, nil))
+	require.Equal(t, "s3cr3t", req.URL.Query().Get("apikey"))
+	require.Equal(t, "1", req.URL.Query().Get("page"))
+
+	a, err = New(Config{Type: "api_key", Name: "Authorization", Prefix: "Token ", Value: "$.literal"})
+	require.NoError(t, err)
+	req = httptest.NewRequest("GET", "http://example.com/data", nil)
+	require.NoError(t, a.Apply(req, nil))
+	require.Equal(t, "Token $.literal", req.Header.Get("Authorization"))
+}
+
+func TestHmac(t *testing.T) {
+	a, err := newHmacAuth(HmacConfig{Secret: "key", KeyID: "client-1"})
+	require.NoError(t, err)
+	a.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	body := []byte(`{"a":1}`)
+	req := httptest.NewRequest("POST", "http://example.com/v1/items?x=1", nil)
+	require.NoError(t, a.Apply(req, body))
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("POST\n/v1/items\nx=1\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+	require.Equal(t, hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"))
+	require.Equal(t, "1700000000", req.Header.Get("X-Timestamp"))
+	require.Equal(t, "client-1", req.Header.Get("X-Key-Id"))
+
+	_, err = New(Config{Type: "hmac", Hmac: &HmacConfig{Secret: "key", Algorithm: "md5"}})
+	require.Error(t, err)
+}
+
+func TestLoginToken(t *testing.T) {
+	t.Setenv("TEST_LOGIN_PASSWORD", "pw")
+
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, "pw", body["password"])
+		logins++
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"token": "t" + string(rune('0'+logins)), "ttl": 60}})
+	}))
+	defer srv.Close()
+
+	a, err := New(Config{Type: "login", Login: &LoginConfig{
+		URL:               srv.URL,
+		Body:              map[string]any{"user": "u", "password": "${TEST_LOGIN_PASSWORD}"},
+		TokenSelector:     "$.data.token",
+		ExpiresInSelector: "$.data.ttl",
+	}})
+	require.NoError(t, err)
+	login := a.(*loginAuth)
+	now := time.Now()
+	login.now = func() time.Time { return now }
+
+	apply := func() string {
+		req := httptest.NewRequest("GET", "http://example.com/data", nil)
+		require.NoError(t, a.Apply(req, nil))
+		return req.Header.Get("Authorization")
+	}
+
+	require.Equal(t, "Bearer t1", apply())
+	require.Equal(t, "Bearer t1", apply(), "session must be reused")
+
+	now = now.Add(61 * time.Second)
+	require.Equal(t, "Bearer t2", apply(), "expired session must be renewed")
+
+	a.Invalidate()
+	require.Equal(t, "Bearer t3", apply(), "rejected session must be renewed")
+	require.Equal(t, 3, logins)
+}
+
+func TestLoginCookie(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "u", r.PostForm.Get("user"))
+		http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: "abc"})
+	}))
+	defer srv.Close()
+
+	a, err := New(Config{Type: "login", Login: &LoginConfig{
+		URL:      srv.URL,
+		Body:     map[string]any{"user": "u"},
+		BodyType: "form",
+	}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/data", nil)
+	require.NoError(t, a.Apply(req, nil))
+	c, err := req.Cookie("SESSION")
+	require.NoError(t, err)
+	require.Equal(t, "abc", c.Value)
+	require.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestOAuthScopesAudience(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "api://poller", r.PostForm.Get("audience"))
+		require.Equal(t, "data.read", r.PostForm.Get("scope"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "bearer", "expires_in": 3600})
+	}))
+	defer srv.Close()
+
+	a, err := New(Config{Type: "oauth2", OAuth: &oauth.Config{
+		Method:       "client_credentials",
+		TokenURL:     srv.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"data.read"},
+		Audience:     "api://poller",
+	}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/data", nil)
+	require.NoError(t, a.Apply(req, nil))
+	require.Equal(t, "Bearer at", req.Header.Get("Authorization"))
+}
+
+func TestOAuthRefreshToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		require.Equal(t, "rt", r.PostForm.Get("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "bearer", "expires_in": 3600})
+	}))
+	defer srv.Close()
+
+	p, err := oauth.New(oauth.Config{Method: "refresh_token", TokenURL: srv.URL, ClientID: "id", RefreshToken: "rt"})
+	require.NoError(t, err)
+	token, err := p.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "at", token)
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStringToSign = "{method}\n{path}\n{query}\n{timestamp}\n{body_sha256}"
+
+// HmacConfig signs every request with a shared secret
+type HmacConfig struct {
+	Secret          string `yaml:"secret"`
+	Algorithm       string `yaml:"algorithm,omitempty"`        // sha256 | sha1 | sha512, defaults to sha256
+	Encoding        string `yaml:"encoding,omitempty"`         // hex | base64, defaults to hex
+	SignatureHeader string `yaml:"signature_header,omitempty"` // defaults to X-Signature
+	SignaturePrefix string `yaml:"signature_prefix,omitempty"` // e.g. "HMAC "
+	TimestampHeader string `yaml:"timestamp_header,omitempty"` // defaults to X-Timestamp, "-" to not send it
+	TimestampFormat string `yaml:"timestamp_format,omitempty"` // unix | unix_ms | rfc3339, defaults to unix
+	KeyID           string `yaml:"key_id,omitempty"`
+	KeyIDHeader     string `yaml:"key_id_header,omitempty"` // defaults to X-Key-Id
+	// StringToSign is a template with the placeholders {method} {path} {query} {timestamp} {body} {body_sha256}
+	StringToSign string `yaml:"string_to_sign,omitempty"`
+}
+
+type hmacAuth struct {
+	config  HmacConfig
+	newHash func() hash.Hash
+	now     func() time.Time
+}
+
+func newHmacAuth(c HmacConfig) (*hmacAuth, error) {
+	if c.Secret == "" {
+		return nil, fmt.Errorf("hmac auth requires secret")
+	}
+
+	a := &hmacAuth{config: c, now: time.Now}
+	switch c.Algorithm {
+	case "", "sha256":
+		a.newHash = sha256.New
+	case "sha1":
+		a.newHash = sha1.New
+	case "sha512":
+		a.newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported hmac algorithm %q", c.Algorithm)
+	}
+	if c.Encoding != "" && c.Encoding != "hex" && c.Encoding != "base64" {
+		return nil, fmt.Errorf("unsupported hmac encoding %q", c.Encoding)
+	}
+
+	if a.config.SignatureHeader == "" {
+		a.config.SignatureHeader = "X-Signature"
+	}
+	if a.config.TimestampHeader == "" {
+		a.config.TimestampHeader = "X-Timestamp"
+	}
+	if a.config.KeyIDHeader == "" {
+		a.config.KeyIDHeader = "X-Key-Id"
+	}
+	if a.config.StringToSign == "" {
+		a.config.StringToSign = defaultStringToSign
+	}
+	return a, nil
+}
+
+func (a *hmacAuth) timestamp() string {
+	now := a.now().UTC()
+	switch a.config.TimestampFormat {
+	case "unix_ms":
+		return strconv.FormatInt(now.UnixMilli(), 10)
+	case "rfc3339":
+		return now.Format(time.RFC3339)
+	}
+	return strconv.FormatInt(now.Unix(), 10)
+}
+
+func (a *hmacAuth) Apply(req *http.Request, body []byte) error {
+	ts := a.timestamp()
+	bodyHash := sha256.Sum256(body)
+
+	toSign := strings.NewReplacer(
+		"{method}", req.Method,
+		"{path}", req.URL.EscapedPath(),
+		"{query}", req.URL.RawQuery,
+		"{timestamp}", ts,
+		"{body}", string(body),
+		"{body_sha256}", hex.EncodeToString(bodyHash[:]),
+	).Replace(a.config.StringToSign)
+
+	mac := hmac.New(a.newHash, []byte(a.config.Secret))
+	mac.Write([]byte(toSign))
+	sum := mac.Sum(nil)
+
+	signature := hex.EncodeToString(sum)
+	if a.config.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+
+	req.Header.Set(a.config.SignatureHeader, a.config.SignaturePrefix+signature)
+	if a.config.TimestampHeader != "-" {
+		req.Header.Set(a.config.TimestampHeader, ts)
+	}
+	if a.config.KeyID != "" {
+		req.Header.Set(a.config.KeyIDHeader, a.config.KeyID)
+	}
+	return nil
+}
+
+// Invalidate is a no-op, signatures are computed per request
+func (a *hmacAuth) Invalidate() {}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oliveagle/jsonpath"
+)
+
+// LoginConfig describes the request that opens a session.
+// The session is either a token taken from the login response, or the cookies it sets.
+type LoginConfig struct {
+	URL      string            `yaml:"url"`
+	Method   string            `yaml:"method,omitempty"` // defaults to POST
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Body     any               `yaml:"body,omitempty"`
+	BodyType string            `yaml:"body_type,omitempty"` // json | form | raw, defaults to json
+
+	TokenSelector string `yaml:"token_selector,omitempty"` // JSONPath in the login response body
+	TokenHeader   string `yaml:"token_header,omitempty"`   // response header carrying the token, alternative to token_selector
+
+	ExpiresIn         string `yaml:"expires_in,omitempty"`          // fixed session lifetime, e.g. 55m
+	ExpiresInSelector string `yaml:"expires_in_selector,omitempty"` // JSONPath to the lifetime in seconds
+}
+
+type loginAuth struct {
+	config   LoginConfig
+	in       string
+	name     string
+	prefix   string
+	lifetime time.Duration
+
+	mu      sync.Mutex
+	token   string
+	cookies []*http.Cookie
+	expiry  time.Time
+	now     func() time.Time
+}
+
+func newLoginAuth(c Config) (*loginAuth, error) {
+	l := *c.Login
+	if l.URL == "" {
+		return nil, fmt.Errorf("login auth requires url")
+	}
+	if l.Method == "" {
+		l.Method = http.MethodPost
+	}
+
+	a := &loginAuth{
+		config: l,
+		in:     c.In,
+		name:   c.Name,
+		prefix: c.Prefix,
+		now:    time.Now,
+	}
+	if a.name == "" {
+		a.name = "Authorization"
+		if a.prefix == "" {
+			a.prefix = "Bearer "
+		}
+	}
+	if l.ExpiresIn != "" {
+		d, err := time.ParseDuration(l.ExpiresIn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login expires_in %q: %w", l.ExpiresIn, err)
+		}
+		a.lifetime = d
+	}
+	return a, nil
+}
+
+func (a *loginAuth) Apply(req *http.Request, body []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.valid() {
+		if err := a.login(); err != nil {
+			return fmt.Errorf("login to %s failed: %w", a.config.URL, err)
+		}
+	}
+
+	if a.token != "" {
+		setCredential(req, a.in, a.name, a.prefix+a.token)
+	}
+	for _, c := range a.cookies {
+		req.AddCookie(c)
+	}
+	return nil
+}
+
+func (a *loginAuth) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+	a.cookies = nil
+}
+
+func (a *loginAuth) valid() bool {
+	if a.token == "" && len(a.cookies) == 0 {
+		return false
+	}
+	return a.expiry.IsZero() || a.now().Before(a.expiry)
+}
+
+func (a *loginAuth) login() error {
+	slog.Info("opening session", "url", a.config.URL)
+
+	body, contentType, err := a.encodeBody()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(a.config.Method, a.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range a.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("login returned status %d", resp.StatusCode)
+	}
+
+	token, lifetime := "", a.lifetime
+	if a.config.TokenHeader != "" {
+		token = resp.Header.Get(a.config.TokenHeader)
+		if token == "" {
+			return fmt.Errorf("login response has no %s header", a.config.TokenHeader)
+		}
+	}
+	if a.config.TokenSelector != "" || a.config.ExpiresInSelector != "" {
+		var data any
+		if err := json.Unmarshal(res, &data); err != nil {
+			return fmt.Errorf("could not parse login response: %w", err)
+		}
+		if a.config.TokenSelector != "" {
+			v, err := jsonpath.JsonPathLookup(data, a.config.TokenSelector)
+			if err != nil {
+				return fmt.Errorf("token not found in login response: %w", err)
+			}
+			token = fmt.Sprintf("%v", v)
+		}
+		if a.config.ExpiresInSelector != "" {
+			v, err := jsonpath.JsonPathLookup(data, a.config.ExpiresInSelector)
+			if err != nil {
+				return fmt.Errorf("expiry not found in login response: %w", err)
+			}
+			secs, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
+			if err != nil {
+				return fmt.Errorf("invalid expiry in login response: %w", err)
+			}
+			lifetime = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	cookies := resp.Cookies()
+	if token == "" && len(cookies) == 0 {
+		return fmt.Errorf("login response contains neither token nor session cookies")
+	}
+
+	a.token = token
+	// session cookies are only needed without token
+	a.cookies = nil
+	if token == "" {
+		a.cookies = cookies
+	}
+	a.expiry = time.Time{}
+	if lifetime > 0 {
+		a.expiry = a.now().Add(lifetime)
+	}
+	return nil
+}
+
+func (a *loginAuth) encodeBody() ([]byte, string, error) {
+	switch b := a.config.Body.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return []byte(b), "", nil
+	}
+
+	switch a.config.BodyType {
+	case "form":
+		m, ok := a.config.Body.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("form login body must be an object")
+		}
+		form := url.Values{}
+		for k, v := range m {
+			form.Set(k, fmt.Sprintf("%v", v))
+		}
+		return []byte(form.Encode()), "application/x-www-form-urlencoded", nil
+	case "", "json":
+		j, err := json.Marshal(a.config.Body)
+		return j, "application/json", err
+	}
+	return nil, "", fmt.Errorf("unsupported login body_type %q", a.config.BodyType)
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"opendatahub.com/multi-rest-poller/auth"
+	"opendatahub.com/multi-rest-poller/oauth"
+)
+
+// authenticators are kept over polls, so that tokens and sessions are reused until they expire
+var authenticators = map[*auth.Config]auth.Authenticator{}
+var defaultAuthenticator auth.Authenticator = nil
+var authenticatorsMu sync.Mutex
+
+// authenticatorFor returns the authenticator of a call, or the one configured via AUTH_STRATEGY if the call has none.
+// Returns nil if requests are not authenticated.
+func authenticatorFor(config CallConfig) (auth.Authenticator, error) {
+	authenticatorsMu.Lock()
+	defer authenticatorsMu.Unlock()
+
+	if config.Auth == nil {
+		if defaultAuthenticator == nil {
+			defaultAuthenticator = envAuthenticator()
+		}
+		return defaultAuthenticator, nil
+	}
+
+	if a, ok := authenticators[config.Auth]; ok {
+		return a, nil
+	}
+	a, err := auth.New(*config.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth config for url %s: %w", config.URL, err)
+	}
+	authenticators[config.Auth] = a
+	return a, nil
+}
+
+func envAuthenticator() auth.Authenticator {
+	switch env.AUTH_STRATEGY {
+	case "oauth2":
+		return auth.NewOAuth(oauth.NewOAuthProvider())
+	case "basic":
+		a, _ := auth.New(auth.Config{Type: "basic", Username: env.BASIC_AUTH_USERNAME, Password: env.BASIC_AUTH_PASSWORD})
+		return a
+	case "bearer":
+		a, _ := auth.New(auth.Config{Type: "bearer", Token: env.AUTH_BEARER_TOKEN})
+		return a
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"opendatahub.com/multi-rest-poller/auth"
+)
+
+func TestNestedCallsInheritAuth(t *testing.T) {
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			logins++
+			json.NewEncoder(w).Encode(map[string]any{"token": map[int]string{1: "expired", 2: "fresh"}[logins]})
+			return
+		case "/public":
+			require.Empty(t, r.Header.Get("Authorization"))
+			require.Equal(t, "k", r.URL.Query().Get("key"))
+			json.NewEncoder(w).Encode(map[string]any{"ok": true})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/items" {
+			json.NewEncoder(w).Encode([]any{map[string]any{"id": "a"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"detail": r.URL.Path})
+	}))
+	defer srv.Close()
+
+	config := &RootConfig{Call: &CallConfig{
+		URL:              srv.URL + "/items",
+		DataSelectorType: "json",
+		Auth: &auth.Config{Type: "login", Login: &auth.LoginConfig{
+			URL:           srv.URL + "/login",
+			TokenSelector: "$.token",
+		}},
+		NestedCalls: []CallConfig{
+			{
+				URL:               srv.URL + "/items/%s",
+				DataSelectorType:  "json",
+				ParamSelectorType: "json",
+				ParamSelectors:    []string{"$.id"},
+				DataDestination:   "detail",
+			},
+			{
+				URL:              srv.URL + "/public",
+				DataSelectorType: "json",
+				DataDestination:  "public",
+				Auth:             &auth.Config{Type: "api_key", In: "query", Name: "key", Value: "k"},
+			},
+		},
+	}}
+
+	data, err := Poll(config, nil)
+	require.NoError(t, err)
+	item := data.([]any)[0].(map[string]any)
+	require.Equal(t, map[string]any{"detail": "/items/a"}, item["detail"])
+	require.Equal(t, map[string]any{"ok": true}, item["public"])
+
+	// the first token was rejected and renewed once, afterwards the session is reused
+	require.Equal(t, 2, logins)
+}
@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// templateVerb matches the format verbs that insert params into a template, and escaped percent signs.
+// Any other % is literal text, e.g. in "100%"
+var templateVerb = regexp.MustCompile(`%%|%(\[([1-9][0-9]*)\])?[sdvq]`)
+
+// bodyType returns the effective encoding of the configured body.
+// When body_type is not set, string bodies are sent raw and objects as json.
+func bodyType(config CallConfig) string {
+	if config.BodyType != "" {
+		return config.BodyType
+	}
+	if _, ok := config.Body.(string); ok {
+		return "raw"
+	}
+	return "json"
+}
+
+// requestMethod defaults to POST when a body is configured and no method is set.
+func requestMethod(config CallConfig) string {
+	if config.Method == "" && config.Body != nil {
+		return "POST"
+	}
+	return config.Method
+}
+
+// fillTemplate fills the verbs of tmpl with the next params and returns the params left for the following templates.
+// Explicit indexes like %[2]s refer to all params and consume none. A string without verbs is kept as it is
+func fillTemplate(tmpl string, params []any) (string, []any, error) {
+	var format strings.Builder
+	verbs, indexed, last := 0, false, 0
+	for _, loc := range templateVerb.FindAllStringSubmatchIndex(tmpl, -1) {
+		format.WriteString(strings.ReplaceAll(tmpl[last:loc[0]], "%", "%%"))
+		format.WriteString(tmpl[loc[0]:loc[1]])
+		last = loc[1]
+		switch {
+		case tmpl[loc[0]:loc[1]] == "%%":
+		case loc[4] >= 0:
+			indexed = true
+			if i, _ := strconv.Atoi(tmpl[loc[4]:loc[5]]); i > len(params) {
+				return "", nil, fmt.Errorf("template %q refers to parameter %d, but only %d are selected", tmpl, i, len(params))
+			}
+		default:
+			verbs++
+		}
+	}
+	format.WriteString(strings.ReplaceAll(tmpl[last:], "%", "%%"))
+
+	switch {
+	case indexed && verbs > 0:
+		return "", nil, fmt.Errorf("template %q mixes indexed and sequential verbs", tmpl)
+	case indexed:
+		return fmt.Sprintf(format.String(), params...), params, nil
+	case verbs == 0:
+		return tmpl, params, nil
+	case verbs > len(params):
+		return "", nil, fmt.Errorf("template %q needs %d parameters, but only %d are left", tmpl, verbs, len(params))
+	}
+	return fmt.Sprintf(format.String(), params[:verbs]...), params[verbs:], nil
+}
+
+// templateBody fills the format verbs of the string leaves of the body with params, continuing after the params
+// the url consumed. Leaves are visited in sorted key order, which is also the order of the encoded json body.
+// It returns the params no leaf consumed
+func templateBody(body any, params []any) (any, []any, error) {
+	if body == nil || len(params) == 0 {
+		return body, params, nil
+	}
+
+	// walk a copy, nested calls may template the same config concurrently
+	body = cloneBody(body)
+	var err error
+	body = walkLeaves(body, func(s string) string {
+		if err != nil {
+			return s
+		}
+		s, params, err = fillTemplate(s, params)
+		return s
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, params, nil
+}
+
+// walkLeaves calls fn on every string of the body in a deterministic order and stores its result.
+func walkLeaves(node any, fn func(string) string) any {
+	switch v := node.(type) {
+	case string:
+		return fn(v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v[k] = walkLeaves(v[k], fn)
+		}
+	case []any:
+		for i := range v {
+			v[i] = walkLeaves(v[i], fn)
+		}
+	}
+	return node
+}
+
+// cloneBody deep copies maps and slices so templating never mutates the shared config.
+func cloneBody(body any) any {
+	switch v := body.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for k, child := range v {
+			clone[k] = cloneBody(child)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, child := range v {
+			clone[i] = cloneBody(child)
+		}
+		return clone
+	}
+	return body
+}
+
+// setBodyField injects value into the body at key, a dot separated path for json bodies.
+// A call without body gets a new json object holding just the value.
+func setBodyField(config CallConfig, key string, value any) (any, error) {
+	body := cloneBody(config.Body)
+	if body == nil {
+		body = map[string]any{}
+	}
+
+	obj, ok := body.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("request strategy 'body' requires a json or form body, got %s", bodyType(config))
+	}
+
+	if bodyType(config) == "form" {
+		obj[key] = fmt.Sprintf("%v", value)
+		return obj, nil
+	}
+
+	path := strings.Split(key, ".")
+	current := obj
+	for _, p := range path[:len(path)-1] {
+		next, ok := current[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[p] = next
+		}
+		current = next
+	}
+	current[path[len(path)-1]] = value
+	return obj, nil
+}
+
+// encodeBody serializes the body according to its body_type and returns it with the matching content type.
+func encodeBody(config CallConfig, body any) ([]byte, string, error) {
+	if body == nil {
+		return nil, "", nil
+	}
+
+	contentType := ""
+	switch bodyType(config) {
+	case "raw":
+		contentType = "text/plain"
+	case "json":
+		contentType = "application/json"
+	case "form":
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		return nil, "", fmt.Errorf("unsupported body_type %q", config.BodyType)
+	}
+
+	// string bodies are already encoded templates
+	if s, ok := body.(string); ok {
+		return []byte(s), contentType, nil
+	}
+
+	switch bodyType(config) {
+	case "json":
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("error marshalling json body: %w", err)
+		}
+		return b, contentType, nil
+	case "form":
+		obj, ok := body.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("form body must be an object")
+		}
+		values := url.Values{}
+		for k, v := range obj {
+			values.Set(k, fmt.Sprintf("%v", v))
+		}
+		return []byte(values.Encode()), contentType, nil
+	}
+	return nil, "", fmt.Errorf("raw body must be a string")
+}
@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"opendatahub.com/multi-rest-poller/auth"
+	"opendatahub.com/multi-rest-poller/fixture"
+	"opendatahub.com/multi-rest-poller/oauth"
+)
+
+const cliUsage = `Usage: multi-rest-poller <command> [flags]
+
+Without command the collector polls on the CRON schedule and publishes to the broker.
+
+Commands:
+  check     validate a call config
+  run-once  poll once without broker and print the payloads
+
+Run 'multi-rest-poller <command> -h' for the flags of a command.
+`
+
+// runCLI executes a CLI command and returns the exit code
+func runCLI(args []string) int {
+	switch args[0] {
+	case "check":
+		return runCheck(args[1:], os.Stdout, os.Stderr)
+	case "run-once":
+		return runOnce(args[1:], os.Stdout, os.Stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+	return 2
+}
+
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", os.Getenv("HTTP_CONFIG_PATH"), "call config to validate")
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+
+	if _, err := LoadConfigStrict(*configPath); err != nil {
+		fmt.Fprintf(stderr, "%s: invalid config:\n%s\n", *configPath, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: ok\n", *configPath)
+	return 0
+}
+
+func runOnce(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("run-once", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", os.Getenv("HTTP_CONFIG_PATH"), "call config to poll")
+	outDir := flags.String("out", "", "write each payload to a file in this directory instead of stdout")
+	record := flags.String("record", "", "record all HTTP exchanges to this fixture file")
+	replay := flags.String("replay", "", "answer HTTP requests from this fixture file instead of the network")
+	commit := flags.Bool("commit-watermarks", false, "store the watermarks of the poll, like a published poll would")
+	if err := flags.Parse(args); err != nil {
+		return parseExitCode(err)
+	}
+	fail := func(format string, args ...any) int {
+		fmt.Fprintf(stderr, format+"\n", args...)
+		return 1
+	}
+
+	// only the poller settings, there is no broker
+	if err := envconfig.Process("", &env.pollerEnv); err != nil {
+		return fail("invalid environment: %s", err)
+	}
+
+	config, err := LoadConfigStrict(*configPath)
+	if err != nil {
+		return fail("%s: invalid config:\n%s", *configPath, err)
+	}
+
+	var recorder *fixture.Recorder
+	switch {
+	case *record != "" && *replay != "":
+		return fail("--record and --replay are mutually exclusive")
+	case *record != "":
+		recorder = &fixture.Recorder{SecretParams: secretParams(*config)}
+		defer useTransport(recorder, recorder.Credentials())()
+	case *replay != "":
+		exchanges, err := fixture.Load(*replay)
+		if err != nil {
+			return fail("%s", err)
+		}
+		replayer := fixture.NewReplayer(exchanges)
+		replayer.SecretParams = secretParams(*config)
+		defer useTransport(replayer, replayer.Credentials())()
+	}
+
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			return fail("failed to create output directory: %s", err)
+		}
+	}
+	ext := ".txt"
+	if isStructured(config.SelectorType()) {
+		ext = ".json"
+	}
+
+	encoder := GetEncoder(*config)
+	payloads := 0
+	write := func(d any, name string) error {
+		enc, err := encoder(d)
+		if err != nil {
+			return fmt.Errorf("failed to encode data: %w", err)
+		}
+		payloads++
+		if *outDir == "" {
+			_, err = fmt.Fprintln(stdout, enc)
+			return err
+		}
+		return os.WriteFile(filepath.Join(*outDir, name+ext), []byte(enc), 0o644)
+	}
+
+	stream := make(chan any)
+	streamErr := make(chan error, 1)
+	go func() {
+		var err error
+		for d := range stream {
+			if err == nil {
+				err = write(d, fmt.Sprintf("stream-%04d", payloads+1))
+			}
+		}
+		streamErr <- err
+	}()
+
+	data, err := Poll(config, stream)
+	close(stream)
+	if serr := <-streamErr; serr != nil && err == nil {
+		err = serr
+	}
+
+	if recorder != nil {
+		if serr := fixture.Save(*record, recorder.Exchanges()); serr != nil {
+			return fail("failed to save fixture: %s", serr)
+		}
+		fmt.Fprintf(stderr, "recorded %d exchanges to %s\n", len(recorder.Exchanges()), *record)
+	}
+	if err != nil {
+		return fail("failed to poll: %s", err)
+	}
+
+	if data != nil {
+		if err := write(data, "payload"); err != nil {
+			return fail("%s", err)
+		}
+	}
+	if *commit {
+		if err := CommitWatermarks(); err != nil {
+			return fail("failed to store watermarks: %s", err)
+		}
+	}
+
+	fmt.Fprintf(stderr, "poll produced %d payloads\n", payloads)
+	return 0
+}
+
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// useTransport routes the requests of the poller through the transport, and the requests obtaining credentials through
+// credentials. It returns a function restoring the defaults
+func useTransport(t, credentials http.RoundTripper) func() {
+	prevTransport, prevAuth, prevOAuth := httpTransport, auth.HTTPClient, oauth.HTTPClient
+
+	client := &http.Client{Transport: credentials, Timeout: 30 * time.Second}
+	httpTransport, auth.HTTPClient, oauth.HTTPClient = t, client, client
+
+	return func() {
+		httpTransport, auth.HTTPClient, oauth.HTTPClient = prevTransport, prevAuth, prevOAuth
+	}
+}
+
+// secretParams returns the query parameters the configured auth strategies put credentials in
+func secretParams(config RootConfig) []string {
+	params := []string{}
+	var walk func(calls []CallConfig)
+	walk = func(calls []CallConfig) {
+		for _, c := range calls {
+			if c.Auth != nil && c.Auth.In == "query" && !slices.Contains(params, c.Auth.Name) {
+				params = append(params, c.Auth.Name)
+			}
+			walk(c.NestedCalls)
+		}
+	}
+	if config.Call != nil {
+		walk([]CallConfig{*config.Call})
+	} else {
+		walk(config.MultipleRootCalls.NestedCalls)
+	}
+	return params
+}
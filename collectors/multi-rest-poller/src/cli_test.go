@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(yaml), 0o644))
+	return path
+}
+
+func TestCheckShippedConfigs(t *testing.T) {
+	files, err := filepath.Glob("../infrastructure/http_config/*.yaml")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, f := range files {
+		_, err := LoadConfigStrict(f)
+		require.NoError(t, err, f)
+	}
+}
+
+func TestCheckReportsMistakes(t *testing.T) {
+	path := writeConfig(t, `
+http_call:
+  url: https://example.com/items
+  data_selector_type: json
+  data_selectr: $.items
+`)
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 1, runCheck([]string{"--config", path}, &stdout, &stderr))
+	require.Contains(t, stderr.String(), "field data_selectr not found")
+
+	path = writeConfig(t, `
+http_call:
+  url: https://example.com/items
+  data_selector_type: json
+  data_selector: $.items[
+  pagination:
+    request_strategy: query
+    lookup_strategy: body
+    request_key: page
+  nested_calls:
+    - url: https://example.com/items/%s
+      param_selector_type: json
+      param_selectors: [$.id]
+`)
+	stderr.Reset()
+	require.Equal(t, 1, runCheck([]string{"--config", path}, &stdout, &stderr))
+	out := stderr.String()
+	require.Contains(t, out, "http_call.data_selector: invalid JSONPath")
+	require.Contains(t, out, "http_call.pagination.offset_builder.next_field: is required for lookup_strategy 'body'")
+	require.Contains(t, out, "http_call.nested_calls[0].data_destination_field: is required")
+
+	path = writeConfig(t, `
+http_call:
+  url: https://example.com/items
+  data_selector_type: json
+  data_selector: $.items
+`)
+	stdout.Reset()
+	require.Equal(t, 0, runCheck([]string{"--config", path}, &stdout, &stderr))
+	require.Contains(t, stdout.String(), "ok")
+}
+
+func TestRunOnceRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items" {
+			json.NewEncoder(w).Encode(map[string]any{"items": []any{map[string]any{"id": "a"}, map[string]any{"id": "b"}}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"name": strings.TrimPrefix(r.URL.Path, "/items/")})
+	}))
+
+	path := writeConfig(t, `
+http_call:
+  url: `+srv.URL+`/items
+  data_selector_type: json
+  data_selector: $.items
+  nested_calls:
+    - url: `+srv.URL+`/items/%s
+      data_selector_type: json
+      param_selector_type: json
+      param_selectors: [$.id]
+      data_destination_field: detail
+`)
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+
+	var recorded, stderr bytes.Buffer
+	require.Equal(t, 0, runOnce([]string{"--config", path, "--record", fixture}, &recorded, &stderr), stderr.String())
+	require.JSONEq(t, `[{"id":"a","detail":{"name":"a"}},{"id":"b","detail":{"name":"b"}}]`, recorded.String())
+
+	// offline
+	srv.Close()
+
+	var replayed bytes.Buffer
+	require.Equal(t, 0, runOnce([]string{"--config", path, "--replay", fixture}, &replayed, &stderr), stderr.String())
+	require.Equal(t, recorded.String(), replayed.String())
+
+	out := t.TempDir()
+	require.Equal(t, 0, runOnce([]string{"--config", path, "--replay", fixture, "--out", out}, &replayed, &stderr), stderr.String())
+	written, err := os.ReadFile(filepath.Join(out, "payload.json"))
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(recorded.String()), string(written))
+}
+
+func TestRecordRedactsCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.URL.Path {
+		case "/login":
+			require.Equal(t, "s3cret-password", r.PostForm.Get("pass"))
+			json.NewEncoder(w).Encode(map[string]any{"session": "session-token-1", "ttl": 3600})
+		case "/token":
+			_, secret, _ := r.BasicAuth()
+			require.Equal(t, "s3cret-client", secret+r.PostForm.Get("client_secret"))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "access-token-1", "token_type": "Bearer", "expires_in": 3600})
+		case "/items":
+			require.Equal(t, "session-token-1", r.Form.Get("sid"))
+			json.NewEncoder(w).Encode(map[string]any{"items": []any{map[string]any{"id": "a"}}})
+		default:
+			require.Equal(t, "Bearer access-token-1", r.Header.Get("Authorization"))
+			require.Equal(t, "s3cret-key", r.Form.Get("api_key"))
+			json.NewEncoder(w).Encode(map[string]any{"name": strings.TrimPrefix(r.URL.Path, "/items/")})
+		}
+	}))
+
+	t.Setenv("TEST_LOGIN_PASSWORD", "s3cret-password")
+	t.Setenv("TEST_CLIENT_SECRET", "s3cret-client")
+	path := writeConfig(t, `
+http_call:
+  url: `+srv.URL+`/items
+  data_selector_type: json
+  data_selector: $.items
+  auth:
+    type: login
+    in: query
+    name: sid
+    login:
+      url: `+srv.URL+`/login
+      body_type: form
+      body:
+        user: poller
+        pass: ${TEST_LOGIN_PASSWORD}
+      token_selector: $.session
+      expires_in_selector: $.ttl
+  nested_calls:
+    - url: `+srv.URL+`/items/%s?api_key=s3cret-key
+      data_selector_type: json
+      param_selector_type: json
+      param_selectors: [$.id]
+      data_destination_field: detail
+      auth:
+        type: oauth2
+        oauth:
+          method: client_credentials
+          token_url: `+srv.URL+`/token
+          client_id: poller
+          client_secret: ${TEST_CLIENT_SECRET}
+`)
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+
+	var recorded, stderr bytes.Buffer
+	require.Equal(t, 0, runOnce([]string{"--config", path, "--record", fixture}, &recorded, &stderr), stderr.String())
+
+	written, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+	for _, secret := range []string{"s3cret-password", "s3cret-client", "s3cret-key", "session-token-1", "access-token-1", "poller"} {
+		require.NotContains(t, string(written), secret)
+	}
+
+	// the redacted fixture still replays offline, with other credentials
+	srv.Close()
+	t.Setenv("TEST_LOGIN_PASSWORD", "")
+	t.Setenv("TEST_CLIENT_SECRET", "")
+	var replayed bytes.Buffer
+	require.Equal(t, 0, runOnce([]string{"--config", path, "--replay", fixture}, &replayed, &stderr), stderr.String())
+	require.Equal(t, recorded.String(), replayed.String())
+}
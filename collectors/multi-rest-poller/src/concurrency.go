@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst,omitempty"` // defaults to 1
+}
+
+// throttle delays requests to an endpoint, according to its rate limit and to the Retry-After of 429 responses.
+// It is shared by all workers calling the same endpoint.
+type throttle struct {
+	limiter *rate.Limiter // nil if not rate limited
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// throttles are keyed by rate limit config, or by host for calls without rate limit
+var throttles = map[any]*throttle{}
+var throttlesMu sync.Mutex
+
+func throttleFor(config CallConfig, url string) *throttle {
+	throttlesMu.Lock()
+	defer throttlesMu.Unlock()
+
+	var key any = config.RateLimit
+	if config.RateLimit == nil {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return &throttle{}
+		}
+		key = u.Host
+	}
+
+	if t, ok := throttles[key]; ok {
+		return t
+	}
+	t := &throttle{}
+	if rl := config.RateLimit; rl != nil && rl.RequestsPerSecond > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(rl.RequestsPerSecond), max(rl.Burst, 1))
+	}
+	throttles[key] = t
+	return t
+}
+
+// wait blocks until a request may be sent
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	pause := time.Until(t.until)
+	t.mu.Unlock()
+
+	if pause > 0 {
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if t.limiter != nil {
+		return t.limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// observe pauses all requests to the endpoint when the server asks to back off
+func (t *throttle) observe(resp *http.Response) {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	pause, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return
+	}
+	slog.Warn("rate limited by server, pausing requests", "url", resp.Request.URL.Host, "retry_after", pause)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(pause); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, given either as seconds or as HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+	if date, err := http.ParseTime(v); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// forEachItem calls fn for every index, with up to maxConcurrency calls in parallel.
+// The first error by index is returned, items after a failure are not started anymore.
+func forEachItem(n, maxConcurrency int, fn func(i int) error) error {
+	if maxConcurrency <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrency)
+
+	for i := 0; i < n && !failed.Load(); i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				errs[i] = err
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stationsConfig(url string) *RootConfig {
+	return &RootConfig{Call: &CallConfig{
+		URL:              url + "/stations",
+		DataSelectorType: "json",
+		NestedCalls: []CallConfig{{
+			URL:               url + "/stations/%s",
+			DataSelectorType:  "json",
+			ParamSelectorType: "json",
+			ParamSelectors:    []string{"$.id"},
+			DataDestination:   "detail",
+		}},
+	}}
+}
+
+func TestNestedCallsConcurrentOrder(t *testing.T) {
+	var running, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stations" {
+			stations := []any{}
+			for i := 0; i < 40; i++ {
+				stations = append(stations, map[string]any{"id": fmt.Sprint(i)})
+			}
+			json.NewEncoder(w).Encode(stations)
+			return
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]any{"name": "station " + strings.TrimPrefix(r.URL.Path, "/stations/")})
+	}))
+	defer srv.Close()
+
+	sequential, err := Poll(stationsConfig(srv.URL), nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), peak.Load())
+
+	config := stationsConfig(srv.URL)
+	config.Call.MaxConcurrency = 8
+	concurrent, err := Poll(config, nil)
+	require.NoError(t, err)
+	require.LessOrEqual(t, peak.Load(), int32(8))
+	require.Greater(t, peak.Load(), int32(1))
+
+	// downstream must see the identical payload
+	a, _ := json.Marshal(sequential)
+	b, _ := json.Marshal(concurrent)
+	require.JSONEq(t, string(a), string(b))
+	require.Equal(t, "station 39", concurrent.([]any)[39].(map[string]any)["detail"].(map[string]any)["name"])
+}
+
+func TestNestedCallsRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stations" {
+			json.NewEncoder(w).Encode([]any{
+				map[string]any{"id": "a"}, map[string]any{"id": "b"}, map[string]any{"id": "c"},
+				map[string]any{"id": "d"}, map[string]any{"id": "e"}, map[string]any{"id": "f"},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{})
+	}))
+	defer srv.Close()
+
+	config := stationsConfig(srv.URL)
+	config.Call.MaxConcurrency = 6
+	config.Call.NestedCalls[0].RateLimit = &RateLimit{RequestsPerSecond: 20, Burst: 2}
+
+	start := time.Now()
+	_, err := Poll(config, nil)
+	require.NoError(t, err)
+	// 2 requests of the burst are immediate, the other 4 are spaced by 50ms
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+}
+
+func TestRetryAfterPausesEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	limited := false
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stations" {
+			json.NewEncoder(w).Encode([]any{map[string]any{"id": "a"}, map[string]any{"id": "b"}, map[string]any{"id": "c"}})
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !limited {
+			limited = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		times = append(times, time.Now())
+		json.NewEncoder(w).Encode(map[string]any{})
+	}))
+	defer srv.Close()
+
+	config := stationsConfig(srv.URL)
+	config.Call.NestedCalls[0].RateLimit = &RateLimit{RequestsPerSecond: 100}
+	// the retry of the first item and the requests of the other items must all wait for Retry-After
+	start := time.Now()
+	_, err := Poll(config, nil)
+	require.NoError(t, err)
+	require.Len(t, times, 3)
+	for _, ts := range times {
+		require.GreaterOrEqual(t, ts.Sub(start), 900*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("120", now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, d)
+
+	d, ok = parseRetryAfter("Wed, 01 May 2024 10:00:30 GMT", now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, d)
+
+	_, ok = parseRetryAfter("soon", now)
+	require.False(t, ok)
+}
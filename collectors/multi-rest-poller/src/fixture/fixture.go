@@ -0,0 +1,302 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package fixture records HTTP exchanges to a file and replays them, so that configs can be tested offline.
+package fixture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces credentials in the recorded exchanges
+const Redacted = "REDACTED"
+
+// secretParams are the query parameters that carry credentials with any api
+var secretParams = []string{"access_token", "api_key", "apikey", "client_secret", "password", "secret", "signature", "token"}
+
+// Exchange is a recorded request with its response.
+// Request headers are not recorded, they usually carry credentials. Neither are the credentials in query parameters
+// and the requests obtaining credentials, see Recorder.Credentials
+type Exchange struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	RequestBody string `json:"request_body,omitempty"`
+	// Credentials marks a request obtaining credentials, e.g. a login
+	Credentials bool        `json:"credentials,omitempty"`
+	Status      int         `json:"status"`
+	Headers     http.Header `json:"headers,omitempty"`
+	Body        string      `json:"body"`
+}
+
+func (e Exchange) key() string {
+	if e.Credentials {
+		return "credentials " + e.Method + " " + e.URL
+	}
+	return e.Method + " " + e.URL + "\n" + e.RequestBody
+}
+
+// request returns the exchange of req as it is recorded, with the credentials redacted.
+// Requests obtaining credentials are recorded without query and body, they usually are the credentials
+func request(req *http.Request, body string, credentials bool, params []string) Exchange {
+	u := *req.URL
+	u.User = nil
+	if credentials {
+		u.RawQuery = ""
+		return Exchange{Method: req.Method, URL: u.String(), Credentials: true}
+	}
+
+	q := u.Query()
+	redacted := false
+	for name, values := range q {
+		if slices.Contains(secretParams, strings.ToLower(name)) || slices.Contains(params, name) {
+			for i := range values {
+				values[i] = Redacted
+			}
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = q.Encode()
+	}
+	return Exchange{Method: req.Method, URL: u.String(), RequestBody: body}
+}
+
+// redactResponse replaces the strings of a response that obtained credentials, e.g. tokens, session ids and cookies.
+// Numbers like lifetimes are kept, so that the credentials expire the same way on replay
+func redactResponse(header http.Header, body string) (http.Header, string) {
+	redacted := http.Header{}
+	for name, values := range header {
+		for _, v := range values {
+			switch name {
+			case "Content-Type":
+			case "Set-Cookie":
+				cookieName, _, _ := strings.Cut(v, "=")
+				v = cookieName + "=" + Redacted
+			default:
+				v = Redacted
+			}
+			redacted.Add(name, v)
+		}
+	}
+
+	if body == "" {
+		return redacted, body
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(body)
+		if err == nil {
+			for _, vs := range values {
+				for i := range vs {
+					vs[i] = Redacted
+				}
+			}
+			return redacted, values.Encode()
+		}
+	}
+	var tree any
+	if err := json.Unmarshal([]byte(body), &tree); err != nil {
+		return redacted, Redacted
+	}
+	b, err := json.Marshal(redactStrings(tree))
+	if err != nil {
+		return redacted, Redacted
+	}
+	return redacted, string(b)
+}
+
+func redactStrings(node any) any {
+	switch v := node.(type) {
+	case string:
+		return Redacted
+	case map[string]any:
+		for k, child := range v {
+			v[k] = redactStrings(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redactStrings(child)
+		}
+	}
+	return node
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Load reads the exchanges of a fixture file
+func Load(path string) ([]Exchange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture %s: %w", path, err)
+	}
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return exchanges, nil
+}
+
+// Save writes exchanges to a fixture file
+func Save(path string, exchanges []Exchange) error {
+	data, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readBody(r io.ReadCloser) (string, io.ReadCloser, error) {
+	if r == nil || r == http.NoBody {
+		return "", r, nil
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(b), io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Recorder is a transport that performs requests and records them
+type Recorder struct {
+	Transport http.RoundTripper // defaults to http.DefaultTransport
+	// SecretParams are further query parameters whose values are redacted, e.g. the configured api key parameters
+	SecretParams []string
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.record(req, false)
+}
+
+// Credentials returns the transport for the requests that obtain credentials, e.g. logins and token requests.
+// They are recorded without query and body, and with the strings of the response redacted
+func (r *Recorder) Credentials() http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return r.record(req, true)
+	})
+}
+
+func (r *Recorder) record(req *http.Request, credentials bool) (*http.Response, error) {
+	reqBody, body, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = body
+
+	e := request(req, reqBody, credentials, r.SecretParams)
+	e.Status = resp.StatusCode
+	e.Headers, e.Body = resp.Header.Clone(), resBody
+	if credentials {
+		e.Headers, e.Body = redactResponse(resp.Header, resBody)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exchanges = append(r.exchanges, e)
+	return resp, nil
+}
+
+// Exchanges returns the recorded exchanges in request order
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Replayer is a transport answering requests from recorded exchanges without network access.
+// Identical requests are answered in recording order, the last answer is repeated once they are used up.
+// Credentials are redacted like by the Recorder before a request is matched
+type Replayer struct {
+	// SecretParams are further query parameters whose values are redacted, as when recording
+	SecretParams []string
+
+	mu        sync.Mutex
+	exchanges map[string][]Exchange
+}
+
+func NewReplayer(exchanges []Exchange) *Replayer {
+	r := &Replayer{exchanges: map[string][]Exchange{}}
+	for _, e := range exchanges {
+		r.exchanges[e.key()] = append(r.exchanges[e.key()], e)
+	}
+	return r
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.replay(req, false)
+}
+
+// Credentials returns the transport answering the requests that obtain credentials, see Recorder.Credentials
+func (r *Replayer) Credentials() http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return r.replay(req, true)
+	})
+}
+
+func (r *Replayer) replay(req *http.Request, credentials bool) (*http.Response, error) {
+	reqBody, _, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	key := request(req, reqBody, credentials, r.SecretParams).key()
+
+	r.mu.Lock()
+	recorded := r.exchanges[key]
+	if len(recorded) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL.Redacted())
+	}
+	e := recorded[0]
+	if len(recorded) > 1 {
+		r.exchanges[key] = recorded[1:]
+	}
+	r.mu.Unlock()
+
+	headers := e.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          io.NopCloser(bytes.NewReader([]byte(e.Body))),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}, nil
+}
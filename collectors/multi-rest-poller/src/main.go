@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
 	"time"
 
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/dc"
@@ -24,6 +25,11 @@ var env struct {
 	dc.Env
 	CRON string
 
+	pollerEnv
+}
+
+// pollerEnv holds the settings needed to poll, also without broker
+type pollerEnv struct {
 	HTTP_CONFIG_PATH string
 
 	PAGING_PARAM_TYPE  string // query, header, path...
@@ -37,9 +43,16 @@ var env struct {
 	BASIC_AUTH_PASSWORD string
 
 	AUTH_BEARER_TOKEN string
+
+	WATERMARK_STORE_PATH string
 }
 
 func main() {
+	// CLI mode: check|run-once --config x.yaml
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	ms.InitWithEnv(context.Background(), "", &env)
 	slog.Info("Starting data collector...")
 
@@ -51,7 +64,7 @@ func main() {
 	ms.FailOnError(context.Background(), err, "failed to load call config")
 
 	contentType := ""
-	if config.SelectorType() == "json" {
+	if isStructured(config.SelectorType()) {
 		contentType = "application/json"
 	}
 
@@ -68,51 +81,44 @@ func main() {
 
 		logger.Get(ctx).Debug("collecting")
 
-		// Create cancelable context for the job
-		streamCtx, cancel := context.WithCancel(ctx)
-		defer cancel()
-
 		stream_channel := make(chan any, 1)
+		// closed when every streamed item has been published
+		streamed := make(chan struct{})
 
 		go func(ctx context.Context) {
-			defer close(stream_channel)
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case stream_d, ok := <-stream_channel:
-					if !ok {
-						return
-					}
-					// streamed results
-					enc_data, err := encoder(stream_d)
-					ms.FailOnError(ctx, err, "failed to encode data", "err", err, "data", stream_d)
-
-					pubCtx := ctx
-					var pubSpan trace.Span = noop.Span{}
-					// link span without full trace
-					rootContext := trace.SpanContextFromContext(ctx)
-					if rootContext.IsValid() {
-						pubCtx, pubSpan = tel.TraceStart(context.Background(), fmt.Sprintf("%s.data-stream", tel.GetServiceName()),
-							trace.WithLinks(trace.Link{
-								SpanContext: rootContext,
-							}),
-							trace.WithSpanKind(trace.SpanKindInternal),
-						)
-					}
-
-					err = col.Publish(pubCtx, &rdb.RawAny{
-						Timestamp:   time.Now(),
-						Rawdata:     enc_data,
-						ContentType: contentType,
-					})
-					ms.FailOnError(pubCtx, err, "failed to publish", "err", err)
-					pubSpan.End()
+			defer close(streamed)
+			for stream_d := range stream_channel {
+				// streamed results
+				enc_data, err := encoder(stream_d)
+				ms.FailOnError(ctx, err, "failed to encode data", "err", err, "data", stream_d)
+
+				pubCtx := ctx
+				var pubSpan trace.Span = noop.Span{}
+				// link span without full trace
+				rootContext := trace.SpanContextFromContext(ctx)
+				if rootContext.IsValid() {
+					pubCtx, pubSpan = tel.TraceStart(context.Background(), fmt.Sprintf("%s.data-stream", tel.GetServiceName()),
+						trace.WithLinks(trace.Link{
+							SpanContext: rootContext,
+						}),
+						trace.WithSpanKind(trace.SpanKindInternal),
+					)
 				}
+
+				err = col.Publish(pubCtx, &rdb.RawAny{
+					Timestamp:   time.Now(),
+					Rawdata:     enc_data,
+					ContentType: contentType,
+				})
+				ms.FailOnError(pubCtx, err, "failed to publish", "err", err)
+				pubSpan.End()
 			}
-		}(streamCtx)
+		}(ctx)
 
 		data, err := Poll(config, stream_channel)
+		// Poll returns after its last send, wait for the streamed items to be published before committing the watermarks
+		close(stream_channel)
+		<-streamed
 		ms.FailOnError(ctx, err, "failed to poll", "err", err)
 
 		// only publish if something returned
@@ -128,6 +134,9 @@ func main() {
 			ms.FailOnError(ctx, err, "failed to publish", "err", err)
 		}
 
+		err = CommitWatermarks()
+		ms.FailOnError(ctx, err, "failed to store watermarks", "err", err)
+
 		logger.Get(ctx).Info("collection completed", "runtime_ms", time.Since(jobstart).Milliseconds())
 	})
 	c.Run()
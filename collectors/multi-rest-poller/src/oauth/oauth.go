@@ -6,54 +6,116 @@ package oauth
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
+// Config of an OAuth2 token endpoint
+type Config struct {
+	Method       string   `yaml:"method"` // password | client_credentials | refresh_token
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Username     string   `yaml:"username,omitempty"`
+	Password     string   `yaml:"password,omitempty"`
+	RefreshToken string   `yaml:"refresh_token,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	Audience     string   `yaml:"audience,omitempty"`
+}
+
+// HTTPClient is used for token requests if set, e.g. to record or replay them
+var HTTPClient *http.Client = nil
+
+func clientContext() context.Context {
+	if HTTPClient != nil {
+		return context.WithValue(context.Background(), oauth2.HTTPClient, HTTPClient)
+	}
+	return context.Background()
+}
+
 // OAuthProvider struct
 type OAuthProvider struct {
-	conf        *oauth2.Config
 	clientCreds *clientcredentials.Config
+	refreshConf *oauth2.Config
+	source      oauth2.TokenSource
 	token       *oauth2.Token
-	mu          sync.Mutex
+	// refreshToken is the last refresh token issued, servers may rotate it on every refresh
+	refreshToken string
+	mu           sync.Mutex
 }
 
-// NewOAuthProvider initializes the OAuth2 wrapper
+// NewOAuthProvider initializes the OAuth2 wrapper from the OAUTH_* environment variables
 func NewOAuthProvider() *OAuthProvider {
-	authMethod := os.Getenv("OAUTH_METHOD")
-	tokenURL := os.Getenv("OAUTH_TOKEN_URL")
-	clientID := os.Getenv("OAUTH_CLIENT_ID")
-	clientSecret := os.Getenv("OAUTH_CLIENT_SECRET")
+	scopes := []string{"read", "write"}
+	if s := os.Getenv("OAUTH_SCOPES"); s != "" {
+		scopes = strings.Fields(s)
+	}
+
+	wrapper, err := New(Config{
+		Method:       os.Getenv("OAUTH_METHOD"),
+		TokenURL:     os.Getenv("OAUTH_TOKEN_URL"),
+		ClientID:     os.Getenv("OAUTH_CLIENT_ID"),
+		ClientSecret: os.Getenv("OAUTH_CLIENT_SECRET"),
+		Username:     os.Getenv("OAUTH_USERNAME"),
+		Password:     os.Getenv("OAUTH_PASSWORD"),
+		Scopes:       scopes,
+		Audience:     os.Getenv("OAUTH_AUDIENCE"),
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		panic(err.Error())
+	}
+	return wrapper
+}
+
+// New initializes the OAuth2 wrapper for the given grant
+func New(c Config) (*OAuthProvider, error) {
+	params := url.Values{}
+	if c.Audience != "" {
+		params.Set("audience", c.Audience)
+	}
 
 	wrapper := &OAuthProvider{}
 
-	switch authMethod {
+	switch c.Method {
 	case "password":
-		wrapper.conf = &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			Endpoint: oauth2.Endpoint{
-				TokenURL: tokenURL,
-			},
-			Scopes: []string{"read", "write"},
-		}
+		// client credentials config with overridden grant type, so that extra endpoint params like audience are sent too
+		params.Set("grant_type", "password")
+		params.Set("username", c.Username)
+		params.Set("password", c.Password)
+		fallthrough
 	case "client_credentials":
 		wrapper.clientCreds = &clientcredentials.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			TokenURL:     tokenURL,
-			Scopes:       []string{"read", "write"},
+			ClientID:       c.ClientID,
+			ClientSecret:   c.ClientSecret,
+			TokenURL:       c.TokenURL,
+			Scopes:         c.Scopes,
+			EndpointParams: params,
 		}
+	case "refresh_token":
+		wrapper.refreshConf = &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				TokenURL: c.TokenURL,
+			},
+			Scopes: c.Scopes,
+		}
+		wrapper.refreshToken = c.RefreshToken
+		wrapper.resetSource()
 	default:
-		slog.Error("Unsupported OAUTH_METHOD. Use 'password' or 'client_credentials'")
-		panic("Unsupported OAUTH_METHOD. Use 'password' or 'client_credentials'")
+		return nil, fmt.Errorf("unsupported OAuth method %q. Use 'password', 'client_credentials' or 'refresh_token'", c.Method)
 	}
 
-	return wrapper
+	return wrapper, nil
 }
 
 // GetToken retrieves a valid access token (refreshing if necessary)
@@ -61,7 +123,7 @@ func (w *OAuthProvider) GetToken() (string, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	ctx := context.Background()
+	ctx := clientContext()
 
 	// If token exists and is still valid, return it
 	if w.token != nil && w.token.Valid() {
@@ -72,11 +134,9 @@ func (w *OAuthProvider) GetToken() (string, error) {
 	var token *oauth2.Token
 	var err error
 
-	if w.conf != nil { // Password flow
-		username := os.Getenv("OAUTH_USERNAME")
-		password := os.Getenv("OAUTH_PASSWORD")
-		token, err = w.conf.PasswordCredentialsToken(ctx, username, password)
-	} else { // Client Credentials flow
+	if w.source != nil { // Refresh token flow
+		token, err = w.source.Token()
+	} else { // Password and Client Credentials flow
 		token, err = w.clientCreds.Token(ctx)
 	}
 
@@ -86,5 +146,25 @@ func (w *OAuthProvider) GetToken() (string, error) {
 
 	// Store new token
 	w.token = token
+	if token.RefreshToken != "" {
+		w.refreshToken = token.RefreshToken
+	}
 	return token.AccessToken, nil
 }
+
+// Invalidate drops the current token, e.g. after the server rejected it
+func (w *OAuthProvider) Invalidate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.token = nil
+	if w.refreshConf != nil {
+		// the token source caches the access token until it expires, so it has to be replaced as well
+		w.resetSource()
+	}
+}
+
+// resetSource creates the token source of the refresh token flow, it refreshes on the first Token() call.
+// The source keeps track of rotated refresh tokens
+func (w *OAuthProvider) resetSource() {
+	w.source = w.refreshConf.TokenSource(clientContext(), &oauth2.Token{RefreshToken: w.refreshToken})
+}
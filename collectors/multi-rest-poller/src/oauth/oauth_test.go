@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshTokenRevoked(t *testing.T) {
+	// rotates the refresh token on every refresh, like Keycloak with refresh token rotation
+	var refreshTokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		refreshTokens = append(refreshTokens, r.PostForm.Get("refresh_token"))
+		n := len(refreshTokens)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  fmt.Sprintf("access-%d", n),
+			"refresh_token": fmt.Sprintf("refresh-%d", n),
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer srv.Close()
+
+	p, err := New(Config{Method: "refresh_token", TokenURL: srv.URL, ClientID: "client", RefreshToken: "refresh-0"})
+	require.NoError(t, err)
+
+	token, err := p.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "access-1", token)
+
+	token, err = p.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "access-1", token, "a valid token is reused")
+
+	// the server revoked the access token before it expired
+	p.Invalidate()
+	token, err = p.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "access-2", token)
+
+	p.Invalidate()
+	token, err = p.GetToken()
+	require.NoError(t, err)
+	require.Equal(t, "access-3", token)
+
+	require.Equal(t, []string{"refresh-0", "refresh-1", "refresh-2"}, refreshTokens, "each refresh uses the last rotated refresh token")
+}
@@ -5,21 +5,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark"
 	"github.com/noi-techpark/opendatahub-go-sdk/tel/logger"
 	"github.com/oliveagle/jsonpath"
 	"gopkg.in/yaml.v3"
-	"opendatahub.com/multi-rest-poller/oauth"
+	"opendatahub.com/multi-rest-poller/auth"
 )
 
 type RootConfig struct {
@@ -43,6 +48,8 @@ type CallConfig struct {
 	URL               string            `yaml:"url"`
 	Method            string            `yaml:"method"`
 	Headers           map[string]string `yaml:"headers"`
+	Body              any               `yaml:"body,omitempty"`      // string template or object
+	BodyType          string            `yaml:"body_type,omitempty"` // raw | json | form
 	Stream            bool              `yaml:"stream"`
 	DataSelector      string            `yaml:"data_selector"`
 	DataSelectorType  string            `yaml:"data_selector_type"`
@@ -51,10 +58,14 @@ type CallConfig struct {
 	ParamSelectors    []string          `yaml:"param_selectors,omitempty"`
 	DataDestination   string            `yaml:"data_destination_field,omitempty"`
 	Pagination        *Pagination       `yaml:"pagination,omitempty"`
+	Watermark         *watermark.Config `yaml:"watermark,omitempty"`
+	Auth              *auth.Config      `yaml:"auth,omitempty"`            // defaults to the parent call's auth, then to AUTH_STRATEGY
+	MaxConcurrency    int               `yaml:"max_concurrency,omitempty"` // items whose nested calls run in parallel, defaults to 1
+	RateLimit         *RateLimit        `yaml:"rate_limit,omitempty"`
 }
 
 type Pagination struct {
-	RequestStrategy string        `yaml:"request_strategy"` // header | query | body
+	RequestStrategy string        `yaml:"request_strategy"` // header | query | body | url
 	LookupStrategy  string        `yaml:"lookup_strategy"`  // header | body | increment
 	OffsetBuilder   OffsetBuilder `yaml:"offset_builder"`   //
 	RequestKey      string        `yaml:"request_key"`      // where to put the offset for next requests
@@ -66,11 +77,13 @@ type OffsetBuilder struct {
 	Increment        int    `yaml:"increment"`
 	NextType         string `yaml:"next_type"`
 	BreakOnNextEmpty bool   `yaml:"break_on_next_empty"`
+	LinkRel          string `yaml:"link_rel,omitempty"` // relation to follow when next_field is the Link header, defaults to next
 }
 
 type encoder func(d any) (string, error)
 
-var oauthProvider *oauth.OAuthProvider = nil
+// httpTransport replaces the default transport of poll requests if set, e.g. to record or replay them
+var httpTransport http.RoundTripper = nil
 
 // LoadConfig reads the YAML configuration from the given file path,
 // unmarshals it into a CallConfig instance, and returns a pointer to it.
@@ -96,9 +109,10 @@ func LoadConfig(filename string) (*RootConfig, error) {
 
 // Poll is the entry point that starts the recursive processing and returns the final result as a string.
 func Poll(config *RootConfig, stream chan<- any) (any, error) {
-	if env.AUTH_STRATEGY == "oauth2" {
-		oauthProvider = oauth.NewOAuthProvider()
-	}
+	// watermarks of a failed poll must not survive
+	pendingWatermarksMu.Lock()
+	pendingWatermarks = map[string]string{}
+	pendingWatermarksMu.Unlock()
 
 	var result interface{} = nil
 	var err error
@@ -137,8 +151,8 @@ func Poll(config *RootConfig, stream chan<- any) (any, error) {
 func GetEncoder(c RootConfig) func(d any) (string, error) {
 	return func(d any) (string, error) {
 		// Based on the configured DataSelectorType, convert the result to a string.
-		if c.SelectorType() == "json" {
-			// For JSON responses, marshal the result.
+		if isStructured(c.SelectorType()) {
+			// For JSON, XML and CSV responses, marshal the extracted tree.
 			finalBytes, err := json.Marshal(d)
 			if err != nil {
 				return "", fmt.Errorf("error marshalling final result: %s", err.Error())
@@ -158,7 +172,7 @@ func GetEncoder(c RootConfig) func(d any) (string, error) {
 	}
 }
 
-// extractData attempts to extract a value using a JSONPath selector.
+// extractData attempts to extract a value using a JSONPath (json, csv) or XPath (xml) selector.
 // If an "index out of range" error occurs, it returns nil
 func extractData(result []byte, selector_type, selector string) (interface{}, error) {
 	switch selector_type {
@@ -183,10 +197,15 @@ func extractData(result []byte, selector_type, selector string) (interface{}, er
 			return val, nil
 		}
 		return jsonData, nil
+	case "xml":
+		slog.Debug("extracting with xml selector", "selector", selector)
+		return extractXml(result, selector)
+	case "csv":
+		slog.Debug("extracting with csv selector", "selector", selector)
+		return extractCsv(result, selector)
 	case "string":
 		return string(result), nil
 	}
-	// TODO do xml extractor using https://github.com/antchfx/xmlquery
 	return result, nil
 }
 
@@ -196,68 +215,107 @@ func existsData(result []byte, selector_type, selector string) bool {
 	return err == nil && r != nil
 }
 
-func httpRequest(method, url string, headers map[string]string, body any) ([]byte, error) {
-	// TODO BODY for POST
-	var req_body io.Reader = nil
-
-	req, err := retryablehttp.NewRequest(method, url, req_body)
-	if err != nil {
-		return nil, fmt.Errorf("could not create request for url %s: %s", url, err.Error())
+func httpRequest(authenticator auth.Authenticator, throttle *throttle, method, url string, headers map[string]string, body []byte) ([]byte, http.Header, error) {
+	client := retryablehttp.NewClient()
+	client.Logger = logger.Get(context.Background())
+	if httpTransport != nil {
+		client.HTTPClient.Transport = httpTransport
 	}
-
-	// set headers
-	for key, value := range headers {
-		req.Header.Add(key, value)
+	// retries of 429 responses already wait for Retry-After, other workers calling the same endpoint pause too
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		throttle.observe(resp)
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 	}
+	client.PrepareRetry = func(req *http.Request) error {
+		return throttle.wait(req.Context())
+	}
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var req_body io.Reader = nil
+		if body != nil {
+			req_body = bytes.NewReader(body)
+		}
 
-	// Inject authentication headers if needed.
-	if oauthProvider != nil {
-		token, err := oauthProvider.GetToken()
+		req, err := retryablehttp.NewRequest(method, url, req_body)
 		if err != nil {
-			return nil, fmt.Errorf("could not get oauth token: %s", err.Error())
+			return nil, nil, fmt.Errorf("could not create request for url %s: %s", url, err.Error())
 		}
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	} else if env.AUTH_STRATEGY == "basic" {
-		req.SetBasicAuth(env.BASIC_AUTH_USERNAME, env.BASIC_AUTH_PASSWORD)
-	} else if env.AUTH_STRATEGY == "bearer" {
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", env.AUTH_BEARER_TOKEN))
-	}
 
-	client := retryablehttp.NewClient()
-	client.Logger = logger.Get(context.Background())
+		// set headers
+		for key, value := range headers {
+			req.Header.Add(key, value)
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error during http request for %s: %s", url, err.Error())
+		// Inject authentication if needed.
+		if authenticator != nil {
+			if err := authenticator.Apply(req.Request, body); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if err := throttle.wait(req.Context()); err != nil {
+			return nil, nil, err
+		}
+
+		resp, err = client.Do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error during http request for %s: %s", url, err.Error())
+		}
+		// credentials may have been revoked or the session expired early, renew them once
+		if resp.StatusCode == http.StatusUnauthorized && authenticator != nil && attempt == 0 {
+			slog.Info("request unauthorized, renewing credentials", "url", url)
+			resp.Body.Close()
+			authenticator.Invalidate()
+			continue
+		}
+		break
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, errNotModified
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http request returned non-OK status %d for url %s", resp.StatusCode, url)
+		return nil, nil, fmt.Errorf("http request returned non-OK status %d for url %s", resp.StatusCode, url)
 	}
-	defer resp.Body.Close()
 
 	res_body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body for %s: %s", url, err.Error())
+		return nil, nil, fmt.Errorf("error reading response body for %s: %s", url, err.Error())
 	}
-	return res_body, nil
+	return res_body, resp.Header, nil
 }
 
 func handleNestedCalls(parent_call CallConfig, data *map[string]any) error {
 	for _, nestedCall := range parent_call.NestedCalls {
 		slog.Info("handling nested call", "template", nestedCall.URL)
+		if nestedCall.Auth == nil {
+			nestedCall.Auth = parent_call.Auth
+		}
 		// Copy the nested call config and update the URL.
 		// Extract parameters using the nested call's ParamSelectors.
 		params := []interface{}{}
 		for _, selector := range nestedCall.ParamSelectors {
-			val, err := jsonpath.JsonPathLookup(*data, selector)
+			val, err := selectParam(*data, nestedCall.ParamSelectorType, selector)
 			if err != nil {
 				return fmt.Errorf("error in param selector %s: %s", selector, err.Error())
 			}
 			params = append(params, fmt.Sprintf("%v", val))
 		}
 		if len(params) != 0 {
-			// Format the nested URL using the extracted parameters.
-			nestedCall.URL = fmt.Sprintf(nestedCall.URL, params...)
+			// Format the nested URL and body using the extracted parameters.
+			// The url consumes the first params, the body the following ones
+			nestedURL, rest, err := fillTemplate(nestedCall.URL, params)
+			if err != nil {
+				return fmt.Errorf("error templating url: %s", err.Error())
+			}
+			nestedCall.URL = nestedURL
+			body, _, err := templateBody(nestedCall.Body, rest)
+			if err != nil {
+				return fmt.Errorf("error templating body for url %s: %s", nestedCall.URL, err.Error())
+			}
+			nestedCall.Body = body
 		}
 		// Recursively process the nested call.
 		nestedResult, err := processCall(nestedCall, nil)
@@ -271,51 +329,69 @@ func handleNestedCalls(parent_call CallConfig, data *map[string]any) error {
 	return nil
 }
 
-func getTree(config CallConfig, method, url string, headers map[string]string, body any) (any, []byte, error) {
+func getTree(config CallConfig, method, url string, headers map[string]string, body any) (any, []byte, http.Header, error) {
 	/// -------------------- CALL
-	// TODO BODY for POST
-	body_res, err := httpRequest(method, url, headers, body)
+	req_body, contentType, err := encodeBody(config, body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if req_body != nil {
+		headers = cloneHeaders(headers)
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = contentType
+		}
+	}
+
+	authenticator, err := authenticatorFor(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	body_res, res_headers, err := httpRequest(authenticator, throttleFor(config, url), method, url, headers, req_body)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	/// -------------------- RESULT MANIPULATION
 	result, err := extractData(body_res, config.DataSelectorType, config.DataSelector)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error extracting data: %s", err.Error())
+		return nil, nil, nil, fmt.Errorf("error extracting data: %s", err.Error())
 	}
 
 	// Process nested calls if defined.
 	if len(config.NestedCalls) == 0 {
-		return result, body_res, nil
+		return result, body_res, res_headers, nil
 	}
 
 	/// -------------------- NESTED CALLS
 	switch data := result.(type) {
 	case []interface{}:
-		// Iterate over each entity in the slice.
-		for i, item := range data {
-			itemMap, ok := item.(map[string]interface{})
+		// Iterate over each entity in the slice, results stay in place so their order never changes.
+		err := forEachItem(len(data), config.MaxConcurrency, func(i int) error {
+			itemMap, ok := data[i].(map[string]interface{})
 			if !ok {
-				continue // Skip non-object items.
+				return nil // Skip non-object items.
 			}
-			err := handleNestedCalls(config, &itemMap)
-			if err != nil {
-				return nil, nil, err
+			if err := handleNestedCalls(config, &itemMap); err != nil {
+				return err
 			}
 			data[i] = itemMap
+			return nil
+		})
+		if err != nil {
+			return nil, nil, nil, err
 		}
 		result = data
 	case map[string]interface{}:
 		// Process nested calls for a single object.
 		err := handleNestedCalls(config, &data)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		result = data
 	}
 
-	return result, body_res, nil
+	return result, body_res, res_headers, nil
 }
 
 func handleStream(config CallConfig, data any, stream chan<- any) (any, bool) {
@@ -351,11 +427,27 @@ func processCall(config CallConfig, stream chan<- any) (interface{}, error) {
 		}
 	}
 
+	if config.Pagination != nil && config.Pagination.LookupStrategy == "header" && config.Pagination.OffsetBuilder.Next == "" {
+		return nil, fmt.Errorf("pagination with lookup_strategy == 'header' requires next_field to name the header")
+	}
+
+	wm, err := startWatermark(&config)
+	if err != nil {
+		return nil, fmt.Errorf("error loading watermark for url %s: %s", config.URL, err.Error())
+	}
+
 	/// -------------------- CALL first time is the same for paginated and not paginated
-	result, body_result, err := getTree(config, config.Method, config.URL, config.Headers, nil)
+	result, body_result, headers_result, err := getTree(config, requestMethod(config), config.URL, config.Headers, config.Body)
+	if errors.Is(err, errNotModified) {
+		slog.Info("endpoint not modified since last poll", "endpoint", config.URL)
+		return nil, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting data from url %s: %s", config.URL, err.Error())
 	}
+	if err := wm.observe(config, body_result, headers_result, true); err != nil {
+		return nil, err
+	}
 
 	// handle steram
 	result, _ = handleStream(config, result, stream)
@@ -366,7 +458,7 @@ func processCall(config CallConfig, stream chan<- any) (interface{}, error) {
 		if !ok {
 			return nil, fmt.Errorf("cannot paginate if results are not arrays url %s", config.URL)
 		}
-		pagination_results, err := doPaginatedRequests(config, body_result, stream)
+		pagination_results, err := doPaginatedRequests(config, body_result, headers_result, wm, stream)
 		if err != nil {
 			return nil, fmt.Errorf("error performing pagination url %s: %s", config.URL, err.Error())
 		}
@@ -374,14 +466,17 @@ func processCall(config CallConfig, stream chan<- any) (interface{}, error) {
 		result = array_result
 	}
 
+	wm.done()
 	return result, nil
 }
 
 // doPaginatedRequest loops requests and aggregates the data from each page.
-func doPaginatedRequests(config CallConfig, first_call_body []byte, stream chan<- any) ([]interface{}, error) {
+func doPaginatedRequests(config CallConfig, first_call_body []byte, first_call_headers http.Header, wm *watermarkTracker, stream chan<- any) ([]interface{}, error) {
 	p := config.Pagination
 	offsetBuilder := p.OffsetBuilder
 	prev_call_body := first_call_body
+	prev_call_headers := first_call_headers
+	prev_call_url := config.URL
 
 	// We'll accumulate all item-data pages into a single slice
 	allItems := make([]interface{}, 0)
@@ -405,8 +500,8 @@ func doPaginatedRequests(config CallConfig, first_call_body []byte, stream chan<
 			}
 			currentOffset = cur + offsetBuilder.Increment
 			newOffsetFound = true
-
-		// TODO handle 'header' lookup strategy
+		case "header":
+			currentOffset, newOffsetFound, err = computeNextOffsetHeader(config, prev_call_headers)
 		default:
 			return nil, fmt.Errorf("unsupported pagination lookup strategy %q", p.LookupStrategy)
 		}
@@ -423,7 +518,7 @@ func doPaginatedRequests(config CallConfig, first_call_body []byte, stream chan<
 		headers := cloneHeaders(config.Headers)
 
 		p := config.Pagination
-		var body []byte = nil
+		body := config.Body
 
 		slog.Info("pulling", "url", config.URL, "pagination", currentOffset)
 
@@ -439,15 +534,33 @@ func doPaginatedRequests(config CallConfig, first_call_body []byte, stream chan<
 				return nil, err
 			}
 
+		case "body":
+			// Place offset in the request payload, e.g. {"page": OFFSET}
+			body, err = setBodyField(config, p.RequestKey, currentOffset)
+			if err != nil {
+				return nil, err
+			}
+
+		case "url":
+			// Offset is the next page url itself, possibly relative to the previous one
+			url, err = resolveURL(prev_call_url, fmt.Sprintf("%v", currentOffset))
+			if err != nil {
+				return nil, err
+			}
+
 		default:
 			return nil, fmt.Errorf("unsupported pagination strategy %q", p.RequestStrategy)
 		}
 
-		result, body_Result, err := getTree(config, config.Method, url, headers, body)
+		result, body_Result, headers_Result, err := getTree(config, requestMethod(config), url, headers, body)
 		if err != nil {
 			return nil, fmt.Errorf("error extracting data on url %s: %s", config.URL, err.Error())
 		}
 
+		if err := wm.observe(config, body_Result, headers_Result, false); err != nil {
+			return nil, err
+		}
+
 		// If no data, we stop
 		if result == nil {
 			slog.Debug("no data extracted; stopping pagination")
@@ -468,6 +581,8 @@ func doPaginatedRequests(config CallConfig, first_call_body []byte, stream chan<
 			allItems = append(allItems, array_result...)
 		}
 		prev_call_body = body_Result
+		prev_call_headers = headers_Result
+		prev_call_url = url
 	}
 
 	return allItems, nil
@@ -485,6 +600,9 @@ func computeNextOffsetBody(config CallConfig, response_body []byte) (interface{}
 		if err != nil {
 			return nil, false, fmt.Errorf("error extracting next offset: %w", err)
 		}
+		if config.DataSelectorType == "xml" {
+			val = singleValue(val)
+		}
 	}
 
 	// If we found a next offset in the JSON
@@ -512,6 +630,37 @@ func computeNextOffsetBody(config CallConfig, response_body []byte) (interface{}
 	return nextOffset, newOffsetFound, nil
 }
 
+// computeNextOffsetHeader reads the next offset from the response header named in next_field.
+// The Link header is parsed according to RFC 5988 and yields the url of the link_rel relation.
+// A missing header ends the pagination.
+func computeNextOffsetHeader(config CallConfig, response_headers http.Header) (interface{}, bool, error) {
+	offsetBuilder := config.Pagination.OffsetBuilder
+
+	var val string
+	if http.CanonicalHeaderKey(offsetBuilder.Next) == "Link" {
+		rel := offsetBuilder.LinkRel
+		if rel == "" {
+			rel = "next"
+		}
+		val = parseLinkHeader(response_headers.Values("Link"))[rel]
+	} else {
+		val = response_headers.Get(offsetBuilder.Next)
+	}
+
+	if val == "" {
+		return nil, false, nil
+	}
+
+	if offsetBuilder.NextType == "int" {
+		intVal, err := toInt(val)
+		if err != nil {
+			return nil, false, fmt.Errorf("next offset is not convertible to int: %v", err)
+		}
+		return intVal, true, nil
+	}
+	return val, true, nil
+}
+
 // -------------------- Utility Helpers --------------------
 func cloneHeaders(original map[string]string) map[string]string {
 	clone := make(map[string]string, len(original))
@@ -532,6 +681,45 @@ func buildURLWithQueryParam(baseURL, key, val string) (string, error) {
 	return req.URL.String(), nil
 }
 
+// resolveURL resolves a possibly relative next page url against the url of the previous page.
+func resolveURL(baseURL, next string) (string, error) {
+	base, err := neturl.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse url %s: %w", baseURL, err)
+	}
+	ref, err := neturl.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("could not parse next url %s: %w", next, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// parseLinkHeader maps each relation type of RFC 5988 Link header values to its target url, e.g.
+// <https://example.com/ocpi/cpo/locations/2.2?limit=100&offset=100>; rel="next"
+func parseLinkHeader(values []string) map[string]string {
+	links := map[string]string{}
+	for _, value := range values {
+		for _, m := range linkRegex.FindAllStringSubmatch(value, -1) {
+			target := m[1]
+			for _, param := range strings.Split(m[2], ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				// rel may hold several space separated relation types
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(val), `"`)) {
+					if _, exists := links[strings.ToLower(rel)]; !exists {
+						links[strings.ToLower(rel)] = target
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+var linkRegex = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^;,]+)*)`)
+
 // isEmptyValue is a basic check to see if an interface is nil, empty string, or numeric zero.
 func isEmptyValue(v interface{}) bool {
 	if v == nil {
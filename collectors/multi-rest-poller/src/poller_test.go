@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider serves the same paginated list and detail records via query params (GET)
+// and via json or form payloads (POST), so both request styles must yield identical trees.
+func fakeProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	// params reads the request parameters either from the query or from the payload
+	params := func(r *http.Request) url.Values {
+		if r.Method == http.MethodGet {
+			return r.URL.Query()
+		}
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		switch r.Header.Get("Content-Type") {
+		case "application/x-www-form-urlencoded":
+			v, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+			return v
+		default:
+			var payload map[string]any
+			require.NoError(t, json.Unmarshal(b, &payload))
+			v := url.Values{}
+			for k, val := range payload {
+				v.Set(k, fmt.Sprintf("%v", val))
+			}
+			require.Equal(t, "stations", v.Get("kind"), "static body fields must be kept")
+			return v
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(params(r).Get("page"))
+		next := page + 1
+		if next > 2 {
+			next = 0
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": []any{
+				map[string]any{"id": fmt.Sprintf("p%d-a", page)},
+				map[string]any{"id": fmt.Sprintf("p%d-b", page)},
+			},
+			"next": next,
+		})
+	})
+	mux.HandleFunc("/detail", func(w http.ResponseWriter, r *http.Request) {
+		id := params(r).Get("id")
+		json.NewEncoder(w).Encode(map[string]any{"detail": map[string]any{"name": "station " + id}})
+	})
+	return httptest.NewServer(mux)
+}
+
+func paginatedCall(srv *httptest.Server) CallConfig {
+	return CallConfig{
+		URL:              srv.URL + "/items",
+		Method:           "GET",
+		DataSelectorType: "json",
+		DataSelector:     "$.data",
+		Pagination: &Pagination{
+			RequestStrategy: "query",
+			LookupStrategy:  "body",
+			RequestKey:      "page",
+			OffsetBuilder: OffsetBuilder{
+				Next:             "$.next",
+				NextType:         "int",
+				BreakOnNextEmpty: true,
+			},
+		},
+	}
+}
+
+func TestPaginationGetPostParity(t *testing.T) {
+	srv := fakeProvider(t)
+	defer srv.Close()
+
+	get := paginatedCall(srv)
+	getResult, err := processCall(get, nil)
+	require.NoError(t, err)
+	require.Len(t, getResult, 6)
+
+	post := paginatedCall(srv)
+	post.Method = "POST"
+	post.Body = map[string]any{"kind": "stations"}
+	post.Pagination.RequestStrategy = "body"
+	postResult, err := processCall(post, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, getResult, postResult)
+}
+
+func TestNestedCallGetPostParity(t *testing.T) {
+	srv := fakeProvider(t)
+	defer srv.Close()
+
+	nested := CallConfig{
+		DataSelectorType:  "json",
+		DataSelector:      "$.detail",
+		ParamSelectorType: "json",
+		ParamSelectors:    []string{"$.id"},
+		DataDestination:   "details",
+	}
+
+	get := paginatedCall(srv)
+	getNested := nested
+	getNested.URL = srv.URL + "/detail?id=%s"
+	getNested.Method = "GET"
+	get.NestedCalls = []CallConfig{getNested}
+	getResult, err := processCall(get, nil)
+	require.NoError(t, err)
+	require.Equal(t, "station p1-b", getResult.([]any)[3].(map[string]any)["details"].(map[string]any)["name"])
+
+	// method defaults to POST when a body is set
+	jsonNested := nested
+	jsonNested.URL = srv.URL + "/detail"
+	jsonNested.Body = map[string]any{"id": "%s", "kind": "stations"}
+	get.NestedCalls = []CallConfig{jsonNested}
+	jsonResult, err := processCall(get, nil)
+	require.NoError(t, err)
+	require.Equal(t, getResult, jsonResult)
+
+	formNested := nested
+	formNested.URL = srv.URL + "/detail"
+	formNested.Method = "POST"
+	formNested.BodyType = "form"
+	formNested.Body = map[string]any{"id": "%s"}
+	get.NestedCalls = []CallConfig{formNested}
+	formResult, err := processCall(get, nil)
+	require.NoError(t, err)
+	require.Equal(t, getResult, formResult)
+
+	// templating must not leak into the shared config
+	require.Equal(t, "%s", formNested.Body.(map[string]any)["id"])
+}
+
+func TestTemplateBody(t *testing.T) {
+	body := map[string]any{
+		"b": []any{"%s", 1},
+		"a": map[string]any{"z": "%s", "y": "static"},
+	}
+	templated, rest, err := templateBody(body, []any{"first", "second"})
+	require.NoError(t, err)
+	// verbs are consumed in sorted key order, as in the encoded json body
+	require.Equal(t, map[string]any{
+		"b": []any{"second", 1},
+		"a": map[string]any{"z": "first", "y": "static"},
+	}, templated)
+	require.Empty(t, rest)
+
+	// params only meant for the url leave the body untouched
+	templated, rest, err = templateBody(map[string]any{"a": "static"}, []any{"first"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"a": "static"}, templated)
+	require.Equal(t, []any{"first"}, rest)
+
+	// a literal % is kept, in leaves with and without verbs
+	templated, _, err = templateBody(map[string]any{"discount": "100%", "id": "%s at 5%"}, []any{"42"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"discount": "100%", "id": "42 at 5%"}, templated)
+
+	// explicit indexes refer to all params
+	templated, _, err = templateBody(map[string]any{"a": "%[2]s", "b": "%[1]s-%[2]s"}, []any{"x", "y"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"a": "y", "b": "x-y"}, templated)
+
+	_, _, err = templateBody(map[string]any{"a": "%s", "b": "%s"}, []any{"x"})
+	require.ErrorContains(t, err, "needs 1 parameters, but only 0 are left")
+	_, _, err = templateBody(map[string]any{"a": "%[3]s"}, []any{"x"})
+	require.ErrorContains(t, err, "refers to parameter 3")
+
+	raw, _, err := templateBody(`<id>%s</id>`, []any{"42"})
+	require.NoError(t, err)
+	encoded, contentType, err := encodeBody(CallConfig{Body: `<id>%s</id>`}, raw)
+	require.NoError(t, err)
+	require.Equal(t, "<id>42</id>", string(encoded))
+	require.Equal(t, "text/plain", contentType)
+}
+
+func TestNestedCallSplitVerbs(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/list" {
+			w.Write([]byte(`{"data":[{"id":"a1","page":"2"}]}`))
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		path, body = r.URL.Path, string(b)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	_, err := processCall(CallConfig{
+		URL:              srv.URL + "/list",
+		DataSelectorType: "json",
+		DataSelector:     "$.data",
+		NestedCalls: []CallConfig{{
+			URL:               srv.URL + "/items/%s",
+			Body:              map[string]any{"page": "%s", "filter": "100%"},
+			DataSelectorType:  "json",
+			DataSelector:      "$",
+			ParamSelectorType: "json",
+			ParamSelectors:    []string{"$.id", "$.page"},
+			DataDestination:   "detail",
+		}},
+	}, nil)
+	require.NoError(t, err)
+	// the url consumes the first param, the body the second
+	require.Equal(t, "/items/a1", path)
+	require.JSONEq(t, `{"page":"2","filter":"100%"}`, body)
+}
+
+func TestSetBodyField(t *testing.T) {
+	config := CallConfig{Body: map[string]any{"query": map[string]any{"q": "x"}}}
+	body, err := setBodyField(config, "query.cursor", "abc")
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"query": map[string]any{"q": "x", "cursor": "abc"}}, body)
+	require.NotContains(t, config.Body.(map[string]any)["query"], "cursor")
+
+	_, err = setBodyField(CallConfig{Body: "raw"}, "page", 1)
+	require.Error(t, err)
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	links := parseLinkHeader([]string{
+		`<https://example.com/ocpi/cpo/2.2/locations?offset=100&limit=100>; rel="next", <https://example.com/ocpi/cpo/2.2/locations?offset=0>; rel="first prev"`,
+		`</last>;rel=last;title="last page"`,
+	})
+	require.Equal(t, map[string]string{
+		"next":  "https://example.com/ocpi/cpo/2.2/locations?offset=100&limit=100",
+		"first": "https://example.com/ocpi/cpo/2.2/locations?offset=0",
+		"prev":  "https://example.com/ocpi/cpo/2.2/locations?offset=0",
+		"last":  "/last",
+	}, links)
+}
+
+// headerPagedProvider pages like OCPI: the next page is announced in the Link header,
+// alternatively as bare cursor in X-Next-Cursor
+func headerPagedProvider() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset < 4 {
+			next := offset + 2
+			// relative link, must be resolved against the current page
+			w.Header().Add("Link", fmt.Sprintf(`<locations?offset=%d&limit=2>; rel="next"`, next))
+			w.Header().Set("X-Next-Cursor", strconv.Itoa(next))
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": []any{map[string]any{"id": offset}, map[string]any{"id": offset + 1}},
+		})
+	}))
+}
+
+func TestHeaderPagination(t *testing.T) {
+	srv := headerPagedProvider()
+	defer srv.Close()
+
+	link := CallConfig{
+		URL:              srv.URL + "/ocpi/locations?limit=2",
+		Method:           "GET",
+		DataSelectorType: "json",
+		DataSelector:     "$.data",
+		Pagination: &Pagination{
+			RequestStrategy: "url",
+			LookupStrategy:  "header",
+			OffsetBuilder:   OffsetBuilder{Next: "link"},
+		},
+	}
+	linkResult, err := processCall(link, nil)
+	require.NoError(t, err)
+	require.Len(t, linkResult, 6)
+	require.Equal(t, float64(5), linkResult.([]any)[5].(map[string]any)["id"])
+
+	cursor := link
+	cursor.Pagination = &Pagination{
+		RequestStrategy: "query",
+		LookupStrategy:  "header",
+		RequestKey:      "offset",
+		OffsetBuilder:   OffsetBuilder{Next: "X-Next-Cursor", NextType: "int"},
+	}
+	cursorResult, err := processCall(cursor, nil)
+	require.NoError(t, err)
+	require.Equal(t, linkResult, cursorResult)
+
+	cursor.Pagination = &Pagination{RequestStrategy: "query", LookupStrategy: "header"}
+	_, err = processCall(cursor, nil)
+	require.Error(t, err)
+}
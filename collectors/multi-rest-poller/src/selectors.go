@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/oliveagle/jsonpath"
+)
+
+// isStructured reports whether a selector type produces a data tree instead of a raw string.
+// Structured results are published as json.
+func isStructured(selector_type string) bool {
+	switch selector_type {
+	case "json", "xml", "csv":
+		return true
+	}
+	return false
+}
+
+// extractXml evaluates an XPath expression against an XML document.
+// Node sets are returned as arrays of converted nodes (see xmlNodeToValue),
+// while scalar expressions like count() or string() return their plain value.
+// An empty selector returns the whole document.
+func extractXml(result []byte, selector string) (interface{}, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(result))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing xml: %s", err.Error())
+	}
+	if selector == "" {
+		return xmlNodeToValue(doc), nil
+	}
+	return evaluateXPath(doc, selector)
+}
+
+func evaluateXPath(top *xmlquery.Node, selector string) (interface{}, error) {
+	expr, err := xpath.Compile(selector)
+	if err != nil {
+		return nil, fmt.Errorf("error in xml data selector %s: %s", selector, err.Error())
+	}
+
+	switch val := expr.Evaluate(xmlquery.CreateXPathNavigator(top)).(type) {
+	case *xpath.NodeIterator:
+		nodes := []interface{}{}
+		for val.MoveNext() {
+			nav := val.Current().(*xmlquery.NodeNavigator)
+			if nav.NodeType() == xpath.AttributeNode {
+				nodes = append(nodes, nav.Value())
+				continue
+			}
+			nodes = append(nodes, xmlNodeToValue(nav.Current()))
+		}
+		return nodes, nil
+	default:
+		return val, nil
+	}
+}
+
+// xmlNodeToValue converts an XML node into the same kind of tree the json selector produces:
+// elements become objects keyed by child element name (repeated children become arrays),
+// attributes are stored as "@name" and mixed text as "#text".
+// Elements holding only text collapse to a string.
+func xmlNodeToValue(n *xmlquery.Node) interface{} {
+	switch n.Type {
+	case xmlquery.TextNode, xmlquery.CharDataNode:
+		return n.Data
+	case xmlquery.AttributeNode:
+		return n.InnerText()
+	}
+
+	obj := map[string]interface{}{}
+	for _, a := range n.Attr {
+		obj["@"+a.Name.Local] = a.Value
+	}
+
+	text := strings.Builder{}
+	hasChildren := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case xmlquery.ElementNode:
+			hasChildren = true
+			child := xmlNodeToValue(c)
+			switch existing := obj[c.Data].(type) {
+			case nil:
+				obj[c.Data] = child
+			case []interface{}:
+				obj[c.Data] = append(existing, child)
+			default:
+				obj[c.Data] = []interface{}{existing, child}
+			}
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			text.WriteString(c.Data)
+		}
+	}
+
+	trimmed := strings.TrimSpace(text.String())
+	if !hasChildren && len(obj) == 0 {
+		return trimmed
+	}
+	if trimmed != "" {
+		obj["#text"] = trimmed
+	}
+	return obj
+}
+
+// valueToXml renders a tree produced by xmlNodeToValue back into an XML document with the given root,
+// so that XPath param selectors can be evaluated relative to an item.
+func valueToXml(root string, v interface{}) (*xmlquery.Node, error) {
+	buf := bytes.Buffer{}
+	writeXmlValue(&buf, root, v)
+	doc, err := xmlquery.Parse(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering item as xml: %s", err.Error())
+	}
+	return doc.SelectElement(root), nil
+}
+
+func writeXmlValue(buf *bytes.Buffer, name string, v interface{}) {
+	if arr, ok := v.([]interface{}); ok {
+		for _, el := range arr {
+			writeXmlValue(buf, name, el)
+		}
+		return
+	}
+
+	buf.WriteString("<" + name)
+	obj, isObj := v.(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if strings.HasPrefix(k, "@") {
+			buf.WriteString(" " + k[1:] + `="`)
+			xml.EscapeText(buf, []byte(fmt.Sprintf("%v", obj[k])))
+			buf.WriteString(`"`)
+		}
+	}
+	buf.WriteString(">")
+
+	if !isObj {
+		if v != nil {
+			xml.EscapeText(buf, []byte(fmt.Sprintf("%v", v)))
+		}
+	} else {
+		for _, k := range keys {
+			switch {
+			case k == "#text":
+				xml.EscapeText(buf, []byte(fmt.Sprintf("%v", obj[k])))
+			case !strings.HasPrefix(k, "@"):
+				writeXmlValue(buf, k, obj[k])
+			}
+		}
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+// extractCsv turns CSV rows into objects keyed by the header row.
+// The delimiter is detected from the header line (comma, semicolon, tab or pipe).
+// A selector is applied as JSONPath on the resulting array of rows.
+func extractCsv(result []byte, selector string) (interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(result))
+	r.Comma = detectCsvDelimiter(result)
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing csv: %s", err.Error())
+	}
+
+	rows := []interface{}{}
+	if len(records) > 0 {
+		header := records[0]
+		// strip byte order mark that excel likes to put in front of the first column
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		for _, rec := range records[1:] {
+			row := map[string]interface{}{}
+			for i, col := range header {
+				if i < len(rec) {
+					row[col] = rec[i]
+				} else {
+					row[col] = ""
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	if selector == "" {
+		return rows, nil
+	}
+	val, err := jsonpath.JsonPathLookup(rows, selector)
+	if err != nil {
+		if strings.Contains(err.Error(), "index") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error in csv data selector %s: %s", selector, err.Error())
+	}
+	return val, nil
+}
+
+func detectCsvDelimiter(data []byte) rune {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	delimiter := ','
+	best := 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		if c := bytes.Count(header, []byte(string(d))); c > best {
+			delimiter, best = d, c
+		}
+	}
+	return delimiter
+}
+
+// selectParam evaluates a param selector against an item of the parent result.
+// xml selectors are XPath expressions relative to the item, everything else is JSONPath.
+func selectParam(item map[string]any, selector_type, selector string) (interface{}, error) {
+	if selector_type != "xml" {
+		return jsonpath.JsonPathLookup(item, selector)
+	}
+
+	node, err := valueToXml("item", item)
+	if err != nil {
+		return nil, err
+	}
+	val, err := evaluateXPath(node, selector)
+	if err != nil {
+		return nil, err
+	}
+	return singleValue(val), nil
+}
+
+// singleValue unwraps XPath node sets for places where a single value is expected,
+// like next offsets and params: empty sets become nil and single element sets their element.
+func singleValue(v interface{}) interface{} {
+	if arr, ok := v.([]interface{}); ok {
+		switch len(arr) {
+		case 0:
+			return nil
+		case 1:
+			return arr[0]
+		}
+	}
+	return v
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const datexSample = `<?xml version="1.0" encoding="UTF-8"?>
+<d2:payload xmlns:d2="http://datex2.eu/schema/3/d2Payload" lang="it">
+  <d2:situation id="S1" version="2">
+    <d2:severity>high</d2:severity>
+    <d2:comment><![CDATA[lane closed]]></d2:comment>
+  </d2:situation>
+  <d2:situation id="S2" version="1">
+    <d2:severity>low</d2:severity>
+    <d2:comment>roadworks</d2:comment>
+    <d2:comment>night only</d2:comment>
+  </d2:situation>
+</d2:payload>`
+
+func TestExtractXml(t *testing.T) {
+	situations, err := extractData([]byte(datexSample), "xml", "//d2:situation")
+	require.NoError(t, err)
+	require.Equal(t, []any{
+		map[string]any{"@id": "S1", "@version": "2", "severity": "high", "comment": "lane closed"},
+		map[string]any{"@id": "S2", "@version": "1", "severity": "low", "comment": []any{"roadworks", "night only"}},
+	}, situations)
+
+	ids, err := extractData([]byte(datexSample), "xml", "//*[local-name()='situation']/@id")
+	require.NoError(t, err)
+	require.Equal(t, []any{"S1", "S2"}, ids)
+
+	count, err := extractData([]byte(datexSample), "xml", "count(//d2:situation)")
+	require.NoError(t, err)
+	require.Equal(t, float64(2), count)
+
+	_, err = extractData([]byte(datexSample), "xml", "//d2:situation[")
+	require.Error(t, err)
+}
+
+func TestExtractCsv(t *testing.T) {
+	data := "\ufeffcode;name;free\nBZ1;Centro;12\nME1;Merano;0\nBZ2;\"Piazza; Walther\";3\n"
+
+	rows, err := extractData([]byte(data), "csv", "")
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, map[string]any{"code": "BZ2", "name": "Piazza; Walther", "free": "3"}, rows.([]any)[2])
+
+	name, err := extractData([]byte(data), "csv", "$[1].name")
+	require.NoError(t, err)
+	require.Equal(t, "Merano", name)
+}
+
+// TestXmlPaginationAndNestedCalls pages through an XML feed using the next_field of the body
+// and resolves the details of each item with an XPath param selector.
+func TestXmlPaginationAndNestedCalls(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stations", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		next := ""
+		if page < 1 {
+			next = strconv.Itoa(page + 1)
+		}
+		fmt.Fprintf(w, `<result><station code="A%d"/><station code="B%d"/><next>%s</next></result>`, page, page, next)
+	})
+	mux.HandleFunc("/detail", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "code,capacity\n%s,%d\n", r.URL.Query().Get("code"), len(r.URL.Query().Get("code")))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	config := CallConfig{
+		URL:              srv.URL + "/stations",
+		Method:           "GET",
+		DataSelectorType: "xml",
+		DataSelector:     "//station",
+		Pagination: &Pagination{
+			RequestStrategy: "query",
+			LookupStrategy:  "body",
+			RequestKey:      "page",
+			OffsetBuilder: OffsetBuilder{
+				Next:             "//next",
+				NextType:         "int",
+				BreakOnNextEmpty: true,
+			},
+		},
+		NestedCalls: []CallConfig{{
+			URL:               srv.URL + "/detail?code=%s",
+			Method:            "GET",
+			DataSelectorType:  "csv",
+			DataSelector:      "$[0].capacity",
+			ParamSelectorType: "xml",
+			ParamSelectors:    []string{"@code"},
+			DataDestination:   "capacity",
+		}},
+	}
+
+	result, err := processCall(config, nil)
+	require.NoError(t, err)
+	require.Equal(t, []any{
+		map[string]any{"@code": "A0", "capacity": "2"},
+		map[string]any{"@code": "B0", "capacity": "2"},
+		map[string]any{"@code": "A1", "capacity": "2"},
+		map[string]any{"@code": "B1", "capacity": "2"},
+	}, result)
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/antchfx/xpath"
+	"github.com/oliveagle/jsonpath"
+	"gopkg.in/yaml.v3"
+	"opendatahub.com/multi-rest-poller/auth"
+)
+
+// LoadConfigStrict reads the configuration like LoadConfig, but fails on keys that are not part of the schema
+// and validates the whole call tree.
+func LoadConfigStrict(filename string) (*RootConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", filename, err)
+	}
+
+	var config RootConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks the configuration for mistakes that would otherwise only show up while polling.
+// All problems are reported at once.
+func (r RootConfig) Validate() error {
+	if r.Call == nil && r.MultipleRootCalls == nil {
+		return fmt.Errorf("either 'http_call' or 'http_calls' needs to be set")
+	}
+	if r.Call != nil && r.MultipleRootCalls != nil {
+		return fmt.Errorf("only one of 'http_call' and 'http_calls' may be set")
+	}
+
+	errs := []error{}
+	if r.Call != nil {
+		r.Call.validate("http_call", false, &errs)
+	} else {
+		if err := checkOneOf(r.MultipleRootCalls.DataSelectorType, "", "json", "xml", "csv", "string"); err != nil {
+			errs = append(errs, fmt.Errorf("http_calls.data_selector_type: %w", err))
+		}
+		for i, call := range r.MultipleRootCalls.NestedCalls {
+			call.validate(fmt.Sprintf("http_calls.nested_calls[%d]", i), true, &errs)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c CallConfig) validate(path string, nested bool, errs *[]error) {
+	fail := func(field string, format string, args ...any) {
+		*errs = append(*errs, fmt.Errorf("%s.%s: %s", path, field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.URL == "" {
+		fail("url", "is required")
+	}
+	if err := checkOneOf(c.Method, "", "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"); err != nil {
+		fail("method", "%s", err)
+	}
+	if err := checkOneOf(c.BodyType, "", "raw", "json", "form"); err != nil {
+		fail("body_type", "%s", err)
+	}
+	if err := checkOneOf(c.DataSelectorType, "", "json", "xml", "csv", "string"); err != nil {
+		fail("data_selector_type", "%s", err)
+	}
+	if err := checkSelector(c.DataSelectorType, c.DataSelector); err != nil {
+		fail("data_selector", "%s", err)
+	}
+
+	if nested {
+		if c.DataDestination == "" {
+			fail("data_destination_field", "is required for nested calls")
+		}
+		for i, selector := range c.ParamSelectors {
+			if err := checkSelector(c.ParamSelectorType, selector); err != nil {
+				fail(fmt.Sprintf("param_selectors[%d]", i), "%s", err)
+			}
+		}
+	} else if len(c.ParamSelectors) != 0 {
+		fail("param_selectors", "only nested calls have parameters")
+	}
+
+	if p := c.Pagination; p != nil {
+		if err := checkOneOf(p.RequestStrategy, "header", "query", "body", "url"); err != nil {
+			fail("pagination.request_strategy", "%s", err)
+		}
+		if err := checkOneOf(p.LookupStrategy, "header", "body", "increment"); err != nil {
+			fail("pagination.lookup_strategy", "%s", err)
+		}
+		if p.RequestKey == "" && p.RequestStrategy != "url" {
+			fail("pagination.request_key", "is required for request_strategy %q", p.RequestStrategy)
+		}
+		if p.RequestStrategy == "body" && bodyType(c) == "raw" {
+			fail("pagination.request_strategy", "'body' requires a json or form body")
+		}
+		if err := checkOneOf(p.OffsetBuilder.NextType, "", "int", "string"); err != nil {
+			fail("pagination.offset_builder.next_type", "%s", err)
+		}
+		switch p.LookupStrategy {
+		case "body":
+			if c.DataSelectorType == "" || c.DataSelector == "" {
+				fail("pagination.lookup_strategy", "'body' requires data_selector and data_selector_type to be set")
+			}
+			if p.OffsetBuilder.Next == "" {
+				fail("pagination.offset_builder.next_field", "is required for lookup_strategy 'body'")
+			} else if err := checkSelector(c.DataSelectorType, p.OffsetBuilder.Next); err != nil {
+				fail("pagination.offset_builder.next_field", "%s", err)
+			}
+		case "header":
+			if p.OffsetBuilder.Next == "" {
+				fail("pagination.offset_builder.next_field", "is required for lookup_strategy 'header'")
+			}
+		}
+	}
+
+	if c.Watermark != nil {
+		if err := c.Watermark.Validate(); err != nil {
+			fail("watermark", "%s", err)
+		} else if c.Watermark.Source == "body" {
+			if err := checkSelector(c.DataSelectorType, c.Watermark.Field); err != nil {
+				fail("watermark.field", "%s", err)
+			}
+		}
+	}
+
+	if c.Auth != nil {
+		if _, err := auth.New(*c.Auth); err != nil {
+			fail("auth", "%s", err)
+		}
+	}
+
+	if c.MaxConcurrency < 0 {
+		fail("max_concurrency", "must not be negative")
+	}
+	if c.RateLimit != nil && (c.RateLimit.RequestsPerSecond <= 0 || c.RateLimit.Burst < 0) {
+		fail("rate_limit", "requests_per_second must be positive and burst not negative")
+	}
+
+	for i, nestedCall := range c.NestedCalls {
+		nestedCall.validate(fmt.Sprintf("%s.nested_calls[%d]", path, i), true, errs)
+	}
+}
+
+// checkSelector compiles the selector according to its type
+func checkSelector(selectorType, selector string) error {
+	if selector == "" {
+		return nil
+	}
+	switch selectorType {
+	case "json", "csv":
+		if _, err := jsonpath.Compile(selector); err != nil {
+			return fmt.Errorf("invalid JSONPath %q: %w", selector, err)
+		}
+	case "xml":
+		if _, err := xpath.Compile(selector); err != nil {
+			return fmt.Errorf("invalid XPath %q: %w", selector, err)
+		}
+	}
+	return nil
+}
+
+func checkOneOf(value string, allowed ...string) error {
+	if slices.Contains(allowed, value) {
+		return nil
+	}
+	return fmt.Errorf("unsupported value %q, expected one of %q", value, allowed)
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark"
+)
+
+// errNotModified is returned for 304 responses to conditional requests, the poll is skipped
+var errNotModified = errors.New("not modified since last poll")
+
+var watermarkStore watermark.Store = nil
+var watermarkStoreMu sync.Mutex
+
+// pendingWatermarks holds the watermarks of the current poll until its data has been published
+var pendingWatermarks = map[string]string{}
+var pendingWatermarksMu sync.Mutex
+
+type watermarkTracker struct {
+	config watermark.Config
+	key    string
+	value  string
+}
+
+// startWatermark loads the last watermark of the call and injects it into the request.
+// Returns nil if the call has no watermark configured.
+func startWatermark(config *CallConfig) (*watermarkTracker, error) {
+	if config.Watermark == nil {
+		return nil, nil
+	}
+	if err := config.Watermark.Validate(); err != nil {
+		return nil, err
+	}
+	if err := openWatermarkStore(); err != nil {
+		return nil, err
+	}
+
+	t := &watermarkTracker{config: *config.Watermark, key: config.Watermark.Key}
+	if t.key == "" {
+		t.key = config.URL
+	}
+
+	last, err := watermarkStore.Load(t.key)
+	if err != nil {
+		return nil, err
+	}
+	t.value = last
+
+	// first poll, fetch everything
+	if last == "" {
+		return t, nil
+	}
+
+	slog.Info("injecting watermark", "key", t.key, "watermark", last)
+	switch t.config.Target {
+	case "header":
+		config.Headers = cloneHeaders(config.Headers)
+		config.Headers[t.config.TargetKey] = last
+	case "query":
+		config.URL, err = buildURLWithQueryParam(config.URL, t.config.TargetKey, last)
+	case "body":
+		config.Body, err = setBodyField(*config, t.config.TargetKey, last)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not inject watermark: %w", err)
+	}
+	return t, nil
+}
+
+func openWatermarkStore() error {
+	watermarkStoreMu.Lock()
+	defer watermarkStoreMu.Unlock()
+	if watermarkStore != nil {
+		return nil
+	}
+	store, err := watermark.NewStore(env.WATERMARK_STORE_PATH)
+	if err != nil {
+		return err
+	}
+	watermarkStore = store
+	return nil
+}
+
+// observe updates the watermark from a response. Header watermarks are taken from the first response,
+// body watermarks keep the highest value over all pages.
+func (t *watermarkTracker) observe(config CallConfig, body []byte, headers http.Header, first bool) error {
+	if t == nil {
+		return nil
+	}
+	switch t.config.Source {
+	case "header":
+		if first && headers.Get(t.config.Field) != "" {
+			t.value = headers.Get(t.config.Field)
+		}
+	case "body":
+		val, err := extractData(body, config.DataSelectorType, t.config.Field)
+		if err != nil {
+			return fmt.Errorf("could not extract watermark: %w", err)
+		}
+		t.value = watermark.Highest(t.value, val)
+	}
+	return nil
+}
+
+// done marks the watermark to be stored once the data of the poll is published
+func (t *watermarkTracker) done() {
+	if t == nil || t.value == "" {
+		return
+	}
+	pendingWatermarksMu.Lock()
+	defer pendingWatermarksMu.Unlock()
+	pendingWatermarks[t.key] = t.value
+}
+
+// CommitWatermarks stores the watermarks of the last poll.
+// Must only be called after its data has been published, so that nothing is skipped if publishing fails.
+func CommitWatermarks() error {
+	pendingWatermarksMu.Lock()
+	defer pendingWatermarksMu.Unlock()
+
+	for key, value := range pendingWatermarks {
+		if err := watermarkStore.Save(key, value); err != nil {
+			return err
+		}
+		delete(pendingWatermarks, key)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark"
+	"github.com/stretchr/testify/require"
+)
+
+func useWatermarkStore(t *testing.T) {
+	store, err := watermark.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	watermarkStore = store
+	pendingWatermarks = map[string]string{}
+	t.Cleanup(func() { watermarkStore = nil })
+}
+
+func TestETagWatermark(t *testing.T) {
+	useWatermarkStore(t)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode([]any{map[string]any{"id": 1}})
+	}))
+	defer srv.Close()
+
+	config := &RootConfig{Call: &CallConfig{
+		URL:              srv.URL,
+		Method:           "GET",
+		DataSelectorType: "json",
+		Watermark: &watermark.Config{
+			Source:    "header",
+			Field:     "ETag",
+			Target:    "header",
+			TargetKey: "If-None-Match",
+		},
+	}}
+
+	data, err := Poll(config, nil)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+
+	// not committed yet, e.g. because publishing failed: the next poll must fetch everything again
+	data, err = Poll(config, nil)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	require.NoError(t, CommitWatermarks())
+
+	data, err = Poll(config, nil)
+	require.NoError(t, err)
+	require.Nil(t, data, "304 must not produce anything to publish")
+	require.Equal(t, 3, requests)
+}
+
+func TestBodyWatermarkOverPages(t *testing.T) {
+	useWatermarkStore(t)
+
+	records := []any{
+		map[string]any{"id": 1, "updated": "2024-05-01T10:00:00Z"},
+		map[string]any{"id": 2, "updated": "2024-05-03T09:00:00+02:00"},
+		map[string]any{"id": 3, "updated": "2024-05-02T00:00:00Z"},
+	}
+	var since []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since = append(since, r.URL.Query().Get("since"))
+		page := 0
+		if r.URL.Query().Get("page") != "" {
+			page = 1
+		}
+		next := 1
+		if page == 1 {
+			next = 0
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": records[page*2 : min(page*2+2, 3)], "next": next})
+	}))
+	defer srv.Close()
+
+	config := &RootConfig{Call: &CallConfig{
+		URL:              srv.URL,
+		Method:           "GET",
+		DataSelectorType: "json",
+		DataSelector:     "$.data",
+		Pagination: &Pagination{
+			RequestStrategy: "query",
+			LookupStrategy:  "body",
+			RequestKey:      "page",
+			OffsetBuilder:   OffsetBuilder{Next: "$.next", BreakOnNextEmpty: true},
+		},
+		Watermark: &watermark.Config{
+			Key:       "records",
+			Source:    "body",
+			Field:     "$.data[*].updated",
+			Target:    "query",
+			TargetKey: "since",
+		},
+	}}
+
+	_, err := Poll(config, nil)
+	require.NoError(t, err)
+	require.NoError(t, CommitWatermarks())
+	_, err = Poll(config, nil)
+	require.NoError(t, err)
+
+	// the watermark is injected on every page of the next poll
+	latest := "2024-05-03T09:00:00+02:00"
+	require.Equal(t, []string{"", "", latest, latest}, since)
+
+	stored, err := watermarkStore.Load("records")
+	require.NoError(t, err)
+	require.Equal(t, latest, stored)
+}
+
+func TestHighest(t *testing.T) {
+	require.Equal(t, "10", watermark.Highest("9", float64(10)))
+	require.Equal(t, "9", watermark.Highest("9", []any{"1", "2"}))
+	require.Equal(t, "Wed, 22 May 2024 10:00:00 GMT", watermark.Highest("Tue, 21 May 2024 23:00:00 GMT", "Wed, 22 May 2024 10:00:00 GMT"))
+	require.Equal(t, "cursor-b", watermark.Highest("", []any{"cursor-a", "cursor-b"}))
+	require.Equal(t, "x", watermark.Highest("x", nil))
+}
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package auth authenticates incoming push requests. Each route configures its own strategy.
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Authenticator decides if a request may push to a route
+type Authenticator interface {
+	// Authenticate returns nil if the request is allowed, or an *Error.
+	// params are the path parameters of the route, body is the already read request body if NeedsBody, else nil
+	Authenticate(req *http.Request, params map[string]string, body []byte) error
+}
+
+// NeedsBody reports if the authenticator verifies the body, which is then read before authenticating.
+// The body of the other requests is only read once they are authenticated
+func NeedsBody(a Authenticator) bool {
+	b, ok := a.(interface{ needsBody() bool })
+	return ok && b.needsBody()
+}
+
+// Error is a rejected request, with the HTTP status to answer
+type Error struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func unauthorized(msg string, err error) *Error {
+	return &Error{Status: http.StatusUnauthorized, Message: msg, Err: err}
+}
+
+func forbidden(msg string, err error) *Error {
+	return &Error{Status: http.StatusForbidden, Message: msg, Err: err}
+}
+
+// Config selects and configures an authentication strategy.
+// Secrets may reference environment variables as ${NAME}, so that they stay out of the config file.
+type Config struct {
+	Type string `yaml:"type"` // none | basic | token | hmac | uma
+
+	// basic
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// token. Each entry may hold a comma separated list, e.g. from an environment variable
+	Tokens []string `yaml:"tokens,omitempty"`
+	Header string   `yaml:"header,omitempty"` // defaults to Authorization
+	Scheme string   `yaml:"scheme,omitempty"` // e.g. Token or Bearer, defaults to Bearer for the Authorization header
+	// Base64 also accepts the tokens base64 encoded, as OCPI 2.2 sends them
+	Base64 bool `yaml:"base64,omitempty"`
+
+	Hmac *HmacConfig `yaml:"hmac,omitempty"`
+	Uma  *UmaConfig  `yaml:"uma,omitempty"`
+}
+
+// New creates the authenticator for the given config
+func New(c Config) (Authenticator, error) {
+	expandConfig(&c)
+
+	switch c.Type {
+	case "none":
+		return noAuth{}, nil
+	case "basic":
+		if c.Username == "" || c.Password == "" {
+			return nil, fmt.Errorf("basic auth requires username and password")
+		}
+		return &basicAuth{username: c.Username, password: c.Password}, nil
+	case "token":
+		return newTokenAuth(c)
+	case "hmac":
+		if c.Hmac == nil {
+			return nil, fmt.Errorf("hmac auth requires the hmac section")
+		}
+		return newHmacAuth(*c.Hmac)
+	case "uma":
+		if c.Uma == nil {
+			return nil, fmt.Errorf("uma auth requires the uma section")
+		}
+		return newUmaAuth(*c.Uma)
+	case "":
+		return nil, fmt.Errorf("missing auth type, use 'none' for public routes")
+	}
+	return nil, fmt.Errorf("unsupported auth type %q", c.Type)
+}
+
+type noAuth struct{}
+
+func (noAuth) Authenticate(req *http.Request, params map[string]string, body []byte) error {
+	return nil
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) Authenticate(req *http.Request, params map[string]string, body []byte) error {
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return unauthorized("Missing or invalid Authorization header", nil)
+	}
+	if subtle.ConstantTimeCompare([]byte(user), []byte(a.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(pass), []byte(a.password)) != 1 {
+		return unauthorized("Invalid credentials", nil)
+	}
+	return nil
+}
+
+type tokenAuth struct {
+	header string
+	scheme string
+	base64 bool
+	tokens [][]byte
+}
+
+func newTokenAuth(c Config) (*tokenAuth, error) {
+	a := &tokenAuth{header: c.Header, scheme: c.Scheme, base64: c.Base64}
+	if a.header == "" {
+		a.header = "Authorization"
+	}
+	if a.scheme == "" && strings.EqualFold(a.header, "Authorization") {
+		a.scheme = "Bearer"
+	}
+	for _, list := range c.Tokens {
+		for _, t := range strings.Split(list, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				a.tokens = append(a.tokens, []byte(t))
+			}
+		}
+	}
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("token auth requires at least one token")
+	}
+	return a, nil
+}
+
+func (a *tokenAuth) Authenticate(req *http.Request, params map[string]string, body []byte) error {
+	value := strings.TrimSpace(req.Header.Get(a.header))
+	if a.scheme != "" {
+		scheme, token, ok := strings.Cut(value, " ")
+		if !ok || !strings.EqualFold(scheme, a.scheme) {
+			return unauthorized(fmt.Sprintf("Missing or invalid %s header, expected '%s <token>'", a.header, a.scheme), nil)
+		}
+		value = strings.TrimSpace(token)
+	}
+	if value == "" {
+		return unauthorized(fmt.Sprintf("Missing %s header", a.header), nil)
+	}
+
+	candidates := [][]byte{[]byte(value)}
+	if a.base64 {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			candidates = append(candidates, decoded)
+		}
+	}
+
+	// compare against all tokens, so that the timing doesn't tell which one matched
+	found := 0
+	for _, t := range a.tokens {
+		for _, c := range candidates {
+			found |= subtle.ConstantTimeCompare(c, t)
+		}
+	}
+	if found != 1 {
+		return unauthorized("Invalid token", nil)
+	}
+	return nil
+}
+
+var envRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${NAME} with the value of the environment variable NAME.
+// Other uses of $ are kept, unlike os.ExpandEnv
+func ExpandEnv(s string) string {
+	return envRegex.ReplaceAllStringFunc(s, func(m string) string {
+		return os.Getenv(envRegex.FindStringSubmatch(m)[1])
+	})
+}
+
+func expandConfig(c *Config) {
+	c.Username = ExpandEnv(c.Username)
+	c.Password = ExpandEnv(c.Password)
+	tokens := make([]string, len(c.Tokens))
+	for i, t := range c.Tokens {
+		tokens[i] = ExpandEnv(t)
+	}
+	c.Tokens = tokens
+	if c.Hmac != nil {
+		h := *c.Hmac
+		h.Secret = ExpandEnv(h.Secret)
+		c.Hmac = &h
+	}
+	if c.Uma != nil {
+		u := *c.Uma
+		u.URL = ExpandEnv(u.URL)
+		u.Realm = ExpandEnv(u.Realm)
+		u.ClientID = ExpandEnv(u.ClientID)
+		c.Uma = &u
+	}
+}
+
+// FillParams replaces {name} placeholders with the path parameters of the request
+func FillParams(template string, params map[string]string) string {
+	for k, v := range params {
+		template = strings.ReplaceAll(template, "{"+k+"}", v)
+	}
+	return template
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func status(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return http.StatusOK
+	}
+	var authErr *Error
+	require.True(t, errors.As(err, &authErr), "unexpected error type %T", err)
+	return authErr.Status
+}
+
+func TestTokenAuth(t *testing.T) {
+	t.Setenv("PUSH_TOKENS", "t1, t2")
+	a, err := New(Config{Type: "token", Scheme: "Token", Tokens: []string{"${PUSH_TOKENS}"}})
+	require.NoError(t, err)
+
+	for header, want := range map[string]int{
+		"Token t2":  http.StatusOK,
+		"token t1":  http.StatusOK,
+		"Token t3":  http.StatusUnauthorized,
+		"Bearer t1": http.StatusUnauthorized,
+		"t1":        http.StatusUnauthorized,
+		"":          http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", header)
+		require.Equal(t, want, status(t, a.Authenticate(req, nil, nil)), header)
+	}
+
+	a, err = New(Config{Type: "token", Header: "X-Api-Key", Tokens: []string{"key"}})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-Api-Key", "key")
+	require.NoError(t, a.Authenticate(req, nil, nil))
+
+	// OCPI 2.2 sends the token base64 encoded
+	a, err = New(Config{Type: "token", Scheme: "Token", Tokens: []string{"t1"}, Base64: true})
+	require.NoError(t, err)
+	for header, want := range map[string]int{
+		"Token t1":   http.StatusOK,
+		"Token dDE=": http.StatusOK,
+		"Token dDI=": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", header)
+		require.Equal(t, want, status(t, a.Authenticate(req, nil, nil)), header)
+	}
+
+	_, err = New(Config{Type: "token", Tokens: []string{"${PUSH_TOKENS_UNSET}"}})
+	require.Error(t, err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	a, err := New(Config{Type: "basic", Username: "user", Password: "pass"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(req, nil, nil)))
+	req.SetBasicAuth("user", "wrong")
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(req, nil, nil)))
+	req.SetBasicAuth("user", "pass")
+	require.NoError(t, a.Authenticate(req, nil, nil))
+
+	_, err = New(Config{Type: "basic", Username: "user"})
+	require.Error(t, err)
+}
+
+func TestHmacAuth(t *testing.T) {
+	a, err := newHmacAuth(HmacConfig{
+		Secret:          "s3cret",
+		SignatureHeader: "X-Hub-Signature-256",
+		SignaturePrefix: "sha256=",
+		TimestampHeader: "X-Timestamp",
+		StringToSign:    "{timestamp}.{body}",
+	})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	body := []byte(`{"id":1}`)
+	sign := func(ts time.Time, body []byte) *http.Request {
+		unix := strconv.FormatInt(ts.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(unix + "." + string(body)))
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-Timestamp", unix)
+		return req
+	}
+
+	require.NoError(t, a.Authenticate(sign(now, body), nil, body))
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(sign(now, body), nil, []byte(`{"id":2}`))))
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(sign(now.Add(-time.Hour), body), nil, body)))
+
+	req := sign(now, body)
+	req.Header.Del("X-Timestamp")
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(req, nil, body)))
+
+	req = sign(now, body)
+	req.Header.Set("X-Hub-Signature-256", "not hex")
+	require.Equal(t, http.StatusUnauthorized, status(t, a.Authenticate(req, nil, body)))
+}
+
+// fakeKeycloak answers UMA decision requests, allowing only the given resource for the given token
+func fakeKeycloak(t *testing.T, token, resource string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/realms/noi/protocol/openid-connect/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "decision", r.Form.Get("response_mode"))
+		require.Equal(t, "push", r.Form.Get("audience"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		if r.Form.Get("permission") != resource {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"access_denied"}`))
+			return
+		}
+		w.Write([]byte(`{"result":true}`))
+	}))
+}
+
+func TestUmaAuth(t *testing.T) {
+	srv := fakeKeycloak(t, "valid", "/a22/traffic")
+	defer srv.Close()
+
+	a, err := New(Config{Type: "uma", Uma: &UmaConfig{URL: srv.URL, Realm: "noi", ClientID: "push"}})
+	require.NoError(t, err)
+
+	authenticate := func(header string, params map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return status(t, a.Authenticate(req, params, nil))
+	}
+
+	allowed := map[string]string{"provider": "a22", "dataset": "traffic"}
+	require.Equal(t, http.StatusOK, authenticate("Bearer valid", allowed))
+	require.Equal(t, http.StatusForbidden, authenticate("Bearer valid", map[string]string{"provider": "a22", "dataset": "other"}))
+	require.Equal(t, http.StatusUnauthorized, authenticate("Bearer expired", allowed))
+	require.Equal(t, http.StatusUnauthorized, authenticate("", allowed))
+}
+
+func TestUnknownAuthType(t *testing.T) {
+	_, err := New(Config{})
+	require.Error(t, err)
+	_, err = New(Config{Type: "oauth"})
+	require.Error(t, err)
+	_, err = New(Config{Type: "uma"})
+	require.Error(t, err)
+}
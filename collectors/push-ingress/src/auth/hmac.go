@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStringToSign = "{body}"
+
+// HmacConfig verifies a signature computed by the sender with a shared secret, like webhooks usually do
+type HmacConfig struct {
+	Secret          string `yaml:"secret"`
+	Algorithm       string `yaml:"algorithm,omitempty"`        // sha256 | sha1 | sha512, defaults to sha256
+	Encoding        string `yaml:"encoding,omitempty"`         // hex | base64, defaults to hex
+	SignatureHeader string `yaml:"signature_header,omitempty"` // defaults to X-Signature
+	SignaturePrefix string `yaml:"signature_prefix,omitempty"` // e.g. "sha256="
+	// TimestampHeader enables replay protection: the timestamp must be within MaxSkew of the local clock
+	TimestampHeader string        `yaml:"timestamp_header,omitempty"`
+	TimestampFormat string        `yaml:"timestamp_format,omitempty"` // unix | unix_ms | rfc3339, defaults to unix
+	MaxSkew         time.Duration `yaml:"max_skew,omitempty"`         // defaults to 5m
+	// StringToSign is a template with the placeholders {method} {path} {query} {timestamp} {body} {body_sha256}.
+	// Defaults to the plain body
+	StringToSign string `yaml:"string_to_sign,omitempty"`
+}
+
+type hmacAuth struct {
+	config  HmacConfig
+	newHash func() hash.Hash
+	now     func() time.Time
+}
+
+func newHmacAuth(c HmacConfig) (*hmacAuth, error) {
+	if c.Secret == "" {
+		return nil, fmt.Errorf("hmac auth requires secret")
+	}
+
+	a := &hmacAuth{config: c, now: time.Now}
+	switch c.Algorithm {
+	case "", "sha256":
+		a.newHash = sha256.New
+	case "sha1":
+		a.newHash = sha1.New
+	case "sha512":
+		a.newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported hmac algorithm %q", c.Algorithm)
+	}
+	if c.Encoding != "" && c.Encoding != "hex" && c.Encoding != "base64" {
+		return nil, fmt.Errorf("unsupported hmac encoding %q", c.Encoding)
+	}
+	switch c.TimestampFormat {
+	case "", "unix", "unix_ms", "rfc3339":
+	default:
+		return nil, fmt.Errorf("unsupported hmac timestamp format %q", c.TimestampFormat)
+	}
+
+	if a.config.SignatureHeader == "" {
+		a.config.SignatureHeader = "X-Signature"
+	}
+	if a.config.MaxSkew == 0 {
+		a.config.MaxSkew = 5 * time.Minute
+	}
+	if a.config.StringToSign == "" {
+		a.config.StringToSign = defaultStringToSign
+	}
+	return a, nil
+}
+
+func (a *hmacAuth) needsBody() bool {
+	return strings.Contains(a.config.StringToSign, "{body")
+}
+
+func (a *hmacAuth) parseTimestamp(ts string) (time.Time, error) {
+	switch a.config.TimestampFormat {
+	case "unix_ms":
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		return time.UnixMilli(ms), err
+	case "rfc3339":
+		return time.Parse(time.RFC3339, ts)
+	}
+	s, err := strconv.ParseInt(ts, 10, 64)
+	return time.Unix(s, 0), err
+}
+
+func (a *hmacAuth) Authenticate(req *http.Request, params map[string]string, body []byte) error {
+	header := strings.TrimSpace(req.Header.Get(a.config.SignatureHeader))
+	if header == "" {
+		return unauthorized(fmt.Sprintf("Missing %s header", a.config.SignatureHeader), nil)
+	}
+	encoded, ok := strings.CutPrefix(header, a.config.SignaturePrefix)
+	if !ok {
+		return unauthorized(fmt.Sprintf("Invalid %s header", a.config.SignatureHeader), nil)
+	}
+
+	var signature []byte
+	var err error
+	if a.config.Encoding == "base64" {
+		signature, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		signature, err = hex.DecodeString(strings.ToLower(encoded))
+	}
+	if err != nil {
+		return unauthorized(fmt.Sprintf("Invalid %s header", a.config.SignatureHeader), err)
+	}
+
+	ts := ""
+	if a.config.TimestampHeader != "" {
+		ts = req.Header.Get(a.config.TimestampHeader)
+		if ts == "" {
+			return unauthorized(fmt.Sprintf("Missing %s header", a.config.TimestampHeader), nil)
+		}
+		t, err := a.parseTimestamp(ts)
+		if err != nil {
+			return unauthorized(fmt.Sprintf("Invalid %s header", a.config.TimestampHeader), err)
+		}
+		if d := a.now().Sub(t); d > a.config.MaxSkew || d < -a.config.MaxSkew {
+			return unauthorized("Request timestamp outside of the accepted window", nil)
+		}
+	}
+
+	bodyHash := sha256.Sum256(body)
+	toSign := strings.NewReplacer(
+		"{method}", req.Method,
+		"{path}", req.URL.EscapedPath(),
+		"{query}", req.URL.RawQuery,
+		"{timestamp}", ts,
+		"{body}", string(body),
+		"{body_sha256}", hex.EncodeToString(bodyHash[:]),
+	).Replace(a.config.StringToSign)
+
+	mac := hmac.New(a.newHash, []byte(a.config.Secret))
+	mac.Write([]byte(toSign))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return unauthorized("Invalid signature", nil)
+	}
+	return nil
+}
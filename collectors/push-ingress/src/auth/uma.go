@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Nerzal/gocloak/v14"
+	"github.com/golang-jwt/jwt/v5/request"
+)
+
+// UmaConfig asks keycloak for a permission decision on a resource URI, using the bearer token of the request
+type UmaConfig struct {
+	URL      string `yaml:"url"`
+	Realm    string `yaml:"realm"`
+	ClientID string `yaml:"client_id"`
+	// Resource is the URI to authorize, with {param} placeholders for the path parameters.
+	// Defaults to /{provider}/{dataset}
+	Resource string `yaml:"resource,omitempty"`
+}
+
+type umaAuth struct {
+	config UmaConfig
+	client *gocloak.GoCloak
+}
+
+func newUmaAuth(c UmaConfig) (*umaAuth, error) {
+	if c.URL == "" || c.Realm == "" || c.ClientID == "" {
+		return nil, fmt.Errorf("uma auth requires url, realm and client_id")
+	}
+	if c.Resource == "" {
+		c.Resource = "/{provider}/{dataset}"
+	}
+	return &umaAuth{config: c, client: gocloak.NewClient(c.URL)}, nil
+}
+
+func (a *umaAuth) Authenticate(req *http.Request, params map[string]string, body []byte) error {
+	token, err := request.AuthorizationHeaderExtractor.ExtractToken(req)
+	if err != nil {
+		return unauthorized("Missing or invalid Authorization header", nil)
+	}
+
+	// https://www.keycloak.org/docs/latest/authorization_services/index.html#_service_obtaining_permissions
+	// Get a authorization decision from keycloak, supplying a URI
+	t := true
+	formatURI := "uri"
+	opts := gocloak.RequestingPartyTokenOptions{
+		Audience:                      &a.config.ClientID,
+		Permissions:                   []string{FillParams(a.config.Resource, params)},
+		PermissionResourceMatchingURI: &t,
+		PermissionResourceFormat:      &formatURI,
+	}
+
+	res, err := a.client.GetRequestingPartyPermissionDecision(context.Background(), token, a.config.Realm, opts)
+	if err != nil {
+		// Handle 401 vs 403
+		var apiErr *gocloak.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+			return unauthorized("Authentication failed", err)
+		}
+		return forbidden("Not authorized", err)
+	}
+	if res.Result == nil || !*res.Result {
+		return forbidden("Not authorized", nil)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+	"opendatahub.com/push-ingress/auth"
+)
+
+const defaultMaxBodySize = 10 << 20
+
+// Config is the route table of the service
+type Config struct {
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+// RouteConfig describes one push endpoint
+type RouteConfig struct {
+	// Path in echo syntax, e.g. /push/:provider/:dataset
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods,omitempty"` // defaults to POST
+	// Provider is the routing key of the published raw data, with {param} placeholders for the path parameters
+	// and ${NAME} for environment variables
+	Provider string      `yaml:"provider"`
+	Auth     auth.Config `yaml:"auth"`
+	// MaxBodySize limits the request body, as bytes or with a unit like 512KB or 10MB. Defaults to 10MB
+	MaxBodySize string `yaml:"max_body_size,omitempty"`
+	// Schema is a JSON schema file the body must validate against, relative to the config file
+	Schema string `yaml:"schema,omitempty"`
+	// Payload is how the body ends up in the raw data:
+	// raw publishes the bytes as is, json the parsed JSON document,
+	// envelope a JSON object with the path parameters, query parameters and the JSON body.
+	// none publishes nothing and answers 200, for the health checks some providers probe
+	Payload string `yaml:"payload,omitempty"`
+	// Response is the format of the answer: default or ocpi
+	Response string `yaml:"response,omitempty"`
+	// Object names the pushed object in the envelope, e.g. the OCPI module
+	Object string `yaml:"object,omitempty"`
+	// BodyParams are the body fields that must agree with a path parameter, field: param.
+	// A field missing from the body is not checked, e.g. in a partial update
+	BodyParams map[string]string `yaml:"body_params,omitempty"`
+}
+
+// route is a validated RouteConfig, ready to serve
+type route struct {
+	RouteConfig
+	auth        auth.Authenticator
+	schema      *jsonschema.Schema
+	maxBodySize int64
+}
+
+func loadConfig(path string) ([]*route, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read route config: %w", err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse route config: %w", err)
+	}
+	return newRoutes(c, filepath.Dir(path))
+}
+
+func newRoutes(c Config, dir string) ([]*route, error) {
+	if len(c.Routes) == 0 {
+		return nil, fmt.Errorf("no routes configured")
+	}
+	compiler := jsonschema.NewCompiler()
+	var routes []*route
+	for i, rc := range c.Routes {
+		r, err := newRoute(rc, dir, compiler)
+		if err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i, rc.Path, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func newRoute(rc RouteConfig, dir string, compiler *jsonschema.Compiler) (*route, error) {
+	if !strings.HasPrefix(rc.Path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+	if len(rc.Methods) == 0 {
+		rc.Methods = []string{http.MethodPost}
+	}
+	for i, m := range rc.Methods {
+		rc.Methods[i] = strings.ToUpper(m)
+	}
+	rc.Provider = auth.ExpandEnv(rc.Provider)
+	switch rc.Payload {
+	case "":
+		rc.Payload = "raw"
+	case "raw", "json", "envelope", "none":
+	default:
+		return nil, fmt.Errorf("unsupported payload %q", rc.Payload)
+	}
+	if rc.Provider == "" && rc.Payload != "none" {
+		return nil, fmt.Errorf("missing provider")
+	}
+	switch rc.Response {
+	case "":
+		rc.Response = "default"
+	case "default", "ocpi":
+	default:
+		return nil, fmt.Errorf("unsupported response %q", rc.Response)
+	}
+
+	r := &route{RouteConfig: rc, maxBodySize: defaultMaxBodySize}
+	if rc.MaxBodySize != "" {
+		size, err := parseSize(rc.MaxBodySize)
+		if err != nil {
+			return nil, err
+		}
+		r.maxBodySize = size
+	}
+
+	a, err := auth.New(rc.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth: %w", err)
+	}
+	r.auth = a
+
+	if rc.Schema != "" {
+		file := rc.Schema
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		r.schema, err = compiler.Compile(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// loadApispec reads the OpenAPI document served at /apispec. Like the route table, it may reference ${NAME} variables
+func loadApispec(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apispec: %w", err)
+	}
+	return []byte(auth.ExpandEnv(string(b))), nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses sizes like 1048576, 512KB or 10MB
+func parseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, factor = strings.TrimSpace(n), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * factor, nil
+}
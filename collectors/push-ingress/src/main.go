@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/dc"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var env struct {
+	dc.Env
+	ROUTES_CONFIG_PATH string `default:"/config/routes.yaml"`
+	HTTP_ADDRESS       string `default:":8080"`
+
+	// OpenAPI document served at /apispec, and the swagger UI showing it, where / redirects to
+	APISPEC_PATH string
+	SWAGGER_URL  string
+
+	// Accepted pushes are stored here until they are published to rabbitmq.
+	// Without a spool, a push is only answered once it is published
+	SPOOL_DIR          string
+	SPOOL_MAX_BYTES    int64 `default:"1073741824"`
+	SPOOL_MAX_MESSAGES int   `default:"100000"`
+	// reject answers new pushes with 503 when the spool is full, drop-oldest discards the oldest spooled pushes instead
+	SPOOL_FULL_POLICY string `default:"reject"`
+}
+
+func main() {
+	ms.InitWithEnv(context.Background(), "", &env)
+	slog.Info("Starting push ingress...")
+
+	defer tel.FlushOnPanic()
+
+	routes, err := loadConfig(env.ROUTES_CONFIG_PATH)
+	ms.FailOnError(context.Background(), err, "invalid route config", "path", env.ROUTES_CONFIG_PATH)
+
+	collector := dc.NewDc[dc.EmptyData](context.Background(), env.Env)
+	ctx, col := collector.StartCollection(context.Background())
+
+	// requests are served concurrently, the collection publishes one message at a time
+	var mu sync.Mutex
+	publish := func(ctx context.Context, raw *rdb.RawAny) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return col.Publish(ctx, raw)
+	}
+
+	relay := publish
+	var draining sync.WaitGroup
+	drainCtx, stopDrain := context.WithCancel(ctx)
+	if env.SPOOL_DIR != "" {
+		spool, err := OpenSpool(env.SPOOL_DIR, env.SPOOL_MAX_BYTES, env.SPOOL_MAX_MESSAGES, env.SPOOL_FULL_POLICY)
+		ms.FailOnError(ctx, err, "cannot open spool", "dir", env.SPOOL_DIR)
+		defer spool.Close()
+		registerSpoolMetrics(prometheus.DefaultRegisterer, spool)
+
+		draining.Go(func() { drain(drainCtx, spool, publish) })
+		relay = spoolPublisher(spool)
+	}
+
+	e := newServer(ctx, routes, relay)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	if env.APISPEC_PATH != "" {
+		apispec, err := loadApispec(env.APISPEC_PATH)
+		ms.FailOnError(ctx, err, "invalid apispec", "path", env.APISPEC_PATH)
+		serveApispec(e, apispec, env.SWAGGER_URL)
+	}
+
+	go func() {
+		if err := e.Start(env.HTTP_ADDRESS); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ms.FailOnError(ctx, err, "http server failed")
+		}
+	}()
+	for _, r := range routes {
+		slog.Info("Serving route", "path", r.Path, "methods", r.Methods, "provider", r.Provider, "auth", r.Auth.Type)
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("failed to shut down http server", "err", err)
+	}
+	// the pushes still in the spool are published on the next start
+	stopDrain()
+	draining.Wait()
+	col.End(ctx)
+}
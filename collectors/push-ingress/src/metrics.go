@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerSpoolMetrics exposes the spool state, read on every scrape
+func registerSpoolMetrics(reg prometheus.Registerer, spool *Spool) {
+	gauge := func(name, help string, value func(SpoolStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: "push_ingress", Subsystem: "spool", Name: name, Help: help},
+			func() float64 { return value(spool.Stats()) })
+	}
+	counter := func(name, help string, value func(SpoolStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: "push_ingress", Subsystem: "spool", Name: name, Help: help},
+			func() float64 { return float64(value(spool.Stats())) })
+	}
+
+	reg.MustRegister(
+		gauge("messages", "Messages waiting to be published to rabbitmq", func(s SpoolStats) float64 { return float64(s.Messages) }),
+		gauge("bytes", "Size of the messages waiting to be published", func(s SpoolStats) float64 { return float64(s.Bytes) }),
+		gauge("oldest_age_seconds", "Age of the oldest message waiting to be published, 0 if the spool is empty", func(s SpoolStats) float64 {
+			if s.Oldest.IsZero() {
+				return 0
+			}
+			return time.Since(s.Oldest).Seconds()
+		}),
+		counter("appended_total", "Messages accepted into the spool", func(s SpoolStats) uint64 { return s.Appended }),
+		counter("drained_total", "Messages published to rabbitmq and removed from the spool", func(s SpoolStats) uint64 { return s.Drained }),
+		counter("rejected_total", "Pushes rejected because the spool was full", func(s SpoolStats) uint64 { return s.Rejected }),
+		counter("dropped_total", "Messages dropped because the spool was full", func(s SpoolStats) uint64 { return s.Dropped }),
+	)
+}
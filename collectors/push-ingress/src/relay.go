@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+)
+
+// spooledPush is a push as it's stored in the spool until it's published
+type spooledPush struct {
+	ID          string    `json:"id"`
+	Provider    string    `json:"provider"`
+	Timestamp   time.Time `json:"timestamp"`
+	ContentType string    `json:"content_type,omitempty"`
+	// Raw is the body of raw payload routes, JSON the document of json and envelope routes
+	Raw  []byte          `json:"raw,omitempty"`
+	JSON json.RawMessage `json:"json,omitempty"`
+}
+
+func toSpooled(raw *rdb.RawAny) ([]byte, error) {
+	p := spooledPush{ID: raw.ID, Provider: raw.Provider, Timestamp: raw.Timestamp, ContentType: raw.ContentType}
+	switch data := raw.Rawdata.(type) {
+	case []byte:
+		p.Raw = data
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		p.JSON = b
+	}
+	return json.Marshal(p)
+}
+
+func fromSpooled(msg []byte) (*rdb.RawAny, error) {
+	var p spooledPush
+	if err := json.Unmarshal(msg, &p); err != nil {
+		return nil, err
+	}
+	raw := &rdb.RawAny{ID: p.ID, Provider: p.Provider, Timestamp: p.Timestamp, ContentType: p.ContentType}
+	switch {
+	case p.JSON != nil:
+		raw.Rawdata = p.JSON
+	case p.Raw != nil:
+		raw.Rawdata = p.Raw
+	default:
+		// an empty raw body is omitted from the spool file
+		raw.Rawdata = []byte{}
+	}
+	return raw, nil
+}
+
+// spoolPublisher acknowledges a push once it's on disk, drain publishes it in the background
+func spoolPublisher(spool *Spool) publisher {
+	return func(ctx context.Context, raw *rdb.RawAny) error {
+		msg, err := toSpooled(raw)
+		if err != nil {
+			return fmt.Errorf("failed to encode push: %w", err)
+		}
+		return spool.Append(msg)
+	}
+}
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// drain publishes the spooled pushes in order, waiting for the broker to come back when it's unavailable
+func drain(ctx context.Context, spool *Spool, publish publisher) {
+	backoff := minBackoff
+
+	retry := func(msg string, args ...any) {
+		slog.Error(msg, append(args, "retryIn", backoff)...)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	for ctx.Err() == nil {
+		seq, msg, ok, err := spool.Peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-spool.Notify():
+			}
+			continue
+		}
+		if err != nil {
+			retry("Error reading spooled push", "seq", seq, "err", err)
+			continue
+		}
+
+		raw, err := fromSpooled(msg)
+		if err != nil {
+			slog.Error("Discarding undeliverable spooled push", "seq", seq, "err", err)
+			if err := spool.Discard(seq); err != nil {
+				slog.Error("Error discarding spooled push", "seq", seq, "err", err)
+			}
+			continue
+		}
+
+		if err := publish(ctx, raw); err != nil {
+			// the push stays at the head of the spool and is published again
+			retry("Unable to relay spooled push", "seq", seq, "id", raw.ID, "err", err)
+			continue
+		}
+		backoff = minBackoff
+		if err := spool.Remove(seq); err != nil {
+			slog.Error("Error removing published push from spool", "seq", seq, "err", err)
+		}
+	}
+}
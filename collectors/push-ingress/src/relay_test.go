@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedPublisher is a fakePublisher shared with the drain goroutine
+type lockedPublisher struct {
+	mu sync.Mutex
+	fakePublisher
+}
+
+func (p *lockedPublisher) publish(ctx context.Context, raw *rdb.RawAny) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fakePublisher.publish(ctx, raw)
+}
+
+func (p *lockedPublisher) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func TestPushIsAcceptedOnceSpooled(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20, 100, "reject")
+	require.NoError(t, err)
+	defer spool.Close()
+	e := newServer(context.Background(), loadTestRoutes(t, testRoutes, map[string]string{"skidata.json": skidataSchema}), spoolPublisher(spool))
+
+	rec := do(e, http.MethodPost, "/push/a22/traffic", "a;b;c", map[string]string{"Authorization": "Bearer secret1", "Content-Type": "text/csv"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp pushResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	skidata := `{"carpark":{"facilityNr":608448}}`
+	rec = do(e, http.MethodPost, "/skidata/parking-stations/pushEvents", skidata, map[string]string{"Authorization": "Basic c2tpZGF0YTpwYXNz"})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(e, http.MethodPatch, "/ocpi/emsp/2.2.1/locations/IT/NOI/LOC1", `{"status":"AVAILABLE"}`, map[string]string{"Authorization": "Token ocpi"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 3, spool.Stats().Messages)
+
+	// the broker is down at first, the pushes stay in the spool and are published in order once it's back
+	p := &lockedPublisher{fakePublisher: fakePublisher{err: errors.New("connection refused")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		drain(ctx, spool, p.publish)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 3, spool.Stats().Messages)
+	p.setErr(nil)
+	require.Eventually(t, func() bool { return spool.Stats().Messages == 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	// published as if without the spool
+	require.Len(t, p.published, 3)
+	require.Equal(t, resp.ID, p.published[0].ID)
+	require.Equal(t, "a22/traffic", p.published[0].Provider)
+	require.Equal(t, "text/csv", p.published[0].ContentType)
+	require.Equal(t, []byte("a;b;c"), p.published[0].Rawdata)
+	require.Equal(t, "skidata/parking-stations", p.published[1].Provider)
+	require.Equal(t, json.RawMessage(skidata), p.published[1].Rawdata)
+	b, err := json.Marshal(p.published[2].Rawdata)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"method":"PATCH","params":{"country_code":"IT","party_id":"NOI","location_id":"LOC1"},"body":{"status":"AVAILABLE"}}`, string(b))
+}
+
+func TestPushRejectedWhenSpoolFull(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20, 1, "reject")
+	require.NoError(t, err)
+	defer spool.Close()
+	e := newServer(context.Background(), loadTestRoutes(t, testRoutes, map[string]string{"skidata.json": skidataSchema}), spoolPublisher(spool))
+	header := map[string]string{"Authorization": "Bearer secret1"}
+
+	rec := do(e, http.MethodPost, "/push/a22/traffic", "1", header)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// like rest-push, the client is asked to retry later
+	rec = do(e, http.MethodPost, "/push/a22/traffic", "2", header)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+	require.JSONEq(t, `{"message":"Unable to accept data, try again later"}`, rec.Body.String())
+
+	rec = do(e, http.MethodPut, "/ocpi/emsp/2.2.1/locations/IT/NOI/LOC1", `{}`, map[string]string{"Authorization": "Token ocpi"})
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.True(t, strings.Contains(rec.Body.String(), `"status_code":3000`))
+}
+
+func TestDrainDiscardsUndeliverablePushes(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20, 100, "reject")
+	require.NoError(t, err)
+	defer spool.Close()
+	require.NoError(t, spool.Append([]byte("not json")))
+	require.NoError(t, spoolPublisher(spool)(context.Background(), &rdb.RawAny{ID: "1", Provider: "a/b", Rawdata: []byte{}}))
+
+	p := &lockedPublisher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		drain(ctx, spool, p.publish)
+	}()
+	require.Eventually(t, func() bool { return spool.Stats().Messages == 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	require.Len(t, p.published, 1)
+	require.Equal(t, "1", p.published[0].ID)
+	require.Equal(t, []byte{}, p.published[0].Rawdata)
+}
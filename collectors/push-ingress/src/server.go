@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"opendatahub.com/push-ingress/auth"
+)
+
+type publisher func(ctx context.Context, raw *rdb.RawAny) error
+
+type pushResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// envelope carries the request context for providers that push to parametrized URLs, like OCPI
+type envelope struct {
+	Object string              `json:"object,omitempty"`
+	Method string              `json:"method"`
+	Params map[string]string   `json:"params"`
+	Query  map[string][]string `json:"query,omitempty"`
+	// Body is omitted for requests without body, like an OCPI DELETE
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+type ocpiResponse struct {
+	StatusCode    int    `json:"status_code"`
+	StatusMessage string `json:"status_message,omitempty"`
+	// OCPI is particular about date formats, only a subset of RFC 3339 is supported, and must be in UTC
+	Timestamp string `json:"timestamp"`
+}
+
+func newServer(ctx context.Context, routes []*route, publish publisher) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+
+	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+	e.Use(middleware.Decompress())
+	e.Use(middleware.CORS())
+
+	e.GET("/health", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, r := range routes {
+		h := func(c echo.Context) error { return handlePush(ctx, c, r, publish) }
+		for _, m := range r.Methods {
+			e.Add(m, r.Path, h)
+		}
+	}
+	return e
+}
+
+// serveApispec serves the OpenAPI document of the route table at /apispec,
+// and redirects / to the swagger UI showing it if swaggerURL is set
+func serveApispec(e *echo.Echo, apispec []byte, swaggerURL string) {
+	e.GET("/apispec", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "application/yaml; charset=utf-8", apispec)
+	})
+	if swaggerURL != "" {
+		e.GET("/", func(c echo.Context) error {
+			return c.Redirect(http.StatusPermanentRedirect, swaggerURL)
+		})
+	}
+}
+
+func handlePush(ctx context.Context, c echo.Context, r *route, publish publisher) error {
+	req := c.Request()
+	if r.Response == "ocpi" {
+		// OCPI 2.2 requires the request and correlation IDs to be echoed
+		for _, h := range []string{"X-Request-ID", "X-Correlation-ID"} {
+			if v := req.Header.Get(h); v != "" {
+				c.Response().Header().Set(h, v)
+			}
+		}
+	}
+
+	params := map[string]string{}
+	for i, name := range c.ParamNames() {
+		params[name] = c.ParamValues()[i]
+	}
+
+	// the body is only read before authenticating if a signature is computed over it,
+	// so that unauthenticated requests don't get their body buffered
+	signed := auth.NeedsBody(r.auth)
+	var body []byte
+	var err error
+	if signed {
+		if body, err = readBody(c, r); err != nil {
+			return rejectBody(c, r, err)
+		}
+	}
+
+	if err := r.auth.Authenticate(req, params, body); err != nil {
+		var authErr *auth.Error
+		if errors.As(err, &authErr) {
+			return reject(c, r, authErr.Status, authErr.Message, authErr.Err)
+		}
+		return reject(c, r, http.StatusForbidden, "Not authorized", err)
+	}
+
+	if r.Payload == "none" {
+		return c.NoContent(http.StatusOK)
+	}
+
+	if !signed {
+		if body, err = readBody(c, r); err != nil {
+			return rejectBody(c, r, err)
+		}
+	}
+
+	bodyless := len(body) == 0 && r.Payload == "envelope" && r.schema == nil
+	if !bodyless && (r.schema != nil || r.Payload != "raw") {
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+		if err != nil {
+			return reject(c, r, http.StatusBadRequest, "Request body is not valid JSON", err)
+		}
+		if r.schema != nil {
+			if err := r.schema.Validate(doc); err != nil {
+				// the first line names the schema file, only the details concern the client
+				_, details, _ := strings.Cut(err.Error(), "\n")
+				return reject(c, r, http.StatusBadRequest, "Request body does not match the schema: "+strings.TrimSpace(details), nil)
+			}
+		}
+		if err := matchBodyParams(r, doc, params); err != nil {
+			return reject(c, r, http.StatusBadRequest, err.Error(), nil)
+		}
+	}
+
+	var rawdata any
+	switch r.Payload {
+	case "json":
+		rawdata = json.RawMessage(body)
+	case "envelope":
+		rawdata = envelope{Object: r.Object, Method: req.Method, Params: params, Query: c.QueryParams(), Body: json.RawMessage(body)}
+	default:
+		rawdata = body
+	}
+
+	id := uuid.NewString()
+	provider := auth.FillParams(r.Provider, params)
+	slog.Debug("Incoming push", "id", id, "provider", provider, "path", r.Path)
+
+	err = publish(ctx, &rdb.RawAny{
+		ID:          id,
+		Provider:    provider,
+		Timestamp:   time.Now(),
+		Rawdata:     rawdata,
+		ContentType: req.Header.Get(echo.HeaderContentType),
+	})
+	if errors.Is(err, ErrSpoolFull) {
+		slog.Error("Spool full, rejecting push", "id", id, "provider", provider, "err", err)
+		c.Response().Header().Set(echo.HeaderRetryAfter, "60")
+		return reject(c, r, http.StatusServiceUnavailable, "Unable to accept data, try again later", err)
+	}
+	if err != nil {
+		slog.Error("Unable to relay push", "id", id, "provider", provider, "err", err)
+		return reject(c, r, http.StatusInternalServerError, "Unable to relay data", err)
+	}
+
+	if r.Response == "ocpi" {
+		return c.JSON(http.StatusOK, ocpiResponse{StatusCode: 1000, Timestamp: ocpiTimestamp()})
+	}
+	return c.JSON(http.StatusOK, pushResponse{"Data accepted", id})
+}
+
+// matchBodyParams checks that the identifying fields of the body agree with the URL, if the body has them
+func matchBodyParams(r *route, doc any, params map[string]string) error {
+	obj, _ := doc.(map[string]any)
+	for _, field := range slices.Sorted(maps.Keys(r.BodyParams)) {
+		param := r.BodyParams[field]
+		v, ok := obj[field]
+		if !ok || params[param] == "" {
+			continue
+		}
+		if s, isString := v.(string); !isString || s != params[param] {
+			return fmt.Errorf("%s %v doesn't match the URL %s", field, v, params[param])
+		}
+	}
+	return nil
+}
+
+func readBody(c echo.Context, r *route) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, r.maxBodySize))
+}
+
+func rejectBody(c echo.Context, r *route, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return reject(c, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", r.maxBodySize), nil)
+	}
+	return reject(c, r, http.StatusBadRequest, "Unable to read request body", err)
+}
+
+func ocpiTimestamp() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// reject answers a failed request in the format of the route
+func reject(c echo.Context, r *route, status int, msg string, internal error) error {
+	if r.Response == "ocpi" {
+		if internal != nil {
+			slog.Warn("Rejected push", "path", c.Path(), "status", status, "err", internal)
+		}
+		// OCPI status codes: 2000 generic client error, 2001 invalid or missing parameters, 3000 generic server error
+		code := 2001
+		switch {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			code = 2000
+		case status >= 500:
+			code = 3000
+		}
+		return c.JSON(status, ocpiResponse{StatusCode: code, StatusMessage: msg, Timestamp: ocpiTimestamp()})
+	}
+	return echo.NewHTTPError(status, msg).WithInternal(internal)
+}
@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/require"
+	"opendatahub.com/push-ingress/auth"
+)
+
+type fakePublisher struct {
+	published []*rdb.RawAny
+	err       error
+}
+
+func (p *fakePublisher) publish(ctx context.Context, raw *rdb.RawAny) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, raw)
+	return nil
+}
+
+func loadTestRoutes(t *testing.T, config string, files map[string]string) []*route {
+	t.Helper()
+	t.Setenv("PUSH_INGRESS_TEST_PASS", "pass")
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	path := filepath.Join(dir, "routes.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+
+	routes, err := loadConfig(path)
+	require.NoError(t, err)
+	return routes
+}
+
+func testServer(t *testing.T, config string, files map[string]string) (*echo.Echo, *fakePublisher) {
+	t.Helper()
+	p := &fakePublisher{}
+	return newServer(context.Background(), loadTestRoutes(t, config, files), p.publish), p
+}
+
+func do(e *echo.Echo, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+const testRoutes = `
+routes:
+  - path: /push/:provider/:dataset
+    provider: "{provider}/{dataset}"
+    auth:
+      type: token
+      tokens: ["secret1,secret2"]
+    max_body_size: 64B
+  - path: /skidata/parking-stations/*
+    provider: skidata/parking-stations
+    payload: json
+    schema: skidata.json
+    auth:
+      type: basic
+      username: skidata
+      password: ${PUSH_INGRESS_TEST_PASS}
+  - path: /ocpi/emsp/2.2.1/locations/:country_code/:party_id/:location_id
+    methods: [put, patch]
+    provider: ocpi/locations
+    payload: envelope
+    response: ocpi
+    auth:
+      type: token
+      scheme: Token
+      tokens: [ocpi]
+`
+
+const skidataSchema = `{
+  "type": "object",
+  "required": ["carpark"],
+  "properties": {"carpark": {"type": "object", "required": ["facilityNr"]}}
+}`
+
+func TestPushPublishesRawBody(t *testing.T) {
+	e, p := testServer(t, testRoutes, map[string]string{"skidata.json": skidataSchema})
+
+	rec := do(e, http.MethodPost, "/push/a22/traffic?from=1", "a;b;c", map[string]string{
+		"Authorization": "Bearer secret2",
+		"Content-Type":  "text/csv",
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp pushResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "Data accepted", resp.Message)
+
+	require.Len(t, p.published, 1)
+	require.Equal(t, resp.ID, p.published[0].ID)
+	require.Equal(t, "a22/traffic", p.published[0].Provider)
+	require.Equal(t, "text/csv", p.published[0].ContentType)
+	require.Equal(t, []byte("a;b;c"), p.published[0].Rawdata)
+}
+
+func TestPushRejectsUnauthorizedAndOversizedRequests(t *testing.T) {
+	e, p := testServer(t, testRoutes, map[string]string{"skidata.json": skidataSchema})
+
+	rec := do(e, http.MethodPost, "/push/a22/traffic", "{}", nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(e, http.MethodPost, "/push/a22/traffic", "{}", map[string]string{"Authorization": "Bearer wrong"})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(e, http.MethodPost, "/push/a22/traffic", strings.Repeat("x", 65), map[string]string{"Authorization": "Bearer secret1"})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec = do(e, http.MethodGet, "/push/a22/traffic", "", map[string]string{"Authorization": "Bearer secret1"})
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	require.Empty(t, p.published)
+}
+
+// readCounter counts the bytes read from a request body
+type readCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestPushAuthenticatesBeforeReadingBody(t *testing.T) {
+	e, p := testServer(t, testRoutes+`
+  - path: /webhook
+    provider: example/webhook
+    auth:
+      type: hmac
+      hmac:
+        secret: secret
+`, map[string]string{"skidata.json": skidataSchema})
+
+	body := &readCounter{r: strings.NewReader(strings.Repeat("x", 1000))}
+	req := httptest.NewRequest(http.MethodPost, "/push/a22/traffic", body)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Zero(t, body.n, "the body of an unauthenticated request is not read")
+
+	// the signature is computed over the body, which is read first
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`{"id":1}`))
+	rec = do(e, http.MethodPost, "/webhook", `{"id":1}`, map[string]string{"X-Signature": hex.EncodeToString(mac.Sum(nil))})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(e, http.MethodPost, "/webhook", `{"id":2}`, map[string]string{"X-Signature": hex.EncodeToString(mac.Sum(nil))})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Len(t, p.published, 1)
+	require.Equal(t, []byte(`{"id":1}`), p.published[0].Rawdata)
+}
+
+func TestPushValidatesSchema(t *testing.T) {
+	e, p := testServer(t, testRoutes, map[string]string{"skidata.json": skidataSchema})
+	basic := map[string]string{"Authorization": "Basic c2tpZGF0YTpwYXNz"} // skidata:pass
+
+	rec := do(e, http.MethodPost, "/skidata/parking-stations/pushEvents", `{"carpark":{}}`, basic)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "missing property 'facilityNr'")
+	require.NotContains(t, rec.Body.String(), "skidata.json")
+
+	rec = do(e, http.MethodPost, "/skidata/parking-stations/pushEvents", `{"carpark":`, basic)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	body := `{"carpark":{"facilityNr":608448}}`
+	rec = do(e, http.MethodPost, "/skidata/parking-stations/pushEvents", body, basic)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, p.published, 1)
+	require.Equal(t, json.RawMessage(body), p.published[0].Rawdata)
+}
+
+func TestPushEnvelopeWithOcpiResponse(t *testing.T) {
+	e, p := testServer(t, testRoutes, map[string]string{"skidata.json": skidataSchema})
+	header := map[string]string{"Authorization": "Token ocpi"}
+
+	rec := do(e, http.MethodPatch, "/ocpi/emsp/2.2.1/locations/IT/NOI/LOC1", `{"status":"AVAILABLE"}`, header)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp ocpiResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, 1000, resp.StatusCode)
+	require.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$`, resp.Timestamp)
+
+	require.Len(t, p.published, 1)
+	require.Equal(t, "ocpi/locations", p.published[0].Provider)
+	env := p.published[0].Rawdata.(envelope)
+	require.Equal(t, map[string]string{"country_code": "IT", "party_id": "NOI", "location_id": "LOC1"}, env.Params)
+	require.JSONEq(t, `{"status":"AVAILABLE"}`, string(env.Body))
+
+	rec = do(e, http.MethodPut, "/ocpi/emsp/2.2.1/locations/IT/NOI/LOC1", `not json`, header)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, 2001, resp.StatusCode)
+
+	p.err = errors.New("connection closed")
+	rec = do(e, http.MethodPut, "/ocpi/emsp/2.2.1/locations/IT/NOI/LOC1", `{}`, header)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, 3000, resp.StatusCode)
+}
+
+func TestInvalidRouteConfig(t *testing.T) {
+	for name, rc := range map[string]RouteConfig{
+		"no provider":     {Path: "/a", Auth: auth.Config{Type: "none"}},
+		"relative path":   {Path: "a", Provider: "p", Auth: auth.Config{Type: "none"}},
+		"no auth":         {Path: "/a", Provider: "p"},
+		"unknown payload": {Path: "/a", Provider: "p", Auth: auth.Config{Type: "none"}, Payload: "xml"},
+		"invalid size":    {Path: "/a", Provider: "p", Auth: auth.Config{Type: "none"}, MaxBodySize: "10XB"},
+		"missing schema":  {Path: "/a", Provider: "p", Auth: auth.Config{Type: "none"}, Schema: "missing.json"},
+	} {
+		_, err := newRoutes(Config{Routes: []RouteConfig{rc}}, t.TempDir())
+		require.Error(t, err, name)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"1024": 1024, "64B": 64, "512KB": 512 << 10, "10 mb": 10 << 20, "1GB": 1 << 30} {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+	_, err := parseSize("-1MB")
+	require.Error(t, err)
+}
@@ -0,0 +1,333 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrSpoolFull is returned by Append when the spool reached its size limit and the policy is to reject
+var ErrSpoolFull = errors.New("spool is full")
+
+const (
+	spoolExt = ".msg"
+	tmpExt   = ".tmp"
+	badExt   = ".bad"
+)
+
+type spoolEntry struct {
+	seq   uint64
+	size  int64
+	added time.Time
+}
+
+// Spool is an on-disk write-ahead queue. Every message is a file named by its sequence number,
+// which is fsync'd before Append returns, so that accepted pushes survive a restart.
+// Messages are consumed in order with Peek and Remove.
+type Spool struct {
+	dir         string
+	maxBytes    int64
+	maxMessages int
+	dropOldest  bool
+	lock        *os.File
+
+	mu      sync.Mutex
+	entries []spoolEntry // oldest first
+	bytes   int64        // including the reserved space of writes in progress
+	nextSeq uint64
+	notify  chan struct{}
+
+	appended atomic.Uint64
+	drained  atomic.Uint64
+	rejected atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// OpenSpool opens the spool in dir, picking up the messages left by a previous run.
+// policy decides what happens when the limits are reached: reject new messages, or drop-oldest
+func OpenSpool(dir string, maxBytes int64, maxMessages int, policy string) (*Spool, error) {
+	s := &Spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxMessages: maxMessages,
+		notify:      make(chan struct{}, 1),
+	}
+	switch policy {
+	case "reject":
+	case "drop-oldest":
+		s.dropOldest = true
+	default:
+		return nil, fmt.Errorf("unsupported spool full policy %q, use reject or drop-oldest", policy)
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	// two processes draining the same spool would publish messages twice and out of order
+	lock, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool lock: %w", err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("spool %s is in use by another process: %w", dir, err)
+	}
+	s.lock = lock
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool dir: %w", err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, tmpExt):
+			// a write interrupted by a crash, it was never acknowledged to the client
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return fmt.Errorf("failed to remove incomplete spool file: %w", err)
+			}
+		case strings.HasSuffix(name, spoolExt):
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+			if err != nil {
+				slog.Warn("Ignoring unknown file in spool dir", "file", name)
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat spool file: %w", err)
+			}
+			s.entries = append(s.entries, spoolEntry{seq: seq, size: info.Size(), added: info.ModTime()})
+			s.bytes += info.Size()
+			s.nextSeq = max(s.nextSeq, seq+1)
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+	if len(s.entries) > 0 {
+		slog.Info("Recovered spooled messages", "messages", len(s.entries), "bytes", s.bytes)
+		s.signal()
+	}
+	return nil
+}
+
+func (s *Spool) path(seq uint64, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Notify signals that messages were appended
+func (s *Spool) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// reserve assigns the sequence number and space for a new message
+func (s *Spool) reserve(size int64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if size > s.maxBytes {
+		s.rejected.Add(1)
+		return 0, fmt.Errorf("%w: message of %d bytes exceeds the spool size", ErrSpoolFull, size)
+	}
+	for s.bytes+size > s.maxBytes || len(s.entries) >= s.maxMessages {
+		if !s.dropOldest || len(s.entries) == 0 {
+			s.rejected.Add(1)
+			return 0, ErrSpoolFull
+		}
+		oldest := s.entries[0]
+		s.entries = s.entries[1:]
+		s.bytes -= oldest.size
+		s.dropped.Add(1)
+		slog.Warn("Spool full, dropping oldest message", "seq", oldest.seq, "added", oldest.added)
+		if err := os.Remove(s.path(oldest.seq, spoolExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Failed to remove dropped spool message", "seq", oldest.seq, "err", err)
+		}
+	}
+
+	seq := s.nextSeq
+	s.nextSeq++
+	s.bytes += size
+	return seq, nil
+}
+
+// Append durably stores a message. Once it returns without error, the message survives a crash
+func (s *Spool) Append(data []byte) error {
+	size := int64(len(data))
+	seq, err := s.reserve(size)
+	if err != nil {
+		return err
+	}
+
+	err = s.write(seq, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.bytes -= size
+		return err
+	}
+	// concurrent appends may finish out of order, keep the queue sorted
+	i := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].seq > seq })
+	s.entries = append(s.entries, spoolEntry{})
+	copy(s.entries[i+1:], s.entries[i:])
+	s.entries[i] = spoolEntry{seq: seq, size: size, added: time.Now()}
+	s.appended.Add(1)
+	s.signal()
+	return nil
+}
+
+// write stores the message in a temporary file and renames it, so that a crash never leaves a partial message
+func (s *Spool) write(seq uint64, data []byte) error {
+	tmp := s.path(seq, tmpExt)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path(seq, spoolExt))
+	}
+	if err == nil {
+		err = s.syncDir()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	return nil
+}
+
+// syncDir persists the rename of a spool file
+func (s *Spool) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Peek returns the oldest message, ok is false if the spool is empty
+func (s *Spool) Peek() (seq uint64, data []byte, ok bool, err error) {
+	s.mu.Lock()
+	if len(s.entries) == 0 {
+		s.mu.Unlock()
+		return 0, nil, false, nil
+	}
+	seq = s.entries[0].seq
+	s.mu.Unlock()
+
+	data, err = os.ReadFile(s.path(seq, spoolExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// removed behind our back, there is nothing left to deliver
+			s.unlink(seq)
+		}
+		return seq, nil, true, fmt.Errorf("failed to read spool file: %w", err)
+	}
+	return seq, data, true, nil
+}
+
+func (s *Spool) unlink(seq uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.seq == seq {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			s.bytes -= e.size
+			return true
+		}
+	}
+	// already dropped by the size policy
+	return false
+}
+
+// Remove deletes a message after it has been delivered
+func (s *Spool) Remove(seq uint64) error {
+	if !s.unlink(seq) {
+		return nil
+	}
+	s.drained.Add(1)
+	if err := os.Remove(s.path(seq, spoolExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove spool file: %w", err)
+	}
+	return nil
+}
+
+// Discard sets aside a message that can't be delivered, keeping the file for inspection
+func (s *Spool) Discard(seq uint64) error {
+	if !s.unlink(seq) {
+		return nil
+	}
+	if err := os.Rename(s.path(seq, spoolExt), s.path(seq, badExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to discard spool file: %w", err)
+	}
+	return nil
+}
+
+// SpoolStats is a snapshot of the spool state
+type SpoolStats struct {
+	Messages int
+	Bytes    int64
+	Oldest   time.Time // zero if the spool is empty
+
+	Appended uint64
+	Drained  uint64
+	Rejected uint64
+	Dropped  uint64
+}
+
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SpoolStats{
+		Messages: len(s.entries),
+		Bytes:    s.bytes,
+		Appended: s.appended.Load(),
+		Drained:  s.drained.Load(),
+		Rejected: s.rejected.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+	if len(s.entries) > 0 {
+		st.Oldest = s.entries[0].added
+	}
+	return st
+}
+
+// Close releases the lock on the spool dir
+func (s *Spool) Close() error {
+	return s.lock.Close()
+}
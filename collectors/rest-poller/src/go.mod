@@ -3,7 +3,9 @@ module opendatahub.com/rest-poller
 go 1.25.0
 
 require (
+	github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark v0.0.0
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
+	github.com/oliveagle/jsonpath v0.1.4
 	github.com/robfig/cron/v3 v3.0.1
 )
 
@@ -80,3 +82,5 @@ require (
 	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.7
 	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
 )
+
+replace github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark => ../../utils/watermark
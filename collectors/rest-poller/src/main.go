@@ -6,6 +6,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
@@ -34,6 +35,12 @@ var env struct {
 	PAGING_SIZE        int
 	PAGING_LIMIT_NAME  string
 	PAGING_OFFSET_NAME string
+
+	WATERMARK_SOURCE     string // header | body
+	WATERMARK_FIELD      string // header name or JSONPath into the response body
+	WATERMARK_TARGET     string // header | query
+	WATERMARK_TARGET_KEY string
+	WATERMARK_STORE_PATH string
 }
 
 const ENV_HEADER_PREFIX = "HTTP_HEADER_"
@@ -46,62 +53,94 @@ func main() {
 
 	collector := dc.NewDc[dc.EmptyData](context.Background(), env.Env)
 
+	headers := customHeaders()
+	u, err := url.Parse(env.HTTP_URL)
+	ms.FailOnError(context.Background(), err, "failed parsing poll URL")
+
+	wm, err := newWatermark()
+	ms.FailOnError(context.Background(), err, "failed setting up watermark")
+
 	c := cron.New(cron.WithSeconds())
 	c.AddFunc(env.CRON, func() {
-		collector.GetInputChannel() <- dc.NewInput[dc.EmptyData](context.Background(), nil)
+		// a failed poll is only logged, the next scheduled poll retries it
+		if err := poll(context.Background(), collector, u, headers, wm); err != nil {
+			slog.Error("poll job failed", "err", err)
+		}
 	})
 
 	slog.Info("Setup complete. Starting cron scheduler")
-	go func() {
-		c.Run()
-	}()
+	c.Run()
+}
 
-	headers := customHeaders()
-	u, err := url.Parse(env.HTTP_URL)
-	ms.FailOnError(context.Background(), err, "failed parsing poll URL")
+func poll(ctx context.Context, collector *dc.Dc[dc.EmptyData], u *url.URL, headers http.Header, wm *watermarkState) error {
+	ctx, col := collector.StartCollection(ctx)
+	defer col.End(ctx)
 
-	err = collector.Start(context.Background(), func(ctx context.Context, a dc.EmptyData) (*rdb.RawAny, error) {
-		slog.Info("Starting poll job")
-		jobstart := time.Now()
+	slog.Info("Starting poll job")
+	jobstart := time.Now()
 
-		req, err := http.NewRequest(env.HTTP_METHOD, u.String(), http.NoBody)
-		ms.FailOnError(context.Background(), err, "could not create http request")
+	pollURL := *u
+	headers = headers.Clone()
+	if err := wm.inject(&pollURL, headers); err != nil {
+		return err
+	}
 
-		req.Header = headers
+	req, err := http.NewRequest(env.HTTP_METHOD, pollURL.String(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("could not create http request: %w", err)
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			slog.Error("error during http request:", "err", err)
-			return nil, err
-		}
-		if resp.StatusCode != http.StatusOK {
-			slog.Error("http request returned non-OK status", "statusCode", resp.StatusCode)
-			return nil, err
-		}
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("error reading response body:", "err", err)
-			return nil, err
-		}
+	req.Header = headers
 
-		var raw any
-		if env.RAW_BINARY {
-			raw = body
-		} else {
-			raw = string(body)
-		}
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Error("error during http request:", "err", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		slog.Info("Endpoint not modified since last poll, nothing to publish", "watermark", wm.value)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("http request returned non-OK status", "statusCode", resp.StatusCode)
+		return fmt.Errorf("http request returned non-OK status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Error("error reading response body:", "err", err)
+		return err
+	}
 
-		ret := rdb.RawAny{
-			Provider:  env.PROVIDER,
-			Timestamp: time.Now(),
-			Rawdata:   raw,
-		}
-		slog.Info("Polling job completed", "runtime_ms", time.Since(jobstart).Milliseconds())
-		return &ret, nil
+	next, err := wm.next(body, resp.Header)
+	if err != nil {
+		return err
+	}
+
+	var raw any
+	if env.RAW_BINARY {
+		raw = body
+	} else {
+		raw = string(body)
+	}
+
+	err = col.Publish(ctx, &rdb.RawAny{
+		Provider:  env.PROVIDER,
+		Timestamp: time.Now(),
+		Rawdata:   raw,
 	})
-	ms.FailOnError(context.Background(), err, err.Error())
+	if err != nil {
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+
+	// only advance the watermark once the data is safely published
+	if err := wm.commit(next); err != nil {
+		return err
+	}
+
+	slog.Info("Polling job completed", "runtime_ms", time.Since(jobstart).Milliseconds())
+	return nil
 }
 
 func customHeaders() http.Header {
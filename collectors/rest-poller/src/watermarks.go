@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark"
+	"github.com/oliveagle/jsonpath"
+)
+
+// watermarkState holds the watermark of the last successful poll. A nil state disables watermarks.
+type watermarkState struct {
+	config watermark.Config
+	store  watermark.Store
+	value  string
+}
+
+func newWatermark() (*watermarkState, error) {
+	if env.WATERMARK_SOURCE == "" {
+		return nil, nil
+	}
+
+	config := watermark.Config{
+		Key:       env.PROVIDER,
+		Source:    env.WATERMARK_SOURCE,
+		Field:     env.WATERMARK_FIELD,
+		Target:    env.WATERMARK_TARGET,
+		TargetKey: env.WATERMARK_TARGET_KEY,
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Target == "body" {
+		return nil, fmt.Errorf("watermark target 'body' is not supported, requests have no body")
+	}
+
+	store, err := watermark.NewStore(env.WATERMARK_STORE_PATH)
+	if err != nil {
+		return nil, err
+	}
+	value, err := store.Load(config.Key)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Loaded watermark", "key", config.Key, "watermark", value)
+
+	return &watermarkState{config: config, store: store, value: value}, nil
+}
+
+// inject adds the last watermark to the request as query param or header, e.g. If-Modified-Since
+func (w *watermarkState) inject(u *url.URL, headers http.Header) error {
+	if w == nil || w.value == "" {
+		return nil
+	}
+	switch w.config.Target {
+	case "header":
+		headers.Set(w.config.TargetKey, w.value)
+	case "query":
+		q := u.Query()
+		q.Set(w.config.TargetKey, w.value)
+		u.RawQuery = q.Encode()
+	}
+	return nil
+}
+
+// next computes the watermark from a response without storing it yet
+func (w *watermarkState) next(body []byte, headers http.Header) (string, error) {
+	if w == nil {
+		return "", nil
+	}
+	switch w.config.Source {
+	case "header":
+		if v := headers.Get(w.config.Field); v != "" {
+			return v, nil
+		}
+		return w.value, nil
+	default:
+		var data any
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", fmt.Errorf("could not parse response for watermark: %w", err)
+		}
+		val, err := jsonpath.JsonPathLookup(data, w.config.Field)
+		if err != nil {
+			slog.Warn("watermark field not found, keeping last watermark", "field", w.config.Field, "err", err)
+			return w.value, nil
+		}
+		return watermark.Highest(w.value, val), nil
+	}
+}
+
+func (w *watermarkState) commit(value string) error {
+	if w == nil || value == "" || value == w.value {
+		return nil
+	}
+	if err := w.store.Save(w.config.Key, value); err != nil {
+		return err
+	}
+	w.value = value
+	return nil
+}
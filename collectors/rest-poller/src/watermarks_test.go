@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func setWatermarkEnv(t *testing.T, source, field, target, targetKey string) {
+	prev := env
+	t.Cleanup(func() { env = prev })
+	env.PROVIDER = "test/provider"
+	env.WATERMARK_SOURCE = source
+	env.WATERMARK_FIELD = field
+	env.WATERMARK_TARGET = target
+	env.WATERMARK_TARGET_KEY = targetKey
+	env.WATERMARK_STORE_PATH = t.TempDir()
+}
+
+func TestWatermarkDisabled(t *testing.T) {
+	setWatermarkEnv(t, "", "", "", "")
+	wm, err := newWatermark()
+	if err != nil || wm != nil {
+		t.Fatalf("expected no watermark, got %v, %v", wm, err)
+	}
+
+	u, _ := url.Parse("https://example.com/data")
+	headers := http.Header{}
+	if err := wm.inject(u, headers); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := wm.next([]byte("not json"), nil); err != nil || next != "" {
+		t.Fatalf("expected no watermark, got %q, %v", next, err)
+	}
+	if err := wm.commit("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if u.RawQuery != "" || len(headers) != 0 {
+		t.Fatalf("request changed without watermark: %s %v", u, headers)
+	}
+}
+
+func TestHeaderWatermark(t *testing.T) {
+	setWatermarkEnv(t, "header", "ETag", "header", "If-None-Match")
+	wm, err := newWatermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://example.com/data")
+	headers := http.Header{}
+	wm.inject(u, headers)
+	if headers.Get("If-None-Match") != "" {
+		t.Fatal("first poll must not send a watermark")
+	}
+
+	next, err := wm.next(nil, http.Header{"Etag": {`"v1"`}})
+	if err != nil || next != `"v1"` {
+		t.Fatalf("expected the ETag, got %q, %v", next, err)
+	}
+	// not committed yet, e.g. because publishing failed
+	wm.inject(u, headers)
+	if headers.Get("If-None-Match") != "" {
+		t.Fatal("uncommitted watermark must not be sent")
+	}
+
+	if err := wm.commit(next); err != nil {
+		t.Fatal(err)
+	}
+	wm.inject(u, headers)
+	if headers.Get("If-None-Match") != `"v1"` {
+		t.Fatalf("expected the committed watermark, got %q", headers.Get("If-None-Match"))
+	}
+	if next, _ := wm.next(nil, http.Header{}); next != `"v1"` {
+		t.Fatalf("response without ETag must keep the watermark, got %q", next)
+	}
+
+	// survives a restart
+	restarted, err := newWatermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.value != `"v1"` {
+		t.Fatalf("expected the stored watermark, got %q", restarted.value)
+	}
+}
+
+func TestBodyWatermark(t *testing.T) {
+	setWatermarkEnv(t, "body", "$.data[*].updated", "query", "since")
+	wm, err := newWatermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"data": [{"updated": "2024-01-02T10:00:00Z"}, {"updated": "2024-01-03T08:00:00Z"}, {"updated": "2024-01-01T00:00:00Z"}]}`
+	next, err := wm.next([]byte(body), nil)
+	if err != nil || next != "2024-01-03T08:00:00Z" {
+		t.Fatalf("expected the highest timestamp, got %q, %v", next, err)
+	}
+	if err := wm.commit(next); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://example.com/data?limit=10")
+	wm.inject(u, http.Header{})
+	if got := u.Query().Get("since"); got != "2024-01-03T08:00:00Z" {
+		t.Fatalf("expected the watermark in the query, got %q", got)
+	}
+	if u.Query().Get("limit") != "10" {
+		t.Fatal("existing query params must be kept")
+	}
+
+	if next, err := wm.next([]byte(`{"data": []}`), nil); err != nil || next != "2024-01-03T08:00:00Z" {
+		t.Fatalf("empty response must keep the watermark, got %q, %v", next, err)
+	}
+	if _, err := wm.next([]byte("<html>"), nil); err == nil {
+		t.Fatal("expected an error for a non JSON response")
+	}
+}
+
+func TestWatermarkBodyTarget(t *testing.T) {
+	setWatermarkEnv(t, "header", "ETag", "body", "etag")
+	if _, err := newWatermark(); err == nil {
+		t.Fatal("expected body target to be rejected")
+	}
+}
@@ -5,9 +5,12 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var Config struct {
@@ -17,6 +20,13 @@ var Config struct {
 	AuthURL      string `default:"https://auth.opendatahub.testingmachine.eu/auth/"`
 	AuthRealm    string `default:"noi"`
 	AuthClientId string `default:"opendatahub-push-testing"`
+
+	// Accepted pushes are stored here until they are published to rabbitmq
+	SpoolDir         string `default:"/var/spool/rest-push"`
+	SpoolMaxBytes    int64  `default:"1073741824"`
+	SpoolMaxMessages int    `default:"100000"`
+	// reject answers new pushes with 503 when the spool is full, drop-oldest discards the oldest spooled messages instead
+	SpoolFullPolicy string `default:"reject"`
 }
 
 func initConfig() {
@@ -30,7 +40,14 @@ func main() {
 	initConfig()
 	initLogging()
 
-	q := make(chan restMsg)
-	InitRabbitMq(q)
-	serve(q)
+	spool, err := OpenSpool(Config.SpoolDir, Config.SpoolMaxBytes, Config.SpoolMaxMessages, Config.SpoolFullPolicy)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer spool.Close()
+
+	registerSpoolMetrics(prometheus.DefaultRegisterer, spool)
+
+	go drain(context.Background(), spool, Config.RabbitURL)
+	serve(spool, promhttp.Handler())
 }
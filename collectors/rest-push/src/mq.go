@@ -7,6 +7,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -29,7 +30,15 @@ type restMsg struct {
 	Query       map[string][]string
 	ContentType string
 	Payload     []byte
-	Response    chan bool `json:"-"`
+}
+
+func fromRest(rMsg restMsg) mqMsg {
+	return mqMsg{
+		ID:        rMsg.ID,
+		Timestamp: rMsg.Timestamp,
+		Provider:  fmt.Sprintf("%s/%s", rMsg.Provider, rMsg.Dataset),
+		Rawdata:   rMsg.Payload,
+	}
 }
 
 type rCon struct {
@@ -45,6 +54,13 @@ func (r *rCon) connect(url string) error {
 
 	ch, err := con.Channel()
 	if err != nil {
+		con.Close()
+		return err
+	}
+
+	// publisher confirms: a message is only removed from the spool once the broker took responsibility for it
+	if err := ch.Confirm(false); err != nil {
+		con.Close()
 		return err
 	}
 
@@ -54,79 +70,121 @@ func (r *rCon) connect(url string) error {
 	return nil
 }
 
-func fromRest(rMsg restMsg) mqMsg {
-	return mqMsg{
-		ID:        rMsg.ID,
-		Timestamp: rMsg.Timestamp,
-		Provider:  fmt.Sprintf("%s/%s", rMsg.Provider, rMsg.Dataset),
-		Rawdata:   rMsg.Payload,
+func (r *rCon) connected() bool {
+	return r.ch != nil && !r.ch.IsClosed()
+}
+
+func (r *rCon) close() {
+	if r.con != nil {
+		r.con.Close()
 	}
+	r.ch = nil
+	r.con = nil
 }
 
-func InitRabbitMq(msgQ <-chan restMsg) {
+func (r *rCon) publish(ctx context.Context, msg []byte) error {
+	// only the routing key is needed, the message is forwarded as is
+	var head struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.Unmarshal(msg, &head); err != nil {
+		return &invalidMsgError{err}
+	}
+
+	confirm, err := r.ch.PublishWithDeferredConfirmWithContext(ctx,
+		"ingress",     // exchange
+		head.Provider, // routing key
+		false,         // mandatory
+		false,         // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			// survive a broker restart, the confirm below only covers the broker accepting the message
+			DeliveryMode: amqp.Persistent,
+			Body:         msg,
+			Headers:      amqp.Table{"provider": head.Provider},
+		})
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("message nacked by broker")
+	}
+	return nil
+}
+
+type invalidMsgError struct{ err error }
+
+func (e *invalidMsgError) Error() string { return fmt.Sprintf("invalid spooled message: %s", e.err) }
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// drain publishes the spooled messages in order, waiting for the broker to come back when it's unavailable
+func drain(ctx context.Context, spool *Spool, url string) {
 	r := new(rCon)
-	conErr := make(chan *amqp.Error) // when the connection drops, we get a message on this channel
+	defer r.close()
+	backoff := minBackoff
+
+	retry := func(msg string, args ...any) {
+		slog.Error(msg, append(args, "retryIn", backoff)...)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
 
-	go func() {
-		for {
+	for ctx.Err() == nil {
+		seq, msg, ok, err := spool.Peek()
+		if !ok {
 			select {
-			// connect to rabbitmq
-			case e := <-conErr:
-				if e != nil {
-					slog.Error("Rabbit connection dropped.", "closeErr", e)
-				}
-				retry := 0
-				for {
-					err := r.connect(Config.RabbitURL)
-					if err != nil {
-						retry++
-						slog.Error("Error establishing Rabbitmq connection", "err", err)
-						if retry < 5 {
-							time.Sleep(time.Second * 5)
-						} else {
-							slog.Error("Exhausted connection retries. aborting")
-							panic("Unable to connect to rabbitmq")
-						}
-					} else {
-						slog.Info("Connection to rabbitmq established")
-						conErr = make(chan *amqp.Error)
-						r.ch.NotifyClose(conErr)
-						break
-					}
-				}
-
-			// Handle incoming message
-			case rMsg := <-msgQ:
-				msg := fromRest(rMsg)
-				payload, err := json.Marshal(msg)
-				if err != nil {
-					slog.Error("Error marshalling message to json", "err", err, "UID", msg.ID)
-				}
-
-				err = func() error {
-					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-					defer cancel()
-
-					return r.ch.PublishWithContext(ctx,
-						"ingress",    // exchange
-						msg.Provider, // routing key
-						false,        // mandatory
-						false,        // immediate
-						amqp.Publishing{
-							ContentType: "application/json",
-							Body:        payload,
-							Headers:     amqp.Table{"provider": msg.Provider},
-						})
-				}()
-				if err != nil {
-					slog.Error("Error sending amqp msg", "err", err, "UID", rMsg.ID)
-					rMsg.Response <- false
-				} else {
-					rMsg.Response <- true
-				}
+			case <-ctx.Done():
+			case <-spool.Notify():
 			}
+			continue
+		}
+		if err != nil {
+			retry("Error reading spooled message", "seq", seq, "err", err)
+			continue
 		}
-	}()
 
-	conErr <- nil // force initial connect with rabbitmq
+		if !r.connected() {
+			r.close()
+			if err := r.connect(url); err != nil {
+				retry("Error establishing Rabbitmq connection", "err", err)
+				continue
+			}
+			slog.Info("Connection to rabbitmq established")
+		}
+
+		err = func() error {
+			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			return r.publish(ctx, msg)
+		}()
+
+		var invalid *invalidMsgError
+		switch {
+		case errors.As(err, &invalid):
+			slog.Error("Discarding undeliverable spooled message", "seq", seq, "err", err)
+			if err := spool.Discard(seq); err != nil {
+				slog.Error("Error discarding spooled message", "seq", seq, "err", err)
+			}
+		case err != nil:
+			// the message stays at the head of the spool and is sent again on a fresh connection
+			r.close()
+			retry("Error sending amqp msg", "seq", seq, "err", err)
+		default:
+			backoff = minBackoff
+			if err := spool.Remove(seq); err != nil {
+				slog.Error("Error removing delivered message from spool", "seq", seq, "err", err)
+			}
+		}
+	}
 }
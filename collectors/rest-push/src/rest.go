@@ -6,6 +6,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,7 +26,12 @@ type pushResponse struct {
 	ID      string `json:"id"`
 }
 
-func serve(send chan<- restMsg) {
+func serve(spool *Spool, metrics http.Handler) {
+	e := newRouter(spool, metrics)
+	e.Logger.Fatal(e.Start(":8080"))
+}
+
+func newRouter(spool *Spool, metrics http.Handler) *echo.Echo {
 	e := echo.New()
 
 	e.Use(middleware.Logger())
@@ -39,8 +46,10 @@ func serve(send chan<- restMsg) {
 		return c.NoContent(http.StatusOK)
 	})
 
+	e.GET("/metrics", echo.WrapHandler(metrics))
+
 	e.POST("/push/:provider/:dataset", func(c echo.Context) error {
-		return push(c, send)
+		return push(c, spool)
 	}, NewUMAAuthz().Middleware())
 
 	authUrl := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", Config.AuthURL, Config.AuthRealm)
@@ -49,7 +58,7 @@ func serve(send chan<- restMsg) {
 		return c.Blob(http.StatusOK, "application/yaml; charset=utf-8", apispec)
 	})
 
-	e.Logger.Fatal(e.Start(":8080"))
+	return e
 }
 
 func loadApispec(file string, authUrl string) []byte {
@@ -67,7 +76,7 @@ func loadApispec(file string, authUrl string) []byte {
 	return buf.Bytes()
 }
 
-func push(c echo.Context, sendQ chan<- restMsg) error {
+func push(c echo.Context, spool *Spool) error {
 	var msg restMsg
 	msg.ID = uuid.NewString()
 	msg.Timestamp = time.Now()
@@ -86,16 +95,22 @@ func push(c echo.Context, sendQ chan<- restMsg) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Unable to read request body").WithInternal(err)
 	}
 	msg.Payload = body
-	msg.Response = make(chan bool)
-	sendQ <- msg
 
-	ok := <-msg.Response
+	payload, err := json.Marshal(fromRest(msg))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to relay data").WithInternal(err)
+	}
 
-	if ok {
-		return c.JSON(http.StatusOK, pushResponse{"Data accepted", msg.ID})
-	} else {
-		msg.Payload = []byte("not logged")
-		slog.Error("Got nok from rabbitmq", "UID", msg.ID, "msg", msg)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to relay data")
+	// the data is acknowledged once it's on disk, it's relayed to rabbitmq in the background
+	if err := spool.Append(payload); err != nil {
+		if errors.Is(err, ErrSpoolFull) {
+			slog.Error("Spool full, rejecting push", "UID", msg.ID, "provider", msg.Provider, "dataset", msg.Dataset, "err", err)
+			c.Response().Header().Set(echo.HeaderRetryAfter, "60")
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "Unable to accept data, try again later").WithInternal(err)
+		}
+		slog.Error("Unable to spool push", "UID", msg.ID, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Unable to relay data").WithInternal(err)
 	}
+
+	return c.JSON(http.StatusOK, pushResponse{"Data accepted", msg.ID})
 }
@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func drainAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var msgs []string
+	for {
+		seq, data, ok, err := s.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return msgs
+		}
+		msgs = append(msgs, string(data))
+		require.NoError(t, s.Remove(seq))
+	}
+}
+
+func TestSpoolKeepsOrderAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 100, "reject")
+	require.NoError(t, err)
+	for _, m := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Append([]byte(m)))
+	}
+
+	// the first message is delivered, then the process dies mid write
+	seq, data, ok, err := s.Peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", string(data))
+	require.NoError(t, s.Remove(seq))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.tmp"), []byte("partial"), 0o640))
+	require.NoError(t, s.Close())
+
+	s, err = OpenSpool(dir, 1<<20, 100, "reject")
+	require.NoError(t, err)
+	defer s.Close()
+	stats := s.Stats()
+	require.Equal(t, 2, stats.Messages)
+	require.EqualValues(t, 2, stats.Bytes)
+	require.False(t, stats.Oldest.IsZero())
+
+	require.NoError(t, s.Append([]byte("d")))
+	require.Equal(t, []string{"b", "c", "d"}, drainAll(t, s))
+	require.NoFileExists(t, filepath.Join(dir, "00000000000000000003.tmp"))
+	require.Zero(t, s.Stats().Bytes)
+}
+
+func TestSpoolIsLocked(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 100, "reject")
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = OpenSpool(dir, 1<<20, 100, "reject")
+	require.Error(t, err)
+}
+
+func TestSpoolFullPolicies(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 10, 2, "reject")
+	require.NoError(t, err)
+	require.NoError(t, s.Append([]byte("1234")))
+	require.NoError(t, s.Append([]byte("5678")))
+	require.ErrorIs(t, s.Append([]byte("9")), ErrSpoolFull)
+	require.ErrorIs(t, s.Append([]byte("12345678901")), ErrSpoolFull)
+	require.EqualValues(t, 2, s.Stats().Rejected)
+	require.Equal(t, []string{"1234", "5678"}, drainAll(t, s))
+	require.NoError(t, s.Close())
+
+	s, err = OpenSpool(t.TempDir(), 10, 3, "drop-oldest")
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Append([]byte("1234")))
+	require.NoError(t, s.Append([]byte("5678")))
+	require.NoError(t, s.Append([]byte("90")))
+	require.NoError(t, s.Append([]byte("abcd")))
+	require.EqualValues(t, 1, s.Stats().Dropped)
+	require.Equal(t, []string{"5678", "90", "abcd"}, drainAll(t, s))
+
+	_, err = OpenSpool(t.TempDir(), 10, 3, "drop-newest")
+	require.Error(t, err)
+}
+
+func TestSpoolDiscardKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 100, "reject")
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Append([]byte("not json")))
+
+	seq, _, _, err := s.Peek()
+	require.NoError(t, err)
+	require.NoError(t, s.Discard(seq))
+	require.Zero(t, s.Stats().Messages)
+	require.FileExists(t, filepath.Join(dir, "00000000000000000000.bad"))
+}
+
+func pushRequest(t *testing.T, s *Spool, body string) *httptest.ResponseRecorder {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/push/testprovider/testdataset", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("provider", "dataset")
+	c.SetParamValues("testprovider", "testdataset")
+
+	err := push(c, s)
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		rec.Code = he.Code
+	} else {
+		require.NoError(t, err)
+	}
+	return rec
+}
+
+func TestPushIsAcceptedOnceSpooled(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 200, 100, "reject")
+	require.NoError(t, err)
+	defer s.Close()
+
+	rec := pushRequest(t, s, `{"value":1}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp pushResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	msgs := drainAll(t, s)
+	require.Len(t, msgs, 1)
+	var msg mqMsg
+	require.NoError(t, json.Unmarshal([]byte(msgs[0]), &msg))
+	require.Equal(t, resp.ID, msg.ID)
+	require.Equal(t, "testprovider/testdataset", msg.Provider)
+	require.Equal(t, `{"value":1}`, string(msg.Rawdata))
+
+	rec = pushRequest(t, s, strings.Repeat("x", 200))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+}
@@ -6,9 +6,7 @@ package main
 
 import (
 	"context"
-	"io"
 	"log/slog"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,7 +14,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/dc"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
-	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/tel"
 	"github.com/robfig/cron/v3"
 )
@@ -31,6 +28,15 @@ var env struct {
 	AWS_S3_BUCKET_NAME    string
 	AWS_ACCESS_KEY_ID     string
 	AWS_ACCESS_SECRET_KEY string
+
+	AWS_S3_ENDPOINT         string
+	AWS_S3_FORCE_PATH_STYLE bool
+
+	AWS_S3_PREFIX         string
+	AWS_S3_GLOB           string
+	AFTER_PUBLISH         string
+	AWS_S3_ARCHIVE_PREFIX string `default:"archive/"`
+	STATE_PATH            string
 }
 
 func main() {
@@ -48,51 +54,52 @@ func main() {
 	)
 	ms.FailOnError(context.Background(), err, "failed to create AWS config")
 
-	// Create an S3 client
-	s3Client := s3.NewFromConfig(customConfig)
+	// Create an S3 client, optionally for a S3 compatible store like MinIO
+	s3Client := s3.NewFromConfig(customConfig, func(o *s3.Options) {
+		if env.AWS_S3_ENDPOINT != "" {
+			o.BaseEndpoint = aws.String(env.AWS_S3_ENDPOINT)
+		}
+		o.UsePathStyle = env.AWS_S3_FORCE_PATH_STYLE
+	})
 
 	collector := dc.NewDc[dc.EmptyData](context.Background(), env.Env)
 
-	c := cron.New(cron.WithSeconds())
-	c.AddFunc(env.CRON, func() {
-		collector.GetInputChannel() <- dc.NewInput[dc.EmptyData](context.Background(), nil)
-	})
-
-	slog.Info("Setup complete. Starting cron scheduler")
-	go func() {
-		c.Run()
-	}()
-
-	err = collector.Start(context.Background(), func(ctx context.Context, a dc.EmptyData) (*rdb.RawAny, error) {
-		// Get the object from S3
-		output, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-			Bucket: aws.String(env.AWS_S3_BUCKET_NAME),
-			Key:    aws.String(env.AWS_S3_FILE_NAME),
-		})
-		if err != nil {
-			slog.Error("error while getting s3 object:", "err", err, "bucket", env.AWS_S3_BUCKET_NAME, "file", env.AWS_S3_FILE_NAME)
-			return nil, err
-		}
+	pollObjects(s3Client, collector)
+}
 
-		defer output.Body.Close()
-		body, err := io.ReadAll(output.Body)
-		if err != nil {
-			slog.Error("error reading response body:", "err", err)
-			return nil, err
-		}
+// pollObjects publishes all new or changed objects below AWS_S3_PREFIX, or the object AWS_S3_FILE_NAME, on every tick
+func pollObjects(s3Client *s3.Client, collector *dc.Dc[dc.EmptyData]) {
+	if env.STATE_PATH == "" {
+		slog.Warn("STATE_PATH not set, all objects are published again after a restart")
+	}
+	state, err := loadState(env.STATE_PATH)
+	ms.FailOnError(context.Background(), err, "failed to load object state")
+
+	poller := &objectPoller{
+		client:        s3Client,
+		bucket:        env.AWS_S3_BUCKET_NAME,
+		prefix:        env.AWS_S3_PREFIX,
+		glob:          env.AWS_S3_GLOB,
+		afterPublish:  env.AFTER_PUBLISH,
+		archivePrefix: env.AWS_S3_ARCHIVE_PREFIX,
+		state:         state,
+	}
+	if env.AWS_S3_FILE_NAME != "" {
+		poller.prefix = env.AWS_S3_FILE_NAME
+		poller.key = env.AWS_S3_FILE_NAME
+		poller.rawBinary = env.RAW_BINARY
+	}
+	ms.FailOnError(context.Background(), poller.validate(), "invalid object polling configuration")
+
+	slog.Info("Setup complete. Starting cron scheduler", "bucket", poller.bucket, "prefix", poller.prefix, "glob", poller.glob)
 
-		var raw any
-		if env.RAW_BINARY {
-			raw = body
-		} else {
-			raw = string(body)
-		}
+	c := cron.New(cron.WithSeconds())
+	c.AddFunc(env.CRON, func() {
+		ctx, col := collector.StartCollection(context.Background())
+		defer col.End(ctx)
 
-		return &rdb.RawAny{
-			Provider:  env.PROVIDER,
-			Timestamp: time.Now(),
-			Rawdata:   raw,
-		}, nil
+		err := poller.poll(ctx, env.PROVIDER, col.Publish)
+		ms.FailOnError(ctx, err, "failed to poll objects", "bucket", poller.bucket, "err", err)
 	})
-	ms.FailOnError(context.Background(), err, err.Error())
+	c.Run()
 }
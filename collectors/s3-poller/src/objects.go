@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+)
+
+// Object is the raw payload of a bucket object, analogous to the File of sftp-server
+type Object struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+	File         []byte
+}
+
+// s3API is the part of the S3 client used for polling objects
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+type publisher func(ctx context.Context, raw *rdb.RawAny) error
+
+type objectPoller struct {
+	client        s3API
+	bucket        string
+	prefix        string
+	glob          string
+	afterPublish  string // "" | archive | delete
+	archivePrefix string
+	state         *objectState
+	// key restricts polling to a single object, published as its plain content like the legacy AWS_S3_FILE_NAME mode
+	key       string
+	rawBinary bool
+}
+
+func (p *objectPoller) validate() error {
+	if p.glob != "" {
+		if _, err := path.Match(p.glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", p.glob, err)
+		}
+	}
+	switch p.afterPublish {
+	case "", "delete":
+	case "archive":
+		if p.archivePrefix == "" {
+			return fmt.Errorf("archiving requires an archive prefix")
+		}
+	default:
+		return fmt.Errorf("unsupported after publish action %q, use 'archive' or 'delete'", p.afterPublish)
+	}
+	return nil
+}
+
+// list returns the objects matching prefix and glob, in key order
+func (p *objectPoller) list(ctx context.Context) ([]objectInfo, error) {
+	objects := []objectInfo{}
+	pages := s3.NewListObjectsV2Paginator(p.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(p.prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket %s: %w", p.bucket, err)
+		}
+		for _, o := range page.Contents {
+			key := aws.ToString(o.Key)
+			// folder placeholders
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if p.afterPublish == "archive" && strings.HasPrefix(key, p.archivePrefix) {
+				continue
+			}
+			if p.key != "" && key != p.key {
+				continue
+			}
+			if p.glob != "" {
+				if ok, _ := path.Match(p.glob, key); !ok {
+					continue
+				}
+			}
+			objects = append(objects, objectInfo{
+				key:     key,
+				version: objectVersion{ETag: aws.ToString(o.ETag), LastModified: aws.ToTime(o.LastModified)},
+			})
+		}
+	}
+	return objects, nil
+}
+
+type objectInfo struct {
+	key     string
+	version objectVersion
+}
+
+// poll publishes every new or changed object once, and archives or deletes it afterwards if configured.
+// Objects failing to download are skipped and retried on the next poll.
+func (p *objectPoller) poll(ctx context.Context, provider string, publish publisher) error {
+	objects, err := p.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, o := range objects {
+		listed[o.key] = true
+	}
+	if err := p.state.retain(listed); err != nil {
+		return err
+	}
+
+	published := 0
+	for _, o := range objects {
+		if p.state.published(o.key, o.version) {
+			continue
+		}
+
+		obj, err := p.get(ctx, o)
+		if err != nil {
+			slog.Error("failed to get object, retrying on next poll", "bucket", p.bucket, "key", o.key, "err", err)
+			continue
+		}
+
+		err = publish(ctx, p.payload(provider, obj))
+		if err != nil {
+			return fmt.Errorf("failed to publish object %s: %w", o.key, err)
+		}
+		published++
+
+		if err := p.state.mark(o.key, o.version); err != nil {
+			return err
+		}
+		if err := p.cleanup(ctx, o.key); err != nil {
+			// published already, the state prevents publishing it again
+			slog.Error("failed to clean up published object", "bucket", p.bucket, "key", o.key, "action", p.afterPublish, "err", err)
+		}
+	}
+
+	slog.Info("polled bucket", "bucket", p.bucket, "prefix", p.prefix, "objects", len(objects), "published", published)
+	return nil
+}
+
+// payload wraps the object, or in single object mode just its content
+func (p *objectPoller) payload(provider string, obj *Object) *rdb.RawAny {
+	if p.key == "" {
+		return &rdb.RawAny{
+			Provider:  provider,
+			Timestamp: obj.LastModified,
+			Rawdata:   obj,
+		}
+	}
+
+	var raw any
+	if p.rawBinary {
+		raw = obj.File
+	} else {
+		raw = string(obj.File)
+	}
+	return &rdb.RawAny{
+		Provider:  provider,
+		Timestamp: time.Now(),
+		Rawdata:   raw,
+	}
+}
+
+func (p *objectPoller) get(ctx context.Context, o objectInfo) (*Object, error) {
+	output, err := p.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(o.key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object body: %w", err)
+	}
+
+	return &Object{
+		Bucket:       p.bucket,
+		Key:          o.key,
+		Size:         int64(len(body)),
+		ETag:         o.version.ETag,
+		LastModified: o.version.LastModified,
+		ContentType:  aws.ToString(output.ContentType),
+		Metadata:     output.Metadata,
+		File:         body,
+	}, nil
+}
+
+func (p *objectPoller) cleanup(ctx context.Context, key string) error {
+	switch p.afterPublish {
+	case "archive":
+		target := p.archivePrefix + key
+		_, err := p.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(p.bucket),
+			Key:        aws.String(target),
+			CopySource: aws.String(copySource(p.bucket, key)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy to archive: %w", err)
+		}
+		slog.Debug("archived object", "key", key, "archive", target)
+		fallthrough
+	case "delete":
+		_, err := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(p.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete object: %w", err)
+		}
+	}
+	return nil
+}
+
+// copySource is the url encoded bucket/key of the copied object
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	body     string
+	modified time.Time
+	version  int
+}
+
+// fakeBucket serves objects from memory, listing at most two keys per page
+type fakeBucket struct {
+	objects map[string]*fakeObject
+}
+
+func (b *fakeBucket) put(key, body string) {
+	o, ok := b.objects[key]
+	if !ok {
+		o = &fakeObject{}
+		b.objects[key] = o
+	}
+	o.body = body
+	o.version++
+	o.modified = time.Date(2024, 5, 1, 10, o.version, 0, 0, time.UTC)
+}
+
+func (b *fakeBucket) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	keys := []string{}
+	for k := range b.objects {
+		if strings.HasPrefix(k, aws.ToString(params.Prefix)) && k > aws.ToString(params.ContinuationToken) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(len(keys) > 2)}
+	if len(keys) > 2 {
+		keys = keys[:2]
+		out.NextContinuationToken = aws.String(keys[1])
+	}
+	for _, k := range keys {
+		o := b.objects[k]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(k),
+			ETag:         aws.String(fmt.Sprintf(`"%s-%d"`, k, o.version)),
+			LastModified: aws.Time(o.modified),
+			Size:         aws.Int64(int64(len(o.body))),
+		})
+	}
+	return out, nil
+}
+
+func (b *fakeBucket) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o, ok := b.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, fmt.Errorf("no such key")
+	}
+	return &s3.GetObjectOutput{
+		Body:        io.NopCloser(bytes.NewReader([]byte(o.body))),
+		ContentType: aws.String("text/csv"),
+		Metadata:    map[string]string{"station": "A1"},
+	}, nil
+}
+
+func (b *fakeBucket) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	src, err := url.PathUnescape(strings.TrimPrefix(aws.ToString(params.CopySource), "bucket/"))
+	if err != nil {
+		return nil, err
+	}
+	o, ok := b.objects[src]
+	if !ok {
+		return nil, fmt.Errorf("no such key %s", src)
+	}
+	copied := *o
+	b.objects[aws.ToString(params.Key)] = &copied
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (b *fakeBucket) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	delete(b.objects, aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func collect(published *[]*Object) publisher {
+	return func(ctx context.Context, raw *rdb.RawAny) error {
+		*published = append(*published, raw.Rawdata.(*Object))
+		return nil
+	}
+}
+
+func TestPollPublishesChangedObjectsOnce(t *testing.T) {
+	bucket := &fakeBucket{objects: map[string]*fakeObject{}}
+	bucket.put("in/a.csv", "a1")
+	bucket.put("in/b.csv", "b1")
+	bucket.put("in/c.json", "c1")
+	bucket.put("in/sub/", "")
+	bucket.put("other/d.csv", "d1")
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state, err := loadState(statePath)
+	require.NoError(t, err)
+	p := &objectPoller{client: bucket, bucket: "bucket", prefix: "in/", glob: "in/*.csv", state: state}
+	require.NoError(t, p.validate())
+
+	var published []*Object
+	require.NoError(t, p.poll(context.Background(), "s3", collect(&published)))
+	require.Len(t, published, 2)
+	require.Equal(t, "in/a.csv", published[0].Key)
+	require.Equal(t, []byte("a1"), published[0].File)
+	require.Equal(t, "text/csv", published[0].ContentType)
+	require.Equal(t, map[string]string{"station": "A1"}, published[0].Metadata)
+	require.Equal(t, int64(2), published[0].Size)
+
+	// nothing changed
+	published = nil
+	require.NoError(t, p.poll(context.Background(), "s3", collect(&published)))
+	require.Empty(t, published)
+
+	// state survives restarts
+	bucket.put("in/b.csv", "b2")
+	state, err = loadState(statePath)
+	require.NoError(t, err)
+	p.state = state
+	require.NoError(t, p.poll(context.Background(), "s3", collect(&published)))
+	require.Len(t, published, 1)
+	require.Equal(t, "in/b.csv", published[0].Key)
+	require.Equal(t, []byte("b2"), published[0].File)
+}
+
+func TestPollArchivesPublishedObjects(t *testing.T) {
+	bucket := &fakeBucket{objects: map[string]*fakeObject{}}
+	bucket.put("data 1.xml", "x")
+	bucket.put("data 2.xml", "y")
+
+	state, err := loadState("")
+	require.NoError(t, err)
+	p := &objectPoller{client: bucket, bucket: "bucket", afterPublish: "archive", archivePrefix: "archive/", state: state}
+	require.NoError(t, p.validate())
+
+	var published []*Object
+	require.NoError(t, p.poll(context.Background(), "s3", collect(&published)))
+	require.Len(t, published, 2)
+
+	keys := []string{}
+	for k := range bucket.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	require.Equal(t, []string{"archive/data 1.xml", "archive/data 2.xml"}, keys)
+
+	// archived objects are not published again
+	published = nil
+	require.NoError(t, p.poll(context.Background(), "s3", collect(&published)))
+	require.Empty(t, published)
+}
+
+func TestPollSingleObject(t *testing.T) {
+	bucket := &fakeBucket{objects: map[string]*fakeObject{}}
+	bucket.put("forecast.json", `{"v": 1}`)
+	bucket.put("forecast.json.bak", "old")
+
+	state, err := loadState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+	p := &objectPoller{client: bucket, bucket: "bucket", prefix: "forecast.json", key: "forecast.json", state: state}
+	require.NoError(t, p.validate())
+
+	var published []any
+	publish := func(ctx context.Context, raw *rdb.RawAny) error {
+		published = append(published, raw.Rawdata)
+		return nil
+	}
+	require.NoError(t, p.poll(context.Background(), "s3", publish))
+	require.Equal(t, []any{`{"v": 1}`}, published, "plain content of the legacy single file mode")
+
+	// unchanged file is not published again
+	require.NoError(t, p.poll(context.Background(), "s3", publish))
+	require.Len(t, published, 1)
+
+	bucket.put("forecast.json", `{"v": 2}`)
+	p.rawBinary = true
+	require.NoError(t, p.poll(context.Background(), "s3", publish))
+	require.Equal(t, []any{`{"v": 1}`, []byte(`{"v": 2}`)}, published)
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// objectVersion identifies the content of an object, a new ETag or modification time means it has changed
+type objectVersion struct {
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// objectState remembers which version of each object has been published.
+// Without path it lives in memory only, and everything is published again after a restart.
+type objectState struct {
+	path     string
+	versions map[string]objectVersion
+}
+
+func loadState(path string) (*objectState, error) {
+	s := &objectState{path: path, versions: map[string]objectVersion{}}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.versions); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *objectState) published(key string, v objectVersion) bool {
+	last, ok := s.versions[key]
+	return ok && last.ETag == v.ETag && last.LastModified.Equal(v.LastModified)
+}
+
+func (s *objectState) mark(key string, v objectVersion) error {
+	s.versions[key] = v
+	return s.save()
+}
+
+// retain forgets objects that are not in the bucket anymore
+func (s *objectState) retain(keys map[string]bool) error {
+	changed := false
+	for key := range s.versions {
+		if !keys[key] {
+			delete(s.versions, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes the state atomically, so that a crash never leaves a corrupt file
+func (s *objectState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.versions)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveKind returns zip, tar or tgz for supported archives, or an empty string
+func archiveKind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// processArchive publishes every regular file of the archive matching the filter as a separate record.
+// Nested archives are published as they are
+func (p *processor) processArchive(ctx context.Context, filePath string, kind string, archive File) error {
+	var published int
+	var err error
+	if kind == "zip" {
+		published, err = p.processZip(ctx, filePath, archive)
+	} else {
+		published, err = p.processTar(ctx, filePath, kind == "tgz", archive)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract archive %s after %d entries: %w", archive.Filename, published, err)
+	}
+
+	if published == 0 {
+		slog.Warn("Archive contains no matching entries", "archive", archive.Filename)
+	} else {
+		slog.Info("Published archive entries", "archive", archive.Filename, "entries", published)
+	}
+	return nil
+}
+
+func (p *processor) processZip(ctx context.Context, filePath string, archive File) (int, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	published := 0
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		meta, ok := p.entry(archive, f.Name)
+		if !ok {
+			continue
+		}
+		if !f.Modified.IsZero() {
+			meta.Mtime = f.Modified
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return published, fmt.Errorf("failed to open entry %s: %w", f.Name, err)
+		}
+		err = p.publishContent(ctx, meta, rc, int64(f.UncompressedSize64))
+		rc.Close()
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+func (p *processor) processTar(ctx context.Context, filePath string, gzipped bool, archive File) (int, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	published := 0
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return published, nil
+		}
+		if err != nil {
+			return published, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		meta, ok := p.entry(archive, h.Name)
+		if !ok {
+			continue
+		}
+		if !h.ModTime.IsZero() {
+			meta.Mtime = h.ModTime
+		}
+
+		if err := p.publishContent(ctx, meta, tr, h.Size); err != nil {
+			return published, err
+		}
+		published++
+	}
+}
+
+// entry returns the metadata of an archive entry, and false if the filter excludes it
+func (p *processor) entry(archive File, name string) (File, bool) {
+	// entry names are untrusted, never let them escape the archive
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !p.filter.match(path.Base(name)) {
+		return File{}, false
+	}
+	return File{
+		Filename: name,
+		Dir:      archive.Dir,
+		Mtime:    archive.Mtime,
+		Archive:  archive.Filename,
+	}, true
+}
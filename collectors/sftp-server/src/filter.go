@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// filter selects file names by glob patterns. Without include patterns every name not excluded matches
+type filter struct {
+	include []string
+	exclude []string
+}
+
+func newFilter(include, exclude []string) (filter, error) {
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return filter{}, fmt.Errorf("invalid file pattern %q: %w", p, err)
+		}
+	}
+	return filter{include: include, exclude: exclude}, nil
+}
+
+func (f filter) match(name string) bool {
+	for _, p := range f.exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
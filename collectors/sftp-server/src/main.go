@@ -7,15 +7,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/dc"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
-	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/tel"
 )
 
@@ -24,6 +25,18 @@ var env struct {
 	WATCH_DIR         string `default:"/home/sftp/upload"`
 	STABILITY_SECONDS int    `default:"5"`
 	CHECK_INTERVAL    int    `default:"1"`
+
+	RECURSIVE        bool
+	INCLUDE_PATTERNS []string
+	EXCLUDE_PATTERNS []string
+	EXTRACT_ARCHIVES bool
+
+	LARGE_FILE_SIZE int64
+	LARGE_FILE_MODE string `default:"chunk"`
+	CHUNK_SIZE      int64  `default:"4194304"`
+	REFERENCE_DIR   string
+
+	QUARANTINE_DIR string `default:"/home/sftp/quarantine"`
 }
 
 type File struct {
@@ -31,6 +44,22 @@ type File struct {
 	Dir      string
 	Mtime    time.Time
 	File     []byte
+
+	// Name of the archive the file was extracted from
+	Archive string `json:",omitempty"`
+	// Position of this part of a file published in chunks
+	Chunk *Chunk `json:",omitempty"`
+	// Path of a file published by reference, File is empty then
+	Ref  string `json:",omitempty"`
+	Size int64  `json:",omitempty"`
+}
+
+// Chunk is set on each part of a large file. Concatenating the File of all Count chunks ordered by Index yields the original
+type Chunk struct {
+	Index  int
+	Count  int
+	Offset int64
+	Size   int64
 }
 
 type fileTracker struct {
@@ -45,13 +74,28 @@ func main() {
 	slog.Info("Starting data collector...")
 	defer tel.FlushOnPanic()
 
+	fileFilter, err := newFilter(env.INCLUDE_PATTERNS, env.EXCLUDE_PATTERNS)
+	ms.FailOnError(ctx, err, "invalid file patterns")
+
+	proc := &processor{
+		provider:  env.PROVIDER,
+		filter:    fileFilter,
+		extract:   env.EXTRACT_ARCHIVES,
+		largeSize: env.LARGE_FILE_SIZE,
+		largeMode: env.LARGE_FILE_MODE,
+		chunkSize: env.CHUNK_SIZE,
+		refDir:    env.REFERENCE_DIR,
+	}
+	ms.FailOnError(ctx, proc.validate(), "invalid large file configuration")
+
 	collector := dc.NewDc[File](ctx, env.Env)
-	ms.FailOnError(ctx, watchFiles(ctx, env.WATCH_DIR, collector), "file watcher terminated unexpectedly")
+	ms.FailOnError(ctx, watchFiles(ctx, env.WATCH_DIR, collector, proc), "file watcher terminated unexpectedly")
 }
 
-func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File]) error {
+func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File], proc *processor) error {
 	ctx, collection := collector.StartCollection(ctx)
 	defer collection.End(ctx)
+	proc.publish = collection.Publish
 
 	// Setup filewatcher
 	watcher, err := fsnotify.NewWatcher()
@@ -60,16 +104,29 @@ func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File]) error {
 	}
 	defer watcher.Close()
 
-	err = watcher.Add(dir)
-	if err != nil {
-		return fmt.Errorf("failed to watch directory: %w", err)
+	if err := watchDir(watcher, dir, env.RECURSIVE); err != nil {
+		return err
 	}
 
-	slog.Info("Watching directory for file uploads", "dir", env.WATCH_DIR)
+	slog.Info("Watching directory for file uploads", "dir", env.WATCH_DIR, "recursive", env.RECURSIVE)
 
 	// To make sure that we don't post partially uploaded files, we continuously check a file's mtime and size.
 	// If it remains stable over a certain period, we assume the file has finished uploading and we publish it
 	tracked := make(map[string]*fileTracker)
+	track := func(path string) {
+		// the collector's own output must not be published again when it lives below the watched directory
+		if within(path, env.QUARANTINE_DIR) || within(path, env.REFERENCE_DIR) {
+			return
+		}
+		if !proc.filter.match(filepath.Base(path)) {
+			slog.Debug("Ignoring file not matching patterns", "path", path)
+			return
+		}
+		if _, exists := tracked[path]; !exists {
+			slog.Debug("Started tracking file", "path", path)
+			tracked[path] = &fileTracker{}
+		}
+	}
 	stabilityDuration := time.Duration(env.STABILITY_SECONDS) * time.Second
 	checkInterval := time.Duration(env.CHECK_INTERVAL) * time.Second
 	ticker := time.NewTicker(checkInterval)
@@ -84,12 +141,23 @@ func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File]) error {
 
 			// Track new files or modifications
 			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
-				if info, err := os.Stat(event.Name); err == nil && !info.IsDir() {
-					// Start tracking this file
-					if _, exists := tracked[event.Name]; !exists {
-						slog.Debug("Started tracking file", "path", event.Name)
-						tracked[event.Name] = &fileTracker{}
+				info, err := os.Stat(event.Name)
+				if err != nil {
+					continue
+				}
+				if !info.IsDir() {
+					track(event.Name)
+				} else if env.RECURSIVE && event.Op&fsnotify.Create != 0 {
+					// files might have been written before the directory was watched
+					if err := watchDir(watcher, event.Name, true); err != nil {
+						return err
 					}
+					filepath.WalkDir(event.Name, func(path string, d fs.DirEntry, err error) error {
+						if err == nil && d.Type().IsRegular() {
+							track(path)
+						}
+						return nil
+					})
 				}
 			}
 
@@ -122,29 +190,19 @@ func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File]) error {
 					// File is stable, transfer complete
 					slog.Info("File transfer complete", "path", filePath)
 
-					fileData, err := os.ReadFile(filePath)
-					if err != nil {
-						return fmt.Errorf("failed to read file: %w", err)
-					}
-
-					raw := rdb.RawAny{
-						Provider:  env.PROVIDER,
-						Timestamp: currentModTime,
-						Rawdata: File{
-							Filename: info.Name(),
-							Dir:      filepath.Dir(filePath),
-							Mtime:    currentModTime,
-							File:     fileData,
-						}}
-
-					if err := collection.Publish(ctx, &raw); err != nil {
-						slog.Error("failed to publish raw payload", "payload", fmt.Sprintf("%v", raw))
-						return fmt.Errorf("failed to publish raw payload %w", err)
-					}
-
 					// Remove from tracking
 					delete(tracked, filePath)
 
+					if err := proc.process(ctx, filePath, info); err != nil {
+						slog.Error("failed to publish file, moving it to quarantine", "path", filePath, "err", err)
+						target, qerr := quarantine(dir, env.QUARANTINE_DIR, filePath, err)
+						if qerr != nil {
+							return fmt.Errorf("failed to quarantine %s: %w", filePath, qerr)
+						}
+						slog.Warn("File quarantined", "path", filePath, "quarantine", target)
+						continue
+					}
+
 					// Delete file from filesystem
 					if err := os.Remove(filePath); err != nil {
 						return fmt.Errorf("failed to delete file: %w", err)
@@ -156,3 +214,33 @@ func watchFiles(ctx context.Context, dir string, collector *dc.Dc[File]) error {
 		}
 	}
 }
+
+// watchDir adds dir, and with recursive all its subdirectories, to the watcher
+func watchDir(watcher *fsnotify.Watcher, dir string, recursive bool) error {
+	if !recursive {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch directory: %w", err)
+		}
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk directory %s: %w", path, err)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch directory %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+func within(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
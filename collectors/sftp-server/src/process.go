@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+)
+
+type publisher func(ctx context.Context, raw *rdb.RawAny) error
+
+// processor turns an uploaded file into one or more raw records
+type processor struct {
+	provider string
+	publish  publisher
+	filter   filter
+
+	// publish the entries of zip and tar archives instead of the archive itself
+	extract bool
+
+	// files bigger than largeSize are either split in chunks of chunkSize or stored in refDir and published by reference.
+	// largeSize 0 disables the special handling
+	largeSize int64
+	largeMode string // chunk | reference
+	chunkSize int64
+	refDir    string
+}
+
+func (p *processor) validate() error {
+	if p.largeSize <= 0 {
+		return nil
+	}
+	switch p.largeMode {
+	case "chunk":
+		if p.chunkSize <= 0 {
+			return fmt.Errorf("chunk size must be positive")
+		}
+	case "reference":
+		if p.refDir == "" {
+			return fmt.Errorf("storing large files by reference requires a reference directory")
+		}
+	default:
+		return fmt.Errorf("unsupported large file mode %q, use 'chunk' or 'reference'", p.largeMode)
+	}
+	return nil
+}
+
+// process publishes the file at path. On error, parts of the file might have been published already
+func (p *processor) process(ctx context.Context, path string, info os.FileInfo) error {
+	meta := File{
+		Filename: info.Name(),
+		Dir:      filepath.Dir(path),
+		Mtime:    info.ModTime(),
+	}
+
+	if p.extract {
+		if kind := archiveKind(info.Name()); kind != "" {
+			return p.processArchive(ctx, path, kind, meta)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	defer f.Close()
+	return p.publishContent(ctx, meta, f, info.Size())
+}
+
+// publishContent publishes size bytes from r, as a whole, in chunks or by reference depending on the size
+func (p *processor) publishContent(ctx context.Context, meta File, r io.Reader, size int64) error {
+	if p.largeSize <= 0 || size <= p.largeSize {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", meta.Filename, err)
+		}
+		meta.File = data
+		return p.emit(ctx, meta)
+	}
+
+	if p.largeMode == "reference" {
+		return p.publishReference(ctx, meta, r, size)
+	}
+	return p.publishChunks(ctx, meta, r, size)
+}
+
+func (p *processor) publishChunks(ctx context.Context, meta File, r io.Reader, size int64) error {
+	count := int((size + p.chunkSize - 1) / p.chunkSize)
+	slog.Info("Publishing large file in chunks", "file", meta.Filename, "size", size, "chunks", count)
+
+	buf := make([]byte, p.chunkSize)
+	var offset int64
+	for i := range count {
+		n := min(p.chunkSize, size-offset)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return fmt.Errorf("failed to read chunk %d of %s: %w", i, meta.Filename, err)
+		}
+
+		chunk := meta
+		chunk.File = buf[:n]
+		chunk.Chunk = &Chunk{Index: i, Count: count, Offset: offset, Size: size}
+		if err := p.emit(ctx, chunk); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+// publishReference copies the content to the reference directory and publishes its path instead of the content
+func (p *processor) publishReference(ctx context.Context, meta File, r io.Reader, size int64) error {
+	name := meta.Filename
+	if meta.Archive != "" {
+		name = meta.Archive + "_" + name
+	}
+	// entries of archives may contain directories
+	name = strings.ReplaceAll(name, "/", "_")
+	target := filepath.Join(p.refDir, time.Now().UTC().Format("20060102T150405.000000000")+"_"+name)
+
+	if err := writeAtomic(target, r); err != nil {
+		return fmt.Errorf("failed to store %s by reference: %w", meta.Filename, err)
+	}
+	slog.Info("Stored large file by reference", "file", meta.Filename, "size", size, "ref", target)
+
+	meta.Ref = target
+	meta.Size = size
+	if err := p.emit(ctx, meta); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
+func (p *processor) emit(ctx context.Context, f File) error {
+	raw := rdb.RawAny{
+		Provider:  p.provider,
+		Timestamp: f.Mtime,
+		Rawdata:   f,
+	}
+	if err := p.publish(ctx, &raw); err != nil {
+		return fmt.Errorf("failed to publish raw payload of %s: %w", f.Filename, err)
+	}
+	return nil
+}
+
+// writeAtomic writes r to a temporary file renamed to path once complete, so readers never see partial files
+func writeAtomic(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/require"
+)
+
+func collect(published *[]File) publisher {
+	return func(ctx context.Context, raw *rdb.RawAny) error {
+		f := raw.Rawdata.(File)
+		// chunks share the read buffer
+		f.File = bytes.Clone(f.File)
+		*published = append(*published, f)
+		return nil
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) os.FileInfo {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info
+}
+
+func zipOf(t *testing.T, entries map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range entries {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func tgzOf(t *testing.T, entries map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	w := tar.NewWriter(gz)
+	for name, content := range entries {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestFilter(t *testing.T) {
+	f, err := newFilter([]string{"*.csv", "*.zip"}, []string{"tmp_*"})
+	require.NoError(t, err)
+	require.True(t, f.match("data.csv"))
+	require.True(t, f.match("bundle.zip"))
+	require.False(t, f.match("data.json"))
+	require.False(t, f.match("tmp_data.csv"))
+
+	f, err = newFilter(nil, []string{"*.part"})
+	require.NoError(t, err)
+	require.True(t, f.match("data.json"))
+	require.False(t, f.match("data.json.part"))
+
+	_, err = newFilter([]string{"[a-"}, nil)
+	require.Error(t, err)
+}
+
+func TestProcessPlainFile(t *testing.T) {
+	dir := t.TempDir()
+	info := writeFile(t, filepath.Join(dir, "flights.csv"), []byte("a;b"))
+
+	var published []File
+	p := &processor{provider: "sftp-server/test", publish: collect(&published)}
+	require.NoError(t, p.validate())
+	require.NoError(t, p.process(context.Background(), filepath.Join(dir, "flights.csv"), info))
+
+	require.Len(t, published, 1)
+	require.Equal(t, "flights.csv", published[0].Filename)
+	require.Equal(t, dir, published[0].Dir)
+	require.Equal(t, []byte("a;b"), published[0].File)
+	require.Empty(t, published[0].Archive)
+	require.Nil(t, published[0].Chunk)
+}
+
+func TestProcessArchives(t *testing.T) {
+	entries := map[string]string{
+		"2025/flights.csv":  "a;b",
+		"2025/readme.txt":   "ignored",
+		"../../escape.csv":  "c;d",
+		"2025/nested/x.csv": "e;f",
+	}
+	fileFilter, err := newFilter([]string{"*.csv", "*.zip", "*.tar.gz"}, nil)
+	require.NoError(t, err)
+
+	for name, data := range map[string][]byte{
+		"bundle.zip":    zipOf(t, entries),
+		"bundle.tar.gz": tgzOf(t, entries),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			info := writeFile(t, filepath.Join(dir, name), data)
+
+			var published []File
+			p := &processor{publish: collect(&published), filter: fileFilter, extract: true}
+			require.NoError(t, p.process(context.Background(), filepath.Join(dir, name), info))
+
+			contents := map[string]string{}
+			for _, f := range published {
+				require.Equal(t, name, f.Archive)
+				require.Equal(t, dir, f.Dir)
+				contents[f.Filename] = string(f.File)
+			}
+			require.Equal(t, map[string]string{
+				"2025/flights.csv":  "a;b",
+				"escape.csv":        "c;d",
+				"2025/nested/x.csv": "e;f",
+			}, contents)
+		})
+	}
+}
+
+func TestProcessArchiveWithoutExtraction(t *testing.T) {
+	dir := t.TempDir()
+	data := zipOf(t, map[string]string{"a.csv": "a"})
+	info := writeFile(t, filepath.Join(dir, "bundle.zip"), data)
+
+	var published []File
+	p := &processor{publish: collect(&published)}
+	require.NoError(t, p.process(context.Background(), filepath.Join(dir, "bundle.zip"), info))
+	require.Len(t, published, 1)
+	require.Equal(t, data, published[0].File)
+}
+
+func TestProcessLargeFileInChunks(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("0123456789abcdefghij-")
+	info := writeFile(t, filepath.Join(dir, "big.bin"), data)
+
+	var published []File
+	p := &processor{publish: collect(&published), largeSize: 10, largeMode: "chunk", chunkSize: 8}
+	require.NoError(t, p.validate())
+	require.NoError(t, p.process(context.Background(), filepath.Join(dir, "big.bin"), info))
+
+	require.Len(t, published, 3)
+	joined := []byte{}
+	for i, f := range published {
+		require.Equal(t, "big.bin", f.Filename)
+		require.Equal(t, &Chunk{Index: i, Count: 3, Offset: int64(8 * i), Size: int64(len(data))}, f.Chunk)
+		joined = append(joined, f.File...)
+	}
+	require.Equal(t, data, joined)
+}
+
+func TestProcessLargeFileByReference(t *testing.T) {
+	dir := t.TempDir()
+	refDir := filepath.Join(t.TempDir(), "store")
+	data := zipOf(t, map[string]string{"data/big.csv": "0123456789abcdef", "small.csv": "a"})
+	info := writeFile(t, filepath.Join(dir, "bundle.zip"), data)
+
+	var published []File
+	p := &processor{publish: collect(&published), extract: true, largeSize: 10, largeMode: "reference", refDir: refDir}
+	require.NoError(t, p.validate())
+	require.NoError(t, p.process(context.Background(), filepath.Join(dir, "bundle.zip"), info))
+
+	require.Len(t, published, 2)
+	byName := map[string]File{}
+	for _, f := range published {
+		byName[f.Filename] = f
+	}
+
+	big := byName["data/big.csv"]
+	require.Empty(t, big.File)
+	require.Equal(t, int64(16), big.Size)
+	require.Equal(t, refDir, filepath.Dir(big.Ref))
+	stored, err := os.ReadFile(big.Ref)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789abcdef", string(stored))
+
+	require.Empty(t, byName["small.csv"].Ref)
+	require.Equal(t, []byte("a"), byName["small.csv"].File)
+}
+
+func TestProcessValidation(t *testing.T) {
+	require.Error(t, (&processor{largeSize: 10, largeMode: "split"}).validate())
+	require.Error(t, (&processor{largeSize: 10, largeMode: "reference"}).validate())
+	require.Error(t, (&processor{largeSize: 10, largeMode: "chunk"}).validate())
+	require.NoError(t, (&processor{largeMode: "split"}).validate())
+}
+
+func TestQuarantine(t *testing.T) {
+	watch := t.TempDir()
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+
+	path := filepath.Join(watch, "sub", "broken.zip")
+	writeFile(t, path, []byte("first"))
+	target, err := quarantine(watch, quarantineDir, path, errors.New("zip: not a valid zip file"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(quarantineDir, "sub", "broken.zip"), target)
+	require.NoFileExists(t, path)
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(content))
+	cause, err := os.ReadFile(target + ".error")
+	require.NoError(t, err)
+	require.Contains(t, string(cause), "zip: not a valid zip file")
+
+	// a second upload with the same name keeps the first one
+	writeFile(t, path, []byte("second"))
+	second, err := quarantine(watch, quarantineDir, path, errors.New("failed again"))
+	require.NoError(t, err)
+	require.NotEqual(t, target, second)
+	require.FileExists(t, target)
+}
+
+func TestWithin(t *testing.T) {
+	require.True(t, within("/home/sftp/upload/quarantine/a.csv", "/home/sftp/upload/quarantine"))
+	require.False(t, within("/home/sftp/upload/a.csv", "/home/sftp/upload/quarantine"))
+	require.False(t, within("/home/sftp/upload/quarantine-2/a.csv", "/home/sftp/upload/quarantine"))
+	require.False(t, within("/home/sftp/upload/a.csv", ""))
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// quarantine moves a file that could not be published from watchDir to the same relative path in quarantineDir,
+// next to a <name>.error file with the cause
+func quarantine(watchDir, quarantineDir, filePath string, cause error) (string, error) {
+	rel, err := filepath.Rel(watchDir, filePath)
+	if err != nil {
+		rel = filepath.Base(filePath)
+	}
+	target := filepath.Join(quarantineDir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	// don't overwrite an earlier upload with the same name
+	if _, err := os.Stat(target); err == nil {
+		target += "." + time.Now().UTC().Format("20060102T150405")
+	}
+
+	if err := moveFile(filePath, target); err != nil {
+		return "", fmt.Errorf("failed to move file to quarantine: %w", err)
+	}
+
+	msg := fmt.Sprintf("%s\n%s\n", time.Now().UTC().Format(time.RFC3339), cause)
+	if err := os.WriteFile(target+".error", []byte(msg), 0o644); err != nil {
+		return target, fmt.Errorf("failed to write quarantine error file: %w", err)
+	}
+	return target, nil
+}
+
+// moveFile renames src to dst, falling back to copy and delete across file systems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := writeAtomic(dst, in); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
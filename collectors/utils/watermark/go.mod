@@ -0,0 +1,3 @@
+module github.com/noi-techpark/opendatahub-collectors/collectors/utils/watermark
+
+go 1.25.0
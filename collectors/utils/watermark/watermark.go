@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package watermark keeps track of the last value seen by a poller (max timestamp, ETag, Last-Modified, cursor)
+// so that the next poll only asks the provider for what changed since.
+package watermark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Config describes where a watermark is read from and how it's sent with the next request
+type Config struct {
+	// Key identifies the watermark in the store
+	Key string `yaml:"key,omitempty"`
+	// Source is where to read the watermark from: header | body
+	Source string `yaml:"source"`
+	// Field is the header name, or the selector into the response body.
+	// When a body selector matches several values, the highest one is kept.
+	Field string `yaml:"field"`
+	// Target is where to inject the watermark into the next request: header | query | body
+	Target string `yaml:"target"`
+	// TargetKey is the header, query param or body field to set, e.g. If-None-Match
+	TargetKey string `yaml:"target_key"`
+}
+
+func (c Config) Validate() error {
+	switch c.Source {
+	case "header", "body":
+	default:
+		return fmt.Errorf("unsupported watermark source %q, use 'header' or 'body'", c.Source)
+	}
+	switch c.Target {
+	case "header", "query", "body":
+	default:
+		return fmt.Errorf("unsupported watermark target %q, use 'header', 'query' or 'body'", c.Target)
+	}
+	if c.Field == "" || c.TargetKey == "" {
+		return fmt.Errorf("watermark field and target_key must be set")
+	}
+	return nil
+}
+
+// Store persists watermarks between polls
+type Store interface {
+	// Load returns the stored watermark, or "" if there is none yet
+	Load(key string) (string, error)
+	Save(key, value string) error
+}
+
+// NewStore creates the store for the given location. Only local directories are supported for now.
+func NewStore(location string) (Store, error) {
+	if location == "" {
+		return nil, fmt.Errorf("watermark store location not set")
+	}
+	return NewFileStore(location)
+}
+
+// FileStore keeps one file per watermark in a directory, which should be on a persistent volume
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create watermark directory %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	name := url.PathEscape(key)
+	if len(name) > 200 {
+		sum := sha256.Sum256([]byte(key))
+		name = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(s.dir, name+".watermark")
+}
+
+func (s *FileStore) Load(key string) (string, error) {
+	b, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read watermark %s: %w", key, err)
+	}
+	return string(b), nil
+}
+
+// Save writes the watermark atomically, so a crash never leaves a truncated value behind
+func (s *FileStore) Save(key, value string) error {
+	tmp, err := os.CreateTemp(s.dir, ".watermark-*")
+	if err != nil {
+		return fmt.Errorf("could not create watermark file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write watermark %s: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync watermark %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close watermark %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("could not store watermark %s: %w", key, err)
+	}
+	return nil
+}
+
+// Highest returns the highest of the current watermark and all values in v, which may be a single value or an array.
+// Values compare as numbers or timestamps when both sides parse as such, otherwise as strings.
+func Highest(current string, v any) string {
+	switch vt := v.(type) {
+	case nil:
+		return current
+	case []any:
+		for _, el := range vt {
+			current = Highest(current, el)
+		}
+		return current
+	case float64:
+		return higher(current, strconv.FormatFloat(vt, 'f', -1, 64))
+	default:
+		return higher(current, fmt.Sprintf("%v", vt))
+	}
+}
+
+func higher(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			if fb > fa {
+				return b
+			}
+			return a
+		}
+	}
+	if ta, ok := parseTime(a); ok {
+		if tb, ok := parseTime(b); ok {
+			if tb.After(ta) {
+				return b
+			}
+			return a
+		}
+	}
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, http.TimeFormat, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package watermark
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := store.Load("GET https://example.com/data?x=1"); err != nil || v != "" {
+		t.Fatalf("expected no watermark, got %q, %v", v, err)
+	}
+
+	long := strings.Repeat("k", 300)
+	for key, value := range map[string]string{"GET https://example.com/data?x=1": `"v1"`, long: "2024-01-01"} {
+		if err := store.Save(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Save(key, value+"x"); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := store.Load(key); err != nil || v != value+"x" {
+			t.Fatalf("%s: expected %q, got %q, %v", key, value+"x", v, err)
+		}
+	}
+
+	// no temporary files left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 watermark files, got %d", len(entries))
+	}
+}
+
+func TestHighest(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		v       any
+		want    string
+	}{
+		{"empty", "", nil, ""},
+		{"first value", "", "abc", "abc"},
+		{"numbers", "9", []any{10.0, 2.0}, "10"},
+		{"timestamps", "2024-01-02T00:00:00Z", []any{"2024-01-01T10:00:00+02:00", "2024-01-03T00:00:00Z"}, "2024-01-03T00:00:00Z"},
+		{"http dates", "Mon, 01 Jan 2024 10:00:00 GMT", "Sun, 31 Dec 2023 10:00:00 GMT", "Mon, 01 Jan 2024 10:00:00 GMT"},
+		{"strings", "b", []any{"a", "c"}, "c"},
+		{"keeps current", "5", nil, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highest(tt.current, tt.v); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{Source: "header", Field: "ETag", Target: "header", TargetKey: "If-None-Match"}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Config{
+		{Source: "cookie", Field: "ETag", Target: "header", TargetKey: "If-None-Match"},
+		{Source: "header", Field: "ETag", Target: "path", TargetKey: "If-None-Match"},
+		{Source: "body", Field: "$.ts", Target: "query"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AggregationConfig defines the periods the elaboration produces measurements for.
+// The shortest period is the base period, which is elaborated from the raw vehicles.
+// All other periods are rolled up from the base windows, so they must be multiples of it
+type AggregationConfig struct {
+	// BatchWindows is the number of base windows read from the a22 db in one query
+	BatchWindows int            `yaml:"batch_windows"`
+	Periods      []PeriodConfig `yaml:"periods"`
+	Quality      QualityConfig  `yaml:"quality"`
+}
+
+type PeriodConfig struct {
+	// Period in seconds
+	Period uint64 `yaml:"period"`
+	// Metrics are the data types elaborated for this period. Empty means all
+	Metrics []string `yaml:"metrics"`
+}
+
+// aggregation is the validated AggregationConfig
+type aggregation struct {
+	batchWindows int
+	// periods sorted ascending, the first one is the base period
+	periods []periodAggregation
+	quality QualityConfig
+}
+
+type periodAggregation struct {
+	period  uint64
+	metrics map[string]bool
+}
+
+// defaultAggregation is what the elaboration did before periods were configurable
+var defaultAggregation = AggregationConfig{
+	BatchWindows: 10,
+	Periods:      []PeriodConfig{{Period: 600}},
+	Quality:      defaultQuality,
+}
+
+func loadAggregation(path string) (*aggregation, error) {
+	if path == "" {
+		return newAggregation(defaultAggregation)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregation config: %w", err)
+	}
+	var c AggregationConfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregation config %s: %w", path, err)
+	}
+	return newAggregation(c)
+}
+
+func newAggregation(c AggregationConfig) (*aggregation, error) {
+	if len(c.Periods) == 0 {
+		return nil, fmt.Errorf("no periods configured")
+	}
+	a := &aggregation{batchWindows: c.BatchWindows}
+	if a.batchWindows <= 0 {
+		a.batchWindows = defaultAggregation.BatchWindows
+	}
+
+	periods := slices.Clone(c.Periods)
+	slices.SortFunc(periods, func(a, b PeriodConfig) int { return int(a.Period) - int(b.Period) })
+	for i, p := range periods {
+		if p.Period == 0 {
+			return nil, fmt.Errorf("period must be > 0")
+		}
+		if i > 0 && p.Period == periods[i-1].Period {
+			return nil, fmt.Errorf("period %d configured twice", p.Period)
+		}
+		if p.Period%periods[0].Period != 0 {
+			return nil, fmt.Errorf("period %d is not a multiple of the base period %d", p.Period, periods[0].Period)
+		}
+
+		metrics := map[string]bool{}
+		for _, m := range p.Metrics {
+			if !slices.Contains(allDataTypes, m) {
+				return nil, fmt.Errorf("unknown metric %q for period %d", m, p.Period)
+			}
+			metrics[m] = true
+		}
+		if len(metrics) == 0 {
+			for _, m := range allDataTypes {
+				metrics[m] = true
+			}
+		}
+		a.periods = append(a.periods, periodAggregation{period: p.Period, metrics: metrics})
+	}
+
+	a.quality = c.Quality
+	if err := a.quality.validate(a.base().period); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// base is the period elaborated from the raw vehicles
+func (a *aggregation) base() periodAggregation {
+	return a.periods[0]
+}
+
+// rollups are the periods computed by merging base windows
+func (a *aggregation) rollups() []periodAggregation {
+	return a.periods[1:]
+}
+
+// longest returns the longest period in milliseconds. Elaboration starts aligned to it, so that rollups are complete
+func (a *aggregation) longest() int64 {
+	return int64(a.periods[len(a.periods)-1].period) * 1000
+}
+
+func (a *aggregation) periodList() []uint64 {
+	var ret []uint64
+	for _, p := range a.periods {
+		ret = append(ret, p.period)
+	}
+	return ret
+}
+
+func (p periodAggregation) has(dataType string) bool {
+	return p.metrics[dataType]
+}
+
+// rollup accumulates the base windows of one rollup period
+type rollup struct {
+	periodAggregation
+	// start of the window being accumulated, in millis
+	start int64
+	stats *windowStats
+}
+
+// add merges a base window into the rollup. If it completes the rollup window, the merged stats are returned.
+// Rollup windows that are missing base windows (e.g. skipped as inconsistent) are discarded
+func (r *rollup) add(winStart, winEnd int64, base uint64, stats *windowStats) (*windowStats, bool) {
+	length := int64(r.period) * 1000
+	start := (winStart / length) * length
+	if r.stats == nil || r.start != start {
+		r.start = start
+		r.stats = newWindowStats()
+	}
+	r.stats.merge(stats)
+
+	if winEnd%length != 0 {
+		return nil, false
+	}
+	complete := r.stats.windows == int(r.period/base)
+	merged := r.stats
+	r.stats = nil
+	return merged, complete
+}
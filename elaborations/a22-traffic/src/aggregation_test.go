@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bdpclient "github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/noi-techpark/opendatahub-go-sdk/bdplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAggregation(t *testing.T) {
+	a, err := newAggregation(AggregationConfig{Periods: []PeriodConfig{
+		{Period: 3600, Metrics: []string{DataTypeLightVehicles}},
+		{Period: 300},
+		{Period: 900},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{300, 900, 3600}, a.periodList())
+	require.Equal(t, 10, a.batchWindows)
+	require.True(t, a.base().has(DataTypeAvgFlow))
+	require.False(t, a.periods[2].has(DataTypeAvgFlow))
+	require.Equal(t, int64(3600_000), a.longest())
+
+	_, err = newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}, {Period: 900}}})
+	require.ErrorContains(t, err, "not a multiple")
+
+	_, err = newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600, Metrics: []string{"Nr. Bikes"}}}})
+	require.ErrorContains(t, err, "unknown metric")
+}
+
+func testVehicles(start int64, n int) []Vehicle {
+	nat := []string{"I", "D", "A"}
+	plates := []string{"AB", "ZZ"}
+	var vehicles []Vehicle
+	for i := 0; i < n; i++ {
+		vehicles = append(vehicles, Vehicle{
+			Timestamp:     start/1000 + int64(i*37%3600),
+			Distance:      float64(10 + i%7),
+			Headway:       float64(1 + i%5),
+			Length:        float64(400 + i*53%900),
+			ClassNr:       1 + i%7,
+			Speed:         float64(60 + i*13%70),
+			Direction:     1 + i%3%2,
+			PlateNat:      &nat[i%3],
+			PlateInitials: &plates[i%2],
+		})
+	}
+	return vehicles
+}
+
+func TestRollup(t *testing.T) {
+	euroTypeUtils = &EUROTypeUtil{vehicleDataMap2023: map[string]EUROType{}, vehicleDataMap2024: map[string]EUROType{
+		"AB": {Targa: "AB", Probabilities: map[string]float64{EURO5: 0.4, EURO6: 0.6}},
+	}}
+	station := Station{Station: bdpclient.Station{Id: "1", Name: "Bolzano (direzione sud)"}}
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli()
+	vehicles := testVehicles(start, 500)
+
+	a, err := newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}, {Period: 3600}}})
+	require.NoError(t, err)
+	r := &rollup{periodAggregation: a.periods[1]}
+
+	// merging the stats of the 10 minute windows gives the same as computing the stats of the hour
+	windows := splitVehiclesByWindow(vehicles, start, 600_000)
+	var merged *windowStats
+	for i := 0; i < 6; i++ {
+		winStart := start + int64(i)*600_000
+		stats := createWindowStats(station, windows[i], winStart, winStart+600_000, a.quality)
+		var complete bool
+		merged, complete = r.add(winStart, winStart+600_000, 600, stats)
+		if i < 5 {
+			require.Nil(t, merged)
+		} else {
+			require.True(t, complete)
+		}
+	}
+	hour := createWindowStats(station, vehicles, start, start+3600_000, a.quality)
+	hour.windows = 6
+	require.Equal(t, hour.nationality, merged.nationality)
+	require.Equal(t, hour.euroLight.valid, merged.euroLight.valid)
+	require.Equal(t, hour.light.count, merged.light.count)
+	require.InDelta(t, hour.sumSpeed, merged.sumSpeed, 1e-6)
+	require.InDelta(t, variance(hour.heavy), variance(merged.heavy), 1e-6)
+
+	meas := &measurementMap{LastByDataTypes: map[measurementKey]time.Time{}}
+	hourly := bdplib.DataMap{}
+	require.NoError(t, elaborate(context.Background(), &hourly, meas, station, merged, start+3600_000, a.periods[1]))
+	direct := bdplib.DataMap{}
+	require.NoError(t, elaborate(context.Background(), &direct, meas, station, hour, start+3600_000, a.periods[1]))
+	require.Equal(t, len(direct.Branch["1"].Branch), len(hourly.Branch["1"].Branch))
+	for dt, d := range direct.Branch["1"].Branch {
+		require.Equal(t, uint64(3600), hourly.Branch["1"].Branch[dt].Data[0].Period, dt)
+		if f, ok := d.Data[0].Value.(float64); ok {
+			require.InDelta(t, f, hourly.Branch["1"].Branch[dt].Data[0].Value, 1e-6, dt)
+		}
+	}
+
+	// the variance equals the one computed from the deviations
+	var sum, sq float64
+	var count int
+	for _, v := range vehicles {
+		if v.IsHeavy() {
+			sum += v.Speed
+			count++
+		}
+	}
+	for _, v := range vehicles {
+		if v.IsHeavy() {
+			sq += squareDiff(v.Speed, sum/float64(count))
+		}
+	}
+	require.InDelta(t, sq/float64(count), variance(merged.heavy), 1e-6)
+
+	// a rollup missing windows is not complete
+	r = &rollup{periodAggregation: a.periods[1]}
+	for i := 1; i < 6; i++ {
+		winStart := start + int64(i)*600_000
+		merged, complete := r.add(winStart, winStart+600_000, 600, createWindowStats(station, nil, winStart, winStart+600_000, a.quality))
+		require.False(t, complete)
+		if i == 5 {
+			require.Equal(t, 5, merged.windows)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/noi-techpark/opendatahub-go-sdk/bdplib"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel/logger"
+)
+
+type backfillOptions struct {
+	Stations []string  `json:"stations"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	// Overwrite pushes all measurements, otherwise the ones already in the timeseries are skipped
+	Overwrite bool `json:"overwrite"`
+
+	checkpoint string
+}
+
+func parseBackfillArgs(args []string) (backfillOptions, error) {
+	var opts backfillOptions
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	stations := fs.String("stations", "", "comma separated station codes, all stations if empty")
+	from := fs.String("from", "", "start of the time range, RFC3339 or YYYY-MM-DD (UTC)")
+	to := fs.String("to", "", "end of the time range (exclusive), RFC3339 or YYYY-MM-DD (UTC)")
+	mode := fs.String("mode", "skip", "skip: keep existing measurements, overwrite: push all measurements")
+	fs.StringVar(&opts.checkpoint, "checkpoint", "", "file to store the progress in, to resume an interrupted backfill")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	var err error
+	if opts.From, err = parseBackfillTime(*from); err != nil {
+		return opts, fmt.Errorf("invalid -from: %w", err)
+	}
+	if opts.To, err = parseBackfillTime(*to); err != nil {
+		return opts, fmt.Errorf("invalid -to: %w", err)
+	}
+	if !opts.To.After(opts.From) {
+		return opts, fmt.Errorf("-to must be after -from")
+	}
+	switch *mode {
+	case "skip":
+	case "overwrite":
+		opts.Overwrite = true
+	default:
+		return opts, fmt.Errorf("invalid -mode %q, use skip or overwrite", *mode)
+	}
+	opts.Stations = nonEmptyFields(*stations)
+	return opts, nil
+}
+
+func parseBackfillTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("required")
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func nonEmptyFields(s string) []string {
+	var ret []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// backfillCheckpoint records up to when each station has been backfilled, so that an interrupted backfill can be resumed
+type backfillCheckpoint struct {
+	path string
+	mu   sync.Mutex
+
+	Options backfillOptions `json:"options"`
+	// end of the last pushed batch per station, in millis
+	Progress map[string]int64 `json:"progress"`
+}
+
+func openCheckpoint(path string, opts backfillOptions) (*backfillCheckpoint, error) {
+	c := &backfillCheckpoint{path: path, Options: opts, Progress: map[string]int64{}}
+	if path == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, c.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var stored backfillCheckpoint
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if !stored.Options.From.Equal(opts.From) || !stored.Options.To.Equal(opts.To) ||
+		stored.Options.Overwrite != opts.Overwrite || !slices.Equal(stored.Options.Stations, opts.Stations) {
+		return nil, fmt.Errorf("checkpoint %s belongs to a backfill with different options, remove it to start over", path)
+	}
+	if stored.Progress != nil {
+		c.Progress = stored.Progress
+	}
+	return c, nil
+}
+
+// resumeFrom returns where to continue backfilling a station
+func (c *backfillCheckpoint) resumeFrom(station string, from int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(from, c.Progress[station])
+}
+
+func (c *backfillCheckpoint) done(station string, ts int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Progress[station] = max(c.Progress[station], ts)
+	return c.save()
+}
+
+// save writes the checkpoint atomically. Must be called with the lock held, or before it's shared
+func (c *backfillCheckpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// runBackfill elaborates a time range for a set of stations, independently of the measurements already in the timeseries
+func runBackfill(ctx context.Context, args []string, bdp bdplib.Bdp, ad22DbConnection *sqlx.DB, oauth *OAuthProvider) error {
+	opts, err := parseBackfillArgs(args)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := openCheckpoint(opts.checkpoint, opts)
+	if err != nil {
+		return err
+	}
+
+	stations, err := readStations(ctx, ad22DbConnection, bdp.GetOrigin(), sensorStationType)
+	if err != nil {
+		return fmt.Errorf("failed to get stations from a22 db: %w", err)
+	}
+	if len(opts.Stations) > 0 {
+		stations = slices.DeleteFunc(stations, func(s Station) bool { return !slices.Contains(opts.Stations, s.Id) })
+		if len(stations) != len(opts.Stations) {
+			return fmt.Errorf("found %d of the %d stations in the a22 db", len(stations), len(opts.Stations))
+		}
+	}
+
+	// the stations might not be in the timeseries yet. Don't touch the state of the others
+	bdpStations := make([]bdplib.Station, len(stations))
+	for i, s := range stations {
+		bdpStations[i] = bdplib.Station(s.Station)
+	}
+	if err := bdp.SyncStations(sensorStationType, bdpStations, false, true); err != nil {
+		return fmt.Errorf("failed to sync stations: %w", err)
+	}
+
+	logger.Get(ctx).Info("starting backfill", "stations", len(stations), "from", opts.From, "to", opts.To,
+		"overwrite", opts.Overwrite, "checkpoint", opts.checkpoint)
+
+	stationChan := make(chan Station)
+	var wg sync.WaitGroup
+	for i := 0; i < MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for station := range stationChan {
+				backfillStation(ctx, station, opts, checkpoint, bdp, ad22DbConnection, oauth)
+			}
+		}()
+	}
+	for _, station := range stations {
+		stationChan <- station
+	}
+	close(stationChan)
+	wg.Wait()
+
+	logger.Get(ctx).Info("backfill completed", "stations", len(stations))
+	return nil
+}
+
+func backfillStation(ctx context.Context, station Station, opts backfillOptions, checkpoint *backfillCheckpoint,
+	bdp bdplib.Bdp, ad22DbConnection *sqlx.DB, oauth *OAuthProvider) {
+	startTime := checkpoint.resumeFrom(station.Id, max(opts.From.UnixMilli(), station.MinTimestamp))
+	endTime := min(opts.To.UnixMilli(), station.MaxTimestamp)
+	if startTime >= endTime {
+		logger.Get(ctx).Info("nothing to backfill", "stationcode", station.Id)
+		return
+	}
+
+	existing := func(from, to int64) (measurementFilter, error) {
+		if opts.Overwrite {
+			return allMeasurements{}, nil
+		}
+		// measurements are timestamped at the end of their window
+		return getMeasurementHistory(ctx, oauth, station.Id, agg.periodList(), time.UnixMilli(from+1), time.UnixMilli(to+1))
+	}
+	done := func(batchEnd int64) {
+		if err := checkpoint.done(station.Id, batchEnd); err != nil {
+			logger.Get(ctx).Error("failed to save checkpoint", "stationcode", station.Id, "err", err)
+		}
+	}
+	elaborateStation(ctx, station, startTime, endTime, bdp, ad22DbConnection, existing, done, nil)
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackfillArgs(t *testing.T) {
+	opts, err := parseBackfillArgs([]string{"-stations", "1, 2", "-from", "2025-01-01", "-to", "2025-03-01T12:00:00+01:00", "-mode", "overwrite"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2"}, opts.Stations)
+	require.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), opts.From)
+	require.Equal(t, time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC), opts.To.UTC())
+	require.True(t, opts.Overwrite)
+
+	_, err = parseBackfillArgs([]string{"-from", "2025-01-01"})
+	require.Error(t, err)
+	_, err = parseBackfillArgs([]string{"-from", "2025-02-01", "-to", "2025-01-01"})
+	require.Error(t, err)
+	_, err = parseBackfillArgs([]string{"-from", "2025-01-01", "-to", "2025-02-01", "-mode", "replace"})
+	require.Error(t, err)
+}
+
+func TestBackfillCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	opts, err := parseBackfillArgs([]string{"-from", "2025-01-01", "-to", "2025-02-01"})
+	require.NoError(t, err)
+
+	c, err := openCheckpoint(path, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), c.resumeFrom("1", 100))
+	require.NoError(t, c.done("1", 500))
+
+	// resuming continues where the last run stopped
+	c, err = openCheckpoint(path, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(500), c.resumeFrom("1", 100))
+	require.Equal(t, int64(100), c.resumeFrom("2", 100))
+
+	opts.Overwrite = true
+	_, err = openCheckpoint(path, opts)
+	require.ErrorContains(t, err, "different options")
+}
+
+func TestExclusions(t *testing.T) {
+	l, err := loadExclusions("../resources/exclusions.yaml")
+	require.NoError(t, err)
+	require.Len(t, l, 1)
+
+	start := l[0].Start.UnixMilli()
+	end := l[0].End.UnixMilli()
+	require.True(t, l.overlaps(start-1000, start+1000))
+	require.False(t, l.overlaps(start-2000, start))
+	require.False(t, l.overlaps(end, end+1000))
+	require.True(t, l.covers(start, end))
+	require.False(t, l.covers(start, end+1))
+}
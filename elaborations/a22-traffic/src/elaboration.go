@@ -13,216 +13,268 @@ import (
 
 const NULL_VALUE = -999
 
-func elaborate(ctx context.Context, dataMap *bdplib.DataMap, existingMeasurements *measurementMap,
-	station Station, vehicles []Vehicle, timestamp int64, period uint64) error {
-	// existingMeasurements is used to check wether a specific DataType should be elaborate by checking the time of last
-	// measurement in the ninja.
-	t := time.Unix(timestamp/1000, (timestamp%1000)*1_000_000).UTC()
+// classStats are the count and speed statistics of one vehicle class
+type classStats struct {
+	count int
+	// the speed sums are over the plausible vehicles only
+	speeds     int
+	sumSpeed   float64
+	sumSqSpeed float64
+}
 
-	// Euro distribution
-	if IsCamera(station) && existingMeasurements.shouldElaborate(DataTypeEuroPct, t) {
-		euroPct := createVehicleEuro(vehicles, t)
-		dataMap.AddRecord(station.Id, DataTypeEuroPct, bdplib.CreateRecord(timestamp, euroPct, period))
+func (c *classStats) add(speed float64, plausible bool) {
+	c.count++
+	if plausible {
+		c.speeds++
+		c.sumSpeed += speed
+		c.sumSqSpeed += speed * speed
 	}
+}
+
+func (c *classStats) merge(o classStats) {
+	c.count += o.count
+	c.speeds += o.speeds
+	c.sumSpeed += o.sumSpeed
+	c.sumSqSpeed += o.sumSqSpeed
+}
 
-	// Nationality distribution
-	if IsCamera(station) && existingMeasurements.shouldElaborate(DataTypeNationalityCount, t) {
-		natCounts := createVehicleNationality(vehicles)
-		dataMap.AddRecord(station.Id, DataTypeNationalityCount, bdplib.CreateRecord(timestamp, natCounts, period))
+// euroStats sums the EURO category probabilities of the vehicles with a known plate
+type euroStats struct {
+	sum   map[string]float64
+	valid int
+}
+
+func (e *euroStats) merge(o euroStats) {
+	for k, v := range o.sum {
+		e.sum[k] += v
 	}
+	e.valid += o.valid
+}
 
-	// Per-class Nationality and EURO distributions (camera-only)
-	if IsCamera(station) {
-		light, heavy, buses := filterVehiclesByClass(vehicles)
+// windowStats holds the sums all metrics are derived from. Unlike the metrics themselves, sums can be merged,
+// which is how the longer periods are rolled up from the base windows without reading the vehicles again
+type windowStats struct {
+	// number of base windows merged into these stats
+	windows int
+
+	light, heavy, buses classStats
 
-		if existingMeasurements.shouldElaborate(DataTypeNationalityCountLight, t) {
-			dataMap.AddRecord(station.Id, DataTypeNationalityCountLight, bdplib.CreateRecord(timestamp, createVehicleNationality(light), period))
+	vehicles int
+	// the gap, headway and speed sums are over the plausible vehicles only
+	plausible                    int
+	sumGap, sumHeadway, sumSpeed float64
+	// vehicles traveling in the direction of the station
+	normalDirection int
+
+	nationality, nationalityLight, nationalityHeavy, nationalityBuses map[string]int
+	euro, euroLight                                                   euroStats
+
+	quality qualityStats
+}
+
+func newWindowStats() *windowStats {
+	return &windowStats{
+		nationality:      map[string]int{},
+		nationalityLight: map[string]int{},
+		nationalityHeavy: map[string]int{},
+		nationalityBuses: map[string]int{},
+		euro:             euroStats{sum: map[string]float64{}},
+		euroLight:        euroStats{sum: map[string]float64{}},
+	}
+}
+
+// createWindowStats computes the stats of the vehicles of the base window [from, to).
+// Implausible vehicles count, but are left out of the speed, headway and gap sums
+func createWindowStats(station Station, vehicles []Vehicle, from, to int64, q QualityConfig) *windowStats {
+	s := newWindowStats()
+	s.windows = 1
+	s.quality = newQualityStats(vehicles, from, to, q)
+	stationDirection := station.Direction()
+	euroTypeMap := euroTypeUtils.GetVehicleDataMap(time.UnixMilli(to).UTC())
+
+	for _, v := range vehicles {
+		plausible := q.Plausibility.plausible(v)
+		s.vehicles++
+		if plausible {
+			s.plausible++
+			s.sumGap += v.Distance
+			s.sumHeadway += v.Headway
+			s.sumSpeed += v.Speed
 		}
-		if existingMeasurements.shouldElaborate(DataTypeNationalityCountHeavy, t) {
-			dataMap.AddRecord(station.Id, DataTypeNationalityCountHeavy, bdplib.CreateRecord(timestamp, createVehicleNationality(heavy), period))
+		if v.Direction == int(stationDirection) {
+			s.normalDirection++
 		}
-		if existingMeasurements.shouldElaborate(DataTypeNationalityCountBuses, t) {
-			dataMap.AddRecord(station.Id, DataTypeNationalityCountBuses, bdplib.CreateRecord(timestamp, createVehicleNationality(buses), period))
+
+		euroData, hasEuro := EUROType{}, false
+		if v.PlateInitials != nil && *v.PlateInitials != "" {
+			euroData, hasEuro = euroTypeMap[*v.PlateInitials]
+		}
+		hasNat := v.PlateNat != nil && *v.PlateNat != ""
+
+		if hasNat {
+			s.nationality[*v.PlateNat]++
+		}
+		if hasEuro {
+			s.euro.add(euroData)
 		}
 
-		if existingMeasurements.shouldElaborate(DataTypeEuroPctLight, t) {
-			dataMap.AddRecord(station.Id, DataTypeEuroPctLight, bdplib.CreateRecord(timestamp, createVehicleEuro(light, t), period))
+		if v.IsLight() {
+			s.light.add(v.Speed, plausible)
+			if hasNat {
+				s.nationalityLight[*v.PlateNat]++
+			}
+			if hasEuro {
+				s.euroLight.add(euroData)
+			}
+		} else if v.IsHeavy() {
+			s.heavy.add(v.Speed, plausible)
+			if hasNat {
+				s.nationalityHeavy[*v.PlateNat]++
+			}
+		} else if v.IsBus() {
+			s.buses.add(v.Speed, plausible)
+			if hasNat {
+				s.nationalityBuses[*v.PlateNat]++
+			}
 		}
-		// if existingMeasurements.shouldElaborate(DataTypeEuroPctHeavy, t) {
-		// 	dataMap.AddRecord(station.Id, DataTypeEuroPctHeavy, bdplib.CreateRecord(timestamp, createVehicleEuro(heavy, t), period))
-		// }
-		// if existingMeasurements.shouldElaborate(DataTypeEuroPctBuses, t) {
-		// 	dataMap.AddRecord(station.Id, DataTypeEuroPctBuses, bdplib.CreateRecord(timestamp, createVehicleEuro(buses, t), period))
-		// }
 	}
+	return s
+}
 
-	// Vehicle counts
-	classCounts := createVehicleCounts(vehicles)
+func (e *euroStats) add(d EUROType) {
+	for euroClass, prob := range d.Probabilities {
+		e.sum[euroClass] += prob
+	}
+	e.valid++
+}
 
-	var nrLight, nrHeavy, nrBuses float64
-	if val, ok := classCounts[DataTypeLightVehicles]; ok && existingMeasurements.shouldElaborate(DataTypeLightVehicles, t) {
-		nrLight = float64(val)
-		dataMap.AddRecord(station.Id, DataTypeLightVehicles, bdplib.CreateRecord(timestamp, val, period))
+func (s *windowStats) merge(o *windowStats) {
+	s.windows += o.windows
+	s.light.merge(o.light)
+	s.heavy.merge(o.heavy)
+	s.buses.merge(o.buses)
+	s.vehicles += o.vehicles
+	s.plausible += o.plausible
+	s.sumGap += o.sumGap
+	s.sumHeadway += o.sumHeadway
+	s.sumSpeed += o.sumSpeed
+	s.normalDirection += o.normalDirection
+	mergeCounts(s.nationality, o.nationality)
+	mergeCounts(s.nationalityLight, o.nationalityLight)
+	mergeCounts(s.nationalityHeavy, o.nationalityHeavy)
+	mergeCounts(s.nationalityBuses, o.nationalityBuses)
+	s.euro.merge(o.euro)
+	s.euroLight.merge(o.euroLight)
+	s.quality.merge(o.quality)
+}
+
+func mergeCounts(dst, src map[string]int) {
+	for k, v := range src {
+		dst[k] += v
 	}
-	if val, ok := classCounts[DataTypeHeavyVehicles]; ok && existingMeasurements.shouldElaborate(DataTypeHeavyVehicles, t) {
-		nrHeavy = float64(val)
-		dataMap.AddRecord(station.Id, DataTypeHeavyVehicles, bdplib.CreateRecord(timestamp, val, period))
+}
+
+// elaborate adds the metrics of a window ending at timestamp to the dataMap
+func elaborate(ctx context.Context, dataMap *bdplib.DataMap, existingMeasurements measurementFilter,
+	station Station, stats *windowStats, timestamp int64, p periodAggregation) error {
+	// existingMeasurements is used to check wether a specific DataType should be elaborate by checking the time of last
+	// measurement in the ninja.
+	t := time.Unix(timestamp/1000, (timestamp%1000)*1_000_000).UTC()
+
+	addRecord := func(dataType string, value any) {
+		if p.has(dataType) && existingMeasurements.shouldElaborate(dataType, p.period, t) {
+			dataMap.AddRecord(station.Id, dataType, bdplib.CreateRecord(timestamp, value, p.period))
+		}
 	}
-	if val, ok := classCounts[DataTypeBuses]; ok && existingMeasurements.shouldElaborate(DataTypeBuses, t) {
-		nrBuses = float64(val)
-		dataMap.AddRecord(station.Id, DataTypeBuses, bdplib.CreateRecord(timestamp, val, period))
+
+	// Euro and nationality distributions, camera-only
+	if IsCamera(station) {
+		addRecord(DataTypeEuroPct, createVehicleEuro(stats.euro))
+		addRecord(DataTypeNationalityCount, createVehicleNationality(stats.nationality))
+
+		addRecord(DataTypeNationalityCountLight, createVehicleNationality(stats.nationalityLight))
+		addRecord(DataTypeNationalityCountHeavy, createVehicleNationality(stats.nationalityHeavy))
+		addRecord(DataTypeNationalityCountBuses, createVehicleNationality(stats.nationalityBuses))
+
+		addRecord(DataTypeEuroPctLight, createVehicleEuro(stats.euroLight))
 	}
 
-	// Equivalent vehicles
-	equivVehicles := nrLight + 2.5*(nrHeavy+nrBuses)
-	if existingMeasurements.shouldElaborate(DataTypeEquivalentVehicles, t) {
-		dataMap.AddRecord(station.Id, DataTypeEquivalentVehicles, bdplib.CreateRecord(timestamp, equivVehicles, period))
+	// Vehicle counts
+	classCounts := createVehicleCounts(stats)
+	for dataType, val := range classCounts {
+		addRecord(dataType, val)
 	}
 
+	// Equivalent vehicles
+	equivVehicles := float64(classCounts[DataTypeLightVehicles]) +
+		2.5*float64(classCounts[DataTypeHeavyVehicles]+classCounts[DataTypeBuses])
+	addRecord(DataTypeEquivalentVehicles, equivVehicles)
+
 	// Average speeds
-	classAvgSpeeds := createClassAvgSpeeds(vehicles)
+	classAvgSpeeds := createClassAvgSpeeds(stats)
 	for dataType, val := range classAvgSpeeds {
-		if existingMeasurements.shouldElaborate(dataType, t) {
-			dataMap.AddRecord(station.Id, dataType, bdplib.CreateRecord(timestamp, val, period))
-		}
+		addRecord(dataType, val)
 	}
 
 	// Variance of speeds
-	classVarSpeeds := createClassVarSpeeds(vehicles, classAvgSpeeds)
+	classVarSpeeds := createClassVarSpeeds(stats)
 	for dataType, val := range classVarSpeeds {
-		if existingMeasurements.shouldElaborate(dataType, t) {
-			dataMap.AddRecord(station.Id, dataType, bdplib.CreateRecord(timestamp, val, period))
-		}
+		addRecord(dataType, val)
 	}
 
 	// Average metrics
-	classAvgs := createClassAvgs(vehicles, equivVehicles, int64(period))
+	classAvgs := createClassAvgs(stats, equivVehicles, int64(p.period))
 	for dataType, val := range classAvgs {
-		if existingMeasurements.shouldElaborate(dataType, t) {
-			dataMap.AddRecord(station.Id, dataType, bdplib.CreateRecord(timestamp, val, period))
-		}
+		addRecord(dataType, val)
 	}
 
 	// Direction
-	stationDirection := station.Direction()
-	if len(vehicles) != 0 && stationDirection != STATION_DIRECTION_UNKNOWN {
-		var normalCount int
-		for _, v := range vehicles {
-			if v.Direction == int(stationDirection) {
-				normalCount++
-			}
-		}
-		total := len(vehicles)
+	if stats.vehicles != 0 && station.Direction() != STATION_DIRECTION_UNKNOWN {
+		total := stats.vehicles
 
 		var direction int = 1
-		var score float64 = 1
-		if total > 0 {
-			score = float64(normalCount) / float64(total)
-			if normalCount < total/2 {
-				direction = 0
-			}
+		score := float64(stats.normalDirection) / float64(total)
+		if stats.normalDirection < total/2 {
+			direction = 0
 		}
 
-		if existingMeasurements.shouldElaborate(DataTypeDirection, t) {
-			dataMap.AddRecord(station.Id, DataTypeDirection, bdplib.CreateRecord(timestamp, direction, period))
-		}
-		if existingMeasurements.shouldElaborate(DataTypeDirectionScore, t) {
-			dataMap.AddRecord(station.Id, DataTypeDirectionScore, bdplib.CreateRecord(timestamp, score, period))
-		}
+		addRecord(DataTypeDirection, direction)
+		addRecord(DataTypeDirectionScore, score)
 	}
 
-	return nil
-}
-
-func filterVehiclesByClass(vehicles []Vehicle) (light, heavy, buses []Vehicle) {
-	for _, v := range vehicles {
-		if v.IsLight() {
-			light = append(light, v)
-		} else if v.IsHeavy() {
-			heavy = append(heavy, v)
-		} else if v.IsBus() {
-			buses = append(buses, v)
-		}
+	// Data quality
+	for dataType, val := range createQualityMetrics(stats.quality) {
+		addRecord(dataType, val)
 	}
-	return
-}
 
-func createVehicleCounts(vehicles []Vehicle) map[string]int {
-	counts := map[string]int{
-		DataTypeLightVehicles: 0,
-		DataTypeHeavyVehicles: 0,
-		DataTypeBuses:         0,
-	}
-	for _, v := range vehicles {
-		if v.IsLight() {
-			counts[DataTypeLightVehicles]++
-		} else if v.IsHeavy() {
-			counts[DataTypeHeavyVehicles]++
-		} else if v.IsBus() {
-			counts[DataTypeBuses]++
-		}
-	}
-	return counts
+	return nil
 }
 
-func createClassAvgSpeeds(vehicles []Vehicle) map[string]float64 {
-	var sumLight, sumHeavy, sumBus float64
-	var countLight, countHeavy, countBus int
-
-	for _, v := range vehicles {
-		s := v.Speed
-		if v.IsLight() {
-			sumLight += s
-			countLight++
-		} else if v.IsHeavy() {
-			sumHeavy += s
-			countHeavy++
-		} else if v.IsBus() {
-			sumBus += s
-			countBus++
-		}
+func createVehicleCounts(stats *windowStats) map[string]int {
+	return map[string]int{
+		DataTypeLightVehicles: stats.light.count,
+		DataTypeHeavyVehicles: stats.heavy.count,
+		DataTypeBuses:         stats.buses.count,
 	}
+}
 
+func createClassAvgSpeeds(stats *windowStats) map[string]float64 {
 	return map[string]float64{
-		DataTypeAvgSpeedLight: average(sumLight, countLight),
-		DataTypeAvgSpeedHeavy: average(sumHeavy, countHeavy),
-		DataTypeAvgSpeedBuses: average(sumBus, countBus),
+		DataTypeAvgSpeedLight: average(stats.light.sumSpeed, stats.light.speeds),
+		DataTypeAvgSpeedHeavy: average(stats.heavy.sumSpeed, stats.heavy.speeds),
+		DataTypeAvgSpeedBuses: average(stats.buses.sumSpeed, stats.buses.speeds),
 	}
 }
 
-func createClassVarSpeeds(vehicles []Vehicle, avgSpeeds map[string]float64) map[string]float64 {
-	var varLight, varHeavy, varBus float64
-	var countLight, countHeavy, countBus int
-
-	for _, v := range vehicles {
-		s := v.Speed
-		if v.IsLight() {
-			varLight += squareDiff(s, avgSpeeds[DataTypeAvgSpeedLight])
-			countLight++
-		} else if v.IsHeavy() {
-			varHeavy += squareDiff(s, avgSpeeds[DataTypeAvgSpeedHeavy])
-			countHeavy++
-		} else if v.IsBus() {
-			varBus += squareDiff(s, avgSpeeds[DataTypeAvgSpeedBuses])
-			countBus++
-		}
-	}
-
+func createClassVarSpeeds(stats *windowStats) map[string]float64 {
 	return map[string]float64{
-		DataTypeVarSpeedLight: average(varLight, countLight),
-		DataTypeVarSpeedHeavy: average(varHeavy, countHeavy),
-		DataTypeVarSpeedBuses: average(varBus, countBus),
+		DataTypeVarSpeedLight: variance(stats.light),
+		DataTypeVarSpeedHeavy: variance(stats.heavy),
+		DataTypeVarSpeedBuses: variance(stats.buses),
 	}
 }
 
-func createClassAvgs(vehicles []Vehicle, equivalentVehicles float64, windowLength int64) map[string]float64 {
-	var sumGap, sumHeadway, sumSpeed float64
-	for _, v := range vehicles {
-		sumGap += v.Distance
-		sumHeadway += v.Headway
-		sumSpeed += v.Speed
-	}
-
+func createClassAvgs(stats *windowStats, equivalentVehicles float64, windowLength int64) map[string]float64 {
 	var avgHeadway float64 = NULL_VALUE
 	var avgGap float64 = NULL_VALUE
 	var avgSpeed float64 = NULL_VALUE
@@ -230,13 +282,14 @@ func createClassAvgs(vehicles []Vehicle, equivalentVehicles float64, windowLengt
 	var avgFlow float64 = NULL_VALUE
 	var avgDensity float64 = NULL_VALUE
 
-	count := float64(len(vehicles))
-	if count != 0 {
-		avgHeadway = sumHeadway / count
-		avgGap = sumGap / count
-		avgSpeed = sumSpeed / count
+	if stats.vehicles != 0 {
 		// windowLength is in seconds
 		avgFlow = equivalentVehicles * 3.6 / float64(windowLength)
+	}
+	if count := float64(stats.plausible); count != 0 {
+		avgHeadway = stats.sumHeadway / count
+		avgGap = stats.sumGap / count
+		avgSpeed = stats.sumSpeed / count
 		if avgSpeed == 0 {
 			avgDensity = 0
 		} else {
@@ -252,28 +305,19 @@ func createClassAvgs(vehicles []Vehicle, equivalentVehicles float64, windowLengt
 	}
 }
 
-func createVehicleNationality(vehicles []Vehicle) map[string]int {
+func createVehicleNationality(counts map[string]int) map[string]int {
 	nations := map[string]int{
 		"I": 0, "F": 0, "GB": 0, "D": 0, "CH": 0, "A": 0, "NL": 0, "E": 0, "B": 0, "DK": 0,
 		"L": 0, "S": 0, "PL": 0, "GR": 0, "H": 0, "CZ": 0, "SK": 0, "BG": 0, "EST": 0, "FIN": 0,
 		"HR": 0, "IRL": 0, "LT": 0, "LV": 0, "P": 0, "RO": 0, "RSM": 0, "SLO": 0, "XXX": 0,
 	}
-
-	for _, v := range vehicles {
-		if nil == v.PlateNat || "" == *v.PlateNat {
-			continue
-		}
-		nat := *v.PlateNat
-		if _, ok := nations[nat]; !ok {
-			nations[nat] = 1
-		} else {
-			nations[nat]++
-		}
+	for nat, count := range counts {
+		nations[nat] += count
 	}
 	return nations
 }
 
-func createVehicleEuro(vehicles []Vehicle, t time.Time) map[string]float64 {
+func createVehicleEuro(stats euroStats) map[string]float64 {
 	euroProb := map[string]float64{
 		EURO0:  0.0,
 		EURO1:  0.0,
@@ -285,35 +329,22 @@ func createVehicleEuro(vehicles []Vehicle, t time.Time) map[string]float64 {
 		EUROE:  0.0,
 		NVALID: 0.0,
 	}
-	validVehicleCount := 0
-
-	euroTypeMap := euroTypeUtils.GetVehicleDataMap(t)
-
-	for _, vehicle := range vehicles {
-		if nil == vehicle.PlateInitials || *vehicle.PlateInitials == "" {
-			continue
-		}
-
-		euroData, exists := euroTypeMap[*vehicle.PlateInitials]
-		if !exists {
-			continue
-		}
-
-		probabilities := euroData.Probabilities
-		for euroClass, prob := range probabilities {
-			euroProb[euroClass] += prob
+	if stats.valid > 0 {
+		for euroClass, sum := range stats.sum {
+			euroProb[euroClass] = sum / float64(stats.valid)
 		}
-		validVehicleCount++
+		euroProb[NVALID] = float64(stats.valid)
 	}
+	return euroProb
+}
 
-	if validVehicleCount > 0 {
-		for euroClass := range euroProb {
-			euroProb[euroClass] /= float64(validVehicleCount)
-		}
-		euroProb[NVALID] = float64(validVehicleCount)
+// variance is the population variance of the speeds, computed from the sums so that it can be rolled up
+func variance(c classStats) float64 {
+	if c.speeds == 0 {
+		return NULL_VALUE
 	}
-
-	return euroProb
+	mean := c.sumSpeed / float64(c.speeds)
+	return max(c.sumSqSpeed/float64(c.speeds)-mean*mean, 0)
 }
 
 func squareDiff(value, mean float64) float64 {
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exclusion is a time range where the a22 data is known to be inconsistent and must not be elaborated
+type exclusion struct {
+	Start  time.Time `yaml:"start"`
+	End    time.Time `yaml:"end"`
+	Reason string    `yaml:"reason"`
+}
+
+type exclusionList []exclusion
+
+func loadExclusions(path string) (exclusionList, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exclusions: %w", err)
+	}
+	var c struct {
+		Exclusions exclusionList `yaml:"exclusions"`
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse exclusions %s: %w", path, err)
+	}
+	for _, e := range c.Exclusions {
+		if !e.End.After(e.Start) {
+			return nil, fmt.Errorf("exclusion %s - %s: end must be after start", e.Start, e.End)
+		}
+	}
+	return c.Exclusions, nil
+}
+
+// overlaps returns true if any part of [from, to) is excluded. Times are in millis
+func (l exclusionList) overlaps(from, to int64) bool {
+	for _, e := range l {
+		if from < e.End.UnixMilli() && to > e.Start.UnixMilli() {
+			return true
+		}
+	}
+	return false
+}
+
+// covers returns true if all of [from, to) is excluded by a single exclusion. Times are in millis
+func (l exclusionList) covers(from, to int64) bool {
+	for _, e := range l {
+		if from >= e.Start.UnixMilli() && to <= e.End.UnixMilli() {
+			return true
+		}
+	}
+	return false
+}
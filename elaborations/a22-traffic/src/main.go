@@ -56,19 +56,14 @@ const (
 	DataTypeNationalityCountBuses = "Plate Nationality Count Buses"
 	DataTypeDirection             = "Traffic Normal Direction"
 	DataTypeDirectionScore        = "Traffic Direction Score"
-
-	MeasurementPeriod uint64 = 600
+	DataTypeCoverage              = "Data Coverage"
+	DataTypeImplausiblePct        = "Implausible Records Pct"
+	DataTypeDataGap               = "Data Gap"
 )
 
 // Hardcoded minimum allowed start time: 2024-Jul-09 23:59:59 UTC
 var hardcodedMinStartTime = time.Date(2024, time.July, 9, 23, 59, 59, 0, time.UTC).UnixMilli()
 
-// inconsistent data start:  2024-Jul-09 23:59:59 UTC
-var InconsistentDataStart = time.Date(2024, time.July, 9, 23, 59, 59, 0, time.UTC).UnixMilli()
-
-// inconsistent data end:  2024-Nov-26 23:59:59 UTC
-var InconsistentDataEnd = time.Date(2024, time.November, 26, 23, 59, 59, 0, time.UTC).UnixMilli()
-
 // allDataTypes is the complete list of data‐types we expect.
 var allDataTypes = []string{
 	DataTypeLightVehicles,
@@ -95,6 +90,9 @@ var allDataTypes = []string{
 	DataTypeNationalityCountBuses,
 	DataTypeDirection,
 	DataTypeDirectionScore,
+	DataTypeCoverage,
+	DataTypeImplausiblePct,
+	DataTypeDataGap,
 }
 
 var dataTypes []bdplib.DataType
@@ -108,6 +106,13 @@ var env struct {
 	NINJA_CONSUMER string `envconfig:"NINJA_CONSUMER"`
 
 	CRON string `envconfig:"CRON"`
+
+	// YAML file with the periods and metrics to elaborate. Defaults to all metrics every 600 seconds
+	AGGREGATION_CONFIG string `envconfig:"AGGREGATION_CONFIG"`
+	// YAML file with the time ranges where the a22 data is inconsistent and must not be elaborated
+	EXCLUSIONS_CONFIG string `envconfig:"EXCLUSIONS_CONFIG" default:"../resources/exclusions.yaml"`
+	// file keeping the partial rollups across restarts. Without it, the first run after a restart reads the whole longest period again
+	ROLLUP_STATE_PATH string `envconfig:"ROLLUP_STATE_PATH"`
 }
 
 type CronLogger struct {
@@ -144,6 +149,8 @@ func createDBConnection() (*sqlx.DB, error) {
 
 var sensorUtils *SensorTypeUtil = nil
 var euroTypeUtils *EUROTypeUtil = nil
+var agg *aggregation = nil
+var exclusions exclusionList = nil
 
 func milliToRFC3339(milli int64) string {
 	return time.Unix(milli/1000, (milli%1000)*1_000_000).UTC().Format(time.RFC3339)
@@ -173,13 +180,13 @@ func createBatchSpan(ctx context.Context) (context.Context, trace.Span) {
 	return windowCtx, windowSpan
 }
 
-func processStationTask(ctx context.Context, task stationTask, horizon int64, bdp bdplib.Bdp, ad22DbConnection *sqlx.DB) {
+func processStationTask(ctx context.Context, task stationTask, horizon int64, bdp bdplib.Bdp, ad22DbConnection *sqlx.DB, state *rollupState) {
 	station := task.Station
 	meas := task.Meas
 
 	// if the min timestamp of this station (the type with the most past measurement) is >= station MaxTimestamp,
 	// it means there are no new data to consume for this station, skip it
-	minMeasTs := meas.startFrom(station)
+	minMeasTs := meas.startFrom(station, agg)
 	if minMeasTs.UnixMilli() >= station.MaxTimestamp {
 		return
 	}
@@ -190,42 +197,70 @@ func processStationTask(ctx context.Context, task stationTask, horizon int64, bd
 	startTime = max(startTime, hardcodedMinStartTime)
 
 	endTime := min(station.MaxTimestamp, horizon)
+
+	existing := func(from, to int64) (measurementFilter, error) { return meas, nil }
+	elaborateStation(ctx, station, startTime, endTime, bdp, ad22DbConnection, existing, nil, state)
+}
+
+// elaborateStation elaborates and pushes the measurements of a station between startTime and endTime.
+// existing returns which measurements of a batch must be elaborated. done, if not nil, is called after each pushed batch.
+// state, if not nil, continues the rollups of the previous elaboration
+func elaborateStation(ctx context.Context, station Station, startTime, endTime int64, bdp bdplib.Bdp, ad22DbConnection *sqlx.DB,
+	existing func(from, to int64) (measurementFilter, error), done func(batchEnd int64), state *rollupState) {
+	base := agg.base()
+
 	logger.Get(ctx).Info("processing station",
 		"stationcode", station.Id,
 		"start_time", milliToRFC3339(startTime),
 		"end_time", milliToRFC3339(endTime))
 
-	windowLength := int64(MeasurementPeriod * 1000)
-	batchWindowCount := 10
+	windowLength := int64(base.period * 1000)
+	batchWindowCount := agg.batchWindows
 	batchWindowLength := windowLength * int64(batchWindowCount)
 
-	// align start time with the nearest 10*x minute timestamp
-	alignedStartTime := (startTime / windowLength) * windowLength
 	endTime = (endTime / windowLength) * windowLength
 
+	rollups := make([]*rollup, 0, len(agg.rollups()))
+	for _, p := range agg.rollups() {
+		rollups = append(rollups, &rollup{periodAggregation: p})
+	}
+
+	// continue the rollups of the previous elaboration after its last base window. Otherwise align the start time
+	// with the longest period, so that the first rollup windows are complete.
+	// Base windows that are already elaborated are read again, but not pushed
+	alignedStartTime, resumed := state.restore(station.Id, startTime, rollups)
+	if !resumed {
+		alignedStartTime = (startTime / agg.longest()) * agg.longest()
+	}
+
 	logger.Get(ctx).Debug("processing station (aligned)",
 		"stationcode", station.Id,
-		"start_time", milliToRFC3339(startTime),
-		"end_time", milliToRFC3339(endTime))
+		"start_time", milliToRFC3339(alignedStartTime),
+		"end_time", milliToRFC3339(endTime),
+		"resumed_rollups", resumed)
 
 	for window := alignedStartTime; window < endTime; window += batchWindowLength {
-		// exclude windows where data is insonsistent
-		if window >= InconsistentDataStart && window <= InconsistentDataEnd {
-			continue
-		}
-
 		// Cap windowEnd so it doesn't exceed endTime
 		windowEnd := window + batchWindowLength
 		if windowEnd > endTime {
 			windowEnd = endTime
 		}
 
+		// exclude windows where data is insonsistent
+		if exclusions.covers(window, windowEnd) {
+			continue
+		}
+
 		batchCtx, batchSpan := createBatchSpan(ctx)
 		defer batchSpan.End()
 
 		logger.Get(batchCtx).Info("batch query", "station", station.Id,
 			"window_start", milliToRFC3339(window), "window_end", milliToRFC3339(windowEnd))
 
+		meas, err := existing(window, windowEnd)
+		ms.FailOnError(batchCtx, err, "failed to get existing measurements", "station", station.Id,
+			"window_start", milliToRFC3339(window), "window_end", milliToRFC3339(windowEnd))
+
 		vehicles, err := ReadVehiclesWindow(context.Background(), ad22DbConnection, window, windowEnd, station.Id)
 		ms.FailOnError(batchCtx, err, "failed to get vehicles", "station", station.Id,
 			"window_start", milliToRFC3339(window), "window_end", milliToRFC3339(windowEnd))
@@ -242,19 +277,47 @@ func processStationTask(ctx context.Context, task stationTask, horizon int64, bd
 			}
 
 			winEnd := winStart + windowLength
+			if exclusions.overlaps(winStart, winEnd) {
+				// the rollups containing this window are incomplete, and won't be pushed
+				continue
+			}
 			vInWindow := vehicleMap[i]
 
 			logger.Get(batchCtx).Debug("elaborating", "station", station.Id,
 				"window_start", milliToRFC3339(winStart), "window_end", milliToRFC3339(winEnd), "vehicle_count", len(vInWindow))
 
-			err = elaborate(batchCtx, &dataMap, meas, station, vInWindow, winEnd, MeasurementPeriod)
+			stats := createWindowStats(station, vInWindow, winStart, winEnd, agg.quality)
+			err = elaborate(batchCtx, &dataMap, meas, station, stats, winEnd, base)
 			ms.FailOnError(batchCtx, err, "failed to elaborate vehicles", "station", station.Id,
 				"window_start", milliToRFC3339(winStart), "window_end", milliToRFC3339(winEnd))
+
+			// hourly, daily... measurements are rolled up from the base windows
+			for _, r := range rollups {
+				merged, complete := r.add(winStart, winEnd, base.period, stats)
+				if merged == nil {
+					continue
+				}
+				if !complete {
+					logger.Get(batchCtx).Debug("skipping incomplete rollup", "station", station.Id, "period", r.period,
+						"window_end", milliToRFC3339(winEnd), "windows", merged.windows)
+					continue
+				}
+				err = elaborate(batchCtx, &dataMap, meas, station, merged, winEnd, r.periodAggregation)
+				ms.FailOnError(batchCtx, err, "failed to elaborate rollup", "station", station.Id, "period", r.period,
+					"window_end", milliToRFC3339(winEnd))
+			}
 		}
 
 		err = bdp.PushData(station.StationType, dataMap)
 		ms.FailOnError(batchCtx, err, "failed to push data", "station", station.Id,
 			"window_start", milliToRFC3339(window), "window_end", milliToRFC3339(windowEnd))
+
+		err = state.store(station.Id, windowEnd, rollups)
+		ms.FailOnError(batchCtx, err, "failed to store rollups", "station", station.Id)
+
+		if done != nil {
+			done(windowEnd)
+		}
 	}
 }
 
@@ -268,6 +331,17 @@ func main() {
 	sensorUtils = NewSensorTypeUtil()
 	euroTypeUtils = NewEUROTypeUtil()
 
+	var err error
+	agg, err = loadAggregation(env.AGGREGATION_CONFIG)
+	ms.FailOnError(context.Background(), err, "failed to load aggregation config")
+	logger.Get(context.Background()).Info("elaborating periods", "periods", agg.periodList())
+
+	exclusions, err = loadExclusions(env.EXCLUSIONS_CONFIG)
+	ms.FailOnError(context.Background(), err, "failed to load exclusions")
+
+	rollupState, err := loadRollupState(env.ROLLUP_STATE_PATH)
+	ms.FailOnError(context.Background(), err, "failed to load rollup state")
+
 	// Setup connection
 	ad22DbConnection, err := createDBConnection()
 	defer ad22DbConnection.Close()
@@ -285,6 +359,13 @@ func main() {
 	///////////////////
 	ninjaTokenProvider := NewOAuthProvider()
 
+	// reprocessing a time range on demand instead of the periodic elaboration
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		err := runBackfill(context.Background(), os.Args[2:], bdp, ad22DbConnection, ninjaTokenProvider)
+		ms.FailOnError(context.Background(), err, "backfill failed")
+		return
+	}
+
 	// Setup Cron Job
 	c := cron.New(
 		cron.WithSeconds(),
@@ -301,8 +382,8 @@ func main() {
 		// horizon ensures that we do not use real-time data which might be incomplete
 		// the horizon must be a multiple of the Period, otherwise it uses partial data of the last period and the rest of the
 		// data wont be processed since we checkpoint the last window's endtime.
-		// right now it is 5 * period meaning 50 minutes
-		horizon := now.UnixMilli() - (5 * int64(MeasurementPeriod) * 1000)
+		// right now it is 5 * base period, with the default 600s meaning 50 minutes
+		horizon := now.UnixMilli() - (5 * int64(agg.base().period) * 1000)
 
 		ctx := context.Background()
 
@@ -327,7 +408,7 @@ func main() {
 		stations, err := readStations(ctx, ad22DbConnection, bdp.GetOrigin(), sensorStationType)
 		ms.FailOnError(ctx, err, "failed to get stations from a22 db")
 
-		measurements, err := getMeasurementsByStation(ctx, ninjaTokenProvider)
+		measurements, err := getMeasurementsByStation(ctx, ninjaTokenProvider, agg.periodList())
 		ms.FailOnError(ctx, err, "failed to get measurements from ninja")
 
 		// sync stations
@@ -348,7 +429,7 @@ func main() {
 			go func() {
 				defer wg.Done()
 				for task := range stationChan {
-					processStationTask(ctx, task, horizon, bdp, ad22DbConnection)
+					processStationTask(ctx, task, horizon, bdp, ad22DbConnection, rollupState)
 				}
 			}()
 		}
@@ -365,6 +446,9 @@ func main() {
 
 		wg.Wait()
 
+		err = rollupState.save()
+		ms.FailOnError(ctx, err, "failed to save rollup state")
+
 		logger.Get(ctx).Info("elaboration completed", "runtime_ms", time.Since(now).Milliseconds())
 	})
 
@@ -412,6 +496,11 @@ func SyncDataTypes(bdp bdplib.Bdp) {
 	dataTypes = append(dataTypes, bdplib.CreateDataType(DataTypeDirection, "", "Majority of the vehicles are following the normal direction (1 = normal, 0 = inverse)", "Count"))
 	dataTypes = append(dataTypes, bdplib.CreateDataType(DataTypeDirectionScore, "", "Score defined by how many vehicles are traveling in the inverse direction (1 = normal, 0 = inverse)", "Count"))
 
+	// Data quality
+	dataTypes = append(dataTypes, bdplib.CreateDataType(DataTypeCoverage, "%", "Share of the coverage intervals of the window in which the sensor delivered records", "Mean"))
+	dataTypes = append(dataTypes, bdplib.CreateDataType(DataTypeImplausiblePct, "%", "Share of records excluded by the plausibility filters", "Mean"))
+	dataTypes = append(dataTypes, bdplib.CreateDataType(DataTypeDataGap, "", "Number of base windows in which the sensor delivered no records", "Count"))
+
 	// Sync
 	err := bdp.SyncDataTypes(sensorStationType, dataTypes)
 	ms.FailOnError(context.Background(), err, "failed to sync data types")
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import "fmt"
+
+// QualityConfig defines how the data quality of a window is assessed
+type QualityConfig struct {
+	// CoverageInterval in seconds. The coverage is the share of intervals of a window in which the station delivered records
+	CoverageInterval uint64             `yaml:"coverage_interval"`
+	Plausibility     PlausibilityConfig `yaml:"plausibility"`
+}
+
+// PlausibilityConfig are the bounds of plausible vehicle records. Records outside still count in the vehicle counts
+// and distributions, but are excluded from the speed, headway and gap metrics. Unset bounds are not checked
+type PlausibilityConfig struct {
+	// km/h
+	MinSpeed *float64 `yaml:"min_speed"`
+	MaxSpeed *float64 `yaml:"max_speed"`
+	// seconds
+	MinHeadway *float64 `yaml:"min_headway"`
+	MaxHeadway *float64 `yaml:"max_headway"`
+	// cm
+	MaxLength *float64 `yaml:"max_length"`
+}
+
+var defaultQuality = QualityConfig{CoverageInterval: 60}
+
+func (q *QualityConfig) validate(basePeriod uint64) error {
+	if q.CoverageInterval == 0 {
+		q.CoverageInterval = defaultQuality.CoverageInterval
+	}
+	if basePeriod%q.CoverageInterval != 0 {
+		return fmt.Errorf("coverage interval %d doesn't divide the base period %d", q.CoverageInterval, basePeriod)
+	}
+	p := q.Plausibility
+	if p.MinSpeed != nil && p.MaxSpeed != nil && *p.MinSpeed > *p.MaxSpeed {
+		return fmt.Errorf("min_speed is greater than max_speed")
+	}
+	if p.MinHeadway != nil && p.MaxHeadway != nil && *p.MinHeadway > *p.MaxHeadway {
+		return fmt.Errorf("min_headway is greater than max_headway")
+	}
+	return nil
+}
+
+func (p PlausibilityConfig) plausible(v Vehicle) bool {
+	outside := func(value float64, min, max *float64) bool {
+		return (min != nil && value < *min) || (max != nil && value > *max)
+	}
+	return !outside(v.Speed, p.MinSpeed, p.MaxSpeed) &&
+		!outside(v.Headway, p.MinHeadway, p.MaxHeadway) &&
+		!outside(v.Length, nil, p.MaxLength)
+}
+
+// qualityStats are the data quality indicators of a window
+type qualityStats struct {
+	// all records of the window, including the implausible ones
+	records     int
+	implausible int
+	// coverage intervals, and how many of them had records
+	intervals, covered int
+	// base windows without any record
+	emptyWindows int
+}
+
+func newQualityStats(vehicles []Vehicle, from, to int64, q QualityConfig) qualityStats {
+	intervalLength := int64(q.CoverageInterval) * 1000
+	s := qualityStats{
+		records:   len(vehicles),
+		intervals: int((to - from) / intervalLength),
+	}
+	if len(vehicles) == 0 {
+		s.emptyWindows = 1
+	}
+	covered := map[int64]bool{}
+	for _, v := range vehicles {
+		covered[(v.Timestamp*1000-from)/intervalLength] = true
+		if !q.Plausibility.plausible(v) {
+			s.implausible++
+		}
+	}
+	s.covered = len(covered)
+	return s
+}
+
+func (s *qualityStats) merge(o qualityStats) {
+	s.records += o.records
+	s.implausible += o.implausible
+	s.intervals += o.intervals
+	s.covered += o.covered
+	s.emptyWindows += o.emptyWindows
+}
+
+func createQualityMetrics(s qualityStats) map[string]any {
+	var coverage float64 = NULL_VALUE
+	if s.intervals > 0 {
+		coverage = float64(s.covered) / float64(s.intervals) * 100
+	}
+	var implausible float64 = NULL_VALUE
+	if s.records > 0 {
+		implausible = float64(s.implausible) / float64(s.records) * 100
+	}
+	return map[string]any{
+		DataTypeCoverage:       coverage,
+		DataTypeImplausiblePct: implausible,
+		DataTypeDataGap:        s.emptyWindows,
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	bdpclient "github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestQualityConfig(t *testing.T) {
+	a, err := newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(60), a.quality.CoverageInterval)
+
+	_, err = newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}}, Quality: QualityConfig{CoverageInterval: 70}})
+	require.ErrorContains(t, err, "doesn't divide")
+
+	_, err = newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}},
+		Quality: QualityConfig{Plausibility: PlausibilityConfig{MinSpeed: ptr(100.0), MaxSpeed: ptr(10.0)}}})
+	require.ErrorContains(t, err, "min_speed")
+}
+
+func TestQualityStats(t *testing.T) {
+	euroTypeUtils = &EUROTypeUtil{vehicleDataMap2023: map[string]EUROType{}, vehicleDataMap2024: map[string]EUROType{}}
+	station := Station{Station: bdpclient.Station{Id: "1", Name: "Bolzano (direzione sud)"}}
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli()
+	q := QualityConfig{CoverageInterval: 60, Plausibility: PlausibilityConfig{MinSpeed: ptr(1.0), MaxHeadway: ptr(3600.0)}}
+
+	// vehicles in 3 of the 10 minutes, one standing still and one with an absurd headway
+	vehicles := []Vehicle{
+		{Timestamp: start/1000 + 5, Speed: 80, Headway: 2, ClassNr: 1},
+		{Timestamp: start/1000 + 30, Speed: 0, Headway: 2, ClassNr: 1},
+		{Timestamp: start/1000 + 130, Speed: 90, Headway: 5, ClassNr: 1},
+		{Timestamp: start/1000 + 599, Speed: 70, Headway: 99999, ClassNr: 1},
+	}
+	stats := createWindowStats(station, vehicles, start, start+600_000, q)
+	// implausible vehicles are counted, but left out of the speeds and headways
+	require.Equal(t, 4, stats.vehicles)
+	require.Equal(t, 4, stats.light.count)
+	require.Equal(t, 4, createVehicleCounts(stats)[DataTypeLightVehicles])
+	require.InDelta(t, 85.0, createClassAvgSpeeds(stats)[DataTypeAvgSpeedLight], 1e-9)
+	require.InDelta(t, 25.0, createClassVarSpeeds(stats)[DataTypeVarSpeedLight], 1e-9)
+	avgs := createClassAvgs(stats, 4, 600)
+	require.InDelta(t, 3.5, avgs[DataTypeAvgHeadway], 1e-9)
+	require.InDelta(t, 4*3.6/600, avgs[DataTypeAvgFlow], 1e-9)
+
+	metrics := createQualityMetrics(stats.quality)
+	require.InDelta(t, 30.0, metrics[DataTypeCoverage], 1e-9)
+	require.InDelta(t, 50.0, metrics[DataTypeImplausiblePct], 1e-9)
+	require.Equal(t, 0, metrics[DataTypeDataGap])
+
+	// an empty window is a gap, and merges into the rollups
+	empty := createWindowStats(station, nil, start+600_000, start+1200_000, q)
+	metrics = createQualityMetrics(empty.quality)
+	require.Equal(t, 1, metrics[DataTypeDataGap])
+	require.Equal(t, 0.0, metrics[DataTypeCoverage])
+	require.Equal(t, float64(NULL_VALUE), metrics[DataTypeImplausiblePct])
+
+	stats.merge(empty)
+	metrics = createQualityMetrics(stats.quality)
+	require.Equal(t, 1, metrics[DataTypeDataGap])
+	require.InDelta(t, 15.0, metrics[DataTypeCoverage], 1e-9)
+	require.InDelta(t, 50.0, metrics[DataTypeImplausiblePct], 1e-9)
+}
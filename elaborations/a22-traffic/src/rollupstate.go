@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rollupState keeps the partial rollups of every station between runs, so that an elaboration continues after the
+// last base window instead of reading the vehicles of the whole longest period again.
+// Without path it lives in memory only, and the first run after a restart reads the vehicles again
+type rollupState struct {
+	path string
+	mu   sync.Mutex
+	// encoded stationRollups by station id, a snapshot that isn't changed by the ongoing rollups
+	stations map[string]json.RawMessage
+}
+
+// stationRollups are the rollups of a station up to the end of its last pushed base window
+type stationRollups struct {
+	End     int64                  `json:"end"`
+	Rollups map[uint64]savedRollup `json:"rollups"`
+}
+
+type savedRollup struct {
+	Start int64        `json:"start"`
+	Stats *windowStats `json:"stats"`
+}
+
+func loadRollupState(path string) (*rollupState, error) {
+	s := &rollupState{path: path, stations: map[string]json.RawMessage{}}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.stations); err != nil {
+		return nil, fmt.Errorf("failed to parse rollup state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// restore sets the rollups to the stored ones of the station and returns the end of the last base window they contain.
+// It reports false if not all rollups are stored, or if the elaboration has to start before the stored rollups,
+// e.g. because of a new metric
+func (s *rollupState) restore(station string, startTime int64, rollups []*rollup) (int64, bool) {
+	if s == nil || len(rollups) == 0 {
+		return 0, false
+	}
+	s.mu.Lock()
+	raw, ok := s.stations[station]
+	s.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	var stored stationRollups
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return 0, false
+	}
+
+	// the stored rollups contain the base windows since the start of the current window of the longest period
+	longest := int64(rollups[len(rollups)-1].period) * 1000
+	if startTime < (stored.End/longest)*longest || startTime > stored.End {
+		return 0, false
+	}
+	for _, r := range rollups {
+		if _, ok := stored.Rollups[r.period]; !ok {
+			return 0, false
+		}
+	}
+	for _, r := range rollups {
+		saved := stored.Rollups[r.period]
+		r.start, r.stats = saved.Start, saved.Stats
+	}
+	return stored.End, true
+}
+
+// store remembers the rollups of a station after the base windows up to end have been pushed
+func (s *rollupState) store(station string, end int64, rollups []*rollup) error {
+	if s == nil || len(rollups) == 0 {
+		return nil
+	}
+	stored := stationRollups{End: end, Rollups: map[uint64]savedRollup{}}
+	for _, r := range rollups {
+		stored.Rollups[r.period] = savedRollup{Start: r.start, Stats: r.stats}
+	}
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stations[station] = raw
+	return nil
+}
+
+// save writes the state atomically
+func (s *rollupState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.stations)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write rollup state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write rollup state: %w", err)
+	}
+	return nil
+}
+
+// windowStatsJSON is the stored form of the unexported sums of windowStats
+type windowStatsJSON struct {
+	Windows          int              `json:"windows"`
+	Light            classStatsJSON   `json:"light"`
+	Heavy            classStatsJSON   `json:"heavy"`
+	Buses            classStatsJSON   `json:"buses"`
+	Vehicles         int              `json:"vehicles"`
+	Plausible        int              `json:"plausible"`
+	SumGap           float64          `json:"sum_gap"`
+	SumHeadway       float64          `json:"sum_headway"`
+	SumSpeed         float64          `json:"sum_speed"`
+	NormalDirection  int              `json:"normal_direction"`
+	Nationality      map[string]int   `json:"nationality"`
+	NationalityLight map[string]int   `json:"nationality_light"`
+	NationalityHeavy map[string]int   `json:"nationality_heavy"`
+	NationalityBuses map[string]int   `json:"nationality_buses"`
+	Euro             euroStatsJSON    `json:"euro"`
+	EuroLight        euroStatsJSON    `json:"euro_light"`
+	Quality          qualityStatsJSON `json:"quality"`
+}
+
+type classStatsJSON struct {
+	Count      int     `json:"count"`
+	Speeds     int     `json:"speeds"`
+	SumSpeed   float64 `json:"sum_speed"`
+	SumSqSpeed float64 `json:"sum_sq_speed"`
+}
+
+type euroStatsJSON struct {
+	Sum   map[string]float64 `json:"sum"`
+	Valid int                `json:"valid"`
+}
+
+type qualityStatsJSON struct {
+	Records      int `json:"records"`
+	Implausible  int `json:"implausible"`
+	Intervals    int `json:"intervals"`
+	Covered      int `json:"covered"`
+	EmptyWindows int `json:"empty_windows"`
+}
+
+func (s *windowStats) MarshalJSON() ([]byte, error) {
+	class := func(c classStats) classStatsJSON {
+		return classStatsJSON{Count: c.count, Speeds: c.speeds, SumSpeed: c.sumSpeed, SumSqSpeed: c.sumSqSpeed}
+	}
+	return json.Marshal(windowStatsJSON{
+		Windows:          s.windows,
+		Light:            class(s.light),
+		Heavy:            class(s.heavy),
+		Buses:            class(s.buses),
+		Vehicles:         s.vehicles,
+		Plausible:        s.plausible,
+		SumGap:           s.sumGap,
+		SumHeadway:       s.sumHeadway,
+		SumSpeed:         s.sumSpeed,
+		NormalDirection:  s.normalDirection,
+		Nationality:      s.nationality,
+		NationalityLight: s.nationalityLight,
+		NationalityHeavy: s.nationalityHeavy,
+		NationalityBuses: s.nationalityBuses,
+		Euro:             euroStatsJSON{Sum: s.euro.sum, Valid: s.euro.valid},
+		EuroLight:        euroStatsJSON{Sum: s.euroLight.sum, Valid: s.euroLight.valid},
+		Quality: qualityStatsJSON{Records: s.quality.records, Implausible: s.quality.implausible,
+			Intervals: s.quality.intervals, Covered: s.quality.covered, EmptyWindows: s.quality.emptyWindows},
+	})
+}
+
+func (s *windowStats) UnmarshalJSON(b []byte) error {
+	var j windowStatsJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	class := func(c classStatsJSON) classStats {
+		return classStats{count: c.Count, speeds: c.Speeds, sumSpeed: c.SumSpeed, sumSqSpeed: c.SumSqSpeed}
+	}
+	// merging into empty stats initializes the maps also when none were stored
+	*s = *newWindowStats()
+	s.merge(&windowStats{
+		windows:          j.Windows,
+		light:            class(j.Light),
+		heavy:            class(j.Heavy),
+		buses:            class(j.Buses),
+		vehicles:         j.Vehicles,
+		plausible:        j.Plausible,
+		sumGap:           j.SumGap,
+		sumHeadway:       j.SumHeadway,
+		sumSpeed:         j.SumSpeed,
+		normalDirection:  j.NormalDirection,
+		nationality:      j.Nationality,
+		nationalityLight: j.NationalityLight,
+		nationalityHeavy: j.NationalityHeavy,
+		nationalityBuses: j.NationalityBuses,
+		euro:             euroStats{sum: j.Euro.Sum, valid: j.Euro.Valid},
+		euroLight:        euroStats{sum: j.EuroLight.Sum, valid: j.EuroLight.Valid},
+		quality: qualityStats{records: j.Quality.Records, implausible: j.Quality.Implausible,
+			intervals: j.Quality.Intervals, covered: j.Quality.Covered, emptyWindows: j.Quality.EmptyWindows},
+	})
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bdpclient "github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowStatsJSON(t *testing.T) {
+	euroTypeUtils = &EUROTypeUtil{vehicleDataMap2023: map[string]EUROType{}, vehicleDataMap2024: map[string]EUROType{
+		"AB": {Targa: "AB", Probabilities: map[string]float64{EURO5: 0.4, EURO6: 0.6}},
+	}}
+	station := Station{Station: bdpclient.Station{Id: "1", Name: "Bolzano (direzione sud)"}}
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli()
+	q := QualityConfig{CoverageInterval: 60, Plausibility: PlausibilityConfig{MaxSpeed: ptr(120.0)}}
+	stats := createWindowStats(station, testVehicles(start, 200), start, start+3600_000, q)
+
+	b, err := json.Marshal(stats)
+	require.NoError(t, err)
+	var decoded windowStats
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, *stats, decoded)
+
+	// empty stats decode to mergeable stats
+	b, err = json.Marshal(newWindowStats())
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	decoded.merge(stats)
+	require.Equal(t, stats.nationality, decoded.nationality)
+}
+
+func TestRollupState(t *testing.T) {
+	euroTypeUtils = &EUROTypeUtil{vehicleDataMap2023: map[string]EUROType{}, vehicleDataMap2024: map[string]EUROType{}}
+	station := Station{Station: bdpclient.Station{Id: "1", Name: "Bolzano (direzione sud)"}}
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	a, err := newAggregation(AggregationConfig{Periods: []PeriodConfig{{Period: 600}, {Period: 3600}, {Period: 86400}}})
+	require.NoError(t, err)
+	newRollups := func() []*rollup {
+		return []*rollup{{periodAggregation: a.periods[1]}, {periodAggregation: a.periods[2]}}
+	}
+
+	// a first run elaborates the base windows until 10:50
+	path := filepath.Join(t.TempDir(), "rollups.json")
+	state, err := loadRollupState(path)
+	require.NoError(t, err)
+	rollups := newRollups()
+	end := day + 65*600_000
+	for winStart := day; winStart < end; winStart += 600_000 {
+		for _, r := range rollups {
+			r.add(winStart, winStart+600_000, 600, createWindowStats(station, testVehicles(winStart, 10), winStart, winStart+600_000, a.quality))
+		}
+	}
+	require.NoError(t, state.store("1", end, rollups))
+	require.NoError(t, state.save())
+
+	// the next run starts from the last daily measurement, but continues the rollups after the last base window
+	state, err = loadRollupState(path)
+	require.NoError(t, err)
+	restored := newRollups()
+	from, ok := state.restore("1", day, restored)
+	require.True(t, ok)
+	require.Equal(t, end, from)
+	require.Equal(t, 5, restored[0].stats.windows)
+	require.Equal(t, 65, restored[1].stats.windows)
+	require.Equal(t, rollups[1].stats.light, restored[1].stats.light)
+
+	// the rollups complete without reading the earlier vehicles again
+	var merged *windowStats
+	var complete bool
+	for winStart := end; winStart < day+86400_000; winStart += 600_000 {
+		merged, complete = restored[1].add(winStart, winStart+600_000, 600, createWindowStats(station, nil, winStart, winStart+600_000, a.quality))
+	}
+	require.True(t, complete)
+	require.Equal(t, 144, merged.windows)
+	require.Equal(t, 650, merged.vehicles)
+
+	// nothing stored for the station, or the elaboration starts before the stored rollups
+	_, ok = state.restore("2", day, newRollups())
+	require.False(t, ok)
+	_, ok = state.restore("1", day-600_000, newRollups())
+	require.False(t, ok)
+	_, ok = state.restore("1", end+600_000, newRollups())
+	require.False(t, ok)
+
+	// a new rollup period is not stored yet
+	_, ok = state.restore("1", day, []*rollup{{periodAggregation: periodAggregation{period: 1800}}, {periodAggregation: a.periods[2]}})
+	require.False(t, ok)
+
+	// the backfill doesn't keep rollups
+	var none *rollupState
+	_, ok = none.restore("1", day, newRollups())
+	require.False(t, ok)
+	require.NoError(t, none.store("1", end, newRollups()))
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -127,18 +128,29 @@ func readStations(ctx context.Context, db *sqlx.DB, origin, stationType string)
 
 type ninjaResponse struct {
 	Mvalidtime string `json:"mvalidtime"`
+	Mperiod    uint64 `json:"mperiod"`
 	Tname      string `json:"tname"`
 	Scode      string `json:"scode"`
 }
 
+type measurementKey struct {
+	DataType string
+	Period   uint64
+}
+
+// measurementFilter decides which measurements are elaborated, typically skipping the ones already in the timeseries
+type measurementFilter interface {
+	shouldElaborate(dataType string, period uint64, ts time.Time) bool
+}
+
 type measurementMap struct {
 	first           time.Time
 	Last            time.Time
-	LastByDataTypes map[string]time.Time
+	LastByDataTypes map[measurementKey]time.Time
 }
 
-func (m measurementMap) shouldElaborate(dataType string, ts time.Time) bool {
-	last, ok := m.LastByDataTypes[dataType]
+func (m measurementMap) shouldElaborate(dataType string, period uint64, ts time.Time) bool {
+	last, ok := m.LastByDataTypes[measurementKey{dataType, period}]
 	if !ok || last.Before(ts) {
 		return true
 	}
@@ -146,32 +158,41 @@ func (m measurementMap) shouldElaborate(dataType string, ts time.Time) bool {
 }
 
 // startFrom returns the earliest (minimum) "last" timestamp among all
-// data‐types if all are present, or 0 if any are missing.
-func (m measurementMap) startFrom(s Station) time.Time {
+// data‐types and periods if all are present, or 0 if any are missing.
+func (m measurementMap) startFrom(s Station, agg *aggregation) time.Time {
+	isCamera := IsCamera(s)
 	// Check presence of every required data‐type
-	for _, dt := range allDataTypes {
-		// neet to exclude camera specific data types from normal stations, othwrwise we will have these stations starting from
-		// min timestamp every time
-		isCamera := IsCamera(s)
-		if !isCamera && (dt == DataTypeEuroPct ||
-			dt == DataTypeEuroPctLight ||
-			// dt == DataTypeEuroPctHeavy ||
-			// dt == DataTypeEuroPctBuses ||
-			dt == DataTypeNationalityCount ||
-			dt == DataTypeNationalityCountLight ||
-			dt == DataTypeNationalityCountHeavy ||
-			dt == DataTypeNationalityCountBuses) {
-			continue
-		}
-		if _, ok := m.LastByDataTypes[dt]; !ok {
-			return time.Time{}
+	for _, p := range agg.periods {
+		for _, dt := range allDataTypes {
+			if !p.has(dt) {
+				continue
+			}
+			// neet to exclude camera specific data types from normal stations, othwrwise we will have these stations starting from
+			// min timestamp every time
+			if !isCamera && (dt == DataTypeEuroPct ||
+				dt == DataTypeEuroPctLight ||
+				// dt == DataTypeEuroPctHeavy ||
+				// dt == DataTypeEuroPctBuses ||
+				dt == DataTypeNationalityCount ||
+				dt == DataTypeNationalityCountLight ||
+				dt == DataTypeNationalityCountHeavy ||
+				dt == DataTypeNationalityCountBuses) {
+				continue
+			}
+			// same for the direction of stations we don't know the direction of
+			if s.Direction() == STATION_DIRECTION_UNKNOWN && (dt == DataTypeDirection || dt == DataTypeDirectionScore) {
+				continue
+			}
+			if _, ok := m.LastByDataTypes[measurementKey{dt, p.period}]; !ok {
+				return time.Time{}
+			}
 		}
 	}
 
 	return m.first
 }
 
-func getMeasurementsByStation(ctx context.Context, oauth *OAuthProvider) (map[string]*measurementMap, error) {
+func getMeasurementsByStation(ctx context.Context, oauth *OAuthProvider, periods []uint64) (map[string]*measurementMap, error) {
 	token, err := oauth.GetToken()
 	if err != nil {
 		return nil, err
@@ -183,21 +204,20 @@ func getMeasurementsByStation(ctx context.Context, oauth *OAuthProvider) (map[st
 	req.StationTypes = append(req.StationTypes, sensorStationType)
 	req.Repr = odhts.FlatNode
 	req.DataTypes = dataTypesFilter
-	req.Where = "sorigin.eq.A22,mperiod.eq.600"
-	req.Select = "mvalidtime,tname,scode"
-	req.Limit = 10000
+	req.Where = fmt.Sprintf("sorigin.eq.A22,mperiod.in.(%s)", joinPeriods(periods))
+	req.Select = "mvalidtime,mperiod,tname,scode"
+	// one record per station, data type and period
+	req.Limit = -1
 
 	res := odhts.Response[[]ninjaResponse]{}
 	if err := odhts.Latest(req, &res); err != nil {
 		return nil, err
 	}
 
-	const layout = "2006-01-02 15:04:05.000-0700"
-
 	measurements := make(map[string]*measurementMap)
 
 	for _, r := range res.Data {
-		t, err := time.Parse(layout, r.Mvalidtime)
+		t, err := time.Parse(ninjaTimeLayout, r.Mvalidtime)
 		if err != nil {
 			logger.Get(ctx).Error("invalid ninja measurement timestamp format", "measurement", r)
 			continue // Skip invalid timestamp
@@ -208,7 +228,7 @@ func getMeasurementsByStation(ctx context.Context, oauth *OAuthProvider) (map[st
 			meas = &measurementMap{
 				first:           t,
 				Last:            t,
-				LastByDataTypes: make(map[string]time.Time),
+				LastByDataTypes: make(map[measurementKey]time.Time),
 			}
 			measurements[r.Scode] = meas
 		} else {
@@ -220,11 +240,76 @@ func getMeasurementsByStation(ctx context.Context, oauth *OAuthProvider) (map[st
 			}
 		}
 
-		// Update latest time per data type
-		if dt, ok := meas.LastByDataTypes[r.Tname]; !ok || t.After(dt) {
-			meas.LastByDataTypes[r.Tname] = t
+		// Update latest time per data type and period
+		key := measurementKey{r.Tname, r.Mperiod}
+		if dt, ok := meas.LastByDataTypes[key]; !ok || t.After(dt) {
+			meas.LastByDataTypes[key] = t
 		}
 	}
 
 	return measurements, nil
 }
+
+const ninjaTimeLayout = "2006-01-02 15:04:05.000-0700"
+
+func joinPeriods(periods []uint64) string {
+	var ret []string
+	for _, p := range periods {
+		ret = append(ret, strconv.FormatUint(p, 10))
+	}
+	return strings.Join(ret, ",")
+}
+
+// measurementSet holds the measurements of a station that already exist in the timeseries
+type measurementSet map[measurementKey]map[int64]bool
+
+func (m measurementSet) shouldElaborate(dataType string, period uint64, ts time.Time) bool {
+	return !m[measurementKey{dataType, period}][ts.UnixMilli()]
+}
+
+// allMeasurements elaborates every measurement, overwriting the existing ones
+type allMeasurements struct{}
+
+func (allMeasurements) shouldElaborate(string, uint64, time.Time) bool {
+	return true
+}
+
+// getMeasurementHistory returns the measurements of a station between from (inclusive) and to (exclusive)
+func getMeasurementHistory(ctx context.Context, oauth *OAuthProvider, stationCode string, periods []uint64, from, to time.Time) (measurementSet, error) {
+	token, err := oauth.GetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	odhts.C.AuthToken = token
+
+	req := odhts.DefaultRequest()
+	req.StationTypes = append(req.StationTypes, sensorStationType)
+	req.Repr = odhts.FlatNode
+	req.DataTypes = dataTypesFilter
+	req.Where = fmt.Sprintf("sorigin.eq.A22,scode.eq.\"%s\",mperiod.in.(%s)", stationCode, joinPeriods(periods))
+	req.Select = "mvalidtime,mperiod,tname,scode"
+	req.From = from
+	req.To = to
+	req.Limit = -1
+
+	res := odhts.Response[[]ninjaResponse]{}
+	if err := odhts.History(req, &res); err != nil {
+		return nil, err
+	}
+
+	set := measurementSet{}
+	for _, r := range res.Data {
+		t, err := time.Parse(ninjaTimeLayout, r.Mvalidtime)
+		if err != nil {
+			logger.Get(ctx).Error("invalid ninja measurement timestamp format", "measurement", r)
+			continue
+		}
+		key := measurementKey{r.Tname, r.Mperiod}
+		if set[key] == nil {
+			set[key] = map[int64]bool{}
+		}
+		set[key][t.UnixMilli()] = true
+	}
+	return set, nil
+}
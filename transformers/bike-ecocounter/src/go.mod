@@ -4,7 +4,8 @@ go 1.24.4
 
 require (
 	github.com/noi-techpark/go-bdp-client v1.4.6
-	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.7
+	github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload v0.0.0
+	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.10-0.20260703092235-5f89829686e4
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
 	github.com/noi-techpark/opendatahub-go-sdk/testsuite v1.1.1
 	github.com/stretchr/testify v1.10.0
@@ -38,6 +39,7 @@ require (
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.7 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/lithammer/shortuuid/v3 v3.0.7 // indirect
@@ -83,3 +85,5 @@ require (
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.2 // indirect
 )
+
+replace github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload => ../../utils/payload
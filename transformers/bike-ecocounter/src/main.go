@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
@@ -33,7 +34,10 @@ const (
 	DataTypeCar        = "nr. vehicles"
 )
 
-var env tr.Env
+var env struct {
+	tr.Env
+	payload.DecoderEnv
+}
 
 func main() {
 	ms.InitWithEnv(context.Background(), "", &env)
@@ -56,9 +60,9 @@ func main() {
 
 	slog.Info("Starting transformer listener...")
 
-	listener := tr.NewTr[string](context.Background(), env)
+	listener := tr.NewTr[any](context.Background(), env.Env)
 	err := listener.Start(context.Background(),
-		tr.RawString2JsonMiddleware[[]EcocounterSite](TransformWithBdp(b)))
+		payload.Middleware(payload.NewDecoderFromEnv(env.DecoderEnv), TransformWithBdp(b)))
 
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
@@ -6,10 +6,12 @@ package main
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
 	"github.com/noi-techpark/go-bdp-client/bdpmock"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/testsuite"
 	"github.com/stretchr/testify/require"
@@ -36,6 +38,23 @@ func TestTransform(t *testing.T) {
 	bdpmock.CompareBdpMockCalls(t, out, req)
 }
 
+func TestTransform_RawString(t *testing.T) {
+	in, err := os.ReadFile("testdata/in.json")
+	require.Nil(t, err)
+
+	var out = bdpmock.BdpMockCalls{}
+	err = testsuite.LoadOutput(&out, "testdata/out.json")
+	require.Nil(t, err)
+
+	b := bdpmock.MockFromEnv(bdplib.BdpEnv{})
+
+	h := payload.Middleware(&payload.Decoder{}, TransformWithBdp(b))
+	err = h(context.TODO(), &rdb.Raw[any]{Rawdata: string(in)})
+	require.Nil(t, err)
+
+	bdpmock.CompareBdpMockCalls(t, out, b.(*bdpmock.BdpMock).Requests())
+}
+
 func TestGetUniqueDirections(t *testing.T) {
 	measurements := []Measurement{
 		{Direction: "in", TravelMode: "bike", FlowName: "test"},
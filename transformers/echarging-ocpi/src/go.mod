@@ -7,6 +7,8 @@ require (
 	github.com/noi-techpark/go-bdp-client v1.2.1
 	github.com/noi-techpark/go-opendatahub-ingest v1.3.1
 	github.com/noi-techpark/go-timeseries-client v0.3.2
+	github.com/noi-techpark/opendatahub-collectors/transformers/utils/charging v0.0.0
+	go.mongodb.org/mongo-driver v1.17.3
 )
 
 require (
@@ -19,8 +21,9 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
-	go.mongodb.org/mongo-driver v1.17.3 // indirect
 	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 )
+
+replace github.com/noi-techpark/opendatahub-collectors/transformers/utils/charging => ../../utils/charging
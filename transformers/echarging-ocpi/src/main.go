@@ -7,7 +7,6 @@ package main
 import (
 	"fmt"
 	"log/slog"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,28 +19,16 @@ import (
 	"github.com/noi-techpark/go-opendatahub-ingest/tr"
 	"github.com/noi-techpark/go-timeseries-client/odhts"
 	"github.com/noi-techpark/go-timeseries-client/where"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/charging"
 )
 
 const locationCacheTTL = time.Hour
 
-const stationTypeLocation = "EChargingStation"
-const stationTypePlug = "EChargingPlug"
 const period = 1
 
-var dtNumberAvailable = bdplib.DataType{
-	Name:        "number-available",
-	Description: "number of available vehicles / charging points",
-	Rtype:       "Instantaneous",
-}
-var dtPlugStatus = bdplib.DataType{
-	Name:        "echarging-plug-status-ocpi",
-	Description: "Current state of echarging plug according to OCPI standard",
-	Rtype:       "Instantaneous",
-}
-
 func syncDataTypes(b bdplib.Bdp) {
-	ms.FailOnError(b.SyncDataTypes(stationTypeLocation, []bdplib.DataType{dtNumberAvailable}), "could not sync data types. aborting...")
-	ms.FailOnError(b.SyncDataTypes(stationTypePlug, []bdplib.DataType{dtPlugStatus}), "could not sync data types. aborting...")
+	ms.FailOnError(b.SyncDataTypes(charging.StationTypeLocation, append([]bdplib.DataType{charging.DtNumberAvailable}, charging.SessionDataTypes()...)), "could not sync data types. aborting...")
+	ms.FailOnError(b.SyncDataTypes(charging.StationTypePlug, []bdplib.DataType{charging.DtPlugStatus, charging.DtPlugStatusOCPI}), "could not sync data types. aborting...")
 }
 
 var cfg struct {
@@ -60,21 +47,23 @@ var cfg struct {
 	MQ_POLL_QUEUE string
 	MQ_POLL_KEY   string
 
+	// for tariffs and charge detail records, coming via OCPI endpoint. Optional, not every CPO pushes them
+	MQ_TARIFF_QUEUE string
+	MQ_TARIFF_KEY   string
+	MQ_CDR_QUEUE    string
+	MQ_CDR_KEY      string
+
+	// charging sessions are summed up per period, which is pushed after the delay to wait for late records
+	SESSION_PERIOD time.Duration `default:"1h"`
+	SESSION_DELAY  time.Duration `default:"1h"`
+	// provider of the raw charge detail records, to reload the sessions of the open periods after a restart
+	CDR_PROVIDER string
+
 	NINJA_URL string
 
 	LOCATION_CACHE_ENABLED bool `default:"false"`
 }
 
-type EVSERaw struct {
-	Params struct {
-		Country_code string
-		Evse_uid     string
-		Location_id  string
-		Party_id     string
-	}
-	Body OCPIEvse
-}
-
 var ninja odhts.C
 
 func setupNinja() {
@@ -84,13 +73,13 @@ func setupNinja() {
 var locDataMu = sync.Mutex{}
 
 type locationPlugCache struct {
-	states    map[string]string
+	states    map[string]charging.Status
 	fetchedAt time.Time
 }
 
 var locationCache = map[string]*locationPlugCache{}
 
-func pushLocationAvailability(b bdplib.Bdp, locationId, plugId, status string, timestamp time.Time) error {
+func pushLocationAvailability(b bdplib.Bdp, locationId, plugId string, status charging.Status, timestamp time.Time) error {
 	locDataMu.Lock()
 	defer locDataMu.Unlock()
 
@@ -100,11 +89,11 @@ func pushLocationAvailability(b bdplib.Bdp, locationId, plugId, status string, t
 	}
 
 	recs := b.CreateDataMap()
-	recs.AddRecord(locationId, dtNumberAvailable.Name, bdplib.CreateRecord(timestamp.UnixMilli(), numAvailable, period))
-	return b.PushData(stationTypeLocation, recs)
+	recs.AddRecord(locationId, charging.DtNumberAvailable.Name, bdplib.CreateRecord(timestamp.UnixMilli(), numAvailable, period))
+	return b.PushData(charging.StationTypeLocation, recs)
 }
 
-func cacheLocationStates(locationId string, states map[string]string) {
+func cacheLocationStates(locationId string, states map[string]charging.Status) {
 	if !cfg.LOCATION_CACHE_ENABLED {
 		return
 	}
@@ -113,7 +102,7 @@ func cacheLocationStates(locationId string, states map[string]string) {
 	locationCache[locationId] = &locationPlugCache{states: states, fetchedAt: time.Now()}
 }
 
-func availableCount(locationId, plugId, status string) (int, error) {
+func availableCount(locationId, plugId string, status charging.Status) (int, error) {
 	if !cfg.LOCATION_CACHE_ENABLED {
 		states, err := fetchPlugStates(locationId)
 		if err != nil {
@@ -140,21 +129,21 @@ func availableCount(locationId, plugId, status string) (int, error) {
 	return countAvailable(entry.states), nil
 }
 
-func countAvailable(states map[string]string) int {
+func countAvailable(states map[string]charging.Status) int {
 	numAvailable := 0
 	for _, s := range states {
-		if s == "AVAILABLE" {
+		if s == charging.StatusAvailable {
 			numAvailable++
 		}
 	}
 	return numAvailable
 }
 
-func fetchPlugStates(locationId string) (map[string]string, error) {
+func fetchPlugStates(locationId string) (map[string]charging.Status, error) {
 	req := odhts.DefaultRequest()
-	req.StationTypes = append(req.StationTypes, stationTypePlug)
+	req.StationTypes = append(req.StationTypes, charging.StationTypePlug)
 	req.Repr = odhts.FlatNode
-	req.DataTypes = append(req.DataTypes, dtPlugStatus.Name)
+	req.DataTypes = append(req.DataTypes, charging.DtPlugStatusOCPI.Name)
 	req.Where = strings.Join([]string{
 		where.Eq("sactive", "true"),
 		where.Eq("pcode", where.Escape(locationId)),
@@ -166,13 +155,56 @@ func fetchPlugStates(locationId string) (map[string]string, error) {
 		return nil, fmt.Errorf("failed requesting sibling plug states: %w", err)
 	}
 
-	states := map[string]string{}
+	states := map[string]charging.Status{}
 	for _, d := range res.Data {
-		states[d.Scode] = d.Mvalue
+		states[d.Scode] = charging.OCPIStatus(d.Mvalue)
 	}
 	return states, nil
 }
 
+var tariffs = charging.NewTariffs()
+
+// the locations of the last poll, to update their plugs when a tariff changes
+var lastLocations []charging.Location
+var lastLocationsMu = sync.Mutex{}
+
+// loadTariffs restores the tariffs attached to the plugs, CPOs only push them when they change
+func loadTariffs(origin string) error {
+	req := odhts.DefaultRequest()
+	req.StationTypes = append(req.StationTypes, charging.StationTypePlug)
+	req.Repr = odhts.FlatNode
+	req.DataTypes = append(req.DataTypes, charging.DtPlugStatusOCPI.Name)
+	req.Where = strings.Join([]string{
+		where.Eq("sactive", "true"),
+		where.Eq("sorigin", where.Escape(origin)),
+	}, ",")
+	req.Select = "scode,smetadata"
+
+	res := odhts.Response[[]struct {
+		Scode     string
+		Smetadata struct{ Tariffs []charging.Tariff }
+	}]{}
+	if err := odhts.Latest(ninja, req, &res); err != nil {
+		return fmt.Errorf("failed requesting plug tariffs: %w", err)
+	}
+	for _, d := range res.Data {
+		for _, t := range d.Smetadata.Tariffs {
+			tariffs.Put(t)
+		}
+	}
+	return nil
+}
+
+func syncTariffs(b bdplib.Bdp, ids ...string) error {
+	lastLocationsMu.Lock()
+	defer lastLocationsMu.Unlock()
+	if !tariffs.Attach(lastLocations, ids...) {
+		return nil
+	}
+	_, plugs := charging.BdpStations(b.GetOrigin(), lastLocations)
+	return b.SyncStations(charging.StationTypePlug, plugs, true, true)
+}
+
 func main() {
 	envconfig.MustProcess("", &cfg)
 	ms.InitLog(cfg.LOG_LEVEL)
@@ -182,6 +214,8 @@ func main() {
 
 	syncDataTypes(b)
 
+	ms.FailOnError(loadTariffs(b.GetOrigin()), "failed loading tariffs")
+
 	rabbit, err := mq.Connect(cfg.MQ_URI, cfg.MQ_CONSUMER)
 	ms.FailOnError(err, "failed connecting to rabbitmq")
 	defer rabbit.Close()
@@ -191,20 +225,23 @@ func main() {
 
 	// Handle push updates, coming via OCPI endpoint
 	go func() {
-		tr.HandleQueue(pushMQ, cfg.MONGO_URI, func(r *dto.Raw[EVSERaw]) error {
-			plugData := b.CreateDataMap()
+		tr.HandleQueue(pushMQ, cfg.MONGO_URI, func(r *dto.Raw[charging.OCPIEVSEUpdate]) error {
+			locationId, evse := charging.FromOCPIUpdate(b.GetOrigin(), r.Rawdata)
+			if evse.Status == "" {
+				slog.Info("Skipping plug update without status", "plugid", evse.ID)
+				return nil
+			}
 
-			plugid := stationId(r.Rawdata.Params.Evse_uid, b.GetOrigin())
-			locationId := stationId(r.Rawdata.Params.Location_id, b.GetOrigin())
-			plugData.AddRecord(plugid, dtPlugStatus.Name, bdplib.CreateRecord(r.Timestamp.UnixMilli(), r.Rawdata.Body.Status, period))
-			if err := b.PushData(stationTypePlug, plugData); err != nil {
+			plugData := b.CreateDataMap()
+			charging.AddPlugStatus(&plugData, evse, charging.DtPlugStatusOCPI.Name, r.Timestamp.UnixMilli(), period)
+			if err := b.PushData(charging.StationTypePlug, plugData); err != nil {
 				return fmt.Errorf("error pushing plug data: %w", err)
 			}
-			slog.Info("Updated plug state", "plugid", plugid)
+			slog.Info("Updated plug state", "plugid", evse.ID)
 
 			// Update parent station "number available data type"
 			go func() {
-				if err := pushLocationAvailability(b, locationId, plugid, r.Rawdata.Body.Status, r.Timestamp); err != nil {
+				if err := pushLocationAvailability(b, locationId, evse.ID, evse.Status, r.Timestamp); err != nil {
 					slog.Error("failed updating location availability", "err", err)
 					return
 				}
@@ -221,86 +258,38 @@ func main() {
 
 	// Handle full station details, coming a few times a day via REST poller
 	go func() {
-		tr.HandleQueue(pullMQ, cfg.MONGO_URI, func(r *dto.Raw[[]OCPILocations]) error {
-			stations := []bdplib.Station{}
+		tr.HandleQueue(pullMQ, cfg.MONGO_URI, func(r *dto.Raw[[]charging.OCPILocation]) error {
+			locations := charging.FromOCPI(b.GetOrigin(), r.Rawdata)
+			lastLocationsMu.Lock()
+			tariffs.Attach(locations)
+			lastLocations = locations
+			lastLocationsMu.Unlock()
+			stations, plugs := charging.BdpStations(b.GetOrigin(), locations)
+
 			locationData := b.CreateDataMap()
-			plugs := []bdplib.Station{}
 			plugData := b.CreateDataMap()
+			charging.AddStatusRecords(&locationData, &plugData, locations, charging.DtPlugStatusOCPI.Name, r.Timestamp.UnixMilli(), period)
 
-			for _, loc := range r.Rawdata {
-				lat, _ := strconv.ParseFloat(loc.Coordinates.Latitude, 64)
-				lon, _ := strconv.ParseFloat(loc.Coordinates.Longitude, 64)
-				station := bdplib.CreateStation(
-					stationId(loc.ID, b.GetOrigin()),
-					loc.Name,
-					stationTypeLocation,
-					lat,
-					lon,
-					b.GetOrigin())
-
-				station.MetaData = map[string]any{
-					"country_code":  loc.CountryCode,
-					"party_id":      loc.PartyID,
-					"address":       loc.Address,
-					"city":          loc.City,
-					"postal_code":   loc.PostalCode,
-					"time_zone":     loc.TimeZone,
-					"opening_times": loc.OpeningTimes,
-					"capacity":      len(loc.Evses),
+			for _, loc := range locations {
+				plugStates := map[string]charging.Status{}
+				for _, evse := range loc.EVSEs {
+					plugStates[evse.ID] = evse.Status
 				}
-				if len(loc.Directions) > 0 {
-					station.MetaData["directions"] = loc.Directions
-				}
-
-				stations = append(stations, station)
-
-				numAvailable := 0
-				plugStates := map[string]string{}
-
-				for _, evse := range loc.Evses {
-					plug := bdplib.CreateStation(
-						stationId(evse.UID, b.GetOrigin()),
-						evse.EvseID,
-						stationTypePlug,
-						station.Latitude,
-						station.Longitude,
-						b.GetOrigin())
-
-					plug.ParentStation = station.Id
-
-					plug.MetaData = map[string]any{}
-
-					if len(evse.Capabilities) > 0 {
-						plug.MetaData["capabilities"] = evse.Capabilities
-					}
-					if len(evse.Capabilities) > 0 {
-						plug.MetaData["connectors"] = evse.Connectors
-					}
-
-					plugs = append(plugs, plug)
-					if evse.Status == "AVAILABLE" {
-						numAvailable++
-					}
-					plugData.AddRecord(plug.Id, dtPlugStatus.Name, bdplib.CreateRecord(r.Timestamp.UnixMilli(), evse.Status, period))
-					plugStates[plug.Id] = evse.Status
-				}
-
-				cacheLocationStates(station.Id, plugStates)
-				locationData.AddRecord(station.Id, dtNumberAvailable.Name, bdplib.CreateRecord(r.Timestamp.UnixMilli(), numAvailable, period))
+				cacheLocationStates(loc.ID, plugStates)
 			}
 
 			// TODO: figure out some way to sync the total set of stations, and identify inactive ones
 			// e.g. all that have not been updated for a month
-			if err := b.SyncStations(stationTypeLocation, stations, true, true); err != nil {
-				return fmt.Errorf("error syncing %s: %w", stationTypeLocation, err)
+			if err := b.SyncStations(charging.StationTypeLocation, stations, true, true); err != nil {
+				return fmt.Errorf("error syncing %s: %w", charging.StationTypeLocation, err)
 			}
-			if err := b.SyncStations(stationTypePlug, plugs, true, true); err != nil {
-				return fmt.Errorf("error syncing %s: %w", stationTypePlug, err)
+			if err := b.SyncStations(charging.StationTypePlug, plugs, true, true); err != nil {
+				return fmt.Errorf("error syncing %s: %w", charging.StationTypePlug, err)
 			}
-			if err := b.PushData(stationTypeLocation, locationData); err != nil {
+			if err := b.PushData(charging.StationTypeLocation, locationData); err != nil {
 				return fmt.Errorf("error pushing location data: %w", err)
 			}
-			if err := b.PushData(stationTypePlug, plugData); err != nil {
+			if err := b.PushData(charging.StationTypePlug, plugData); err != nil {
 				return fmt.Errorf("error pushing plug data: %w", err)
 			}
 
@@ -310,9 +299,66 @@ func main() {
 		panic("Something went horribly wrong. Station handler closed unexpectedly")
 	}()
 
-	select {}
-}
+	if cfg.MQ_TARIFF_QUEUE != "" {
+		tariffMQ, err := rabbit.Consume(cfg.MQ_EXCHANGE, cfg.MQ_TARIFF_QUEUE, cfg.MQ_TARIFF_KEY)
+		ms.FailOnError(err, "failed creating tariff queue")
+
+		// Handle tariff updates, attached to the plugs referencing them
+		go func() {
+			tr.HandleQueue(tariffMQ, cfg.MONGO_URI, func(r *dto.Raw[charging.OCPIPush[charging.OCPITariff]]) error {
+				id := r.Rawdata.Params["tariff_id"]
+				if r.Rawdata.Method == "DELETE" {
+					tariffs.Delete(id)
+				} else {
+					tariff, err := charging.FromOCPITariff(r.Rawdata.Body)
+					if err != nil {
+						slog.Warn("Skipping invalid tariff", "err", err)
+						return nil
+					}
+					if !tariffs.Put(tariff) {
+						slog.Info("Skipping outdated tariff", "tariffid", tariff.ID)
+						return nil
+					}
+					id = tariff.ID
+				}
+				if err := syncTariffs(b, id); err != nil {
+					return fmt.Errorf("error syncing plug tariffs: %w", err)
+				}
+				slog.Info("Updated tariff", "tariffid", id, "method", r.Rawdata.Method)
+				return nil
+			})
+			panic("Something went horribly wrong. Tariff handler closed unexpectedly")
+		}()
+	}
 
-func stationId(id string, origin string) string {
-	return fmt.Sprintf("%s:%s", origin, id)
+	if cfg.MQ_CDR_QUEUE != "" {
+		cdrMQ, err := rabbit.Consume(cfg.MQ_EXCHANGE, cfg.MQ_CDR_QUEUE, cfg.MQ_CDR_KEY)
+		ms.FailOnError(err, "failed creating cdr queue")
+
+		// Sessions of the last periods are kept in memory until pushed, after a restart they are reloaded from the raw data
+		if cfg.CDR_PROVIDER == "" {
+			panic("CDR_PROVIDER is required to reload the sessions after a restart")
+		}
+		sessions := charging.NewSessionAggregator(cfg.SESSION_PERIOD, cfg.SESSION_DELAY)
+		ms.FailOnError(reloadSessions(b.GetOrigin(), sessions), "failed reloading sessions")
+		go pushSessions(b, sessions)
+
+		// Handle charge detail records of completed sessions
+		go func() {
+			tr.HandleQueue(cdrMQ, cfg.MONGO_URI, func(r *dto.Raw[charging.OCPIPush[charging.OCPICDR]]) error {
+				session, err := charging.FromOCPICDR(b.GetOrigin(), r.Rawdata.Body)
+				if err != nil {
+					slog.Warn("Skipping invalid charge detail record", "err", err)
+					return nil
+				}
+				if !sessions.Add(session) {
+					slog.Warn("Skipping charge detail record of an already counted session or pushed period", "cdrid", session.ID, "end", session.End)
+				}
+				return nil
+			})
+			panic("Something went horribly wrong. CDR handler closed unexpectedly")
+		}()
+	}
+
+	select {}
 }
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/charging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reloadSessions adds the sessions of the open periods again after a restart. They are read from the raw charge
+// detail records received since the start of the first open period, which the raw data writer keeps in the
+// database and collection named after the provider. The queue delivers the unacknowledged ones again, they are counted once
+func reloadSessions(origin string, sessions *charging.SessionAggregator) error {
+	from := sessions.Resume(time.Now())
+	db, collection, _ := strings.Cut(cfg.CDR_PROVIDER, "/")
+
+	ctx := context.Background()
+	c, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MONGO_URI))
+	if err != nil {
+		return fmt.Errorf("failed connecting to mongo: %w", err)
+	}
+	defer c.Disconnect(ctx)
+
+	cur, err := c.Database(db).Collection(collection).Find(ctx, bson.M{"timestamp": bson.M{"$gte": from}})
+	if err != nil {
+		return fmt.Errorf("failed querying raw charge detail records: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		var raw struct {
+			Rawdata bson.Raw `bson:"rawdata"`
+		}
+		if err := cur.Decode(&raw); err != nil {
+			return fmt.Errorf("failed decoding raw charge detail record: %w", err)
+		}
+		// the raw data is the JSON document the collector published
+		b, err := bson.MarshalExtJSON(raw.Rawdata, false, false)
+		if err != nil {
+			return fmt.Errorf("failed decoding raw charge detail record: %w", err)
+		}
+		var push charging.OCPIPush[charging.OCPICDR]
+		if err := json.Unmarshal(b, &push); err != nil {
+			slog.Warn("Skipping invalid raw charge detail record", "err", err)
+			continue
+		}
+		session, err := charging.FromOCPICDR(origin, push.Body)
+		if err != nil {
+			continue
+		}
+		if sessions.Add(session) {
+			n++
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("failed reading raw charge detail records: %w", err)
+	}
+	slog.Info("Reloaded sessions of the open periods", "since", from, "sessions", n)
+	return nil
+}
+
+func pushSessions(b bdplib.Bdp, sessions *charging.SessionAggregator) {
+	for range time.Tick(time.Minute) {
+		stats := sessions.Flush(time.Now())
+		if len(stats) == 0 {
+			continue
+		}
+		recs := b.CreateDataMap()
+		charging.AddSessionRecords(&recs, stats, uint64(cfg.SESSION_PERIOD.Seconds()))
+		if err := b.PushData(charging.StationTypeLocation, recs); err != nil {
+			// retried with the next flush
+			sessions.Restore(stats)
+			slog.Error("failed pushing session data", "err", err)
+			continue
+		}
+		slog.Info("Pushed session data", "periods", len(stats))
+	}
+}
@@ -70,7 +70,7 @@ func TestIntegration_EVSEDataStructure(t *testing.T) {
 				t.Errorf("operator %d, record %d (%s): missing GeoCoordinates", opIdx, i, evse.EvseID)
 				continue
 			}
-			if _, _, err := parseGoogleCoords(evse.GeoCoordinates); err != nil {
+			if _, _, err := evse.GeoCoordinates.LatLon(); err != nil {
 				t.Errorf("operator %d, record %d (%s): invalid coordinates: %v", opIdx, i, evse.EvseID, err)
 			}
 		}
@@ -113,7 +113,7 @@ func TestIntegration_CoordinateRanges(t *testing.T) {
 			if evse.GeoCoordinates == nil {
 				continue
 			}
-			if _, _, err := parseGoogleCoords(evse.GeoCoordinates); err != nil {
+			if _, _, err := evse.GeoCoordinates.LatLon(); err != nil {
 				t.Errorf("EvseID %s: failed to parse coords: %v", evse.EvseID, err)
 				continue
 			}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
 	"github.com/noi-techpark/go-bdp-client/bdpmock"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/charging"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -161,7 +162,7 @@ func TestTransform_StatusMeasurements(t *testing.T) {
 	dmStr := string(dmJSON)
 
 	assert.Contains(t, dmStr, "CH*BFE*E1234567", "DataMap should reference station id")
-	assert.Contains(t, dmStr, DataTypeStatus, "DataMap should contain status datatype")
+	assert.Contains(t, dmStr, charging.DtPlugStatusOICP.Name, "DataMap should contain status datatype")
 }
 
 func TestTransform_NumberAvailableMeasurement(t *testing.T) {
@@ -180,7 +181,7 @@ func TestTransform_NumberAvailableMeasurement(t *testing.T) {
 	dmStr := string(dmJSON)
 
 	assert.Contains(t, dmStr, "OP-1:ST-100", "DataMap should reference parent station id")
-	assert.Contains(t, dmStr, dtNumberAvailable.Name, "DataMap should contain number-available datatype")
+	assert.Contains(t, dmStr, charging.DtNumberAvailable.Name, "DataMap should contain number-available datatype")
 	// single EVSE with status "Available" → count = 1
 	assert.Contains(t, dmStr, "1", "available count should be 1")
 }
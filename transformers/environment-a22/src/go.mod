@@ -4,7 +4,8 @@ go 1.24.1
 
 require (
 	github.com/noi-techpark/go-bdp-client v1.4.6
-	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.7
+	github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload v0.0.0
+	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.10-0.20260703092235-5f89829686e4
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
 	github.com/relvacode/iso8601 v1.7.0
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96
@@ -39,6 +40,7 @@ require (
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/lithammer/shortuuid/v3 v3.0.7 // indirect
@@ -86,3 +88,5 @@ require (
 	google.golang.org/grpc v1.78.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
+
+replace github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload => ../../utils/payload
@@ -6,11 +6,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strconv"
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
@@ -26,6 +26,7 @@ const stationtype = "EnvironmentStation"
 var env struct {
 	tr.Env
 	bdplib.BdpEnv
+	payload.DecoderEnv
 }
 
 func main() {
@@ -47,16 +48,13 @@ func main() {
 	}
 	ms.FailOnError(ctx, b.SyncStations(stationtype, bdpStations, true, false), "error syncing stations")
 
-	listener := tr.NewTr[payload](context.Background(), env.Env)
+	listener := tr.NewTr[any](context.Background(), env.Env)
 
-	err = listener.Start(context.Background(), func(ctx context.Context, r *rdb.Raw[payload]) error {
-		payload := mqttPayload{}
-		if err := json.Unmarshal([]byte(r.Rawdata.Payload), &payload); err != nil {
-			return err
-		}
+	err = listener.Start(context.Background(), payload.Middleware(payload.NewDecoderFromEnv(env.DecoderEnv), func(ctx context.Context, r *rdb.Raw[payload.Message[mqttPayload]]) error {
+		m := r.Rawdata.Payload
 
-		sensorid := payload.ControlUnitId
-		ts := payload.DateTimeAcquisition
+		sensorid := m.ControlUnitId
+		ts := m.DateTimeAcquisition
 
 		station, err := currentStation(stations, sensorid, ts.Time)
 		if err != nil {
@@ -65,7 +63,7 @@ func main() {
 
 		dm := b.CreateDataMap()
 
-		for _, v := range payload.Resval {
+		for _, v := range m.Resval {
 			dt, ok := dtmap[strconv.Itoa(v.Id)]
 			if !ok {
 				return fmt.Errorf("error mapping data type %d for sensor %s", v.Id, sensorid)
@@ -77,7 +75,7 @@ func main() {
 			return fmt.Errorf("error pushing data: %w", err)
 		}
 		return nil
-	})
+	}))
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
 
@@ -93,12 +91,6 @@ func readDataTypes(path string) bdpDataTypeMap {
 	return dtm
 }
 
-type payload struct {
-	MsgId   int
-	Topic   string
-	Payload string
-}
-
 type mqttPayload struct {
 	DateTimeAcquisition iso8601.Time
 	ControlUnitId       string
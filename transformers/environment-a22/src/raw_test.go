@@ -5,10 +5,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"testing"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"gotest.tools/v3/assert"
 )
@@ -16,7 +18,17 @@ import (
 func TestRawUnmarshal(t *testing.T) {
 	f, err := os.ReadFile("./testdata/raw_example.json")
 	assert.NilError(t, err)
-	raw := rdb.Raw[payload]{}
+	raw := rdb.Raw[any]{}
 	err = json.Unmarshal(f, &raw)
 	assert.NilError(t, err)
+
+	var m payload.Message[mqttPayload]
+	h := payload.Middleware(nil, func(ctx context.Context, r *rdb.Raw[payload.Message[mqttPayload]]) error {
+		m = r.Rawdata
+		return nil
+	})
+	assert.NilError(t, h(context.TODO(), &raw))
+	assert.Equal(t, m.Topic, "AirQuino/RawData")
+	assert.Equal(t, m.Payload.ControlUnitId, "AIRQ15")
+	assert.Assert(t, len(m.Payload.Resval) > 0)
 }
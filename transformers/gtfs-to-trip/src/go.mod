@@ -3,7 +3,7 @@ module opendatahub.com/tr-gtfs-to-trip
 go 1.25.0
 
 require (
-	github.com/noi-techpark/opendatahub-go-sdk/clib v0.0.1
+	github.com/noi-techpark/opendatahub-go-sdk/clib v0.0.3
 	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.8
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
 	github.com/noi-techpark/opendatahub-go-sdk/testsuite v1.1.1
@@ -97,7 +97,7 @@ func TransformWithClient(client clib.ContentAPI, cfg MapperConfig) tr.CHandler[t
 	}
 }
 
-// Transform downloads GTFS data, maps it to Trip objects, and upserts them.
+// Transform downloads GTFS data, maps it to Trip objects, and upserts the ones that changed.
 func Transform(ctx context.Context, client clib.ContentAPI, cfg MapperConfig, r *transformedMessage) error {
 	logger.Get(ctx).Info("Received GTFS notification", "url", r.Url)
 
@@ -128,15 +128,33 @@ func Transform(ctx context.Context, client clib.ContentAPI, cfg MapperConfig, r
 
 	logger.Get(ctx).Info("Mapped trips", "count", len(trips))
 
+	// an empty feed is more likely broken than really without trips, don't deactivate them all
 	if len(trips) == 0 {
+		logger.Get(ctx).Warn("No trips in feed, skipping")
 		return nil
 	}
 
-	err = client.PutMultiple(ctx, "Trip", trips)
+	// Reload the existing trips for every feed, so the comparison reflects the content API
+	existing, err := loadTrips(ctx, client, cfg.Source)
+	if err != nil {
+		return fmt.Errorf("failed to load existing trips: %w", err)
+	}
+
+	changes, err := DiffTrips(existing, trips, cfg, syncTime)
+	if err != nil {
+		return err
+	}
+	changes.log(ctx)
+
+	if len(changes.Upserts) == 0 {
+		return nil
+	}
+
+	err = client.PutMultiple(ctx, ENTITY_TYPE, changes.Upserts)
 	if err != nil {
 		return fmt.Errorf("failed to upsert trips: %w", err)
 	}
 
-	logger.Get(ctx).Info("Successfully upserted trips", "count", len(trips))
+	logger.Get(ctx).Info("Successfully upserted trips", "count", len(changes.Upserts))
 	return nil
 }
@@ -288,3 +288,77 @@ func Test_Transform_Snapshot(t *testing.T) {
 
 	clibmock.CompareMockCalls(t, expected, calls)
 }
+
+func Test_DiffTrips(t *testing.T) {
+	zr := openTestZip(t)
+	data, err := ParseGtfsFromZip(zr)
+	if err != nil {
+		t.Fatalf("ParseGtfsFromZip failed: %v", err)
+	}
+	tags = loadTestTags(t)
+
+	day1 := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	trips, err := MapGtfsToTrips(data, testCfg, tags, day1)
+	if err != nil {
+		t.Fatalf("MapGtfsToTrips failed: %v", err)
+	}
+
+	// first import: all trips are created
+	existing := clib.NewCache[odhContentModel.Trip]()
+	changes, err := DiffTrips(existing, trips, testCfg, day1)
+	if err != nil {
+		t.Fatalf("DiffTrips failed: %v", err)
+	}
+	if changes.Created != 292 || len(changes.Upserts) != 292 {
+		t.Fatalf("expected 292 created trips, got %+v upserts %d", changes.Created, len(changes.Upserts))
+	}
+	for _, trip := range trips {
+		hash, _, _ := existing.HasChanged(*trip.ID, trip)
+		existing.Set(*trip.ID, trip, hash)
+	}
+
+	// next day: the sync time alone is not a change, one trip changed and one vanished
+	day2 := day1.Add(24 * time.Hour)
+	trips, err = MapGtfsToTrips(data, testCfg, tags, day2)
+	if err != nil {
+		t.Fatalf("MapGtfsToTrips failed: %v", err)
+	}
+	vanished := trips[0]
+	trips = trips[1:]
+	trips[0].Shortname = clib.StringPtr("BQ0000")
+
+	changes, err = DiffTrips(existing, trips, testCfg, day2)
+	if err != nil {
+		t.Fatalf("DiffTrips failed: %v", err)
+	}
+	if changes.Created != 0 || changes.Updated != 1 || changes.Unchanged != 290 || changes.Deactivated != 1 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if len(changes.Upserts) != 2 {
+		t.Fatalf("expected 2 upserts, got %d", len(changes.Upserts))
+	}
+	if *changes.Upserts[0].Shortname != "BQ0000" {
+		t.Errorf("expected the changed trip first, got %v", *changes.Upserts[0].Shortname)
+	}
+	deactivated := changes.Upserts[1]
+	if *deactivated.ID != *vanished.ID || deactivated.Active {
+		t.Errorf("expected trip %s to be deactivated, got %s active %v", *vanished.ID, *deactivated.ID, deactivated.Active)
+	}
+	if got := deactivated.Mapping["skyalps"]["SyncTime"]; got != day2.Format(time.RFC3339) {
+		t.Errorf("expected deactivation sync time %s, got %s", day2.Format(time.RFC3339), got)
+	}
+	// the cached trip is untouched
+	if cached, _ := existing.Get(*vanished.ID); !cached.Entity.Active || cached.Entity.Mapping["skyalps"]["SyncTime"] != day1.Format(time.RFC3339) {
+		t.Error("deactivation modified the cached trip")
+	}
+
+	// an already inactive trip is not deactivated again
+	existing.Set(*deactivated.ID, deactivated, 0)
+	changes, err = DiffTrips(existing, trips, testCfg, day2)
+	if err != nil {
+		t.Fatalf("DiffTrips failed: %v", err)
+	}
+	if changes.Deactivated != 0 {
+		t.Errorf("expected no deactivation, got %d", changes.Deactivated)
+	}
+}
@@ -10,16 +10,18 @@ import (
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 )
 
+// Generic fields maintained by the content API, and the mapping holding the sync time,
+// are ignored when comparing a trip with the one already stored
 type Generic struct {
 	ID          *string                      `json:"Id,omitempty"`
-	Meta        *clib.Metadata               `json:"_Meta,omitempty"`
+	Meta        *clib.Metadata               `json:"_Meta,omitempty" hash:"ignore"`
 	LicenseInfo *clib.LicenseInfo            `json:"LicenseInfo,omitempty"`
 	Shortname   *string                      `json:"Shortname,omitempty"`
 	Active      bool                         `json:"Active"`
-	FirstImport *time.Time                   `json:"FirstImport,omitempty"`
-	LastChange  *time.Time                   `json:"LastChange,omitempty"`
+	FirstImport *time.Time                   `json:"FirstImport,omitempty" hash:"ignore"`
+	LastChange  *time.Time                   `json:"LastChange,omitempty" hash:"ignore"`
 	HasLanguage []string                     `json:"HasLanguage,omitempty"`
-	Mapping     map[string]map[string]string `json:"Mapping,omitempty"`
+	Mapping     map[string]map[string]string `json:"Mapping,omitempty" hash:"ignore"`
 	Source      *string                      `json:"Source,omitempty"`
 	TagIds      []string                     `json:"TagIds,omitempty"`
 }
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/clib"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel/logger"
+	odhContentModel "opendatahub.com/tr-gtfs-to-trip/odh-content-model"
+)
+
+const ENTITY_TYPE = "Trip"
+
+// TripChanges is the difference between a feed and the trips already in the content API
+type TripChanges struct {
+	// Upserts are the created, updated and deactivated trips
+	Upserts     []odhContentModel.Trip
+	Created     int
+	Updated     int
+	Unchanged   int
+	Deactivated int
+}
+
+func tripID(t odhContentModel.Trip) string {
+	if t.ID == nil {
+		return ""
+	}
+	return *t.ID
+}
+
+// loadTrips loads the trips of the source from the content API, active and inactive ones,
+// so that a trip coming back to the feed is reactivated instead of created again
+func loadTrips(ctx context.Context, client clib.ContentAPI, source string) (*clib.Cache[odhContentModel.Trip], error) {
+	return clib.LoadExisting(ctx, client, clib.LoadConfig[odhContentModel.Trip]{
+		EntityType:  ENTITY_TYPE,
+		QueryParams: map[string]string{"source": source},
+		IDFunc:      tripID,
+	})
+}
+
+// DiffTrips compares the trips mapped from a feed with the existing ones by trip id and content hash.
+// Trips that vanished from the feed are deactivated
+func DiffTrips(existing *clib.Cache[odhContentModel.Trip], trips []odhContentModel.Trip, cfg MapperConfig, syncTime time.Time) (TripChanges, error) {
+	var changes TripChanges
+	seen := map[string]struct{}{}
+
+	for _, trip := range trips {
+		id := tripID(trip)
+		seen[id] = struct{}{}
+
+		_, exists := existing.Get(id)
+		_, changed, err := existing.HasChanged(id, trip)
+		if err != nil {
+			return changes, fmt.Errorf("failed to hash trip %s: %w", id, err)
+		}
+		switch {
+		case !exists:
+			changes.Created++
+		case changed:
+			changes.Updated++
+		default:
+			changes.Unchanged++
+			continue
+		}
+		changes.Upserts = append(changes.Upserts, trip)
+	}
+
+	// deactivate in a stable order, the cache is a map
+	ids := make([]string, 0, len(existing.Entries()))
+	for id := range existing.Entries() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		entry, _ := existing.Get(id)
+		if !entry.Entity.Active {
+			continue
+		}
+		trip := entry.Entity
+		trip.Active = false
+		// don't modify the cached mapping
+		trip.Mapping = maps.Clone(trip.Mapping)
+		if trip.Mapping == nil {
+			trip.Mapping = map[string]map[string]string{}
+		}
+		m := maps.Clone(trip.Mapping[cfg.Source])
+		if m == nil {
+			m = map[string]string{}
+		}
+		m["SyncTime"] = syncTime.Format(time.RFC3339)
+		trip.Mapping[cfg.Source] = m
+
+		changes.Deactivated++
+		changes.Upserts = append(changes.Upserts, trip)
+	}
+	return changes, nil
+}
+
+func (c TripChanges) log(ctx context.Context) {
+	logger.Get(ctx).Info("Trip changes",
+		"created", c.Created,
+		"updated", c.Updated,
+		"unchanged", c.Unchanged,
+		"deactivated", c.Deactivated,
+	)
+}
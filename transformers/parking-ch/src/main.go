@@ -13,6 +13,7 @@ import (
 	"strconv"
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
@@ -45,7 +46,10 @@ var measurementFields = map[string]bool{
 	DataTypeCurrentEstimatedOccupancyLevel: true,
 }
 
-var env tr.Env
+var env struct {
+	tr.Env
+	payload.ChunkEnv
+}
 
 func main() {
 	ms.InitWithEnv(context.Background(), "", &env)
@@ -60,9 +64,11 @@ func main() {
 
 	slog.Info("Starting transformer listener...")
 
-	listener := tr.NewTr[string](context.Background(), env)
+	listener := tr.NewTr[string](context.Background(), env.Env)
 
-	err = listener.Start(context.Background(), MultiFormatMiddleware[Root](TransformWithBdp(b)))
+	// large snapshots arrive in chunks
+	chunks := payload.NewReassemblerFromEnv(env.ChunkEnv)
+	err = listener.Start(context.Background(), payload.MultiFormatMiddleware[Root](chunks, TransformWithBdp(b)))
 
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
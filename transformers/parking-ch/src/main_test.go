@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/noi-techpark/go-bdp-client/bdpmock"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,11 +19,11 @@ import (
 
 // runTransformWithEncoding creates a rdb.Raw[Root] from the sample data and runs Transform.
 // Returns the BdpMock so callers can inspect recorded calls.
-func runTransformWithEncoding(t *testing.T, payload string) *bdpmock.BdpMock {
+func runTransformWithEncoding(t *testing.T, encoded string) *bdpmock.BdpMock {
 	t.Helper()
 
-	root, err := DecodePayload[Root](payload)
-	require.NoError(t, err, "DecodePayload failed")
+	root, err := payload.Decode[Root](encoded)
+	require.NoError(t, err, "Decode failed")
 
 	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
 	raw := &rdb.Raw[Root]{
@@ -7,11 +7,15 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"testing"
+	"time"
 
+	"github.com/noi-techpark/go-bdp-client/bdpmock"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -85,112 +89,32 @@ func encodeGzipBase64JSON(t *testing.T, v interface{}) string {
 	return base64.StdEncoding.EncodeToString(buf.Bytes())
 }
 
-func TestPayloadDecode_PlainJSON(t *testing.T) {
-	root := sampleRoot()
-	raw := encodePlainJSON(t, root)
-
-	decoded, err := DecodePayload[Root](raw)
-	require.NoError(t, err)
-	assert.Equal(t, len(root.Features), len(decoded.Features))
-	assert.Equal(t, "bike-1", decoded.Features[0].ID)
-	assert.Equal(t, "car-1", decoded.Features[1].ID)
-}
-
-func TestPayloadDecode_Base64JSON(t *testing.T) {
-	root := sampleRoot()
-	raw := encodeBase64JSON(t, root)
-
-	decoded, err := DecodePayload[Root](raw)
-	require.NoError(t, err)
-	assert.Equal(t, len(root.Features), len(decoded.Features))
-	assert.Equal(t, "bike-1", decoded.Features[0].ID)
-	assert.Equal(t, "car-1", decoded.Features[1].ID)
-}
-
-func TestPayloadDecode_GzipBase64JSON(t *testing.T) {
-	root := sampleRoot()
-	raw := encodeGzipBase64JSON(t, root)
-
-	decoded, err := DecodePayload[Root](raw)
-	require.NoError(t, err)
-	assert.Equal(t, len(root.Features), len(decoded.Features))
-	assert.Equal(t, "bike-1", decoded.Features[0].ID)
-	assert.Equal(t, "car-1", decoded.Features[1].ID)
-}
-
-func TestPayloadDecode_InvalidPayload(t *testing.T) {
-	cases := []struct {
-		name string
-		raw  string
-	}{
-		{"empty string", ""},
-		{"random text", "this is not json or base64"},
-		{"truncated JSON", `{"bike_parking": {`},
-		{"invalid base64", "!!!not-base64!!!"},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			_, err := DecodePayload[Root](tc.raw)
-			require.Error(t, err)
-			assert.Contains(t, err.Error(), "unable to decode payload")
+// TestTransform_Chunked verifies that a snapshot split into chunk envelopes
+// reaches Transform once all chunks arrived, in any order.
+func TestTransform_Chunked(t *testing.T) {
+	encoded := encodeGzipBase64JSON(t, sampleRoot())
+
+	var chunks []string
+	size := len(encoded)/3 + 1
+	for i := 0; i < 3; i++ {
+		b, err := json.Marshal(map[string]any{
+			"message_id":   "snapshot-1",
+			"chunk_index":  i,
+			"total_chunks": 3,
+			"data":         encoded[i*size : min((i+1)*size, len(encoded))],
 		})
-	}
-}
-
-func TestPayloadDecode_ChunkedEnvelopeRejected(t *testing.T) {
-	cases := []struct {
-		name string
-		raw  string
-	}{
-		{"chunk_index field", `{"chunk_index": 0, "total_chunks": 3, "data": "..."}`},
-		{"chunkIndex field", `{"chunkIndex": 1, "totalChunks": 5, "payload": "..."}`},
-		{"total_chunks only", `{"total_chunks": 2, "data": "abc"}`},
-		{"totalChunks only", `{"totalChunks": 4, "payload": "xyz"}`},
+		require.NoError(t, err)
+		chunks = append(chunks, string(b))
 	}
 
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			_, err := DecodePayload[Root](tc.raw)
-			require.Error(t, err)
-			assert.True(t, errors.Is(err, ErrChunkedPayload),
-				"expected ErrChunkedPayload, got: %v", err)
-		})
+	b := bdpmock.MockFromEnv()
+	h := payload.MultiFormatMiddleware(payload.NewReassembler(payload.ChunkConfig{}), TransformWithBdp(b))
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, i := range []int{2, 0} {
+		require.NoError(t, h(context.Background(), &rdb.Raw[string]{Rawdata: chunks[i], Timestamp: ts}))
 	}
-}
-
-func TestPayloadDecode_AllFormatsProduceSameResult(t *testing.T) {
-	root := sampleRoot()
-
-	plain := encodePlainJSON(t, root)
-	b64 := encodeBase64JSON(t, root)
-	gz64 := encodeGzipBase64JSON(t, root)
-
-	dPlain, err := DecodePayload[Root](plain)
-	require.NoError(t, err)
-
-	dB64, err := DecodePayload[Root](b64)
-	require.NoError(t, err)
-
-	dGz64, err := DecodePayload[Root](gz64)
-	require.NoError(t, err)
-
-	// Re-serialize all three results to compare them cleanly
-	// (avoids issues with floating-point map ordering)
-	jsonPlain, _ := json.Marshal(dPlain)
-	jsonB64, _ := json.Marshal(dB64)
-	jsonGz64, _ := json.Marshal(dGz64)
-
-	assert.JSONEq(t, string(jsonPlain), string(jsonB64), "base64 decode differs from plain")
-	assert.JSONEq(t, string(jsonPlain), string(jsonGz64), "gzip+base64 decode differs from plain")
-}
+	assert.Empty(t, b.(*bdpmock.BdpMock).Requests().SyncedStations, "incomplete snapshot must not be transformed")
 
-func TestIsChunkedEnvelope(t *testing.T) {
-	assert.False(t, isChunkedEnvelope(`{"bike_parking": {}}`))
-	assert.False(t, isChunkedEnvelope(`not json at all`))
-	assert.False(t, isChunkedEnvelope(`["array", "not", "object"]`))
-	assert.True(t, isChunkedEnvelope(`{"chunk_index": 0, "data": "..."}`))
-	assert.True(t, isChunkedEnvelope(`{"chunkIndex": 0, "data": "..."}`))
-	assert.True(t, isChunkedEnvelope(`{"total_chunks": 3}`))
-	assert.True(t, isChunkedEnvelope(`{"totalChunks": 3}`))
+	require.NoError(t, h(context.Background(), &rdb.Raw[string]{Rawdata: chunks[1], Timestamp: ts}))
+	assertExpectedBdpCalls(t, b.(*bdpmock.BdpMock).Requests())
 }
@@ -5,10 +5,11 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 )
 
 type SensorPayload struct {
@@ -20,23 +21,6 @@ type SensorPayload struct {
 	}
 }
 
-// Payload is a string containing a JSON
-func (sp *SensorPayload) UnmarshalJSON(data []byte) error {
-	var raw string
-	if err := json.Unmarshal(data, &raw); err != nil {
-		return err
-	}
-
-	type Alias SensorPayload
-	var alias Alias
-	if err := json.Unmarshal([]byte(raw), &alias); err != nil {
-		return err
-	}
-
-	*sp = SensorPayload(alias)
-	return nil
-}
-
 type MaybeTimezoneTime struct {
 	time.Time
 }
@@ -71,8 +55,5 @@ func (ct *MaybeTimezoneTime) UnmarshalJSON(data []byte) error {
 	return fmt.Errorf("unable to parse timestamp: %s", str)
 }
 
-type RawType struct {
-	Topic   string
-	MsgId   int
-	Payload SensorPayload
-}
+// RawType is the MQTT message, its payload is a string containing a JSON
+type RawType = payload.Message[SensorPayload]
@@ -13,6 +13,7 @@ import (
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
 	"github.com/noi-techpark/go-timeseries-client/odhts"
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
@@ -23,6 +24,7 @@ import (
 var env struct {
 	tr.Env
 	bdplib.BdpEnv
+	payload.DecoderEnv
 	CRON_AGGR            string
 	TS_API_BASE_URL      string
 	TS_API_REFERER       string
@@ -73,10 +75,10 @@ func main() {
 		slog.Info("Aggregation job diabled. Set a cron schedule to enable")
 	}
 
-	listener := tr.NewTr[RawType](ctx, env.Env)
+	listener := tr.NewTr[any](ctx, env.Env)
 	recs := b.CreateDataMap()
 	recs_cnt := 0
-	err = listener.Start(ctx, func(ctx context.Context, r *rdb.Raw[RawType]) error {
+	err = listener.Start(ctx, payload.Middleware(payload.NewDecoderFromEnv(env.DecoderEnv), func(ctx context.Context, r *rdb.Raw[RawType]) error {
 		// last part of topic is the sensor ID used to map metadata in csv
 		parts := strings.Split(r.Rawdata.Topic, "/")
 		sensorId := parts[len(parts)-1]
@@ -109,7 +111,7 @@ func main() {
 		}
 
 		return nil
-	})
+	}))
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
 
@@ -5,10 +5,10 @@
 package main
 
 import (
-	"encoding/json"
 	"os"
 	"testing"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/payload"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,8 +21,8 @@ func TestUnmarshal(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Failed to read file: %v", err)
 			}
-			var rawType RawType
-			if err := json.Unmarshal(data, &rawType); err != nil {
+			rawType, err := payload.Unmarshal[RawType](nil, data, payload.ContentTypeJSON)
+			if err != nil {
 				t.Fatalf("Failed to unmarshal JSON: %v", err)
 			}
 			if rawType.Topic == "" {
@@ -0,0 +1,474 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+)
+
+const GbfsVersion = "3.0"
+
+// GbfsEnv configures the GBFS output mode
+type GbfsEnv struct {
+	// directory to write the feed files to
+	GBFS_DIR string
+	// URL the GBFS_DIR is served at, for the feed discovery in gbfs.json
+	GBFS_BASE_URL string
+	// exported area as WKT polygon in WGS84, e.g. POLYGON((10.38 46.22, 12.48 46.22, 12.48 47.09, 10.38 47.09, 10.38 46.22))
+	GBFS_AREA string
+	// system_id of the feed, defaults to the one of sharedmobility.ch
+	GBFS_SYSTEM_ID     string
+	GBFS_CONTACT_EMAIL string
+	GBFS_TIMEZONE      string `default:"Europe/Rome"`
+	GBFS_OPENING_HOURS string `default:"24/7"`
+	GBFS_TTL           int    `default:"300"`
+}
+
+// GbfsExporter writes the stations, vehicles and geofencing zones inside an area as a GBFS 3.0 feed
+type GbfsExporter struct {
+	cfg  GbfsEnv
+	area []ring
+}
+
+func NewGbfsExporter(cfg GbfsEnv) (*GbfsExporter, error) {
+	if cfg.GBFS_DIR == "" || cfg.GBFS_BASE_URL == "" {
+		return nil, fmt.Errorf("gbfs output needs GBFS_DIR and GBFS_BASE_URL")
+	}
+	if cfg.GBFS_CONTACT_EMAIL == "" {
+		return nil, fmt.Errorf("gbfs output needs GBFS_CONTACT_EMAIL")
+	}
+	area, err := parseWKTPolygon(cfg.GBFS_AREA)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GBFS_AREA: %w", err)
+	}
+	return &GbfsExporter{cfg: cfg, area: area}, nil
+}
+
+// gbfsFiles are the feeds of the export, in the order they are written. gbfs.json is written last,
+// so that it never points to feeds not written yet
+var gbfsFiles = []string{"system_information", "station_information", "station_status", "vehicle_status", "geofencing_zones", "gbfs"}
+
+// Export writes the feed files, atomically so that a file server never serves a partial feed
+func (e *GbfsExporter) Export(ctx context.Context, payload *rdb.Raw[Root]) error {
+	feeds := e.Render(payload.Rawdata, payload.Timestamp)
+	for _, name := range gbfsFiles {
+		b, err := json.Marshal(feeds[name])
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", name, err)
+		}
+		if err := writeFile(filepath.Join(e.cfg.GBFS_DIR, name+".json"), b); err != nil {
+			return err
+		}
+	}
+	slog.Info("Exported GBFS feed", "dir", e.cfg.GBFS_DIR)
+	return nil
+}
+
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write gbfs feed: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write gbfs feed: %w", err)
+	}
+	return nil
+}
+
+type gbfsFeed struct {
+	LastUpdated string `json:"last_updated"`
+	TTL         int    `json:"ttl"`
+	Version     string `json:"version"`
+	Data        any    `json:"data"`
+}
+
+type gbfsText struct {
+	Text     string `json:"text"`
+	Language string `json:"language"`
+}
+
+type gbfsDiscovery struct {
+	Feeds []gbfsFeedURL `json:"feeds"`
+}
+
+type gbfsFeedURL struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type gbfsSystemInformation struct {
+	SystemID         string     `json:"system_id"`
+	Languages        []string   `json:"languages"`
+	Name             []gbfsText `json:"name"`
+	Operator         []gbfsText `json:"operator,omitempty"`
+	URL              string     `json:"url,omitempty"`
+	OpeningHours     string     `json:"opening_hours"`
+	FeedContactEmail string     `json:"feed_contact_email"`
+	Timezone         string     `json:"timezone"`
+}
+
+type gbfsStations[T any] struct {
+	Stations []T `json:"stations"`
+}
+
+type gbfsStationInformation struct {
+	StationID string     `json:"station_id"`
+	Name      []gbfsText `json:"name"`
+	Lat       float64    `json:"lat"`
+	Lon       float64    `json:"lon"`
+	Address   string     `json:"address,omitempty"`
+}
+
+type gbfsStationStatus struct {
+	StationID            string `json:"station_id"`
+	NumVehiclesAvailable int    `json:"num_vehicles_available"`
+	NumDocksAvailable    int    `json:"num_docks_available"`
+	IsInstalled          bool   `json:"is_installed"`
+	IsRenting            bool   `json:"is_renting"`
+	IsReturning          bool   `json:"is_returning"`
+	LastReported         string `json:"last_reported"`
+}
+
+type gbfsVehicles struct {
+	Vehicles []gbfsVehicle `json:"vehicles"`
+}
+
+// gbfsVehicle has no vehicle type and pricing plan, vehicle_types and system_pricing_plans are not exported
+type gbfsVehicle struct {
+	VehicleID          string  `json:"vehicle_id"`
+	Lat                float64 `json:"lat"`
+	Lon                float64 `json:"lon"`
+	IsReserved         bool    `json:"is_reserved"`
+	IsDisabled         bool    `json:"is_disabled"`
+	CurrentRangeMeters float64 `json:"current_range_meters,omitempty"`
+}
+
+type gbfsGeofencing struct {
+	GeofencingZones struct {
+		Type     string        `json:"type"`
+		Features []gbfsFeature `json:"features"`
+	} `json:"geofencing_zones"`
+	GlobalRules []gbfsRule `json:"global_rules"`
+}
+
+type gbfsFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string       `json:"type"`
+		Coordinates multiPolygon `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		Name  []gbfsText `json:"name,omitempty"`
+		Rules []gbfsRule `json:"rules"`
+	} `json:"properties"`
+}
+
+// multiPolygon are the GeoJSON MultiPolygon coordinates, lon, lat
+type multiPolygon = [][][][]float64
+
+type gbfsRule struct {
+	RideStartAllowed   bool  `json:"ride_start_allowed"`
+	RideEndAllowed     bool  `json:"ride_end_allowed"`
+	RideThroughAllowed bool  `json:"ride_through_allowed"`
+	MaximumSpeedKph    *int  `json:"maximum_speed_kph,omitempty"`
+	StationParking     *bool `json:"station_parking,omitempty"`
+}
+
+// sourceRule is a geofencing rule as published by the BFE, in GBFS 2.x or 3.0
+type sourceRule struct {
+	RideAllowed        *bool    `json:"ride_allowed"`
+	RideStartAllowed   *bool    `json:"ride_start_allowed"`
+	RideEndAllowed     *bool    `json:"ride_end_allowed"`
+	RideThroughAllowed *bool    `json:"ride_through_allowed"`
+	MaximumSpeedKph    *float64 `json:"maximum_speed_kph"`
+	StationParking     *bool    `json:"station_parking"`
+	// the rule only applies to these vehicle types, vehicle_type_id in GBFS 2.x
+	VehicleTypeID  []string `json:"vehicle_type_id"`
+	VehicleTypeIDs []string `json:"vehicle_type_ids"`
+}
+
+// Render renders the feeds of the data inside the area, by feed name
+func (e *GbfsExporter) Render(root Root, ts time.Time) map[string]gbfsFeed {
+	lastUpdated := ts.UTC().Truncate(time.Second)
+	feed := func(data any) gbfsFeed {
+		return gbfsFeed{LastUpdated: lastUpdated.Format(time.RFC3339), TTL: e.cfg.GBFS_TTL, Version: GbfsVersion, Data: data}
+	}
+
+	lang := root.SystemInformation.Language
+	if lang == "" {
+		lang = "en"
+	}
+	text := func(s string) []gbfsText {
+		return []gbfsText{{Text: s, Language: lang}}
+	}
+
+	sys := gbfsSystemInformation{
+		SystemID:         e.cfg.GBFS_SYSTEM_ID,
+		Languages:        []string{lang},
+		Name:             text(root.SystemInformation.Name),
+		URL:              root.SystemInformation.URL,
+		OpeningHours:     e.cfg.GBFS_OPENING_HOURS,
+		FeedContactEmail: e.cfg.GBFS_CONTACT_EMAIL,
+		Timezone:         e.cfg.GBFS_TIMEZONE,
+	}
+	if sys.SystemID == "" {
+		sys.SystemID = root.SystemInformation.SystemID
+	}
+	if root.SystemInformation.Name == "" {
+		sys.Name = text(sys.SystemID)
+	}
+	if root.SystemInformation.Operator != "" {
+		sys.Operator = text(root.SystemInformation.Operator)
+	}
+
+	info := gbfsStations[gbfsStationInformation]{Stations: []gbfsStationInformation{}}
+	exported := map[string]bool{}
+	for _, s := range root.StationInformation {
+		if !inRings(e.area, s.Lon, s.Lat) {
+			continue
+		}
+		name := s.Name
+		if name == "" {
+			name = s.StationID
+		}
+		info.Stations = append(info.Stations, gbfsStationInformation{StationID: s.StationID, Name: text(name), Lat: s.Lat, Lon: s.Lon, Address: s.Address})
+		exported[s.StationID] = true
+	}
+
+	status := gbfsStations[gbfsStationStatus]{Stations: []gbfsStationStatus{}}
+	for _, s := range root.StationStatus {
+		if !exported[s.StationID] {
+			continue
+		}
+		reported := lastUpdated
+		if s.LastReported > 0 {
+			reported = time.Unix(s.LastReported, 0).UTC()
+		}
+		status.Stations = append(status.Stations, gbfsStationStatus{
+			StationID:            s.StationID,
+			NumVehiclesAvailable: s.NumBikesAvailable,
+			NumDocksAvailable:    s.NumDocksAvailable,
+			IsInstalled:          s.IsInstalled,
+			IsRenting:            s.IsRenting,
+			IsReturning:          s.IsReturning,
+			LastReported:         reported.Format(time.RFC3339),
+		})
+	}
+
+	vehicles := gbfsVehicles{Vehicles: []gbfsVehicle{}}
+	for _, v := range root.FreeBikeStatus {
+		if !inRings(e.area, v.Lon, v.Lat) {
+			continue
+		}
+		vehicles.Vehicles = append(vehicles.Vehicles, gbfsVehicle{
+			VehicleID:          v.BikeID,
+			Lat:                v.Lat,
+			Lon:                v.Lon,
+			IsReserved:         v.IsReserved,
+			IsDisabled:         v.IsDisabled,
+			CurrentRangeMeters: v.CurrentRangeMeters,
+		})
+	}
+
+	zones := gbfsGeofencing{GlobalRules: []gbfsRule{}}
+	zones.GeofencingZones.Type = "FeatureCollection"
+	zones.GeofencingZones.Features = []gbfsFeature{}
+	for _, f := range root.GeofencingZones.Features {
+		polygons, err := toMultiPolygon(f.Geometry)
+		if err != nil {
+			slog.Warn("Skipping geofencing zone with invalid geometry", "name", f.Properties.Name, "err", err)
+			continue
+		}
+		if !e.intersects(polygons) {
+			continue
+		}
+		var zone gbfsFeature
+		zone.Type = "Feature"
+		zone.Geometry.Type = "MultiPolygon"
+		zone.Geometry.Coordinates = polygons
+		if f.Properties.Name != "" {
+			zone.Properties.Name = text(f.Properties.Name)
+		}
+		zone.Properties.Rules = toRules(f.Properties.Rules)
+		if len(zone.Properties.Rules) == 0 && len(f.Properties.Rules) > 0 {
+			// without its rules the zone would allow everything
+			slog.Debug("Skipping geofencing zone with rules for some vehicle types only", "name", f.Properties.Name)
+			continue
+		}
+		zones.GeofencingZones.Features = append(zones.GeofencingZones.Features, zone)
+	}
+
+	discovery := gbfsDiscovery{}
+	for _, name := range gbfsFiles {
+		if name != "gbfs" {
+			discovery.Feeds = append(discovery.Feeds, gbfsFeedURL{Name: name, URL: strings.TrimSuffix(e.cfg.GBFS_BASE_URL, "/") + "/" + name + ".json"})
+		}
+	}
+
+	return map[string]gbfsFeed{
+		"gbfs":                feed(discovery),
+		"system_information":  feed(sys),
+		"station_information": feed(info),
+		"station_status":      feed(status),
+		"vehicle_status":      feed(vehicles),
+		"geofencing_zones":    feed(zones),
+	}
+}
+
+// toMultiPolygon reads a GeoJSON Polygon or MultiPolygon geometry
+func toMultiPolygon(geometry any) (multiPolygon, error) {
+	b, err := json.Marshal(geometry)
+	if err != nil {
+		return nil, err
+	}
+	var g struct {
+		Type        string
+		Coordinates json.RawMessage
+	}
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, err
+	}
+	var ret multiPolygon
+	switch g.Type {
+	case "Polygon":
+		var p [][][]float64
+		err = json.Unmarshal(g.Coordinates, &p)
+		ret = multiPolygon{p}
+	case "MultiPolygon":
+		err = json.Unmarshal(g.Coordinates, &ret)
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	return ret, err
+}
+
+// intersects tells if a zone and the area overlap, by any vertex of one lying inside the other
+func (e *GbfsExporter) intersects(zone multiPolygon) bool {
+	for _, p := range zone {
+		rings := make([]ring, len(p))
+		for i, r := range p {
+			for _, c := range r {
+				if len(c) < 2 {
+					continue
+				}
+				if inRings(e.area, c[0], c[1]) {
+					return true
+				}
+				rings[i] = append(rings[i], [2]float64{c[0], c[1]})
+			}
+		}
+		for _, r := range e.area {
+			for _, c := range r {
+				if inRings(rings, c[0], c[1]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// toRules maps the rules of a zone to GBFS 3.0. Ride start and end fall back to the GBFS 2.x ride_allowed,
+// rules without a value don't restrict. Vehicle types are not exported, so the rules for some vehicle types only are
+// skipped: as rules for all vehicles they would restrict the other types too, and hide the following rules
+func toRules(rules []any) []gbfsRule {
+	ret := []gbfsRule{}
+	for _, r := range rules {
+		b, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		var src sourceRule
+		if err := json.Unmarshal(b, &src); err != nil {
+			slog.Warn("Skipping invalid geofencing rule", "rule", string(b), "err", err)
+			continue
+		}
+		if len(src.VehicleTypeID) > 0 || len(src.VehicleTypeIDs) > 0 {
+			continue
+		}
+		rideAllowed := orTrue(src.RideAllowed)
+		rule := gbfsRule{
+			RideStartAllowed:   rideAllowed,
+			RideEndAllowed:     rideAllowed,
+			RideThroughAllowed: orTrue(src.RideThroughAllowed),
+			StationParking:     src.StationParking,
+		}
+		if src.RideStartAllowed != nil {
+			rule.RideStartAllowed = *src.RideStartAllowed
+		}
+		if src.RideEndAllowed != nil {
+			rule.RideEndAllowed = *src.RideEndAllowed
+		}
+		if src.MaximumSpeedKph != nil {
+			kph := int(math.Round(*src.MaximumSpeedKph))
+			rule.MaximumSpeedKph = &kph
+		}
+		ret = append(ret, rule)
+	}
+	return ret
+}
+
+func orTrue(b *bool) bool {
+	return b == nil || *b
+}
+
+// ring is a closed line of lon, lat coordinates
+type ring [][2]float64
+
+// inRings tells if a point lies inside a polygon, holes included, by the even-odd rule
+func inRings(rings []ring, lon, lat float64) bool {
+	in := false
+	for _, r := range rings {
+		for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+			a, b := r[i], r[j]
+			if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// parseWKTPolygon parses a WKT POLYGON, the first ring is the outline and the others are holes
+func parseWKTPolygon(s string) ([]ring, error) {
+	s = strings.TrimSpace(s)
+	body, ok := strings.CutPrefix(strings.ToUpper(s), "POLYGON")
+	body = strings.TrimSpace(body)
+	if !ok || !strings.HasPrefix(body, "((") || !strings.HasSuffix(body, "))") {
+		return nil, fmt.Errorf("not a WKT POLYGON: %q", s)
+	}
+	var rings []ring
+	for _, rs := range strings.Split(body[2:len(body)-2], "),") {
+		var r ring
+		for _, ps := range strings.Split(strings.Trim(strings.TrimSpace(rs), "()"), ",") {
+			fields := strings.Fields(ps)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid coordinate %q", ps)
+			}
+			lon, errLon := strconv.ParseFloat(fields[0], 64)
+			lat, errLat := strconv.ParseFloat(fields[1], 64)
+			if errLon != nil || errLat != nil {
+				return nil, fmt.Errorf("invalid coordinate %q", ps)
+			}
+			r = append(r, [2]float64{lon, lat})
+		}
+		if len(r) < 3 {
+			return nil, fmt.Errorf("ring with less than 3 coordinates")
+		}
+		rings = append(rings, r)
+	}
+	return rings, nil
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roughly South Tyrol
+const testArea = "POLYGON((10.38 46.22, 12.48 46.22, 12.48 47.09, 10.38 47.09, 10.38 46.22))"
+
+func testGbfsRoot(t *testing.T) Root {
+	root := Root{
+		SystemInformation: SystemInformation{SystemID: "sharedmobility.ch", Language: "de", Name: "sharedmobility.ch", Operator: "BFE", URL: "https://sharedmobility.ch"},
+		StationInformation: []StationInformation{
+			{StationID: "bz:1", Name: "Bahnhof Bozen", Lat: 46.4967, Lon: 11.3580, Address: "Bahnhofsallee 1", ProviderID: "bz"},
+			{StationID: "bz:2", Lat: 46.6713, Lon: 11.1525, ProviderID: "bz"},
+			{StationID: "zh:1", Name: "Zürich HB", Lat: 47.3779, Lon: 8.5403, ProviderID: "zh"},
+		},
+		StationStatus: []StationStatus{
+			{StationID: "bz:1", NumBikesAvailable: 3, NumDocksAvailable: 7, IsInstalled: true, IsRenting: true, IsReturning: true, LastReported: 1772359200},
+			{StationID: "bz:2", NumBikesAvailable: 1, IsInstalled: true},
+			{StationID: "zh:1", NumBikesAvailable: 12},
+		},
+		FreeBikeStatus: []FreeBikeStatus{
+			{BikeID: "v1", Lat: 46.50, Lon: 11.35, CurrentRangeMeters: 12000, VehicleTypeID: "e-scooter"},
+			{BikeID: "v2", Lat: 46.51, Lon: 11.34, IsDisabled: true},
+			{BikeID: "v3", Lat: 47.37, Lon: 8.54},
+		},
+	}
+	zones := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[11.30, 46.45], [11.40, 46.45], [11.40, 46.52], [11.30, 46.52], [11.30, 46.45]]]},
+		 "properties": {"name": "Bozen", "provider_id": "bz", "rules": [
+			{"vehicle_type_id": ["e-scooter"], "ride_allowed": false, "ride_through_allowed": true, "maximum_speed_kph": 10.0},
+			{"ride_allowed": false, "ride_through_allowed": true, "maximum_speed_kph": 20.0}]}},
+		{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[11.14, 46.66], [11.18, 46.66], [11.18, 46.68], [11.14, 46.68], [11.14, 46.66]]]},
+		 "properties": {"name": "Meran", "provider_id": "bz", "rules": [{"vehicle_type_ids": ["e-scooter"], "ride_allowed": false, "ride_through_allowed": false}]}},
+		{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [[[[8.50, 47.35], [8.60, 47.35], [8.60, 47.40], [8.50, 47.35]]]]},
+		 "properties": {"name": "Zürich", "provider_id": "zh", "rules": []}},
+		{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[5.0, 45.0], [15.0, 45.0], [15.0, 48.0], [5.0, 48.0], [5.0, 45.0]]]},
+		 "properties": {"provider_id": "alps", "rules": [{"ride_start_allowed": true, "ride_end_allowed": false, "ride_through_allowed": true, "station_parking": true}]}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [11.35, 46.50]}, "properties": {}}
+	]}`
+	require.NoError(t, json.Unmarshal([]byte(zones), &root.GeofencingZones))
+	return root
+}
+
+func testGbfsExporter(t *testing.T) *GbfsExporter {
+	e, err := NewGbfsExporter(GbfsEnv{
+		GBFS_DIR:           t.TempDir(),
+		GBFS_BASE_URL:      "https://gbfs.example.com/south-tyrol/",
+		GBFS_AREA:          testArea,
+		GBFS_SYSTEM_ID:     "sharedmobility-ch-south-tyrol",
+		GBFS_CONTACT_EMAIL: "help@example.com",
+		GBFS_TIMEZONE:      "Europe/Rome",
+		GBFS_OPENING_HOURS: "24/7",
+		GBFS_TTL:           300,
+	})
+	require.NoError(t, err)
+	return e
+}
+
+func TestGbfsSchemas(t *testing.T) {
+	e := testGbfsExporter(t)
+	payload := &rdb.Raw[Root]{Timestamp: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC), Rawdata: testGbfsRoot(t)}
+	require.NoError(t, e.Export(context.Background(), payload))
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	for _, name := range gbfsFiles {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join("testdata", "gbfs-v3.0", name+".json")
+			if _, err := os.Stat(file); err != nil {
+				t.Fatalf("schema %s is missing, vendor the official schemas with testdata/gbfs-v3.0/getschemas.sh", file)
+			}
+			schema, err := compiler.Compile(file)
+			require.NoError(t, err)
+
+			b, err := os.ReadFile(filepath.Join(e.cfg.GBFS_DIR, name+".json"))
+			require.NoError(t, err)
+			doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+			require.NoError(t, err)
+			assert.NoError(t, schema.Validate(doc))
+		})
+	}
+}
+
+func TestGbfsRender(t *testing.T) {
+	e := testGbfsExporter(t)
+	feeds := e.Render(testGbfsRoot(t), time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, "2026-03-01T10:00:00Z", feeds["gbfs"].LastUpdated)
+	assert.Equal(t, "3.0", feeds["gbfs"].Version)
+	discovery := feeds["gbfs"].Data.(gbfsDiscovery)
+	assert.Contains(t, discovery.Feeds, gbfsFeedURL{Name: "vehicle_status", URL: "https://gbfs.example.com/south-tyrol/vehicle_status.json"})
+
+	sys := feeds["system_information"].Data.(gbfsSystemInformation)
+	assert.Equal(t, "sharedmobility-ch-south-tyrol", sys.SystemID)
+	assert.Equal(t, []gbfsText{{Text: "BFE", Language: "de"}}, sys.Operator)
+
+	info := feeds["station_information"].Data.(gbfsStations[gbfsStationInformation])
+	require.Len(t, info.Stations, 2, "Zürich is outside the area")
+	assert.Equal(t, "bz:2", info.Stations[1].Name[0].Text, "empty name falls back to the id")
+
+	status := feeds["station_status"].Data.(gbfsStations[gbfsStationStatus])
+	require.Len(t, status.Stations, 2)
+	assert.Equal(t, 3, status.Stations[0].NumVehiclesAvailable)
+	assert.Equal(t, "2026-03-01T10:00:00Z", status.Stations[0].LastReported)
+	assert.Equal(t, "2026-03-01T10:00:00Z", status.Stations[1].LastReported, "unreported falls back to last_updated")
+
+	vehicles := feeds["vehicle_status"].Data.(gbfsVehicles)
+	require.Len(t, vehicles.Vehicles, 2)
+	assert.Equal(t, 12000.0, vehicles.Vehicles[0].CurrentRangeMeters)
+	assert.True(t, vehicles.Vehicles[1].IsDisabled)
+
+	zones := feeds["geofencing_zones"].Data.(gbfsGeofencing)
+	require.Len(t, zones.GeofencingZones.Features, 2, "the zones in Zürich and only for e-scooters and the point are skipped, the zone around the area is kept")
+	bz := zones.GeofencingZones.Features[0]
+	assert.Equal(t, "MultiPolygon", bz.Geometry.Type)
+	assert.Len(t, bz.Geometry.Coordinates[0][0], 5)
+	require.Len(t, bz.Properties.Rules, 1, "the e-scooter rule doesn't apply to all vehicles")
+	rule := bz.Properties.Rules[0]
+	assert.False(t, rule.RideStartAllowed, "ride_allowed of GBFS 2.x")
+	assert.False(t, rule.RideEndAllowed)
+	assert.True(t, rule.RideThroughAllowed)
+	assert.Equal(t, 20, *rule.MaximumSpeedKph)
+	alps := zones.GeofencingZones.Features[1]
+	assert.Empty(t, alps.Properties.Name)
+	assert.True(t, alps.Properties.Rules[0].RideStartAllowed)
+	assert.False(t, alps.Properties.Rules[0].RideEndAllowed)
+}
+
+func TestParseWKTPolygon(t *testing.T) {
+	area, err := parseWKTPolygon("polygon ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+	require.NoError(t, err)
+	require.Len(t, area, 2)
+	assert.True(t, inRings(area, 2, 2))
+	assert.False(t, inRings(area, 5, 5), "inside the hole")
+	assert.False(t, inRings(area, 11, 5))
+
+	for _, invalid := range []string{"", "POINT(1 2)", "POLYGON((1 2, 3))", "POLYGON((1 2, 3 4))"} {
+		_, err := parseWKTPolygon(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/noi-techpark/go-bdp-client/bdplib"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
@@ -32,9 +34,20 @@ const (
 	swissLon = 8.2275
 )
 
-func TransformWithBdp(bdp bdplib.Bdp) tr.Handler[Root] {
+// TransformWithOutputs writes to the enabled outputs, bdp or gbfs are nil if disabled
+func TransformWithOutputs(bdp bdplib.Bdp, gbfs *GbfsExporter) tr.Handler[Root] {
 	return func(ctx context.Context, payload *rdb.Raw[Root]) error {
-		return Transform(ctx, bdp, payload)
+		if bdp != nil {
+			if err := Transform(ctx, bdp, payload); err != nil {
+				return err
+			}
+		}
+		if gbfs != nil {
+			if err := gbfs.Export(ctx, payload); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 }
 
@@ -304,20 +317,43 @@ func SyncDataTypes(bdp bdplib.Bdp) error {
 	return bdp.SyncDataTypes(dataTypes)
 }
 
-var env tr.Env
+var env struct {
+	tr.Env
+	// comma separated outputs: bdp writes to the timeseries, gbfs writes a GBFS 3.0 feed of GBFS_AREA to GBFS_DIR
+	OUTPUT string `default:"bdp"`
+	GbfsEnv
+}
 
 func main() {
 	ms.InitWithEnv(context.Background(), "", &env)
-	slog.Info("Starting sharedmobility-ch data transformer...")
+	slog.Info("Starting sharedmobility-ch data transformer...", "output", env.OUTPUT)
 
 	defer tel.FlushOnPanic()
 
-	b := bdplib.FromEnv()
+	outputs := strings.Split(env.OUTPUT, ",")
+	for i := range outputs {
+		outputs[i] = strings.TrimSpace(outputs[i])
+	}
 
-	ms.FailOnError(context.Background(), SyncDataTypes(b), "failed syncing data types")
+	var b bdplib.Bdp
+	if slices.Contains(outputs, "bdp") {
+		b = bdplib.FromEnv()
+		ms.FailOnError(context.Background(), SyncDataTypes(b), "failed syncing data types")
+	}
+
+	var gbfs *GbfsExporter
+	if slices.Contains(outputs, "gbfs") {
+		var err error
+		gbfs, err = NewGbfsExporter(env.GbfsEnv)
+		ms.FailOnError(context.Background(), err, "failed configuring gbfs output")
+	}
+
+	if b == nil && gbfs == nil {
+		ms.FailOnError(context.Background(), fmt.Errorf("no valid output in %q", env.OUTPUT), "failed configuring outputs")
+	}
 
-	listener := tr.NewTr[string](context.Background(), env)
-	err := listener.Start(context.Background(), tr.RawString2JsonMiddleware[Root](TransformWithBdp(b)))
+	listener := tr.NewTr[string](context.Background(), env.Env)
+	err := listener.Start(context.Background(), tr.RawString2JsonMiddleware[Root](TransformWithOutputs(b, gbfs)))
 
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
@@ -0,0 +1,19 @@
+#!/bin/bash
+
+# SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+#
+# SPDX-License-Identifier: CC0-1.0
+
+# Vendors the GBFS v3.0 JSON schemas of MobilityData/gbfs-json-schema (Apache-2.0) into this directory, unmodified.
+# TestGbfsSchemas validates the exported feeds against them, never edit or trim them.
+
+set -e
+cd "$(dirname "$0")"
+
+REF="${REF:-master}"
+BASE_URL="https://raw.githubusercontent.com/MobilityData/gbfs-json-schema/$REF/v3.0"
+
+for FEED in gbfs system_information station_information station_status vehicle_status geofencing_zones; do
+  echo "Downloading: $FEED.json"
+  curl -fsSL -o "$FEED.json" "$BASE_URL/$FEED.json"
+done
@@ -7,15 +7,12 @@ package main
 import (
 	"fmt"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	dto "opendatahub.com/tr-traffic-event-a22-brennerlec/dto"
 	odhContentModel "opendatahub.com/tr-traffic-event-a22-brennerlec/odh-content-model"
 )
 
-func Float64Ptr(f float64) *float64 {
-	return &f
-}
-
 func generateID(event dto.BrennerLECEvent) string {
 	return clib.GenerateID(ID_TEMPLATE, event.Idtratta)
 }
@@ -83,8 +80,8 @@ func MapBrennerLECEventToAnnouncement(tags clib.TagDefs, event dto.BrennerLECEve
 
 	// Default position: Trento Nord - Interporto interchange on A22
 	announcement.Geo["position"] = clib.GpsInfo{
-		Latitude:  Float64Ptr(46.11739),
-		Longitude: Float64Ptr(11.08773),
+		Latitude:  announcements.Float64Ptr(46.11739),
+		Longitude: announcements.Float64Ptr(11.08773),
 		Default:   true,
 		Geometry:  clib.StringPtr("POINT (11.08773 46.11739)"),
 	}
@@ -45,3 +45,13 @@ type Announcement struct {
 	Detail         map[string]*clib.DetailGeneric  `json:"Detail,omitempty"`
 	RelatedContent []*clib.RelatedContent          `json:"RelatedContent,omitempty" hash:"set"`
 }
+
+// The accessors below are used by the announcement sync engine
+
+func (a *Announcement) AnnouncementID() string    { return *a.ID }
+func (a *Announcement) GetStartTime() *time.Time  { return a.StartTime }
+func (a *Announcement) SetStartTime(t *time.Time) { a.StartTime = t }
+func (a *Announcement) GetEndTime() *time.Time    { return a.EndTime }
+func (a *Announcement) SetEndTime(t *time.Time)   { a.EndTime = t }
+func (a *Announcement) GetSyncTime() time.Time    { return a.Mapping.ProviderA22BrennerLEC.SyncTime }
+func (a *Announcement) SetSyncTime(t time.Time)   { a.Mapping.ProviderA22BrennerLEC.SyncTime = t }
@@ -5,6 +5,7 @@ go 1.24.0
 toolchain go1.24.4
 
 require (
+	github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements v0.0.0
 	github.com/noi-techpark/opendatahub-go-sdk/clib v0.0.3
 	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.9
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
@@ -86,3 +87,5 @@ require (
 	google.golang.org/protobuf v1.36.5 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements => ../../utils/announcements
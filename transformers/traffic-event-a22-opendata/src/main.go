@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -32,11 +33,18 @@ var env struct {
 	ODH_CORE_TOKEN_CLIENT_ID     string
 	ODH_CORE_TOKEN_CLIENT_SECRET string
 	ODH_CORE_TOKEN_URL           string
+
+	// how long an announcement has to be missing from the feed before it's closed
+	ANNOUNCEMENT_GRACE_PERIOD time.Duration `default:"0s"`
+	// log the changes instead of writing them to the content API
+	ANNOUNCEMENT_DRY_RUN bool `default:"false"`
+
+	announcements.ExportEnv
 }
 
 var tags clib.TagDefs
-var contentClient clib.ContentAPI
-var annCache *clib.Cache[odhContentModel.Announcement]
+var syncer *announcements.Syncer[odhContentModel.Announcement, *odhContentModel.Announcement]
+var exporter *announcements.Exporter
 var rd *roadData
 var timeNow = time.Now
 
@@ -53,7 +61,7 @@ func main() {
 	rd, err = LoadRoad("../resources/a22_road.json")
 	ms.FailOnError(context.Background(), err, "failed to load road data")
 
-	contentClient, err = clib.NewContentClient(clib.Config{
+	contentClient, err := clib.NewContentClient(clib.Config{
 		BaseURL:      env.ODH_CORE_URL,
 		TokenURL:     env.ODH_CORE_TOKEN_URL,
 		ClientID:     env.ODH_CORE_TOKEN_CLIENT_ID,
@@ -62,23 +70,11 @@ func main() {
 	})
 	ms.FailOnError(context.Background(), err, "failed to create client")
 
-	annCache, err = clib.LoadExisting(context.Background(), contentClient, clib.LoadConfig[odhContentModel.Announcement]{
-		EntityType:  "Announcement",
-		QueryParams: map[string]string{"active": "true", "source": SOURCE, "rawfilter": "isnotnull(Mapping.ProviderA22Open.Id)"},
-		IDFunc:      func(a odhContentModel.Announcement) string { return *a.ID },
-	})
-	ms.FailOnError(context.Background(), err, "failed to load announcements")
+	exporter, err = announcements.NewExporterFromEnv(context.Background(), env.ExportEnv, env.MQ_URI, env.MQ_CLIENT, "a22-opendata")
+	ms.FailOnError(context.Background(), err, "failed to create announcement exporter")
 
-	// Post-filter: remove entries where EndTime <= SyncTime
-	for id, entry := range annCache.Entries() {
-		if entry.Entity.EndTime != nil {
-			syncTime := entry.Entity.Mapping.ProviderA22Open.SyncTime.Truncate(time.Millisecond)
-			endTime := entry.Entity.EndTime.Truncate(time.Millisecond)
-			if endTime.Before(syncTime) || endTime.Equal(syncTime) {
-				annCache.Delete(id)
-			}
-		}
-	}
+	syncer, err = announcements.Load[odhContentModel.Announcement](context.Background(), contentClient, syncConfig())
+	ms.FailOnError(context.Background(), err, "failed to load announcements")
 
 	tags, err = clib.ReadTagDefs("../resources/tags.json")
 	ms.FailOnError(context.Background(), err, "failed to read tags")
@@ -96,73 +92,33 @@ func main() {
 
 type mapperFunc func(*roadData, dto.A22OpendataEvent) (odhContentModel.Announcement, error)
 
+func syncConfig() announcements.Config {
+	return announcements.Config{
+		Source:      SOURCE,
+		Mapping:     "ProviderA22Open",
+		GracePeriod: env.ANNOUNCEMENT_GRACE_PERIOD,
+		// a DataFine from the feed survives close-detection
+		KeepEndTime: true,
+		DryRun:      env.ANNOUNCEMENT_DRY_RUN,
+		Export:      exporter,
+	}
+}
+
 func Transform(ctx context.Context, r *rdb.Raw[dto.Root]) error {
 	logger.Get(ctx).Info("Processing A22 opendata events",
 		"roadworks", len(r.Rawdata.RoadWorks), "traffic", len(r.Rawdata.Traffic))
 
-	sourceTime := r.Timestamp.Truncate(time.Millisecond)
-
-	seen := map[string]struct{}{}
-	var list []odhContentModel.Announcement
-
-	processEvents := func(events []dto.A22OpendataEvent, mapper mapperFunc) {
-		for _, event := range events {
-			id := generateOpendataID(event)
-			existing, exists := annCache.Get(id)
-
+	mapWith := func(mapper mapperFunc) announcements.Mapper[dto.A22OpendataEvent, odhContentModel.Announcement] {
+		return func(event dto.A22OpendataEvent) (odhContentModel.Announcement, error) {
 			ann, err := mapper(rd, event)
 			if err != nil {
-				logger.Get(ctx).Warn("Failed to map event, skipping", "id", event.IDNotizia, "error", err)
-				continue
-			}
-			ann.Mapping.ProviderA22Open.SyncTime = sourceTime
-
-			if exists {
-				ann.StartTime = existing.Entity.StartTime
-			}
-
-			hash, changed, err := annCache.HasChanged(id, ann)
-			if err != nil {
-				logger.Get(ctx).Error("Failed to hash announcement", "error", err)
-				continue
-			}
-
-			if changed {
-				annCache.Set(id, ann, hash)
-				list = append(list, ann)
+				return ann, fmt.Errorf("event %s: %w", event.IDNotizia, err)
 			}
-
-			seen[id] = struct{}{}
-		}
-	}
-
-	processEvents(r.Rawdata.RoadWorks, MapLavoriToAnnouncement)
-	processEvents(r.Rawdata.Traffic, MapTrafficoToAnnouncement)
-
-	// Detect ended events: cached announcements not in current batch
-	for id, entry := range annCache.Entries() {
-		if _, ok := seen[id]; ok {
-			continue
-		}
-
-		ann := entry.Entity
-		if ann.EndTime == nil {
-			ann.EndTime = &sourceTime
+			return ann, nil
 		}
-		ann.Mapping.ProviderA22Open.SyncTime = sourceTime
-		list = append(list, ann)
-		annCache.Delete(id)
 	}
 
-	logger.Get(ctx).Info("Updating changed announcements", "count", len(list))
-
-	if len(list) == 0 {
-		return nil
-	}
-
-	err := contentClient.PutMultiple(ctx, "Announcement", list)
-	if err != nil {
-		return fmt.Errorf("failed to update announcements: %w", err)
-	}
-	return nil
+	anns := announcements.Map(ctx, r.Rawdata.RoadWorks, mapWith(MapLavoriToAnnouncement))
+	anns = append(anns, announcements.Map(ctx, r.Rawdata.Traffic, mapWith(MapTrafficoToAnnouncement))...)
+	return syncer.Sync(ctx, r.Timestamp, anns)
 }
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib/clibmock"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -24,9 +25,8 @@ import (
 // the feed must survive, otherwise every restart rewrites future end dates to
 // "now".
 func Test_CloseDetection_PreservesProviderEndTime(t *testing.T) {
-	contentClient = clibmock.NewContentMock()
-	mock := contentClient.(*clibmock.ContentMock)
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	mock := clibmock.NewContentMock()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	sourceTime := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
 	plannedEnd := time.Date(2026, 10, 2, 18, 0, 0, 0, time.UTC)
@@ -39,8 +39,8 @@ func Test_CloseDetection_PreservesProviderEndTime(t *testing.T) {
 	openEnded.ID = clib.StringPtr("urn:announcements:a22:open")
 	openEnded.Mapping.ProviderA22Open.Id = "open"
 
-	annCache.Set(*planned.ID, planned, 1)
-	annCache.Set(*openEnded.ID, openEnded, 2)
+	syncer.Cache().Set(*planned.ID, planned, 1)
+	syncer.Cache().Set(*openEnded.ID, openEnded, 2)
 
 	// Empty batch: neither event is in the feed any more.
 	r := &rdb.Raw[dto.Root]{Rawdata: dto.Root{}, Timestamp: sourceTime}
@@ -89,9 +89,8 @@ func Test_Transform_Snapshot(t *testing.T) {
 	}
 
 	mock := clibmock.NewContentMock()
-	contentClient = mock
 
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	var root dto.Root
 	err = testsuite.LoadInputData(&root, "testdata/in.json")
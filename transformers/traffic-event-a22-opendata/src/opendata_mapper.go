@@ -8,13 +8,12 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"opendatahub.com/tr-traffic-event-a22-opendata/dto"
 	odhContentModel "opendatahub.com/tr-traffic-event-a22-opendata/odh-content-model"
 )
 
-func Float64Ptr(f float64) *float64 { return &f }
-
 const opendataDateLayout = "02/01/2006 15:04:05"
 
 // sentinelDate is the A22 placeholder for "no end date".
@@ -92,8 +91,8 @@ func mapOpendataBase(rd *roadData, event dto.A22OpendataEvent, tagID string) (od
 	startPoint := rd.interpolatePoint(rd.KmToDistance(event.KmInizio))
 
 	ann.Geo["position"] = clib.GpsInfo{
-		Latitude:  Float64Ptr(startPoint.Lat),
-		Longitude: Float64Ptr(startPoint.Lon),
+		Latitude:  announcements.Float64Ptr(startPoint.Lat),
+		Longitude: announcements.Float64Ptr(startPoint.Lon),
 		Default:   true,
 		Geometry:  clib.StringPtr(wkt),
 	}
@@ -8,15 +8,12 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	dto "opendatahub.com/tr-traffic-event-a22/dto"
 	odhContentModel "opendatahub.com/tr-traffic-event-a22/odh-content-model"
 )
 
-func Float64Ptr(f float64) *float64 {
-	return &f
-}
-
 // A22 event type (idtipoevento) to shared tag ID mapping.
 var eventTypeTagMap = map[int64]string{
 	1:  "traffic-event:accident",
@@ -42,8 +39,8 @@ var directionLabels = map[int64]map[string]string{
 
 // Default position: Trento Nord - Interporto interchange on A22
 var defaultPosition = clib.GpsInfo{
-	Latitude:  Float64Ptr(46.11739),
-	Longitude: Float64Ptr(11.08773),
+	Latitude:  announcements.Float64Ptr(46.11739),
+	Longitude: announcements.Float64Ptr(11.08773),
 	Default:   true,
 	Geometry:  clib.StringPtr("POINT (11.08773 46.11739)"),
 }
@@ -135,16 +132,16 @@ func MapA22EventToAnnouncement(tags clib.TagDefs, event dto.A22Event, id string)
 		if !hasEndCoords || samePoint {
 			// Point event
 			announcement.Geo["position"] = clib.GpsInfo{
-				Latitude:  Float64Ptr(event.LatInizio),
-				Longitude: Float64Ptr(event.LonInizio),
+				Latitude:  announcements.Float64Ptr(event.LatInizio),
+				Longitude: announcements.Float64Ptr(event.LonInizio),
 				Default:   true,
 				Geometry:  clib.StringPtr(fmt.Sprintf("POINT (%f %f)", event.LonInizio, event.LatInizio)),
 			}
 		} else {
 			// Linear event: start point as position, linestring as geometry
 			announcement.Geo["position"] = clib.GpsInfo{
-				Latitude:  Float64Ptr(event.LatInizio),
-				Longitude: Float64Ptr(event.LonInizio),
+				Latitude:  announcements.Float64Ptr(event.LatInizio),
+				Longitude: announcements.Float64Ptr(event.LonInizio),
 				Default:   true,
 				Geometry: clib.StringPtr(fmt.Sprintf("LINESTRING (%f %f, %f %f)",
 					event.LonInizio, event.LatInizio,
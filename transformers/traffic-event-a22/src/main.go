@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -32,11 +33,18 @@ var env struct {
 	ODH_CORE_TOKEN_CLIENT_ID     string
 	ODH_CORE_TOKEN_CLIENT_SECRET string
 	ODH_CORE_TOKEN_URL           string
+
+	// how long an announcement has to be missing from the feed before it's closed
+	ANNOUNCEMENT_GRACE_PERIOD time.Duration `default:"0s"`
+	// log the changes instead of writing them to the content API
+	ANNOUNCEMENT_DRY_RUN bool `default:"false"`
+
+	announcements.ExportEnv
 }
 
 var tags clib.TagDefs
-var contentClient clib.ContentAPI
-var annCache *clib.Cache[odhContentModel.Announcement]
+var syncer *announcements.Syncer[odhContentModel.Announcement, *odhContentModel.Announcement]
+var exporter *announcements.Exporter
 var timeNow = time.Now
 
 func main() {
@@ -49,7 +57,7 @@ func main() {
 
 	var err error
 
-	contentClient, err = clib.NewContentClient(clib.Config{
+	contentClient, err := clib.NewContentClient(clib.Config{
 		BaseURL:      env.ODH_CORE_URL,
 		TokenURL:     env.ODH_CORE_TOKEN_URL,
 		ClientID:     env.ODH_CORE_TOKEN_CLIENT_ID,
@@ -58,23 +66,11 @@ func main() {
 	})
 	ms.FailOnError(context.Background(), err, "failed to create client")
 
-	annCache, err = clib.LoadExisting(context.Background(), contentClient, clib.LoadConfig[odhContentModel.Announcement]{
-		EntityType:  "Announcement",
-		QueryParams: map[string]string{"active": "true", "source": SOURCE, "rawfilter": "isnotnull(Mapping.ProviderA22.Id)"},
-		IDFunc:      func(a odhContentModel.Announcement) string { return *a.ID },
-	})
-	ms.FailOnError(context.Background(), err, "failed to load announcements")
+	exporter, err = announcements.NewExporterFromEnv(context.Background(), env.ExportEnv, env.MQ_URI, env.MQ_CLIENT, "a22")
+	ms.FailOnError(context.Background(), err, "failed to create announcement exporter")
 
-	// Post-filter: remove entries where EndTime <= SyncTime
-	for id, entry := range annCache.Entries() {
-		if entry.Entity.EndTime != nil {
-			syncTime := entry.Entity.Mapping.ProviderA22.SyncTime.Truncate(time.Millisecond)
-			endTime := entry.Entity.EndTime.Truncate(time.Millisecond)
-			if endTime.Before(syncTime) || endTime.Equal(syncTime) {
-				annCache.Delete(id)
-			}
-		}
-	}
+	syncer, err = announcements.Load[odhContentModel.Announcement](context.Background(), contentClient, syncConfig())
+	ms.FailOnError(context.Background(), err, "failed to load announcements")
 
 	tags, err = clib.ReadTagDefs("../resources/tags.json")
 	ms.FailOnError(context.Background(), err, "failed to read tags")
@@ -90,69 +86,25 @@ func main() {
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
 
+func syncConfig() announcements.Config {
+	return announcements.Config{
+		Source:      SOURCE,
+		Mapping:     "ProviderA22",
+		GracePeriod: env.ANNOUNCEMENT_GRACE_PERIOD,
+		DryRun:      env.ANNOUNCEMENT_DRY_RUN,
+		Export:      exporter,
+	}
+}
+
 func Transform(ctx context.Context, r *rdb.Raw[[]dto.A22Event]) error {
 	logger.Get(ctx).Info("Processing A22 events", "count", len(r.Rawdata))
 
-	// content can't handle nanoseconds, truncate to milliseconds
-	sourceTime := r.Timestamp.Truncate(time.Millisecond)
-
-	seen := map[string]struct{}{}
-	list := []odhContentModel.Announcement{}
-
-	for _, event := range r.Rawdata {
-		id := generateID(event)
-		existing, exists := annCache.Get(id)
-
-		ann, err := MapA22EventToAnnouncement(tags, event, id)
-		if err != nil {
-			logger.Get(ctx).Warn("Failed to map A22 event, skipping", "event_id", event.Id, "error", err)
-			continue
-		}
-		ann.Mapping.ProviderA22.SyncTime = sourceTime
-
-		// Preserve existing start time if we've seen this event before
-		if exists {
-			ann.StartTime = existing.Entity.StartTime
-		}
-
-		// Hash to detect changes
-		hash, changed, err := annCache.HasChanged(id, ann)
+	anns := announcements.Map(ctx, r.Rawdata, func(event dto.A22Event) (odhContentModel.Announcement, error) {
+		ann, err := MapA22EventToAnnouncement(tags, event, generateID(event))
 		if err != nil {
-			logger.Get(ctx).Error("Failed to hash announcement", "error", err)
-			continue
-		}
-
-		if changed {
-			annCache.Set(id, ann, hash)
-			list = append(list, ann)
-		}
-
-		seen[id] = struct{}{}
-	}
-
-	// Detect ended events: cached announcements not in current batch
-	for id, entry := range annCache.Entries() {
-		if _, ok := seen[id]; ok {
-			continue
+			return ann, fmt.Errorf("event %d: %w", event.Id, err)
 		}
-
-		// Set end time and add to sync list
-		ann := entry.Entity
-		ann.EndTime = &sourceTime
-		ann.Mapping.ProviderA22.SyncTime = sourceTime
-		list = append(list, ann)
-		annCache.Delete(id)
-	}
-
-	logger.Get(ctx).Info("Updating changed announcements", "count", len(list))
-
-	if len(list) == 0 {
-		return nil
-	}
-
-	err := contentClient.PutMultiple(ctx, "Announcement", list)
-	if err != nil {
-		return fmt.Errorf("failed to update announcements: %w", err)
-	}
-	return nil
+		return ann, nil
+	})
+	return syncer.Sync(ctx, r.Timestamp, anns)
 }
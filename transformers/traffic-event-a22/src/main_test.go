@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib/clibmock"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -30,10 +31,9 @@ func Test_Transform_Snapshot(t *testing.T) {
 
 	// Use mock content client
 	mock := clibmock.NewContentMock()
-	contentClient = mock
 
 	// Empty cache for clean test
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	// Load test data
 	var events []dto.A22Event
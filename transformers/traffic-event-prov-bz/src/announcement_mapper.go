@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	dto "opendatahub.com/tr-traffic-event-prov-bz/dto"
 	odhContentModel "opendatahub.com/tr-traffic-event-prov-bz/odh-content-model"
@@ -35,8 +36,6 @@ func IfNotEmpty(s string) *string {
 	return &s
 }
 
-func Float64Ptr(f float64) *float64 { return &f }
-
 // GenericTrafficEvent holds the mapped (generic) type and subtype tag IDs.
 type GenericTrafficEvent struct {
 	TypeID    string
@@ -182,8 +181,8 @@ func MapTrafficEventToAnnouncement(tags clib.TagDefs, raw dto.TrafficEvent, id s
 	hasPosition := false
 	if raw.X.Valid && raw.Y.Valid && raw.X.Value != 0 && raw.Y.Value != 0 {
 		announcement.Geo["position"] = clib.GpsInfo{
-			Longitude: Float64Ptr(raw.X.Value),
-			Latitude:  Float64Ptr(raw.Y.Value),
+			Longitude: announcements.Float64Ptr(raw.X.Value),
+			Latitude:  announcements.Float64Ptr(raw.Y.Value),
 			Default:   true,
 			Geometry:  clib.StringPtr(fmt.Sprintf("POINT (%f %f)", raw.X.Value, raw.Y.Value)),
 		}
@@ -196,8 +195,8 @@ func MapTrafficEventToAnnouncement(tags clib.TagDefs, raw dto.TrafficEvent, id s
 			Geometry: clib.StringPtr(PROV_BZ_WKT),
 		}
 		announcement.Geo["position"] = clib.GpsInfo{
-			Latitude:  Float64Ptr(BZ_LATITUTE),
-			Longitude: Float64Ptr(BZ_LONGITUTE),
+			Latitude:  announcements.Float64Ptr(BZ_LATITUTE),
+			Longitude: announcements.Float64Ptr(BZ_LONGITUTE),
 			Default:   false,
 			Geometry:  clib.StringPtr(fmt.Sprintf("POINT (%f %f)", BZ_LATITUTE, BZ_LONGITUTE)),
 		}
@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/ms"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -33,11 +34,18 @@ var env struct {
 	ODH_CORE_TOKEN_CLIENT_ID     string
 	ODH_CORE_TOKEN_CLIENT_SECRET string
 	ODH_CORE_TOKEN_URL           string
+
+	// how long an announcement has to be missing from the feed before it's closed
+	ANNOUNCEMENT_GRACE_PERIOD time.Duration `default:"0s"`
+	// log the changes instead of writing them to the content API
+	ANNOUNCEMENT_DRY_RUN bool `default:"false"`
+
+	announcements.ExportEnv
 }
 
 var tags clib.TagDefs
-var contentClient clib.ContentAPI
-var annCache *clib.Cache[odhContentModel.Announcement]
+var syncer *announcements.Syncer[odhContentModel.Announcement, *odhContentModel.Announcement]
+var exporter *announcements.Exporter
 var location *time.Location
 
 func main() {
@@ -52,7 +60,7 @@ func main() {
 	location, err = time.LoadLocation(PROVIDER_TIMEZONE)
 	ms.FailOnError(context.Background(), err, "failed to load timezone")
 
-	contentClient, err = clib.NewContentClient(clib.Config{
+	contentClient, err := clib.NewContentClient(clib.Config{
 		BaseURL:      env.ODH_CORE_URL,
 		TokenURL:     env.ODH_CORE_TOKEN_URL,
 		ClientID:     env.ODH_CORE_TOKEN_CLIENT_ID,
@@ -61,24 +69,11 @@ func main() {
 	})
 	ms.FailOnError(context.Background(), err, "failed to create client")
 
-	annCache, err = clib.LoadExisting(context.Background(), contentClient, clib.LoadConfig[odhContentModel.Announcement]{
-		EntityType:  "Announcement",
-		QueryParams: map[string]string{"active": "true", "source": SOURCE, "rawfilter": "isnotnull(Mapping.ProviderProvinceBz.Id)"},
-		IDFunc:      func(a odhContentModel.Announcement) string { return *a.ID },
-	})
-	ms.FailOnError(context.Background(), err, "failed to load announcements")
+	exporter, err = announcements.NewExporterFromEnv(context.Background(), env.ExportEnv, env.MQ_URI, env.MQ_CLIENT, "prov-bz")
+	ms.FailOnError(context.Background(), err, "failed to create announcement exporter")
 
-	// Post-filter: drop already-ended announcements (EndTime <= SyncTime) so
-	// they are not held in the cache and re-evaluated on every batch.
-	for id, entry := range annCache.Entries() {
-		if entry.Entity.EndTime != nil {
-			syncTime := entry.Entity.Mapping.ProviderProvinceBz.SyncTime.Truncate(time.Millisecond)
-			endTime := entry.Entity.EndTime.Truncate(time.Millisecond)
-			if endTime.Before(syncTime) || endTime.Equal(syncTime) {
-				annCache.Delete(id)
-			}
-		}
-	}
+	syncer, err = announcements.Load[odhContentModel.Announcement](context.Background(), contentClient, syncConfig())
+	ms.FailOnError(context.Background(), err, "failed to load announcements")
 
 	tags, err = clib.ReadTagDefs("../resources/tags.json")
 	ms.FailOnError(context.Background(), err, "failed to read tags")
@@ -94,74 +89,32 @@ func main() {
 	ms.FailOnError(context.Background(), err, "error while listening to queue")
 }
 
-func Transform(ctx context.Context, r *rdb.Raw[[]dto.TrafficEvent]) error {
-	logger.Get(ctx).Info("Processing announcements", "count", len(r.Rawdata))
-
-	// Content can't handle nanoseconds; truncate so SyncTime and EndTime
-	// share the same precision when compared.
-	sourceTime := r.Timestamp.Truncate(time.Millisecond)
-
-	seen := map[string]struct{}{}
-	var list []odhContentModel.Announcement
-
-	for _, a := range r.Rawdata {
-		id := generateID(a)
-		existing, exists := annCache.Get(id)
-
-		ann, err := MapTrafficEventToAnnouncement(tags, a, id)
-		if err != nil {
-			// Skip a single malformed event instead of failing the whole
-			// batch (which would otherwise stall the queue indefinitely).
-			logger.Get(ctx).Warn("Failed to map event, skipping", "messageId", a.MessageID.String(), "error", err)
-			continue
-		}
-		ann.Mapping.ProviderProvinceBz.SyncTime = sourceTime
-
-		// Preserve the original start time once it has been established.
-		if exists {
-			ann.StartTime = existing.Entity.StartTime
-		}
-
-		hash, changed, err := annCache.HasChanged(id, ann)
-		if err != nil {
-			logger.Get(ctx).Error("Failed to hash announcement", "messageId", a.MessageID.String(), "error", err)
-			continue
-		}
-
-		if changed {
-			annCache.Set(id, ann, hash)
-			list = append(list, ann)
-		}
-
-		seen[id] = struct{}{}
-	}
-
-	// Detect ended announcements: cached entries not present in this batch.
-	for id, entry := range annCache.Entries() {
-		if _, ok := seen[id]; ok {
-			continue
-		}
-		ann := entry.Entity
+func syncConfig() announcements.Config {
+	return announcements.Config{
+		Source:      SOURCE,
+		Mapping:     "ProviderProvinceBz",
+		GracePeriod: env.ANNOUNCEMENT_GRACE_PERIOD,
 		// Only close announcements the provider left open-ended. A planned end
 		// date from the feed is the better answer than "the batch that stopped
 		// listing it", and overwriting it rewrites future end dates to now on
 		// every restart.
-		if ann.EndTime == nil {
-			ann.EndTime = &sourceTime
-		}
-		ann.Mapping.ProviderProvinceBz.SyncTime = sourceTime
-		list = append(list, ann)
-		annCache.Delete(id)
+		KeepEndTime: true,
+		DryRun:      env.ANNOUNCEMENT_DRY_RUN,
+		Export:      exporter,
 	}
+}
 
-	logger.Get(ctx).Info("Proceeding updating changed announcements", "count", len(list))
-
-	if len(list) == 0 {
-		return nil
-	}
+func Transform(ctx context.Context, r *rdb.Raw[[]dto.TrafficEvent]) error {
+	logger.Get(ctx).Info("Processing announcements", "count", len(r.Rawdata))
 
-	if err := contentClient.PutMultiple(ctx, "Announcement", list); err != nil {
-		return fmt.Errorf("failed to update announcements: %w", err)
-	}
-	return nil
+	// A single malformed event is skipped instead of failing the whole
+	// batch (which would otherwise stall the queue indefinitely).
+	anns := announcements.Map(ctx, r.Rawdata, func(a dto.TrafficEvent) (odhContentModel.Announcement, error) {
+		ann, err := MapTrafficEventToAnnouncement(tags, a, generateID(a))
+		if err != nil {
+			return ann, fmt.Errorf("messageId %s: %w", a.MessageID.String(), err)
+		}
+		return ann, nil
+	})
+	return syncer.Sync(ctx, r.Timestamp, anns)
 }
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/noi-techpark/opendatahub-collectors/transformers/utils/announcements"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib"
 	"github.com/noi-techpark/opendatahub-go-sdk/clib/clibmock"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
@@ -35,8 +36,7 @@ func Test_Transform_Snapshot(t *testing.T) {
 	setup(t)
 
 	mock := clibmock.NewContentMock()
-	contentClient = mock
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	var in []dto.TrafficEvent
 	require.NoError(t, testsuite.LoadInputData(&in, "testdata/in.json"))
@@ -104,8 +104,7 @@ func Test_CloseDetection_PreservesProviderEndTime(t *testing.T) {
 	setup(t)
 
 	mock := clibmock.NewContentMock()
-	contentClient = mock
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	sourceTime := time.Date(2026, 5, 26, 10, 0, 0, 0, time.UTC)
 	plannedEnd := time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC)
@@ -118,8 +117,8 @@ func Test_CloseDetection_PreservesProviderEndTime(t *testing.T) {
 	openEnded.ID = clib.StringPtr("urn:announcements:provincebz:open")
 	openEnded.Mapping.ProviderProvinceBz.Id = "open"
 
-	annCache.Set(*planned.ID, planned, 1)
-	annCache.Set(*openEnded.ID, openEnded, 2)
+	syncer.Cache().Set(*planned.ID, planned, 1)
+	syncer.Cache().Set(*openEnded.ID, openEnded, 2)
 
 	// Empty batch: neither announcement is in the feed any more.
 	r := &rdb.Raw[[]dto.TrafficEvent]{Rawdata: []dto.TrafficEvent{}, Timestamp: sourceTime}
@@ -154,8 +153,7 @@ func Test_CloseDetection_ClosesOnlyWhatLeftTheFeed(t *testing.T) {
 	setup(t)
 
 	mock := clibmock.NewContentMock()
-	contentClient = mock
-	annCache = clib.NewCache[odhContentModel.Announcement]()
+	syncer = announcements.New[odhContentModel.Announcement](mock, clib.NewCache[odhContentModel.Announcement](), syncConfig())
 
 	var in []dto.TrafficEvent
 	require.NoError(t, testsuite.LoadInputData(&in, "testdata/in.json"))
@@ -167,13 +165,13 @@ func Test_CloseDetection_ClosesOnlyWhatLeftTheFeed(t *testing.T) {
 		id := generateID(e)
 		ann, err := MapTrafficEventToAnnouncement(tags, e, id)
 		require.NoError(t, err)
-		annCache.Set(id, ann, 0)
+		syncer.Cache().Set(id, ann, 0)
 	}
 
 	stale := odhContentModel.Announcement{}
 	stale.ID = clib.StringPtr("urn:announcements:provincebz:stale")
 	stale.Mapping.ProviderProvinceBz.Id = "stale"
-	annCache.Set(*stale.ID, stale, 0)
+	syncer.Cache().Set(*stale.ID, stale, 0)
 
 	sourceTime := time.Date(2026, 5, 26, 10, 0, 0, 0, time.UTC)
 	r := &rdb.Raw[[]dto.TrafficEvent]{Rawdata: in, Timestamp: sourceTime}
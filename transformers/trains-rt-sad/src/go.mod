@@ -3,11 +3,13 @@ module opendatahub.com/tr-trains-rt-sad
 go 1.25.6
 
 require (
+	github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs v1.0.0
 	github.com/jlaffaye/ftp v0.2.0
 	github.com/noi-techpark/go-bdp-client v1.4.6
 	github.com/noi-techpark/go-netex v0.6.2
 	github.com/noi-techpark/opendatahub-go-sdk/ingest v1.0.7
 	github.com/noi-techpark/opendatahub-go-sdk/tel v1.0.0
+	google.golang.org/protobuf v1.36.5
 	gotest.tools/v3 v3.5.2
 )
 
@@ -78,6 +80,5 @@ require (
 	google.golang.org/genproto/googleapis/api v0.0.0-20250218202821-56aae31c358a // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250218202821-56aae31c358a // indirect
 	google.golang.org/grpc v1.71.1 // indirect
-	google.golang.org/protobuf v1.36.5 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
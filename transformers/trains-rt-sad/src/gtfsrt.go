@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/noi-techpark/go-netex"
+	"google.golang.org/protobuf/proto"
+)
+
+const gtfsRtVersion = "2.0"
+
+func newFeedMessage(timestamp uint64) *gtfs.FeedMessage {
+	return &gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{
+			GtfsRealtimeVersion: proto.String(gtfsRtVersion),
+			Incrementality:      gtfs.FeedHeader_FULL_DATASET.Enum(),
+			Timestamp:           proto.Uint64(timestamp),
+		},
+	}
+}
+
+// tripDescriptor references the trip like a GTFS export of the same NeTEx does:
+// the ServiceJourney id is the trip_id and the Line id the route_id
+func tripDescriptor(t matchedTrain) *gtfs.TripDescriptor {
+	return &gtfs.TripDescriptor{
+		TripId:               proto.String(t.journey.Id),
+		RouteId:              proto.String(t.journey.LineRef.Ref),
+		StartDate:            proto.String(t.tripTime.Format("20060102")),
+		StartTime:            proto.String(t.journey.DepartureTime),
+		ScheduleRelationship: gtfs.TripDescriptor_SCHEDULED.Enum(),
+	}
+}
+
+// stopTimeUpdate carries the delay of the whole trip on the first stop of its journey pattern, from where consumers
+// propagate it to all following stops. Like a GTFS export of the NeTEx, the stop is the ScheduledStopPoint and the
+// stop_sequence the order of the point in the pattern, which doesn't need to start at 1
+func stopTimeUpdate(c *Cache, t matchedTrain, n netex.PublicationDelivery) (*gtfs.TripUpdate_StopTimeUpdate, error) {
+	pattern := findJourneyPattern(n, c, t.journey.ServiceJourneyPatternRef.Ref)
+	if pattern == nil {
+		return nil, fmt.Errorf("could not find journey pattern for train %s in static data", t.train)
+	}
+	if pattern.PointsInSequence == nil || len(*pattern.PointsInSequence) == 0 {
+		return nil, fmt.Errorf("journey pattern %s of train %s has no stops", pattern.Id, t.train)
+	}
+	first := (*pattern.PointsInSequence)[0]
+	order, err := strconv.ParseUint(fmt.Sprint(first.Order), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order of the first stop of journey pattern %s: %w", pattern.Id, err)
+	}
+	return &gtfs.TripUpdate_StopTimeUpdate{
+		StopSequence:         proto.Uint32(uint32(order)),
+		StopId:               proto.String(first.ScheduledStopPointRef.Ref),
+		Departure:            &gtfs.TripUpdate_StopTimeEvent{Delay: proto.Int32(int32(delaySeconds(t.delay)))},
+		ScheduleRelationship: gtfs.TripUpdate_StopTimeUpdate_SCHEDULED.Enum(),
+	}, nil
+}
+
+// trains2GtfsRt builds the GTFS-RT VehiclePositions and TripUpdates feeds.
+// Coupled units report the same train, only the first one is published per trip
+func trains2GtfsRt(c *Cache, trains []matchedTrain, n netex.PublicationDelivery, now time.Time) (vehiclePositions *gtfs.FeedMessage, tripUpdates *gtfs.FeedMessage, err error) {
+	vehiclePositions = newFeedMessage(uint64(now.Unix()))
+	tripUpdates = newFeedMessage(uint64(now.Unix()))
+
+	seen := map[string]bool{}
+	for _, t := range trains {
+		trip := tripDescriptor(t)
+		id := trip.GetTripId() + "_" + trip.GetStartDate()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		stopTime, err := stopTimeUpdate(c, t, n)
+		if err != nil {
+			return vehiclePositions, tripUpdates, err
+		}
+
+		// there are no vehicles in the NeTEx, so the train number identifies the vehicle like in SIRI
+		vehicle := &gtfs.VehicleDescriptor{
+			Id:    proto.String(t.train),
+			Label: proto.String(t.train),
+		}
+		ts := proto.Uint64(uint64(t.posTime.Unix()))
+
+		vehiclePositions.Entity = append(vehiclePositions.Entity, &gtfs.FeedEntity{
+			Id: proto.String(id),
+			Vehicle: &gtfs.VehiclePosition{
+				Trip:    trip,
+				Vehicle: vehicle,
+				Position: &gtfs.Position{
+					Latitude:  proto.Float32(float32(t.latitude)),
+					Longitude: proto.Float32(float32(t.longitude)),
+				},
+				Timestamp: ts,
+			},
+		})
+
+		// the source only has a delay for the whole trip
+		tripUpdates.Entity = append(tripUpdates.Entity, &gtfs.FeedEntity{
+			Id: proto.String(id),
+			TripUpdate: &gtfs.TripUpdate{
+				Trip:           trip,
+				Vehicle:        vehicle,
+				StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{stopTime},
+				Timestamp:      ts,
+				Delay:          proto.Int32(int32(delaySeconds(t.delay))),
+			},
+		})
+	}
+	return vehiclePositions, tripUpdates, nil
+}
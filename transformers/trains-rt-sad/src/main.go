@@ -26,6 +26,7 @@ import (
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
 	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
 	"github.com/noi-techpark/opendatahub-go-sdk/tel"
+	"google.golang.org/protobuf/proto"
 )
 
 var env struct {
@@ -104,7 +105,12 @@ func main() {
 			return err
 		}
 
-		s, err := raw2Siri(cache, r.Timestamp, dto, n)
+		trains, err := matchTrains(cache, r.Timestamp, dto, n)
+		if err != nil {
+			return err
+		}
+
+		s, err := trains2Siri(cache, r.Timestamp, trains, n)
 		if err != nil {
 			return err
 		}
@@ -125,23 +131,58 @@ func main() {
 			return err
 		}
 
+		vehiclePositions, tripUpdates, err := trains2GtfsRt(cache, trains, n, time.Now())
+		if err != nil {
+			return err
+		}
+		rtBytes, err := proto.Marshal(vehiclePositions)
+		if err != nil {
+			return err
+		}
+		if err := putFile(env.FILESERVER_HOST, "/gtfs-rt/vehicle-positions/trains/sad.pb", rtBytes); err != nil {
+			return err
+		}
+
+		rtBytes, err = proto.Marshal(tripUpdates)
+		if err != nil {
+			return err
+		}
+		if err := putFile(env.FILESERVER_HOST, "/gtfs-rt/trip-updates/trains/sad.pb", rtBytes); err != nil {
+			return err
+		}
+
 		return nil
 	})
 
 	ms.FailOnError(ctx, err, "error while listening to queue")
 }
 
+// matchedTrain is a live train position matched to its journey in the static NeTEx data
+type matchedTrain struct {
+	train     string
+	posTime   time.Time
+	tripTime  time.Time
+	journey   *netex.ServiceJourney
+	latitude  float64
+	longitude float64
+	// delay in minutes, as the source delivers it
+	delay int
+}
+
 func raw2Siri(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) (Siri, error) {
-	producer := "SAD"
-	respTs := time.Now().Format(time.RFC3339)
-	s := NewSiri()
-	s.ServiceDelivery.ProducerRef = producer
-	s.ServiceDelivery.ResponseTimestamp = respTs
-	s.ServiceDelivery.VehicleMonitoringDelivery.ResponseTimestamp = respTs
+	trains, err := matchTrains(c, refTime, r, n)
+	if err != nil {
+		return NewSiri(), err
+	}
+	return trains2Siri(c, refTime, trains, n)
+}
 
+// matchTrains filters the running trains and matches them to their NeTEx journeys.
+// SIRI and GTFS-RT are both built from the matched trains
+func matchTrains(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) ([]matchedTrain, error) {
 	locItaly, err := time.LoadLocation("Europe/Rome")
 	if err != nil {
-		return s, fmt.Errorf("cannot find Europe/Rome tz data: %w", err)
+		return nil, fmt.Errorf("cannot find Europe/Rome tz data: %w", err)
 	}
 
 	parseTs := func(ts string) time.Time {
@@ -153,6 +194,7 @@ func raw2Siri(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) (
 		return tm
 	}
 
+	trains := []matchedTrain{}
 	for _, upd := range r.Data {
 		// filter out trains which have state different from 3 and state = 3 but with “old” timestamps
 		statusTime := parseTs(upd.Status.Time)
@@ -162,14 +204,10 @@ func raw2Siri(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) (
 			continue
 		}
 
-		va := VehicleActivity{}
 		posTime := parseTs(upd.Position.Time)
 		if refTime.Before(posTime) {
 			slog.Warn("implausible posTime in the future", "Position.Time", upd.Position.Time, "parsed", posTime, "refTime", refTime, "train", train)
 		}
-		va.RecordedAtTime = posTime.Format(time.RFC3339)
-		va.ValidUntilTime = posTime.Add(time.Hour * 24).Format(time.RFC3339)
-		vj := &va.MonitoredVehicleJourney
 
 		tripTime := parseTs(upd.Trip.Time)
 		nJourney := findJourney(n, c, train, tripTime.Format("2006-01-02"))
@@ -177,6 +215,47 @@ func raw2Siri(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) (
 			slog.Warn("could not find journey for train in static data. Ignoring record", "train", train)
 			continue
 		}
+
+		delay := upd.Trip.Delay
+		scheduledStart, err := journeyScheduledStart(nJourney, tripTime, locItaly)
+		if err != nil {
+			return nil, fmt.Errorf("could not determine scheduled start for train %s: %w", train, err)
+		}
+		if delay < 0 && refTime.Before(scheduledStart) {
+			slog.Warn("suppressing negative delay before scheduled journey start", "train", train, "delay", delay, "scheduledStart", scheduledStart)
+			delay = 0
+		}
+
+		trains = append(trains, matchedTrain{
+			train:     train,
+			posTime:   posTime,
+			tripTime:  tripTime,
+			journey:   nJourney,
+			latitude:  upd.Position.Latitude,
+			longitude: upd.Position.Longitude,
+			delay:     delay,
+		})
+	}
+	return trains, nil
+}
+
+func trains2Siri(c *Cache, refTime time.Time, trains []matchedTrain, n netex.PublicationDelivery) (Siri, error) {
+	producer := "SAD"
+	respTs := time.Now().Format(time.RFC3339)
+	s := NewSiri()
+	s.ServiceDelivery.ProducerRef = producer
+	s.ServiceDelivery.ResponseTimestamp = respTs
+	s.ServiceDelivery.VehicleMonitoringDelivery.ResponseTimestamp = respTs
+
+	for _, t := range trains {
+		train := t.train
+		nJourney := t.journey
+
+		va := VehicleActivity{}
+		va.RecordedAtTime = t.posTime.Format(time.RFC3339)
+		va.ValidUntilTime = t.posTime.Add(time.Hour * 24).Format(time.RFC3339)
+		vj := &va.MonitoredVehicleJourney
+
 		vj.LineRef = nJourney.LineRef.Ref
 
 		nJourneyPattern := findJourneyPattern(n, c, nJourney.ServiceJourneyPatternRef.Ref)
@@ -220,18 +299,9 @@ func raw2Siri(c *Cache, refTime time.Time, r Dto, n netex.PublicationDelivery) (
 		vj.ProductCategoryRef = "unknown"
 		vj.Monitored = true
 		vj.InCongestion = false
-		vj.VehicleLocation.Latitude = float32(upd.Position.Latitude)
-		vj.VehicleLocation.Longitude = float32(upd.Position.Longitude)
-		delay := upd.Trip.Delay
-		scheduledStart, err := journeyScheduledStart(nJourney, tripTime, locItaly)
-		if err != nil {
-			return s, fmt.Errorf("could not determine scheduled start for train %s: %w", train, err)
-		}
-		if delay < 0 && refTime.Before(scheduledStart) {
-			slog.Warn("suppressing negative delay before scheduled journey start", "train", train, "delay", delay, "scheduledStart", scheduledStart)
-			delay = 0
-		}
-		vj.Delay = mapDelay(delay)
+		vj.VehicleLocation.Latitude = float32(t.latitude)
+		vj.VehicleLocation.Longitude = float32(t.longitude)
+		vj.Delay = mapDelay(t.delay)
 		vj.VehicleRef = train //TODO: this is not correct, should be a valid ID, but there are no vehicles defined in the reference Netex. Sta does it like this on their other SIRI-VM though
 
 		s.ServiceDelivery.VehicleMonitoringDelivery.VehicleActivity = append(s.ServiceDelivery.VehicleMonitoringDelivery.VehicleActivity, va)
@@ -246,9 +316,14 @@ func journeyScheduledStart(j *netex.ServiceJourney, date time.Time, loc *time.Lo
 	return time.ParseInLocation("2006-01-02 15:04:05", date.Format("2006-01-02")+" "+j.DepartureTime, loc)
 }
 
+// delaySeconds converts the delay of the source, which is in minutes
+func delaySeconds(d int) int {
+	return d * 60
+}
+
 func mapDelay(d int) string {
 	// delay for Siri is in seconds, source is in minutes
-	delay := d * 60
+	delay := delaySeconds(d)
 
 	if delay < 0 {
 		// xml time period format requiest the minus sign to be in front if negative
@@ -11,7 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
 	"github.com/noi-techpark/go-netex"
+	"google.golang.org/protobuf/proto"
 	"gotest.tools/v3/assert"
 )
 
@@ -82,6 +84,88 @@ func Test_validate(t *testing.T) {
 	if out, err := exec.Command("xmllint", "--noout", "--schema", "testdata/SIRI/xsd/siri.xsd", "siri.xml").CombinedOutput(); err != nil {
 		t.Fatalf("xml validation failed:\n %s", out)
 	}
+
+	t.Log("converting to GTFS-RT")
+	trains, err := matchTrains(c, refTime, dto, n)
+	assert.NilError(t, err)
+	vehiclePositions, tripUpdates, err := trains2GtfsRt(c, trains, n, refTime)
+	assert.NilError(t, err)
+	journeys := map[string]bool{}
+	for _, va := range s.ServiceDelivery.VehicleMonitoringDelivery.VehicleActivity {
+		journeys[va.MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef] = true
+	}
+	assert.Assert(t, len(tripUpdates.Entity) > 0)
+	assert.Equal(t, len(vehiclePositions.Entity), len(tripUpdates.Entity))
+	for _, e := range tripUpdates.Entity {
+		assert.Assert(t, journeys[e.TripUpdate.Trip.GetTripId()], "trip %s is not a SIRI journey", e.TripUpdate.Trip.GetTripId())
+		assert.Equal(t, len(e.TripUpdate.StopTimeUpdate), 1)
+	}
+}
+
+func Test_gtfsRt(t *testing.T) {
+	var n netex.PublicationDelivery
+	assert.NilError(t, xml.Unmarshal([]byte(`<PublicationDelivery><dataObjects><CompositeFrame><frames><ServiceFrame>
+		<journeyPatterns><ServiceJourneyPattern id="it:apb:ServiceJourneyPattern:58"><pointsInSequence>
+			<StopPointInJourneyPattern order="3"><ScheduledStopPointRef ref="it:apb:ScheduledStopPoint:bz"/></StopPointInJourneyPattern>
+			<StopPointInJourneyPattern order="4"><ScheduledStopPointRef ref="it:apb:ScheduledStopPoint:me"/></StopPointInJourneyPattern>
+		</pointsInSequence></ServiceJourneyPattern></journeyPatterns>
+	</ServiceFrame></frames></CompositeFrame></dataObjects></PublicationDelivery>`), &n))
+
+	j := &netex.ServiceJourney{Id: "it:apb:ServiceJourney:024002B-Pizzin-100-1-36540:38"}
+	j.LineRef.Ref = "it:apb:Line:B400"
+	j.ServiceJourneyPatternRef.Ref = "it:apb:ServiceJourneyPattern:58"
+	j.DepartureTime = "08:12:00"
+
+	posTime := time.Date(2026, 2, 6, 8, 50, 14, 0, time.UTC)
+	tripTime := time.Date(2026, 2, 6, 8, 47, 57, 0, time.UTC)
+	train := matchedTrain{train: "1853", posTime: posTime, tripTime: tripTime, journey: j, latitude: 46.49, longitude: 11.35, delay: -2}
+	// a coupled unit reports the same train
+	coupled := train
+	coupled.latitude = 46.491
+
+	now := time.Date(2026, 2, 6, 8, 51, 0, 0, time.UTC)
+	vehiclePositions, tripUpdates, err := trains2GtfsRt(NewCache(), []matchedTrain{train, coupled}, n, now)
+	assert.NilError(t, err)
+
+	for _, feed := range []*gtfs.FeedMessage{vehiclePositions, tripUpdates} {
+		assert.Equal(t, feed.Header.GetGtfsRealtimeVersion(), "2.0")
+		assert.Equal(t, feed.Header.GetIncrementality(), gtfs.FeedHeader_FULL_DATASET)
+		assert.Equal(t, feed.Header.GetTimestamp(), uint64(now.Unix()))
+		assert.Equal(t, len(feed.Entity), 1)
+		assert.Equal(t, feed.Entity[0].GetId(), j.Id+"_20260206")
+	}
+
+	vp := vehiclePositions.Entity[0].Vehicle
+	assert.Equal(t, vp.Trip.GetTripId(), j.Id)
+	assert.Equal(t, vp.Trip.GetRouteId(), "it:apb:Line:B400")
+	assert.Equal(t, vp.Trip.GetStartDate(), "20260206")
+	assert.Equal(t, vp.Trip.GetStartTime(), "08:12:00")
+	assert.Equal(t, vp.Vehicle.GetId(), "1853")
+	assert.Equal(t, vp.Position.GetLatitude(), float32(46.49))
+	assert.Equal(t, vp.GetTimestamp(), uint64(posTime.Unix()))
+
+	tu := tripUpdates.Entity[0].TripUpdate
+	assert.Equal(t, tu.Trip.GetTripId(), j.Id)
+	// the source delay is in minutes
+	assert.Equal(t, tu.GetDelay(), int32(-120))
+	// the delay starts at the first stop of the pattern, with its order as stop_sequence
+	assert.Equal(t, len(tu.StopTimeUpdate), 1)
+	assert.Equal(t, tu.StopTimeUpdate[0].GetStopSequence(), uint32(3))
+	assert.Equal(t, tu.StopTimeUpdate[0].GetStopId(), "it:apb:ScheduledStopPoint:bz")
+	assert.Equal(t, tu.StopTimeUpdate[0].Departure.GetDelay(), int32(-120))
+
+	// a journey without pattern in the static data can't be published
+	unknown := train
+	unknown.journey = &netex.ServiceJourney{Id: "it:apb:ServiceJourney:unknown"}
+	_, _, err = trains2GtfsRt(NewCache(), []matchedTrain{unknown}, n, now)
+	assert.ErrorContains(t, err, "could not find journey pattern")
+
+	// the feeds survive a protobuf round trip
+	b, err := proto.Marshal(tripUpdates)
+	assert.NilError(t, err)
+	var decoded gtfs.FeedMessage
+	assert.NilError(t, proto.Unmarshal(b, &decoded))
+	assert.Equal(t, decoded.Entity[0].TripUpdate.GetDelay(), int32(-120))
 }
 
 func Test_download(t *testing.T) {
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	atomNS = "http://www.w3.org/2005/Atom"
+	capNS  = "urn:oasis:names:tc:emergency:cap:1.2"
+
+	// capLayout is the CAP dateTime, which requires a numeric zone offset
+	capLayout = "2006-01-02T15:04:05-07:00"
+	// capCircleRadius around point announcements, in km
+	capCircleRadius = 0.5
+	// capLinePadding around the bounding box of line announcements, in degrees
+	capLinePadding = 0.005
+)
+
+// capSevere tags are published with severity Moderate, all others Minor
+var capSevere = []string{"traffic-event:closure", "traffic-event:accident"}
+
+// renderCAP renders an Atom feed with a CAP 1.2 alert per announcement, with an info block per language
+func renderCAP(cfg ExportConfig, sits []Situation, now time.Time) ([]byte, error) {
+	w := newXMLWriter()
+	w.start("feed", "xmlns", atomNS)
+	w.elem("id", "urn:announcements:"+cfg.Name+":cap")
+	w.elem("title", "Traffic announcements "+cfg.Name)
+	w.elem("updated", now.UTC().Format(time.RFC3339))
+	w.start("author")
+	w.elem("name", cfg.Sender)
+	w.end()
+
+	for _, sit := range sits {
+		title := sit.ID
+		if len(sit.Texts) > 0 {
+			title = sit.Texts[0].Title
+		}
+		w.start("entry")
+		w.elem("id", sit.ID)
+		w.elem("title", title)
+		w.elem("updated", sit.VersionTime.UTC().Format(time.RFC3339))
+		w.start("content", "type", "application/cap+xml")
+		if err := writeCAPAlert(w, cfg, sit, now); err != nil {
+			return nil, fmt.Errorf("announcement %s: %w", sit.ID, err)
+		}
+		w.end()
+		w.end()
+	}
+	w.end()
+	return w.bytes(), nil
+}
+
+func writeCAPAlert(w *xmlWriter, cfg ExportConfig, sit Situation, now time.Time) error {
+	area, err := capArea(sit.Geometry)
+	if err != nil {
+		return err
+	}
+
+	w.start("alert", "xmlns", capNS)
+	w.elem("identifier", sit.ID)
+	w.elem("sender", cfg.Sender)
+	w.elem("sent", capTime(sit.VersionTime))
+	w.elem("status", "Actual")
+	w.elem("msgType", "Alert")
+	w.elem("source", cfg.Name)
+	w.elem("scope", "Public")
+
+	urgency, certainty := "Immediate", "Observed"
+	if sit.StartTime != nil && sit.StartTime.After(now) {
+		urgency, certainty = "Future", "Likely"
+	}
+	severity := "Minor"
+	if slices.ContainsFunc(sit.TagIDs, func(t string) bool { return slices.Contains(capSevere, t) }) {
+		severity = "Moderate"
+	}
+
+	texts := sit.Texts
+	if len(texts) == 0 {
+		texts = []Text{{Lang: "en", Title: sit.ID}}
+	}
+	for _, t := range texts {
+		w.start("info")
+		w.elem("language", t.Lang)
+		w.elem("category", "Transport")
+		w.elem("event", t.Title)
+		w.elem("urgency", urgency)
+		w.elem("severity", severity)
+		w.elem("certainty", certainty)
+		for _, tag := range sit.TagIDs {
+			w.start("eventCode")
+			w.elem("valueName", "tag")
+			w.elem("value", tag)
+			w.end()
+		}
+		if sit.StartTime != nil {
+			w.elem("onset", capTime(*sit.StartTime))
+		}
+		if sit.EndTime != nil {
+			w.elem("expires", capTime(*sit.EndTime))
+		}
+		w.elem("headline", t.Title)
+		if t.Description != "" {
+			w.elem("description", t.Description)
+		}
+		w.start("area")
+		w.elem("areaDesc", t.Title)
+		w.elem(area[0], area[1])
+		w.end()
+		w.end()
+	}
+	w.end()
+	return nil
+}
+
+// capArea returns the area element name and value of a geometry. Lines have no CAP representation, they are
+// published as the padded bounding box
+func capArea(g geometry) ([2]string, error) {
+	switch g.Type {
+	case geometryPoint:
+		return [2]string{"circle", fmt.Sprintf("%s,%s %s", formatCoord(g.Coords[0].Lat), formatCoord(g.Coords[0].Lon), formatCoord(capCircleRadius))}, nil
+	case geometryLineString:
+		minLat, minLon, maxLat, maxLon := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, c := range g.Coords {
+			minLat, maxLat = min(minLat, c.Lat), max(maxLat, c.Lat)
+			minLon, maxLon = min(minLon, c.Lon), max(maxLon, c.Lon)
+		}
+		minLat, minLon, maxLat, maxLon = minLat-capLinePadding, minLon-capLinePadding, maxLat+capLinePadding, maxLon+capLinePadding
+		return [2]string{"polygon", capPolygon([]coordinate{
+			{Lat: minLat, Lon: minLon}, {Lat: minLat, Lon: maxLon}, {Lat: maxLat, Lon: maxLon}, {Lat: maxLat, Lon: minLon}, {Lat: minLat, Lon: minLon},
+		})}, nil
+	case geometryPolygon:
+		return [2]string{"polygon", capPolygon(g.Coords)}, nil
+	}
+	return [2]string{}, fmt.Errorf("unsupported geometry %q", g.Type)
+}
+
+// capPolygon lists the coordinates as lat,lon pairs
+func capPolygon(coords []coordinate) string {
+	var s []string
+	for _, c := range coords {
+		// the padded corners would otherwise print rounding noise
+		s = append(s, formatCoord(math.Round(c.Lat*1e6)/1e6)+","+formatCoord(math.Round(c.Lon*1e6)/1e6))
+	}
+	return strings.Join(s, " ")
+}
+
+func capTime(t time.Time) string {
+	return t.UTC().Format(capLayout)
+}
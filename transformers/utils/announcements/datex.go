@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	datexPayloadNS   = "http://datex2.eu/schema/3/d2Payload"
+	datexCommonNS    = "http://datex2.eu/schema/3/common"
+	datexSituationNS = "http://datex2.eu/schema/3/situation"
+	datexLocationNS  = "http://datex2.eu/schema/3/locationReferencing"
+	xsiNS            = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// datexRecord is the situation record type and its mandatory type element
+type datexRecord struct {
+	xsiType string
+	// elements written after the common situation record elements, name and value pairs
+	elems []string
+}
+
+// datexRecords maps the announcement tags to situation record types, the first matching tag wins
+var datexRecords = []struct {
+	tag    string
+	record datexRecord
+}{
+	{"traffic-event:closure", datexRecord{"sit:RoadOrCarriagewayOrLaneManagement", []string{"sit:complianceOption", "mandatory", "sit:roadOrCarriagewayOrLaneManagementType", "roadClosed"}}},
+	{"traffic-event:accident", datexRecord{"sit:Accident", []string{"sit:accidentType", "accident"}}},
+	{"traffic-event:road-work", datexRecord{"sit:MaintenanceWorks", []string{"sit:roadMaintenanceType", "roadworks"}}},
+	{"traffic-event:maintenance", datexRecord{"sit:MaintenanceWorks", []string{"sit:roadMaintenanceType", "maintenanceWork"}}},
+	{"traffic-event:congestion", datexRecord{"sit:AbnormalTraffic", []string{"sit:abnormalTrafficType", "queuingTraffic"}}},
+	{"traffic-event:speed-limit", datexRecord{"sit:SpeedManagement", []string{"sit:complianceOption", "mandatory", "sit:speedManagementType", "speedRestrictionInOperation"}}},
+	{"traffic-event:animal-on-road", datexRecord{"sit:AnimalPresenceObstruction", []string{"sit:animalPresenceType", "animalsOnTheRoad"}}},
+}
+
+func datexRecordOf(sit Situation) datexRecord {
+	for _, r := range datexRecords {
+		if slices.Contains(sit.TagIDs, r.tag) {
+			return r.record
+		}
+	}
+	// everything else is published with the title as record name
+	name := sit.ID
+	if len(sit.Texts) > 0 {
+		name = sit.Texts[0].Title
+	}
+	return datexRecord{"sit:GenericSituationRecord", []string{"sit:genericSituationRecordName", name}}
+}
+
+// renderDatex2 renders a DATEX II v3 SituationPublication with one situation per announcement
+func renderDatex2(cfg ExportConfig, sits []Situation, now time.Time) ([]byte, error) {
+	w := newXMLWriter()
+	// the texts are multilingual, the publication language is just the default
+	w.start("d2:payload",
+		"xmlns:d2", datexPayloadNS,
+		"xmlns:com", datexCommonNS,
+		"xmlns:sit", datexSituationNS,
+		"xmlns:loc", datexLocationNS,
+		"xmlns:xsi", xsiNS,
+		"xsi:type", "sit:SituationPublication",
+		"lang", "en",
+		"modelBaseVersion", "3")
+	w.elem("com:publicationTime", datexTime(now))
+	w.start("com:publicationCreator")
+	w.elem("com:country", cfg.Country)
+	w.elem("com:nationalIdentifier", cfg.NationalIdentifier)
+	w.end()
+
+	for _, sit := range sits {
+		version := strconv.FormatInt(sit.VersionTime.Unix(), 10)
+		w.start("sit:situation", "id", sit.ID, "version", version)
+		w.start("sit:headerInformation")
+		w.elem("com:confidentiality", "noRestriction")
+		w.elem("com:informationStatus", "real")
+		w.end()
+
+		record := datexRecordOf(sit)
+		w.start("sit:situationRecord", "xsi:type", record.xsiType, "id", sit.ID+"_1", "version", version)
+		// the record can't be created after it started
+		created := sit.VersionTime
+		if sit.StartTime != nil && sit.StartTime.Before(created) {
+			created = *sit.StartTime
+		}
+		w.elem("sit:situationRecordCreationTime", datexTime(created))
+		w.elem("sit:situationRecordVersionTime", datexTime(sit.VersionTime))
+		w.elem("sit:probabilityOfOccurrence", "certain")
+
+		w.start("sit:validity")
+		w.elem("com:validityStatus", "definedByValidityTimeSpec")
+		w.start("com:validityTimeSpecification")
+		start := created
+		if sit.StartTime != nil {
+			start = *sit.StartTime
+		}
+		w.elem("com:overallStartTime", datexTime(start))
+		if sit.EndTime != nil {
+			w.elem("com:overallEndTime", datexTime(*sit.EndTime))
+		}
+		w.end()
+		w.end()
+
+		if len(sit.Texts) > 0 {
+			w.start("sit:generalPublicComment")
+			w.start("sit:comment")
+			w.start("com:values")
+			for _, t := range sit.Texts {
+				text := t.Title
+				if t.Description != "" {
+					text += ": " + t.Description
+				}
+				w.elem("com:value", text, "lang", t.Lang)
+			}
+			w.end()
+			w.end()
+			w.end()
+		}
+
+		if err := writeDatexLocation(w, sit.Geometry); err != nil {
+			return nil, fmt.Errorf("announcement %s: %w", sit.ID, err)
+		}
+
+		for i := 0; i+1 < len(record.elems); i += 2 {
+			w.elem(record.elems[i], record.elems[i+1])
+		}
+		w.end()
+		w.end()
+	}
+	w.end()
+	return w.bytes(), nil
+}
+
+func writeDatexLocation(w *xmlWriter, g geometry) error {
+	switch g.Type {
+	case geometryPoint:
+		w.start("sit:locationReference", "xsi:type", "loc:PointLocation")
+		w.start("loc:pointByCoordinates")
+		w.start("loc:pointCoordinates")
+		w.elem("loc:latitude", formatCoord(g.Coords[0].Lat))
+		w.elem("loc:longitude", formatCoord(g.Coords[0].Lon))
+		w.end()
+		w.end()
+		w.end()
+	case geometryLineString:
+		w.start("sit:locationReference", "xsi:type", "loc:LinearLocation")
+		w.start("loc:gmlLineString", "srsName", "EPSG:4326")
+		w.elem("loc:posList", datexPosList(g.Coords))
+		w.end()
+		w.end()
+	case geometryPolygon:
+		w.start("sit:locationReference", "xsi:type", "loc:AreaLocation")
+		w.start("loc:gmlMultiPolygon", "srsName", "EPSG:4326")
+		w.start("loc:gmlPolygon")
+		w.start("loc:exterior")
+		w.elem("loc:posList", datexPosList(g.Coords))
+		w.end()
+		w.end()
+		w.end()
+		w.end()
+	default:
+		return fmt.Errorf("unsupported geometry %q", g.Type)
+	}
+	return nil
+}
+
+// datexPosList lists the coordinates in the lat lon order of EPSG:4326
+func datexPosList(coords []coordinate) string {
+	var s []string
+	for _, c := range coords {
+		s = append(s, formatCoord(c.Lat), formatCoord(c.Lon))
+	}
+	return strings.Join(s, " ")
+}
+
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func datexTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
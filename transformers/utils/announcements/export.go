@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/noi-techpark/opendatahub-go-sdk/qmill"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel/logger"
+)
+
+type Format string
+
+const (
+	// FormatDatex2 is a DATEX II v3 SituationPublication
+	FormatDatex2 Format = "datex2"
+	// FormatCAP is an Atom feed of CAP 1.2 alerts, one per announcement
+	FormatCAP Format = "cap"
+)
+
+// Publisher publishes the exports on a message exchange, it's implemented by the qmill publisher
+type Publisher interface {
+	Publish(ctx context.Context, payload []byte, routingKey string) error
+}
+
+type ExportConfig struct {
+	Formats []Format
+	// Dir writes each format to <Dir>/<Name>-<format>.xml. Empty doesn't write files
+	Dir string
+	// Publisher publishes each format with routing key <RoutingKey>.<format>. Nil doesn't publish
+	Publisher  Publisher
+	RoutingKey string
+
+	// Name of the export, used for the file names and as CAP source
+	Name string
+	// Country and NationalIdentifier identify the publication creator in DATEX II
+	Country            string
+	NationalIdentifier string
+	// Sender of the CAP alerts, e.g. a domain name
+	Sender string
+
+	// Now is the publication time, defaults to time.Now
+	Now func() time.Time
+}
+
+// Exporter renders the active announcements of a Syncer to the configured formats
+type Exporter struct {
+	cfg ExportConfig
+}
+
+func NewExporter(cfg ExportConfig) (*Exporter, error) {
+	if len(cfg.Formats) == 0 {
+		return nil, fmt.Errorf("no export formats configured")
+	}
+	for _, f := range cfg.Formats {
+		if f != FormatDatex2 && f != FormatCAP {
+			return nil, fmt.Errorf("unknown export format %q", f)
+		}
+	}
+	if cfg.Dir == "" && cfg.Publisher == nil {
+		return nil, fmt.Errorf("export needs a directory or a publisher")
+	}
+	if cfg.Publisher != nil && cfg.RoutingKey == "" {
+		return nil, fmt.Errorf("export publisher needs a routing key")
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Exporter{cfg: cfg}, nil
+}
+
+// Export renders the situations to every format and writes or publishes them
+func (e *Exporter) Export(ctx context.Context, sits []Situation) error {
+	now := e.cfg.Now().UTC().Truncate(time.Second)
+	for _, f := range e.cfg.Formats {
+		payload, err := e.Render(f, sits, now)
+		if err != nil {
+			return err
+		}
+		if e.cfg.Dir != "" {
+			if err := writeFile(filepath.Join(e.cfg.Dir, fmt.Sprintf("%s-%s.xml", e.cfg.Name, f)), payload); err != nil {
+				return err
+			}
+		}
+		if e.cfg.Publisher != nil {
+			if err := e.cfg.Publisher.Publish(ctx, payload, e.cfg.RoutingKey+"."+string(f)); err != nil {
+				return fmt.Errorf("failed to publish %s export: %w", f, err)
+			}
+		}
+		logger.Get(ctx).Info("Exported announcements", "format", f, "count", len(sits))
+	}
+	return nil
+}
+
+// Render renders the situations to a format, published at now
+func (e *Exporter) Render(f Format, sits []Situation, now time.Time) ([]byte, error) {
+	switch f {
+	case FormatDatex2:
+		return renderDatex2(e.cfg, sits, now)
+	case FormatCAP:
+		return renderCAP(e.cfg, sits, now)
+	}
+	return nil, fmt.Errorf("unknown export format %q", f)
+}
+
+// writeFile writes atomically, so that a file server never serves a partial export
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// Text is the text of a situation in one language
+type Text struct {
+	Lang        string
+	Title       string
+	Description string
+}
+
+// Situation is the provider independent view of an announcement the formats are rendered from
+type Situation struct {
+	ID        string
+	StartTime *time.Time
+	EndTime   *time.Time
+	// VersionTime is when the announcement last changed
+	VersionTime time.Time
+	TagIDs      []string
+	// Texts sorted by language
+	Texts    []Text
+	Geometry geometry
+}
+
+// announcementView are the content model fields the export reads. All provider models share them
+type announcementView struct {
+	Id        *string
+	Shortname *string
+	StartTime *time.Time
+	EndTime   *time.Time
+	TagIds    []string
+	Detail    map[string]*struct {
+		Title, BaseText *string
+	}
+	Geo map[string]struct {
+		Latitude, Longitude *float64
+		Default             bool
+		Geometry            *string
+	}
+}
+
+// NewSituation reads the export view of an announcement
+func NewSituation[T any, PT Entity[T]](ann T) (Situation, error) {
+	b, err := json.Marshal(ann)
+	if err != nil {
+		return Situation{}, err
+	}
+	var v announcementView
+	if err := json.Unmarshal(b, &v); err != nil {
+		return Situation{}, err
+	}
+
+	sit := Situation{
+		ID:          PT(&ann).AnnouncementID(),
+		StartTime:   v.StartTime,
+		EndTime:     v.EndTime,
+		VersionTime: PT(&ann).GetSyncTime().UTC(),
+		TagIDs:      v.TagIds,
+	}
+
+	for _, lang := range slices.Sorted(maps.Keys(v.Detail)) {
+		d := v.Detail[lang]
+		if d == nil || d.Title == nil {
+			continue
+		}
+		t := Text{Lang: lang, Title: *d.Title}
+		if d.BaseText != nil {
+			t.Description = *d.BaseText
+		}
+		sit.Texts = append(sit.Texts, t)
+	}
+	if len(sit.Texts) == 0 && v.Shortname != nil {
+		sit.Texts = []Text{{Lang: "en", Title: *v.Shortname}}
+	}
+
+	// The default geo entry is the announcement's location, the others (e.g. a position inside an area) are fallbacks
+	keys := slices.SortedFunc(maps.Keys(v.Geo), func(a, b string) int {
+		if v.Geo[a].Default != v.Geo[b].Default {
+			if v.Geo[a].Default {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	for _, k := range keys {
+		g := v.Geo[k]
+		if g.Geometry != nil {
+			if sit.Geometry, err = parseWKT(*g.Geometry); err == nil {
+				break
+			}
+		}
+		if g.Latitude != nil && g.Longitude != nil {
+			sit.Geometry = geometry{Type: geometryPoint, Coords: []coordinate{{Lon: *g.Longitude, Lat: *g.Latitude}}}
+			break
+		}
+	}
+	if len(sit.Geometry.Coords) == 0 {
+		return Situation{}, fmt.Errorf("announcement %s has no location", sit.ID)
+	}
+	return sit, nil
+}
+
+// ExportEnv configures the export of a transformer, embed it into the env
+type ExportEnv struct {
+	// comma separated export formats: datex2, cap. Empty disables the export
+	EXPORT_FORMATS string
+	// directory to write the exports to
+	EXPORT_DIR string
+	// publish the exports on the transformed exchange with routing key <EXPORT_ROUTING_KEY>.<format>
+	EXPORT_ROUTING_KEY         string
+	EXPORT_COUNTRY             string `default:"it"`
+	EXPORT_NATIONAL_IDENTIFIER string `default:"NOI Techpark"`
+	EXPORT_SENDER              string `default:"opendatahub.com"`
+}
+
+// NewExporterFromEnv creates the exporter configured in the env, or nil if the export is disabled
+func NewExporterFromEnv(ctx context.Context, env ExportEnv, mqURI string, mqClient string, name string) (*Exporter, error) {
+	var formats []Format
+	for _, f := range strings.Split(env.EXPORT_FORMATS, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			formats = append(formats, Format(f))
+		}
+	}
+	if len(formats) == 0 {
+		return nil, nil
+	}
+
+	cfg := ExportConfig{
+		Formats:            formats,
+		Dir:                env.EXPORT_DIR,
+		RoutingKey:         env.EXPORT_ROUTING_KEY,
+		Name:               name,
+		Country:            env.EXPORT_COUNTRY,
+		NationalIdentifier: env.EXPORT_NATIONAL_IDENTIFIER,
+		Sender:             env.EXPORT_SENDER,
+	}
+	if env.EXPORT_ROUTING_KEY != "" {
+		transEx, err := qmill.NewPublisherQmill(ctx, mqURI, mqClient,
+			qmill.WithExchange("transformed", "topic", true),
+			qmill.WithNoRequeueOnNack(true),
+			qmill.WithLogger(watermill.NewSlogLogger(slog.Default())),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to declare transformed exchange: %w", err)
+		}
+		cfg.Publisher = transEx
+	}
+	return NewExporter(cfg)
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build schema
+
+package announcements
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestExportSchema validates the golden files against the official schemas in testdata/schema with xmllint,
+// run it with go test -tags schema
+func TestExportSchema(t *testing.T) {
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		t.Fatal("xmllint is required to validate the export against the schemas")
+	}
+
+	t.Run(string(FormatDatex2), func(t *testing.T) {
+		validateXML(t, "testdata/export-datex2.xml", "testdata/schema/DATEXII_3_D2Payload.xsd")
+	})
+
+	// Atom has no official XML schema, the CAP alerts of the feed are validated on their own
+	t.Run(string(FormatCAP), func(t *testing.T) {
+		alerts := capAlerts(t, "testdata/export-cap.xml")
+		require.NotEmpty(t, alerts)
+		for i, alert := range alerts {
+			file := filepath.Join(t.TempDir(), fmt.Sprintf("alert-%d.xml", i))
+			require.NoError(t, os.WriteFile(file, alert, 0o644))
+			validateXML(t, file, "testdata/schema/CAP-v1.2.xsd")
+		}
+	})
+}
+
+func validateXML(t *testing.T, file string, schema string) {
+	t.Helper()
+	if _, err := os.Stat(schema); err != nil {
+		t.Fatalf("schema %s is missing, see testdata/schema/README.md", schema)
+	}
+	out, err := exec.Command("xmllint", "--noout", "--schema", schema, file).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+// capAlerts returns the CAP alerts of a feed as documents of their own
+func capAlerts(t *testing.T, file string) [][]byte {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	var alerts [][]byte
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return alerts
+		}
+		require.NoError(t, err)
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Space == capNS && start.Name.Local == "alert" {
+			var alert struct {
+				Inner []byte `xml:",innerxml"`
+			}
+			require.NoError(t, d.DecodeElement(&alert, &start))
+			alerts = append(alerts, fmt.Appendf(nil, "%s<alert xmlns=%q>%s</alert>", xml.Header, capNS, alert.Inner))
+		}
+	}
+}
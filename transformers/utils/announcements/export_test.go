@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/clib"
+	"github.com/noi-techpark/opendatahub-go-sdk/clib/clibmock"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// exportAnnouncement has the fields of the content model the export reads
+type exportAnnouncement struct {
+	ID        string     `json:"Id"`
+	Shortname *string    `json:"Shortname,omitempty"`
+	StartTime *time.Time `json:"StartTime,omitempty"`
+	EndTime   *time.Time `json:"EndTime,omitempty"`
+	SyncTime  time.Time  `json:"SyncTime" hash:"ignore"`
+	TagIds    []string   `json:"TagIds,omitempty"`
+	Detail    map[string]struct {
+		Title    *string `json:"Title,omitempty"`
+		BaseText *string `json:"BaseText,omitempty"`
+	} `json:"Detail,omitempty"`
+	Geo map[string]struct {
+		Latitude  *float64 `json:"Latitude,omitempty"`
+		Longitude *float64 `json:"Longitude,omitempty"`
+		Default   bool     `json:"Default"`
+		Geometry  *string  `json:"Geometry,omitempty"`
+	} `json:"Geo,omitempty"`
+}
+
+func (a *exportAnnouncement) AnnouncementID() string    { return a.ID }
+func (a *exportAnnouncement) GetStartTime() *time.Time  { return a.StartTime }
+func (a *exportAnnouncement) SetStartTime(t *time.Time) { a.StartTime = t }
+func (a *exportAnnouncement) GetEndTime() *time.Time    { return a.EndTime }
+func (a *exportAnnouncement) SetEndTime(t *time.Time)   { a.EndTime = t }
+func (a *exportAnnouncement) GetSyncTime() time.Time    { return a.SyncTime }
+func (a *exportAnnouncement) SetSyncTime(t time.Time)   { a.SyncTime = t }
+
+func readTestAnnouncements(t *testing.T) []exportAnnouncement {
+	t.Helper()
+	b, err := os.ReadFile("testdata/announcements.json")
+	require.NoError(t, err)
+	var anns []exportAnnouncement
+	require.NoError(t, json.Unmarshal(b, &anns))
+	return anns
+}
+
+func testExportConfig() ExportConfig {
+	return ExportConfig{
+		Formats:            []Format{FormatDatex2, FormatCAP},
+		Name:               "a22",
+		Country:            "it",
+		NationalIdentifier: "NOI Techpark",
+		Sender:             "opendatahub.com",
+		Now:                func() time.Time { return time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC) },
+	}
+}
+
+func TestParseWKT(t *testing.T) {
+	g, err := parseWKT("POINT (11.327970 46.462190)")
+	require.NoError(t, err)
+	require.Equal(t, geometry{Type: geometryPoint, Coords: []coordinate{{Lon: 11.32797, Lat: 46.46219}}}, g)
+
+	g, err = parseWKT("LINESTRING (11.5 47.0, 11.4 46.9)")
+	require.NoError(t, err)
+	require.Equal(t, geometryLineString, g.Type)
+	require.Len(t, g.Coords, 2)
+
+	// only the outer ring of a polygon is kept
+	g, err = parseWKT("POLYGON ((0 0, 1 0, 1 1, 0 0), (0.1 0.1, 0.2 0.1, 0.2 0.2, 0.1 0.1))")
+	require.NoError(t, err)
+	require.Equal(t, []coordinate{{0, 0}, {1, 0}, {1, 1}, {0, 0}}, g.Coords)
+
+	_, err = parseWKT("MULTIPOINT ((0 0), (1 1))")
+	require.ErrorContains(t, err, "unsupported")
+	_, err = parseWKT("LINESTRING (11.5 47.0)")
+	require.Error(t, err)
+	_, err = parseWKT("POINT (11.5)")
+	require.Error(t, err)
+}
+
+func TestNewSituation(t *testing.T) {
+	anns := readTestAnnouncements(t)
+
+	// the default area is preferred over the position
+	sit, err := NewSituation[exportAnnouncement](anns[2])
+	require.NoError(t, err)
+	require.Equal(t, geometryPolygon, sit.Geometry.Type)
+	require.Equal(t, []string{"de", "it"}, []string{sit.Texts[0].Lang, sit.Texts[1].Lang})
+	require.Equal(t, time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC), sit.VersionTime)
+
+	// without geometry the coordinates are used
+	sit, err = NewSituation[exportAnnouncement](anns[3])
+	require.NoError(t, err)
+	require.Equal(t, geometry{Type: geometryPoint, Coords: []coordinate{{Lon: 11.5053, Lat: 47.0024}}}, sit.Geometry)
+
+	_, err = NewSituation[exportAnnouncement](exportAnnouncement{ID: "nowhere"})
+	require.ErrorContains(t, err, "no location")
+}
+
+func TestExportGolden(t *testing.T) {
+	var sits []Situation
+	for _, a := range readTestAnnouncements(t) {
+		sit, err := NewSituation[exportAnnouncement](a)
+		require.NoError(t, err)
+		sits = append(sits, sit)
+	}
+	slices.SortFunc(sits, func(a, b Situation) int { return strings.Compare(a.ID, b.ID) })
+
+	cfg := testExportConfig()
+	cfg.Dir = t.TempDir()
+	e, err := NewExporter(cfg)
+	require.NoError(t, err)
+
+	// the golden files are validated against the schemas by TestExportSchema
+	for _, f := range []Format{FormatDatex2, FormatCAP} {
+		t.Run(string(f), func(t *testing.T) {
+			out, err := e.Render(f, sits, cfg.Now())
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "export-"+string(f)+".xml")
+			if *update {
+				require.NoError(t, os.WriteFile(golden, out, 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), string(out))
+		})
+	}
+}
+
+type testPublisher struct {
+	published map[string][]byte
+	calls     int
+}
+
+func (p *testPublisher) Publish(ctx context.Context, payload []byte, routingKey string) error {
+	p.published[routingKey] = payload
+	p.calls++
+	return nil
+}
+
+func TestSyncExport(t *testing.T) {
+	anns := readTestAnnouncements(t)
+	pub := &testPublisher{published: map[string][]byte{}}
+	cfg := testExportConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Publisher = pub
+	cfg.RoutingKey = "announcements.a22"
+	e, err := NewExporter(cfg)
+	require.NoError(t, err)
+
+	s := New[exportAnnouncement](clibmock.NewContentMock(), clib.NewCache[exportAnnouncement](), Config{Export: e})
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.Sync(context.TODO(), t0, anns))
+	require.Equal(t, 2, pub.calls)
+	require.Contains(t, string(pub.published["announcements.a22.datex2"]), `<sit:situation id="urn:announcements:a22:accident"`)
+	require.Contains(t, string(pub.published["announcements.a22.cap"]), `<identifier>urn:announcements:a22:accident</identifier>`)
+	file, err := os.ReadFile(filepath.Join(cfg.Dir, "a22-datex2.xml"))
+	require.NoError(t, err)
+	require.Equal(t, pub.published["announcements.a22.datex2"], file)
+
+	// nothing changed, nothing exported
+	require.NoError(t, s.Sync(context.TODO(), t0.Add(time.Minute), anns))
+	require.Equal(t, 2, pub.calls)
+
+	// a closed announcement is removed from the export
+	require.NoError(t, s.Sync(context.TODO(), t0.Add(2*time.Minute), anns[1:]))
+	require.Equal(t, 4, pub.calls)
+	require.NotContains(t, string(pub.published["announcements.a22.datex2"]), anns[0].ID)
+	require.Equal(t, 4, strings.Count(string(pub.published["announcements.a22.datex2"]), "<sit:situation "))
+}
+
+func TestNewExporter(t *testing.T) {
+	_, err := NewExporter(ExportConfig{Formats: []Format{"kml"}, Dir: "."})
+	require.ErrorContains(t, err, "unknown export format")
+	_, err = NewExporter(ExportConfig{Formats: []Format{FormatCAP}})
+	require.ErrorContains(t, err, "directory or a publisher")
+	_, err = NewExporter(ExportConfig{Formats: []Format{FormatCAP}, Publisher: &testPublisher{}})
+	require.ErrorContains(t, err, "routing key")
+
+	e, err := NewExporterFromEnv(context.TODO(), ExportEnv{}, "", "", "a22")
+	require.NoError(t, err)
+	require.Nil(t, e)
+}
@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package announcements syncs the announcements of a traffic event provider with the content API.
+// A provider maps its events to announcements, the Syncer takes care of the cache, of change detection
+// and of closing the announcements that disappeared from the feed.
+//
+// A transformer loads the Syncer once and syncs every message with it:
+//
+//	syncer, err := announcements.Load[odhContentModel.Announcement](ctx, contentClient, cfg)
+//	...
+//	anns := announcements.Map(ctx, r.Rawdata, mapEvent)
+//	return syncer.Sync(ctx, r.Timestamp, anns)
+//
+// With an Exporter in the Config, the active announcements are also published as DATEX II v3 and CAP 1.2
+// after every change, see ExportEnv.
+package announcements
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/clib"
+	"github.com/noi-techpark/opendatahub-go-sdk/tel/logger"
+)
+
+const entityType = "Announcement"
+
+// Entity is the announcement model of a provider. The content models differ by their provider mapping,
+// these methods give the Syncer access to the fields it manages
+type Entity[T any] interface {
+	*T
+	AnnouncementID() string
+	GetStartTime() *time.Time
+	SetStartTime(*time.Time)
+	GetEndTime() *time.Time
+	SetEndTime(*time.Time)
+	// SyncTime is the time of the last batch that updated the announcement, stored in the provider mapping
+	GetSyncTime() time.Time
+	SetSyncTime(time.Time)
+}
+
+type Config struct {
+	// Source of the announcements in the content API
+	Source string
+	// Mapping is the provider block under Mapping, e.g. ProviderA22. Only announcements having it are synced
+	Mapping string
+	// GracePeriod is how long an announcement has to be missing from the feed before it's closed.
+	// Zero closes it with the first batch it's missing from
+	GracePeriod time.Duration
+	// KeepEndTime closes announcements with an end date from the provider without changing it.
+	// Otherwise the end date is set to when the announcement disappeared from the feed
+	KeepEndTime bool
+	// DryRun logs what would be written instead of writing it
+	DryRun bool
+	// Export renders the active announcements after every change. Nil disables the export
+	Export *Exporter
+}
+
+// Mapper maps a provider event to an announcement
+type Mapper[E any, T any] func(E) (T, error)
+
+// Map maps a batch of events. Events that fail to map are logged and skipped,
+// so that a single malformed event doesn't stall the queue
+func Map[E any, T any](ctx context.Context, events []E, mapper Mapper[E, T]) []T {
+	anns := make([]T, 0, len(events))
+	for i, event := range events {
+		ann, err := mapper(event)
+		if err != nil {
+			logger.Get(ctx).Warn("Failed to map event, skipping", "index", i, "error", err)
+			continue
+		}
+		anns = append(anns, ann)
+	}
+	return anns
+}
+
+type Syncer[T any, PT Entity[T]] struct {
+	cfg    Config
+	client clib.ContentAPI
+	cache  *clib.Cache[T]
+
+	mu sync.Mutex
+	// missingSince is the time of the first batch each cached announcement was missing from
+	missingSince map[string]time.Time
+	// exported is false until the current announcements have been exported
+	exported bool
+}
+
+func New[T any, PT Entity[T]](client clib.ContentAPI, cache *clib.Cache[T], cfg Config) *Syncer[T, PT] {
+	return &Syncer[T, PT]{cfg: cfg, client: client, cache: cache, missingSince: map[string]time.Time{}}
+}
+
+// Load creates a Syncer with the cache warmed up from the active announcements of the provider
+func Load[T any, PT Entity[T]](ctx context.Context, client clib.ContentAPI, cfg Config) (*Syncer[T, PT], error) {
+	cache, err := clib.LoadExisting(ctx, client, clib.LoadConfig[T]{
+		EntityType: entityType,
+		QueryParams: map[string]string{
+			"active":    "true",
+			"source":    cfg.Source,
+			"rawfilter": fmt.Sprintf("isnotnull(Mapping.%s.Id)", cfg.Mapping),
+		},
+		IDFunc: func(a T) string { return PT(&a).AnnouncementID() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load announcements: %w", err)
+	}
+
+	// Drop already ended announcements (EndTime <= SyncTime), so they are not closed again with every batch
+	for id, entry := range cache.Entries() {
+		a := PT(&entry.Entity)
+		if end := a.GetEndTime(); end != nil && !end.Truncate(time.Millisecond).After(a.GetSyncTime().Truncate(time.Millisecond)) {
+			cache.Delete(id)
+		}
+	}
+	return New[T, PT](client, cache, cfg), nil
+}
+
+func (s *Syncer[T, PT]) Cache() *clib.Cache[T] {
+	return s.cache
+}
+
+// change is what a dry run reports instead of writing
+type change struct {
+	action string
+	id     string
+	fields []string
+}
+
+// Sync updates the content API with the announcements of a batch received at ts.
+// Only changed announcements are written, and the ones missing from the batch for longer than the grace period are closed
+func (s *Syncer[T, PT]) Sync(ctx context.Context, ts time.Time, anns []T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// content can't handle nanoseconds, truncate to milliseconds
+	ts = ts.Truncate(time.Millisecond)
+
+	seen := map[string]struct{}{}
+	var list []T
+	var changes []change
+
+	for _, ann := range anns {
+		a := PT(&ann)
+		id := a.AnnouncementID()
+		a.SetSyncTime(ts)
+		seen[id] = struct{}{}
+		delete(s.missingSince, id)
+
+		// Preserve the start time once it has been established
+		existing, exists := s.cache.Get(id)
+		if exists {
+			a.SetStartTime(PT(&existing.Entity).GetStartTime())
+		}
+
+		hash, changed, err := s.cache.HasChanged(id, ann)
+		if err != nil {
+			logger.Get(ctx).Error("Failed to hash announcement", "id", id, "error", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if s.cfg.DryRun {
+			if exists {
+				changes = append(changes, change{action: "update", id: id, fields: changedFields(existing.Entity, ann)})
+			} else {
+				changes = append(changes, change{action: "create", id: id})
+			}
+		}
+		s.cache.Set(id, ann, hash)
+		list = append(list, ann)
+	}
+
+	// Detect ended announcements: cached entries not present in this batch
+	for id, entry := range s.cache.Entries() {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		since, ok := s.missingSince[id]
+		if !ok {
+			since = ts
+			s.missingSince[id] = since
+		}
+		if ts.Sub(since) < s.cfg.GracePeriod {
+			logger.Get(ctx).Debug("Announcement missing from the feed, within grace period", "id", id, "since", since)
+			continue
+		}
+
+		ann := entry.Entity
+		a := PT(&ann)
+		if a.GetEndTime() == nil || !s.cfg.KeepEndTime {
+			end := since
+			a.SetEndTime(&end)
+		}
+		a.SetSyncTime(ts)
+		if s.cfg.DryRun {
+			changes = append(changes, change{action: "close", id: id, fields: []string{"EndTime"}})
+		}
+		list = append(list, ann)
+		s.cache.Delete(id)
+		delete(s.missingSince, id)
+	}
+
+	logger.Get(ctx).Info("Updating changed announcements", "count", len(list))
+
+	if s.cfg.DryRun {
+		for _, c := range changes {
+			logger.Get(ctx).Info("Dry run, not writing announcement", "action", c.action, "id", c.id, "fields", c.fields)
+		}
+		return nil
+	}
+
+	if len(list) > 0 {
+		if err := s.client.PutMultiple(ctx, entityType, list); err != nil {
+			return fmt.Errorf("failed to update announcements: %w", err)
+		}
+		s.exported = false
+	}
+	s.export(ctx)
+	return nil
+}
+
+// export renders the cached announcements, if they changed since the last export.
+// The content API is already up to date, so a failed export is logged and retried with the next batch
+func (s *Syncer[T, PT]) export(ctx context.Context) {
+	if s.cfg.Export == nil || s.exported {
+		return
+	}
+	var sits []Situation
+	for id, entry := range s.cache.Entries() {
+		sit, err := NewSituation[T, PT](entry.Entity)
+		if err != nil {
+			logger.Get(ctx).Warn("Failed to export announcement, skipping", "id", id, "error", err)
+			continue
+		}
+		sits = append(sits, sit)
+	}
+	slices.SortFunc(sits, func(a, b Situation) int { return strings.Compare(a.ID, b.ID) })
+
+	if err := s.cfg.Export.Export(ctx, sits); err != nil {
+		logger.Get(ctx).Error("Failed to export announcements", "error", err)
+		return
+	}
+	s.exported = true
+}
+
+// changedFields lists the top level JSON fields that differ between two announcements
+func changedFields[T any](old, new T) []string {
+	fields := func(v T) map[string]json.RawMessage {
+		m := map[string]json.RawMessage{}
+		b, err := json.Marshal(v)
+		if err == nil {
+			_ = json.Unmarshal(b, &m)
+		}
+		return m
+	}
+	o, n := fields(old), fields(new)
+
+	var ret []string
+	for k, v := range n {
+		if string(o[k]) != string(v) {
+			ret = append(ret, k)
+		}
+	}
+	for k := range o {
+		if _, ok := n[k]; !ok {
+			ret = append(ret, k)
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func Float64Ptr(f float64) *float64 {
+	return &f
+}
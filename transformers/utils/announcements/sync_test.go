@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/clib"
+	"github.com/noi-techpark/opendatahub-go-sdk/clib/clibmock"
+	"github.com/stretchr/testify/require"
+)
+
+type testAnnouncement struct {
+	ID        string     `json:"Id"`
+	Title     string     `json:"Title"`
+	StartTime *time.Time `json:"StartTime,omitempty"`
+	EndTime   *time.Time `json:"EndTime,omitempty"`
+	SyncTime  time.Time  `json:"SyncTime" hash:"ignore"`
+}
+
+func (a *testAnnouncement) AnnouncementID() string    { return a.ID }
+func (a *testAnnouncement) GetStartTime() *time.Time  { return a.StartTime }
+func (a *testAnnouncement) SetStartTime(t *time.Time) { a.StartTime = t }
+func (a *testAnnouncement) GetEndTime() *time.Time    { return a.EndTime }
+func (a *testAnnouncement) SetEndTime(t *time.Time)   { a.EndTime = t }
+func (a *testAnnouncement) GetSyncTime() time.Time    { return a.SyncTime }
+func (a *testAnnouncement) SetSyncTime(t time.Time)   { a.SyncTime = t }
+
+func newTestSyncer(cfg Config) (*Syncer[testAnnouncement, *testAnnouncement], *clibmock.ContentMock) {
+	mock := clibmock.NewContentMock()
+	return New[testAnnouncement](mock, clib.NewCache[testAnnouncement](), cfg), mock
+}
+
+func written(t *testing.T, mock *clibmock.ContentMock, call int) map[string]testAnnouncement {
+	t.Helper()
+	calls := mock.Calls().PutMultiples
+	require.Greater(t, len(calls), call)
+	var list []testAnnouncement
+	require.NoError(t, json.Unmarshal(calls[call].Payload, &list))
+	ret := map[string]testAnnouncement{}
+	for _, a := range list {
+		ret[a.ID] = a
+	}
+	return ret
+}
+
+func TestSync(t *testing.T) {
+	s, mock := newTestSyncer(Config{})
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	start := t0.Add(-time.Hour)
+
+	require.NoError(t, s.Sync(context.TODO(), t0, []testAnnouncement{
+		{ID: "a", Title: "A", StartTime: &start},
+		{ID: "b", Title: "B"},
+	}))
+	require.Len(t, written(t, mock, 0), 2)
+
+	// unchanged announcements are not written again, the start time is kept
+	later := t0.Add(time.Minute)
+	require.NoError(t, s.Sync(context.TODO(), t0.Add(time.Minute), []testAnnouncement{
+		{ID: "a", Title: "A", StartTime: &later},
+		{ID: "b", Title: "B changed"},
+	}))
+	w := written(t, mock, 1)
+	require.Len(t, w, 1)
+	require.Equal(t, "B changed", w["b"].Title)
+
+	// a vanished announcement is closed
+	t2 := t0.Add(2 * time.Minute)
+	require.NoError(t, s.Sync(context.TODO(), t2, []testAnnouncement{{ID: "b", Title: "B changed"}}))
+	w = written(t, mock, 2)
+	require.Len(t, w, 1)
+	require.True(t, t2.Equal(*w["a"].EndTime))
+	require.True(t, start.Equal(*w["a"].StartTime))
+	_, cached := s.Cache().Get("a")
+	require.False(t, cached)
+}
+
+func TestSyncGracePeriod(t *testing.T) {
+	s, mock := newTestSyncer(Config{GracePeriod: 10 * time.Minute})
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+
+	require.NoError(t, s.Sync(context.TODO(), at(0), []testAnnouncement{{ID: "a"}, {ID: "b"}}))
+
+	// a disappears for a moment and comes back, b is gone
+	require.NoError(t, s.Sync(context.TODO(), at(5), []testAnnouncement{}))
+	require.NoError(t, s.Sync(context.TODO(), at(8), []testAnnouncement{{ID: "a"}}))
+	require.NoError(t, s.Sync(context.TODO(), at(12), []testAnnouncement{}))
+	require.Len(t, mock.Calls().PutMultiples, 1)
+
+	// b is closed when the grace period is over, at the time it disappeared
+	require.NoError(t, s.Sync(context.TODO(), at(15), []testAnnouncement{}))
+	w := written(t, mock, 1)
+	require.Len(t, w, 1)
+	require.True(t, at(5).Equal(*w["b"].EndTime))
+	require.True(t, at(15).Equal(w["b"].SyncTime))
+
+	// a's grace period restarted when it came back
+	require.NoError(t, s.Sync(context.TODO(), at(22), []testAnnouncement{}))
+	w = written(t, mock, 2)
+	require.True(t, at(12).Equal(*w["a"].EndTime))
+}
+
+func TestSyncKeepEndTime(t *testing.T) {
+	planned := time.Date(2025, 12, 24, 0, 0, 0, 0, time.UTC)
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprint(keep), func(t *testing.T) {
+			s, mock := newTestSyncer(Config{KeepEndTime: keep})
+			s.Cache().Set("planned", testAnnouncement{ID: "planned", EndTime: &planned}, 1)
+			s.Cache().Set("open", testAnnouncement{ID: "open"}, 2)
+
+			require.NoError(t, s.Sync(context.TODO(), t0, []testAnnouncement{}))
+			w := written(t, mock, 0)
+			require.True(t, t0.Equal(*w["open"].EndTime))
+			if keep {
+				require.True(t, planned.Equal(*w["planned"].EndTime))
+			} else {
+				require.True(t, t0.Equal(*w["planned"].EndTime))
+			}
+		})
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	s, mock := newTestSyncer(Config{DryRun: true})
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.Sync(context.TODO(), t0, []testAnnouncement{{ID: "a", Title: "A"}}))
+	require.NoError(t, s.Sync(context.TODO(), t0.Add(time.Minute), []testAnnouncement{}))
+	require.Empty(t, mock.Calls().PutMultiples)
+
+	require.Equal(t, []string{"SyncTime", "Title"}, changedFields(
+		testAnnouncement{ID: "a", Title: "A", SyncTime: t0},
+		testAnnouncement{ID: "a", Title: "B", SyncTime: t0.Add(time.Minute)}))
+}
+
+func TestMap(t *testing.T) {
+	anns := Map(context.TODO(), []string{"a", "", "c"}, func(id string) (testAnnouncement, error) {
+		if id == "" {
+			return testAnnouncement{}, fmt.Errorf("missing id")
+		}
+		return testAnnouncement{ID: id}, nil
+	})
+	require.Equal(t, []testAnnouncement{{ID: "a"}, {ID: "c"}}, anns)
+}
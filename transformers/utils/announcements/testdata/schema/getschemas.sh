@@ -0,0 +1,29 @@
+#!/bin/bash
+
+# SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+#
+# SPDX-License-Identifier: CC0-1.0
+
+# Vendors the official export schemas into this directory, unmodified.
+# CAP is downloaded from OASIS. The DATEX II v3 schemas are only distributed as a zip from
+# https://docs.datex2.eu/downloads/modelv3.html, pass the downloaded zip as argument.
+# Commit the resulting *.xsd files, the schema tests use them as they are.
+
+set -e
+cd "$(dirname "$0")"
+
+if [ -z "$1" ]; then
+  echo "usage: $0 <DATEX II v3 schema zip>"
+  exit 1
+fi
+
+echo "Downloading: CAP-v1.2.xsd"
+curl -fsSL -o CAP-v1.2.xsd https://docs.oasis-open.org/emergency/cap/v1.2/CAP-v1.2.xsd
+
+echo "Extracting: DATEX II v3 schemas from $1"
+unzip -o -j "$1" '*DATEXII_3_*.xsd' -d .
+
+if [ ! -f DATEXII_3_D2Payload.xsd ]; then
+  echo "DATEXII_3_D2Payload.xsd not found in $1"
+  exit 1
+fi
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type geometryType string
+
+const (
+	geometryPoint      geometryType = "POINT"
+	geometryLineString geometryType = "LINESTRING"
+	geometryPolygon    geometryType = "POLYGON"
+)
+
+type coordinate struct {
+	Lon, Lat float64
+}
+
+// geometry is a parsed WKT geometry. Polygons only keep their outer ring, which is all the export formats can represent
+type geometry struct {
+	Type   geometryType
+	Coords []coordinate
+}
+
+// parseWKT parses the WKT geometries the mappers produce: POINT, LINESTRING and POLYGON, in lon lat order
+func parseWKT(wkt string) (geometry, error) {
+	s := strings.TrimSpace(wkt)
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return geometry{}, fmt.Errorf("invalid WKT %q", wkt)
+	}
+	g := geometry{Type: geometryType(strings.ToUpper(strings.TrimSpace(s[:open])))}
+	body := strings.TrimSpace(s[open+1 : len(s)-1])
+
+	switch g.Type {
+	case geometryPoint, geometryLineString:
+	case geometryPolygon:
+		// outer ring only
+		if !strings.HasPrefix(body, "(") {
+			return geometry{}, fmt.Errorf("invalid WKT %q", wkt)
+		}
+		end := strings.Index(body, ")")
+		if end < 0 {
+			return geometry{}, fmt.Errorf("invalid WKT %q", wkt)
+		}
+		body = body[1:end]
+	default:
+		return geometry{}, fmt.Errorf("unsupported WKT geometry %q", g.Type)
+	}
+
+	for _, pair := range strings.Split(body, ",") {
+		f := strings.Fields(pair)
+		if len(f) < 2 {
+			return geometry{}, fmt.Errorf("invalid WKT coordinate %q", pair)
+		}
+		lon, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			return geometry{}, fmt.Errorf("invalid WKT coordinate %q: %w", pair, err)
+		}
+		lat, err := strconv.ParseFloat(f[1], 64)
+		if err != nil {
+			return geometry{}, fmt.Errorf("invalid WKT coordinate %q: %w", pair, err)
+		}
+		g.Coords = append(g.Coords, coordinate{Lon: lon, Lat: lat})
+	}
+	if g.Type == geometryPoint && len(g.Coords) != 1 ||
+		g.Type == geometryLineString && len(g.Coords) < 2 ||
+		g.Type == geometryPolygon && len(g.Coords) < 4 {
+		return geometry{}, fmt.Errorf("invalid WKT %q: wrong number of coordinates", wkt)
+	}
+	return g, nil
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package announcements
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+// xmlWriter writes indented XML with namespace prefixes, which encoding/xml can't produce
+type xmlWriter struct {
+	buf   bytes.Buffer
+	stack []string
+}
+
+func newXMLWriter() *xmlWriter {
+	w := &xmlWriter{}
+	w.buf.WriteString(xml.Header)
+	return w
+}
+
+// start opens an element, attrs are name, value pairs
+func (w *xmlWriter) start(name string, attrs ...string) {
+	w.indent()
+	w.buf.WriteString("<" + name)
+	w.attrs(attrs)
+	w.buf.WriteString(">\n")
+	w.stack = append(w.stack, name)
+}
+
+func (w *xmlWriter) end() {
+	name := w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	w.indent()
+	w.buf.WriteString("</" + name + ">\n")
+}
+
+// elem writes an element with text content
+func (w *xmlWriter) elem(name string, text string, attrs ...string) {
+	w.indent()
+	w.buf.WriteString("<" + name)
+	w.attrs(attrs)
+	w.buf.WriteString(">")
+	_ = xml.EscapeText(&w.buf, []byte(text))
+	w.buf.WriteString("</" + name + ">\n")
+}
+
+func (w *xmlWriter) attrs(attrs []string) {
+	for i := 0; i+1 < len(attrs); i += 2 {
+		w.buf.WriteString(" " + attrs[i] + `="`)
+		_ = xml.EscapeText(&w.buf, []byte(attrs[i+1]))
+		w.buf.WriteString(`"`)
+	}
+}
+
+func (w *xmlWriter) indent() {
+	w.buf.WriteString(strings.Repeat("  ", len(w.stack)))
+}
+
+func (w *xmlWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"maps"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+)
+
+// DataTypes are the data types of the charging stations, rawStatus is the status data type of the provider format
+func DataTypes(rawStatus bdplib.DataType) []bdplib.DataType {
+	return []bdplib.DataType{DtNumberAvailable, DtPlugStatus, rawStatus}
+}
+
+// SessionDataTypes are the data types of the charging sessions of the stations, see AddSessionRecords
+func SessionDataTypes() []bdplib.DataType {
+	return []bdplib.DataType{DtEnergy, DtSessions, DtOccupancyDuration}
+}
+
+// BdpStations maps the locations to EChargingStation and their EVSEs to EChargingPlug stations.
+// Besides the provider metadata, stations get their "capacity" and plugs their "connectors" and "tariffs".
+// BDP silently drops stations without name, empty names fall back to the location code
+func BdpStations(origin string, locs []Location) (stations []bdplib.Station, plugs []bdplib.Station) {
+	stations = make([]bdplib.Station, 0, len(locs))
+	plugs = make([]bdplib.Station, 0)
+	for _, loc := range locs {
+		station := bdplib.CreateStation(loc.ID, loc.Name, StationTypeLocation, loc.Latitude, loc.Longitude, origin)
+		if station.Name == "" {
+			station.Name = loc.ID
+		}
+		station.MetaData = maps.Clone(loc.MetaData)
+		if station.MetaData == nil {
+			station.MetaData = map[string]any{}
+		}
+		station.MetaData["capacity"] = len(loc.EVSEs)
+		stations = append(stations, station)
+
+		for _, evse := range loc.EVSEs {
+			plug := bdplib.CreateStation(evse.ID, evse.Name, StationTypePlug, evse.Latitude, evse.Longitude, origin)
+			if plug.Name == "" {
+				plug.Name = station.Name
+			}
+			plug.ParentStation = station.Id
+			plug.MetaData = maps.Clone(evse.MetaData)
+			if plug.MetaData == nil {
+				plug.MetaData = map[string]any{}
+			}
+			if len(evse.Connectors) > 0 {
+				plug.MetaData["connectors"] = evse.Connectors
+			}
+			if len(evse.Tariffs) > 0 {
+				plug.MetaData["tariffs"] = evse.Tariffs
+			}
+			plugs = append(plugs, plug)
+		}
+	}
+	return stations, plugs
+}
+
+// AddStatusRecords adds the EVSE status to plugData, as reported with the rawStatus data type and normalized with DtPlugStatus,
+// and the number of available EVSEs of every location to stationData. EVSEs without status have no status records
+func AddStatusRecords(stationData *bdplib.DataMap, plugData *bdplib.DataMap, locs []Location, rawStatus string, ts int64, period uint64) {
+	for _, loc := range locs {
+		for _, evse := range loc.EVSEs {
+			AddPlugStatus(plugData, evse, rawStatus, ts, period)
+		}
+		stationData.AddRecord(loc.ID, DtNumberAvailable.Name, bdplib.CreateRecord(ts, CountAvailable(loc.EVSEs), period))
+	}
+}
+
+// AddPlugStatus adds the status of a single EVSE, see AddStatusRecords
+func AddPlugStatus(plugData *bdplib.DataMap, evse EVSE, rawStatus string, ts int64, period uint64) {
+	if evse.Status == "" {
+		return
+	}
+	plugData.AddRecord(evse.ID, rawStatus, bdplib.CreateRecord(ts, evse.RawStatus, period))
+	plugData.AddRecord(evse.ID, DtPlugStatus.Name, bdplib.CreateRecord(ts, string(evse.Status), period))
+}
+
+func CountAvailable(evses []EVSE) int {
+	n := 0
+	for _, evse := range evses {
+		if evse.Status == StatusAvailable {
+			n++
+		}
+	}
+	return n
+}
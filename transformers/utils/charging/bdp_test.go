@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"testing"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ocpiLocations() []OCPILocation {
+	loc := OCPILocation{ID: "LOC1", Name: "Piazza", City: "Bolzano", CountryCode: "IT", PartyID: "NEO"}
+	loc.Coordinates.Latitude = "46.4983"
+	loc.Coordinates.Longitude = "11.3548"
+	loc.Evses = []OCPIEVSE{
+		{UID: "E1", EvseID: "IT*NEO*E1", Status: "AVAILABLE", Connectors: []OCPIConnector{
+			{ID: "1", Standard: "IEC_62196_T2", Format: "SOCKET", PowerType: "AC_3_PHASE", MaxElectricPower: 22000, TariffIds: []string{"T1"}},
+		}},
+		{UID: "E2", Status: "CHARGING", Capabilities: []string{"RFID_READER"}},
+		{UID: "E3", Status: "STOLEN"},
+		{UID: "E4"},
+	}
+	invalid := OCPILocation{ID: "LOC2"}
+	return []OCPILocation{loc, invalid}
+}
+
+func TestFromOCPI(t *testing.T) {
+	locs := FromOCPI("NEOGY", ocpiLocations())
+
+	require.Len(t, locs, 1, "location without coordinates is skipped")
+	loc := locs[0]
+	assert.Equal(t, "NEOGY:LOC1", loc.ID)
+	assert.Equal(t, "Bolzano", loc.MetaData["city"])
+
+	require.Len(t, loc.EVSEs, 4)
+	assert.Equal(t, "NEOGY:E1", loc.EVSEs[0].ID)
+	assert.Equal(t, "IT*NEO*E1", loc.EVSEs[0].Name)
+	assert.Equal(t, []string{"T1"}, loc.EVSEs[0].Connectors[0].TariffIDs)
+	assert.Equal(t, "E2", loc.EVSEs[1].Name, "evse_id falls back to the uid")
+	assert.Equal(t, StatusCharging, loc.EVSEs[1].Status)
+	assert.Equal(t, StatusUnknown, loc.EVSEs[2].Status)
+	assert.Equal(t, "STOLEN", loc.EVSEs[2].RawStatus)
+	assert.Empty(t, loc.EVSEs[3].Status)
+	assert.Equal(t, loc.Latitude, loc.EVSEs[1].Latitude)
+
+	var u OCPIEVSEUpdate
+	u.Params.Location_id = "LOC1"
+	u.Params.Evse_uid = "E2"
+	u.Body.Status = "AVAILABLE"
+	locID, evse := FromOCPIUpdate("NEOGY", u)
+	assert.Equal(t, "NEOGY:LOC1", locID)
+	assert.Equal(t, "NEOGY:E2", evse.ID)
+	assert.Equal(t, StatusAvailable, evse.Status)
+}
+
+func TestBdpStations(t *testing.T) {
+	locs := FromOCPI("NEOGY", ocpiLocations())
+	locs = append(locs, Location{ID: "OP:ST", EVSEs: []EVSE{{ID: "E5"}}})
+
+	stations, plugs := BdpStations("NEOGY", locs)
+
+	require.Len(t, stations, 2)
+	assert.Equal(t, StationTypeLocation, stations[0].StationType)
+	assert.Equal(t, "Piazza", stations[0].Name)
+	assert.Equal(t, "NEOGY", stations[0].Origin)
+	assert.Equal(t, 4, stations[0].MetaData["capacity"])
+	assert.Equal(t, "IT", stations[0].MetaData["country_code"])
+	assert.NotContains(t, locs[0].MetaData, "capacity", "the location metadata is not modified")
+	assert.Equal(t, "OP:ST", stations[1].Name, "empty name falls back to the code")
+
+	require.Len(t, plugs, 5)
+	assert.Equal(t, StationTypePlug, plugs[0].StationType)
+	assert.Equal(t, "NEOGY:LOC1", plugs[0].ParentStation)
+	assert.Len(t, plugs[0].MetaData["connectors"], 1)
+	assert.NotContains(t, plugs[1].MetaData, "connectors")
+	assert.Equal(t, []string{"RFID_READER"}, plugs[1].MetaData["capabilities"])
+	assert.Equal(t, "OP:ST", plugs[4].Name, "empty name falls back to the station name")
+}
+
+func TestAddStatusRecords(t *testing.T) {
+	locs := FromOCPI("NEOGY", ocpiLocations())
+	stationData, plugData := bdplib.DataMap{}, bdplib.DataMap{}
+
+	AddStatusRecords(&stationData, &plugData, locs, DtPlugStatusOCPI.Name, 1000, 300)
+
+	available := stationData.Branch["NEOGY:LOC1"].Branch[DtNumberAvailable.Name].Data
+	require.Len(t, available, 1)
+	assert.Equal(t, bdplib.CreateRecord(1000, 1, 300), available[0])
+
+	e3 := plugData.Branch["NEOGY:E3"].Branch
+	assert.Equal(t, "STOLEN", e3[DtPlugStatusOCPI.Name].Data[0].Value)
+	assert.Equal(t, "UNKNOWN", e3[DtPlugStatus.Name].Data[0].Value)
+	assert.NotContains(t, plugData.Branch, "NEOGY:E4", "no status, no records")
+}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package charging is the charging domain model shared by the e-charging transformers.
+// Adapters map the provider formats (OCPI 2.x locations and EVSE pushes, OICP EVSEData and EVSEStatus)
+// to Locations, which are mapped the same way for every provider to EChargingStation and EChargingPlug stations:
+//
+//	locations := charging.FromOICP(evseData, evseStatuses)
+//	stations, plugs := charging.BdpStations(origin, locations)
+//	charging.AddStatusRecords(&stationData, &plugData, locations, charging.DtPlugStatusOICP.Name, ts, period)
+//
+// Station codes are kept as the transformers always built them, so the history of the stations continues:
+// OCPI ids are only unique per party and are prefixed with the origin, OICP EVSE ids are globally unique.
+// Plugs have their status as reported by the provider (DtPlugStatusOCPI, DtPlugStatusOICP) and normalized to the
+// OCPI EVSE status (DtPlugStatus), so the plugs of all providers can be queried the same way.
+//
+// OCPI CPOs also push tariffs and charge detail records. Tariffs are kept by Tariffs and attached to the plug
+// metadata of the EVSEs referencing them, the sessions of the records are summed up per location and period by SessionAggregator.
+package charging
+
+import (
+	"time"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+)
+
+const (
+	StationTypeLocation = "EChargingStation"
+	StationTypePlug     = "EChargingPlug"
+)
+
+var DtNumberAvailable = bdplib.DataType{
+	Name:        "number-available",
+	Description: "number of available vehicles / charging points",
+	Rtype:       "Instantaneous",
+}
+
+// DtPlugStatus is the status of every provider, normalized to the OCPI EVSE status
+var DtPlugStatus = bdplib.DataType{
+	Name:        "echarging-plug-status",
+	Description: "Current state of echarging plug, normalized to the OCPI EVSE status",
+	Rtype:       "Instantaneous",
+}
+
+var DtPlugStatusOCPI = bdplib.DataType{
+	Name:        "echarging-plug-status-ocpi",
+	Description: "Current state of echarging plug according to OCPI standard",
+	Rtype:       "Instantaneous",
+}
+
+var DtPlugStatusOICP = bdplib.DataType{
+	Name:        "echarging-plug-status-oicp",
+	Description: "Current state of echarging plug according to OICP standard",
+	Rtype:       "Instantaneous",
+}
+
+var DtEnergy = bdplib.DataType{
+	Name:        "echarging-energy",
+	Unit:        "kWh",
+	Description: "Energy delivered by the charging sessions ended in the period",
+	Rtype:       "Total",
+}
+
+var DtSessions = bdplib.DataType{
+	Name:        "echarging-sessions",
+	Description: "Number of charging sessions ended in the period",
+	Rtype:       "Count",
+}
+
+var DtOccupancyDuration = bdplib.DataType{
+	Name:        "echarging-occupancy-duration",
+	Unit:        "s",
+	Description: "Time the charging sessions ended in the period occupied the charging points, charging and parking",
+	Rtype:       "Total",
+}
+
+// Status of an EVSE, with the values of the OCPI 2.2 EVSE status
+type Status string
+
+const (
+	StatusAvailable   Status = "AVAILABLE"
+	StatusBlocked     Status = "BLOCKED"
+	StatusCharging    Status = "CHARGING"
+	StatusInoperative Status = "INOPERATIVE"
+	StatusOutOfOrder  Status = "OUTOFORDER"
+	StatusPlanned     Status = "PLANNED"
+	StatusRemoved     Status = "REMOVED"
+	StatusReserved    Status = "RESERVED"
+	StatusUnknown     Status = "UNKNOWN"
+)
+
+// Location is a charging station with its EVSEs, the EChargingStation
+type Location struct {
+	// ID is the station code
+	ID        string
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// MetaData of the provider format, the common fields are added by BdpStations
+	MetaData map[string]any
+	EVSEs    []EVSE
+}
+
+// EVSE is a charging point of a Location, the EChargingPlug
+type EVSE struct {
+	// ID is the station code
+	ID        string
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// Status is empty when the payload has no status for the EVSE
+	Status Status
+	// RawStatus is the status as reported by the provider
+	RawStatus  string
+	Connectors []Connector
+	// Tariffs referenced by the connectors, see Tariffs.Attach
+	Tariffs []Tariff
+	// MetaData of the provider format, the common fields are added by BdpStations
+	MetaData map[string]any
+}
+
+// Connector is the plug metadata common to all providers, named after the OCPI connector
+type Connector struct {
+	ID string `json:"id,omitempty"`
+	// Standard is the OCPI ConnectorType, e.g. IEC_62196_T2, or the provider value if there is no OCPI equivalent
+	Standard string `json:"standard"`
+	// Format is SOCKET or CABLE
+	Format string `json:"format,omitempty"`
+	// PowerType is AC_1_PHASE, AC_3_PHASE or DC
+	PowerType   string `json:"power_type,omitempty"`
+	MaxVoltage  int    `json:"max_voltage,omitempty"`
+	MaxAmperage int    `json:"max_amperage,omitempty"`
+	// MaxElectricPower in W
+	MaxElectricPower int      `json:"max_electric_power,omitempty"`
+	TariffIDs        []string `json:"tariff_ids,omitempty"`
+}
+
+// Tariff of an EVSE, named after the OCPI tariff. Prices are in Currency, excluding VAT
+type Tariff struct {
+	ID       string `json:"id"`
+	Currency string `json:"currency"`
+	// Type is AD_HOC_PAYMENT, PROFILE_CHEAP, PROFILE_FAST, PROFILE_GREEN or REGULAR, empty applies to all
+	Type string `json:"type,omitempty"`
+	// ValidFrom and ValidTo are the validity window, open ended if nil
+	ValidFrom   *time.Time      `json:"valid_from,omitempty"`
+	ValidTo     *time.Time      `json:"valid_to,omitempty"`
+	URL         string          `json:"url,omitempty"`
+	Elements    []TariffElement `json:"elements"`
+	LastUpdated time.Time       `json:"last_updated"`
+}
+
+// TariffElement has the price components applying under its restrictions
+type TariffElement struct {
+	PriceComponents []PriceComponent    `json:"price_components" bson:"price_components"`
+	Restrictions    *TariffRestrictions `json:"restrictions,omitempty" bson:"restrictions"`
+}
+
+type PriceComponent struct {
+	// Type is ENERGY (price per kWh), FLAT, PARKING_TIME or TIME (price per hour)
+	Type  string   `json:"type" bson:"type"`
+	Price float64  `json:"price" bson:"price"`
+	VAT   *float64 `json:"vat,omitempty" bson:"vat"`
+	// StepSize is the billing step, in Wh or seconds
+	StepSize int `json:"step_size" bson:"step_size"`
+}
+
+type TariffRestrictions struct {
+	StartTime   string   `json:"start_time,omitempty" bson:"start_time"`
+	EndTime     string   `json:"end_time,omitempty" bson:"end_time"`
+	StartDate   string   `json:"start_date,omitempty" bson:"start_date"`
+	EndDate     string   `json:"end_date,omitempty" bson:"end_date"`
+	MinKWh      *float64 `json:"min_kwh,omitempty" bson:"min_kwh"`
+	MaxKWh      *float64 `json:"max_kwh,omitempty" bson:"max_kwh"`
+	MinCurrent  *float64 `json:"min_current,omitempty" bson:"min_current"`
+	MaxCurrent  *float64 `json:"max_current,omitempty" bson:"max_current"`
+	MinPower    *float64 `json:"min_power,omitempty" bson:"min_power"`
+	MaxPower    *float64 `json:"max_power,omitempty" bson:"max_power"`
+	MinDuration *int     `json:"min_duration,omitempty" bson:"min_duration"`
+	MaxDuration *int     `json:"max_duration,omitempty" bson:"max_duration"`
+	DayOfWeek   []string `json:"day_of_week,omitempty" bson:"day_of_week"`
+	Reservation string   `json:"reservation,omitempty" bson:"reservation"`
+}
+
+// Session is a completed charging session, from a charge detail record
+type Session struct {
+	ID string
+	// LocationID and EVSEID are the station codes
+	LocationID string
+	EVSEID     string
+	Start      time.Time
+	End        time.Time
+	// Energy in kWh
+	Energy float64
+	// Duration the EVSE was occupied, charging and parking
+	Duration time.Duration
+}
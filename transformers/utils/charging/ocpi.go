@@ -0,0 +1,319 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// OCPILocation is an OCPI 2.x Location, as polled from the locations module of a CPO.
+// The bson tags are for the raw data stored by the collectors
+type OCPILocation struct {
+	CountryCode string `bson:"country_code"`
+	PartyID     string `bson:"party_id"`
+	ID          string `bson:"id"`
+	Publish     *bool
+	Name        string
+	Address     string
+	City        string
+	PostalCode  string `bson:"postal_code"`
+	Country     string
+	Coordinates struct {
+		Latitude  string
+		Longitude string
+	}
+	Evses            []OCPIEVSE
+	ParkingType      string `bson:"parking_type"`
+	Operator         OCPIBusinessDetails
+	Suboperator      OCPIBusinessDetails
+	Owner            OCPIBusinessDetails
+	Facilities       []string
+	TimeZone         string            `bson:"time_zone"`
+	OpeningTimes     *OCPIOpeningTimes `bson:"opening_times"`
+	LastUpdated      time.Time         `bson:"last_updated"`
+	PublishAllowedTo []interface{}     `bson:"publish_allowed_to"`
+	RelatedLocations []interface{}     `bson:"related_locations"`
+	Images           []interface{}
+	Directions       []OCPIDisplayText
+}
+
+type OCPIBusinessDetails struct {
+	Name    string
+	Website string
+	Logo    string
+}
+
+type OCPIEVSE struct {
+	UID          string `bson:"uid"`
+	EvseID       string `bson:"evse_id"`
+	Status       string
+	Capabilities []string
+	Connectors   []OCPIConnector
+	LastUpdated  time.Time `bson:"last_updated"`
+}
+
+type OCPIConnector struct {
+	ID               string
+	Standard         string
+	Format           string
+	PowerType        string    `bson:"power_type"`
+	LastUpdated      time.Time `bson:"last_updated"`
+	MaxVoltage       int       `bson:"max_voltage"`
+	MaxAmperage      int       `bson:"max_amperage"`
+	MaxElectricPower int       `bson:"max_electric_power"`
+	TariffIds        []string  `bson:"tariff_ids"`
+}
+
+type OCPIDisplayText struct {
+	Language string `json:"language"`
+	Text     string `json:"text"`
+}
+
+type OCPIOpeningTimes struct {
+	Twentyfourseven *bool `json:"twentyfourseven,omitempty"`
+	RegularHours    []struct {
+		Weekday                int `json:"weekday"`
+		OCPIOpeningHoursPeriod `bson:",inline"`
+	} `bson:"regular_hours" json:"regular_hours,omitempty"`
+	ExceptionalOpenings []OCPIOpeningHoursPeriod `bson:"exceptional_openings" json:"exceptional_openings,omitempty"`
+	ExceptionalClosings []OCPIOpeningHoursPeriod `bson:"exceptional_closings" json:"exceptional_closings,omitempty"`
+}
+
+type OCPIOpeningHoursPeriod struct {
+	PeriodBegin string `bson:"period_begin" json:"period_begin"`
+	PeriodEnd   string `bson:"period_end" json:"period_end"`
+}
+
+// OCPIEVSEUpdate is an EVSE pushed by a CPO to the OCPI endpoint, with the parameters of the request path
+type OCPIEVSEUpdate struct {
+	Params struct {
+		Country_code string
+		Evse_uid     string
+		Location_id  string
+		Party_id     string
+	}
+	Body OCPIEVSE
+}
+
+// OCPICode is the station code of an OCPI location or EVSE. Their ids are only unique per party
+func OCPICode(origin string, id string) string {
+	return fmt.Sprintf("%s:%s", origin, id)
+}
+
+// FromOCPI maps OCPI locations. Locations without valid coordinates are skipped
+func FromOCPI(origin string, locs []OCPILocation) []Location {
+	ret := make([]Location, 0, len(locs))
+	for _, loc := range locs {
+		lat, errLat := strconv.ParseFloat(loc.Coordinates.Latitude, 64)
+		lon, errLon := strconv.ParseFloat(loc.Coordinates.Longitude, 64)
+		if errLat != nil || errLon != nil {
+			slog.Warn("Skipping OCPI location with invalid coordinates", "id", loc.ID, "coordinates", loc.Coordinates)
+			continue
+		}
+
+		l := Location{
+			ID:        OCPICode(origin, loc.ID),
+			Name:      loc.Name,
+			Latitude:  lat,
+			Longitude: lon,
+			MetaData: map[string]any{
+				"country_code":  loc.CountryCode,
+				"party_id":      loc.PartyID,
+				"address":       loc.Address,
+				"city":          loc.City,
+				"postal_code":   loc.PostalCode,
+				"time_zone":     loc.TimeZone,
+				"opening_times": loc.OpeningTimes,
+			},
+		}
+		if len(loc.Directions) > 0 {
+			l.MetaData["directions"] = loc.Directions
+		}
+
+		for _, evse := range loc.Evses {
+			e := fromOCPIEVSE(origin, evse)
+			e.Latitude, e.Longitude = lat, lon
+			l.EVSEs = append(l.EVSEs, e)
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// FromOCPIUpdate maps a pushed EVSE, returning the code of its location.
+// Pushes don't carry coordinates, the EVSE is only good for its status
+func FromOCPIUpdate(origin string, u OCPIEVSEUpdate) (string, EVSE) {
+	if u.Body.UID == "" {
+		u.Body.UID = u.Params.Evse_uid
+	}
+	return OCPICode(origin, u.Params.Location_id), fromOCPIEVSE(origin, u.Body)
+}
+
+func fromOCPIEVSE(origin string, evse OCPIEVSE) EVSE {
+	e := EVSE{
+		ID:        OCPICode(origin, evse.UID),
+		Name:      evse.EvseID,
+		Status:    OCPIStatus(evse.Status),
+		RawStatus: evse.Status,
+		MetaData:  map[string]any{},
+	}
+	// evse_id is optional
+	if e.Name == "" {
+		e.Name = evse.UID
+	}
+	if len(evse.Capabilities) > 0 {
+		e.MetaData["capabilities"] = evse.Capabilities
+	}
+	for _, c := range evse.Connectors {
+		e.Connectors = append(e.Connectors, Connector{
+			ID:               c.ID,
+			Standard:         c.Standard,
+			Format:           c.Format,
+			PowerType:        c.PowerType,
+			MaxVoltage:       c.MaxVoltage,
+			MaxAmperage:      c.MaxAmperage,
+			MaxElectricPower: c.MaxElectricPower,
+			TariffIDs:        c.TariffIds,
+		})
+	}
+	return e
+}
+
+// OCPIStatus validates an OCPI EVSE status, unknown values are StatusUnknown
+func OCPIStatus(s string) Status {
+	switch st := Status(s); st {
+	case "":
+		return ""
+	case StatusAvailable, StatusBlocked, StatusCharging, StatusInoperative, StatusOutOfOrder,
+		StatusPlanned, StatusRemoved, StatusReserved, StatusUnknown:
+		return st
+	}
+	return StatusUnknown
+}
+
+// OCPIPush is an object pushed by a CPO to the OCPI endpoint. Params are the parameters of the request path,
+// Method tells if Body is a complete object (PUT, POST), a partial update (PATCH) or absent (DELETE)
+type OCPIPush[T any] struct {
+	Object string
+	Method string
+	Params map[string]string
+	Body   T
+}
+
+// OCPITariff is an OCPI 2.2 Tariff, pushed to the tariffs module
+type OCPITariff struct {
+	CountryCode   string `bson:"country_code"`
+	PartyID       string `bson:"party_id"`
+	ID            string `bson:"id"`
+	Currency      string
+	Type          string
+	TariffAltURL  string            `bson:"tariff_alt_url"`
+	Elements      []TariffElement   `bson:"elements"`
+	StartDateTime string            `bson:"start_date_time"`
+	EndDateTime   string            `bson:"end_date_time"`
+	LastUpdated   string            `bson:"last_updated"`
+	TariffAltText []OCPIDisplayText `bson:"tariff_alt_text"`
+}
+
+// OCPICDR is an OCPI 2.2 charge detail record, pushed to the cdrs module
+type OCPICDR struct {
+	CountryCode   string `bson:"country_code"`
+	PartyID       string `bson:"party_id"`
+	ID            string `bson:"id"`
+	StartDateTime string `bson:"start_date_time"`
+	EndDateTime   string `bson:"end_date_time"`
+	SessionID     string `bson:"session_id"`
+	CdrLocation   struct {
+		ID          string `bson:"id"`
+		EvseUID     string `bson:"evse_uid"`
+		ConnectorID string `bson:"connector_id"`
+	} `bson:"cdr_location"`
+	Currency  string
+	TotalCost struct {
+		ExclVat float64  `bson:"excl_vat"`
+		InclVat *float64 `bson:"incl_vat"`
+	} `bson:"total_cost"`
+	// TotalEnergy in kWh
+	TotalEnergy float64 `bson:"total_energy"`
+	// TotalTime and TotalParkingTime in hours
+	TotalTime        float64  `bson:"total_time"`
+	TotalParkingTime *float64 `bson:"total_parking_time"`
+	LastUpdated      string   `bson:"last_updated"`
+}
+
+// FromOCPITariff maps a pushed tariff, tariffs are referenced by their id
+func FromOCPITariff(t OCPITariff) (Tariff, error) {
+	ret := Tariff{
+		ID:       t.ID,
+		Currency: t.Currency,
+		Type:     t.Type,
+		URL:      t.TariffAltURL,
+		Elements: t.Elements,
+	}
+	var err error
+	if ret.ValidFrom, err = parseOCPIOptionalTime(t.StartDateTime); err != nil {
+		return Tariff{}, fmt.Errorf("tariff %s: start_date_time: %w", t.ID, err)
+	}
+	if ret.ValidTo, err = parseOCPIOptionalTime(t.EndDateTime); err != nil {
+		return Tariff{}, fmt.Errorf("tariff %s: end_date_time: %w", t.ID, err)
+	}
+	if t.LastUpdated != "" {
+		if ret.LastUpdated, err = parseOCPITime(t.LastUpdated); err != nil {
+			return Tariff{}, fmt.Errorf("tariff %s: last_updated: %w", t.ID, err)
+		}
+	}
+	return ret, nil
+}
+
+// FromOCPICDR maps a charge detail record. The occupation duration is the total time of the record,
+// or the time between start and end if the CPO doesn't report it
+func FromOCPICDR(origin string, cdr OCPICDR) (Session, error) {
+	start, err := parseOCPITime(cdr.StartDateTime)
+	if err != nil {
+		return Session{}, fmt.Errorf("cdr %s: start_date_time: %w", cdr.ID, err)
+	}
+	end, err := parseOCPITime(cdr.EndDateTime)
+	if err != nil {
+		return Session{}, fmt.Errorf("cdr %s: end_date_time: %w", cdr.ID, err)
+	}
+	if cdr.CdrLocation.ID == "" {
+		return Session{}, fmt.Errorf("cdr %s: missing cdr_location", cdr.ID)
+	}
+	s := Session{
+		ID:         cdr.ID,
+		LocationID: OCPICode(origin, cdr.CdrLocation.ID),
+		EVSEID:     OCPICode(origin, cdr.CdrLocation.EvseUID),
+		Start:      start,
+		End:        end,
+		Energy:     cdr.TotalEnergy,
+		Duration:   time.Duration(cdr.TotalTime * float64(time.Hour)).Round(time.Second),
+	}
+	if s.Duration <= 0 {
+		s.Duration = end.Sub(start)
+	}
+	return s, nil
+}
+
+// parseOCPITime parses an OCPI DateTime, which is UTC if the time zone is missing
+func parseOCPITime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05.999999999", s, time.UTC)
+}
+
+func parseOCPIOptionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := parseOCPITime(s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
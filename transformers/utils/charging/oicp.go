@@ -0,0 +1,417 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OICPNameList handles the API inconsistency where this field
+// is sometimes a single object and sometimes an array.
+type OICPNameList []OICPName
+
+func (l *OICPNameList) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || string(data) == "null" {
+		*l = nil
+		return nil
+	}
+	switch data[0] {
+	case '[':
+		var list []OICPName
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*l = list
+	case '{':
+		var single OICPName
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		*l = []OICPName{single}
+	default:
+		return fmt.Errorf("unexpected ChargingStationNames value: %s", data)
+	}
+	return nil
+}
+
+// FlexString handles the API inconsistency where a field is sometimes a string and sometimes a number.
+type FlexString string
+
+func (f *FlexString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*f = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*f = FlexString(s)
+		return nil
+	}
+	// number or other scalar — take raw representation as string
+	*f = FlexString(data)
+	return nil
+}
+
+// --- Static Data Structures (OICP Format with Operator nesting) ---
+
+type OICPOperator struct {
+	OperatorID     string           `json:"OperatorID"`
+	OperatorName   string           `json:"OperatorName"`
+	EVSEDataRecord []OICPEVSERecord `json:"EVSEDataRecord"`
+}
+
+type OICPEVSERecord struct {
+	Accessibility                  *string                `json:"Accessibility"`
+	AccessibilityLocation          *string                `json:"AccessibilityLocation"`
+	AdditionalInfo                 interface{}            `json:"AdditionalInfo"`
+	Address                        *OICPAddress           `json:"Address"`
+	AuthenticationModes            []string               `json:"AuthenticationModes"`
+	CalibrationLawDataAvailability *string                `json:"CalibrationLawDataAvailability"`
+	ChargingFacilities             []OICPChargingFacility `json:"ChargingFacilities"`
+	ChargingPoolID                 *string                `json:"ChargingPoolID"`
+	ChargingStationId              string                 `json:"ChargingStationId"`
+	ChargingStationLocationRef     interface{}            `json:"ChargingStationLocationReference"`
+	ChargingStationNames           OICPNameList           `json:"ChargingStationNames"`
+	ClearinghouseID                *string                `json:"ClearinghouseID"`
+	DynamicInfoAvailable           *string                `json:"DynamicInfoAvailable"`
+	DynamicPowerLevel              interface{}            `json:"DynamicPowerLevel"`
+	EnergySource                   interface{}            `json:"EnergySource"`
+	EnvironmentalImpact            interface{}            `json:"EnvironmentalImpact"`
+	EvseID                         string                 `json:"EvseID"`
+	GeoChargingPointEntrance       *OICPGeoCoordinates    `json:"GeoChargingPointEntrance"`
+	GeoCoordinates                 *OICPGeoCoordinates    `json:"GeoCoordinates"`
+	HardwareManufacturer           *string                `json:"HardwareManufacturer"`
+	HotlinePhoneNumber             *string                `json:"HotlinePhoneNumber"`
+	HubOperatorID                  *string                `json:"HubOperatorID"`
+	IsHubjectCompatible            *bool                  `json:"IsHubjectCompatible"`
+	IsOpen24Hours                  *bool                  `json:"IsOpen24Hours"`
+	LocationImage                  interface{}            `json:"LocationImage"`
+	MaxCapacity                    interface{}            `json:"MaxCapacity"`
+	OpeningTimes                   interface{}            `json:"OpeningTimes"`
+	PaymentOptions                 []string               `json:"PaymentOptions"`
+	Plugs                          []string               `json:"Plugs"`
+	RenewableEnergy                *bool                  `json:"RenewableEnergy"`
+	SuboperatorName                *string                `json:"SuboperatorName"`
+	ValueAddedServices             []string               `json:"ValueAddedServices"`
+	DeltaType                      *string                `json:"deltaType"`
+	LastUpdate                     *string                `json:"lastUpdate"`
+}
+
+type OICPAddress struct {
+	City            *string     `json:"City"`
+	Country         *string     `json:"Country"`
+	Floor           *string     `json:"Floor"`
+	HouseNum        *string     `json:"HouseNum"`
+	ParkingFacility *bool       `json:"ParkingFacility"`
+	ParkingSpot     *string     `json:"ParkingSpot"`
+	PostalCode      *FlexString `json:"PostalCode"`
+	Region          *string     `json:"Region"`
+	Street          *string     `json:"Street"`
+	TimeZone        *string     `json:"TimeZone"`
+}
+
+// OICPChargingFacility has power in kW, amperage and voltage as numbers or strings
+type OICPChargingFacility struct {
+	Power         interface{} `json:"power"`
+	PowerType     *string     `json:"powertype"`
+	Amperage      interface{} `json:"Amperage"`
+	Voltage       interface{} `json:"Voltage"`
+	ChargingModes []string    `json:"ChargingModes"`
+}
+
+type OICPName struct {
+	Lang  string `json:"lang"`
+	Value string `json:"value"`
+}
+
+type OICPGeoCoordinates struct {
+	Google string `json:"Google"` // Format: "lat lon" (space-separated)
+}
+
+// LatLon parses the Google format
+func (geo *OICPGeoCoordinates) LatLon() (float64, float64, error) {
+	if geo == nil || geo.Google == "" {
+		return 0, 0, fmt.Errorf("missing coordinates")
+	}
+
+	parts := strings.Split(geo.Google, " ")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid coordinate format: %s", geo.Google)
+	}
+
+	lat, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude: %w", err)
+	}
+
+	lon, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude: %w", err)
+	}
+
+	return lat, lon, nil
+}
+
+// --- Real-Time Status Data Structures (OICP Format with Operator nesting) ---
+
+type OICPStatusOperator struct {
+	OperatorID       string             `json:"OperatorID"`
+	OperatorName     string             `json:"OperatorName"`
+	EVSEStatusRecord []OICPStatusRecord `json:"EVSEStatusRecord"`
+}
+
+type OICPStatusRecord struct {
+	EvseID     string `json:"EvseID"`
+	EvseStatus string `json:"EvseStatus"`
+}
+
+// FromOICP groups the EVSEs by OperatorID + ChargingStationId into locations, with the status of the EVSE status records.
+// The location code is OperatorID:ChargingStationId, the EVSE code the EvseID, which is globally unique.
+// EVSEs without valid coordinates are skipped, and so are locations without any EVSE left.
+// Locations are named after their first EVSE, preferably in english, names are left empty if there is none
+func FromOICP(evseOperators []OICPOperator, statusOperators []OICPStatusOperator) []Location {
+	statusByEvseID := make(map[string]string)
+	for _, statusOperator := range statusOperators {
+		for _, status := range statusOperator.EVSEStatusRecord {
+			statusByEvseID[status.EvseID] = status.EvseStatus
+		}
+	}
+
+	type stationGroup struct {
+		evses        []OICPEVSERecord
+		operatorID   string
+		operatorName string
+	}
+	groups := make(map[string]*stationGroup)
+	groupOrder := make([]string, 0) // preserve operator order for determinism
+
+	for _, operator := range evseOperators {
+		for _, evse := range operator.EVSEDataRecord {
+			sid := operator.OperatorID + ":" + evse.ChargingStationId
+			if _, ok := groups[sid]; !ok {
+				groups[sid] = &stationGroup{operatorID: operator.OperatorID, operatorName: operator.OperatorName}
+				groupOrder = append(groupOrder, sid)
+			}
+			groups[sid].evses = append(groups[sid].evses, evse)
+		}
+	}
+
+	locs := make([]Location, 0, len(groupOrder))
+	for _, stationID := range groupOrder {
+		group := groups[stationID]
+		loc := Location{ID: stationID}
+
+		// The first EVSE with valid coords is the reference for station-level fields
+		var refEVSE *OICPEVSERecord
+		for i := range group.evses {
+			evse := &group.evses[i]
+			lat, lon, err := evse.GeoCoordinates.LatLon()
+			if err != nil {
+				slog.Warn("Skipping EVSE with invalid coordinates", "evseID", evse.EvseID, "err", err)
+				continue
+			}
+			if refEVSE == nil {
+				refEVSE = evse
+				loc.Latitude, loc.Longitude = lat, lon
+				loc.Name = oicpName(evse.ChargingStationNames)
+			} else if math.Abs(lat-loc.Latitude) > 0.001 || math.Abs(lon-loc.Longitude) > 0.001 {
+				slog.Warn("EVSE under same station has different coordinates",
+					"stationID", stationID, "evseID", evse.EvseID,
+					"expectedLat", loc.Latitude, "expectedLon", loc.Longitude,
+					"gotLat", lat, "gotLon", lon)
+			}
+
+			rawStatus, hasStatus := statusByEvseID[evse.EvseID]
+			e := EVSE{
+				ID:         evse.EvseID,
+				Latitude:   lat,
+				Longitude:  lon,
+				RawStatus:  rawStatus,
+				Connectors: oicpConnectors(evse),
+				MetaData:   oicpEVSEMetadata(evse),
+			}
+			if hasStatus {
+				e.Status = OICPStatus(rawStatus)
+			}
+			loc.EVSEs = append(loc.EVSEs, e)
+		}
+		if refEVSE == nil {
+			continue
+		}
+		loc.MetaData = oicpLocationMetadata(refEVSE, group.operatorID, group.operatorName)
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+// OICPStatus maps an OICP EvseStatus to the OCPI status
+func OICPStatus(s string) Status {
+	switch s {
+	case "Available":
+		return StatusAvailable
+	case "Reserved":
+		return StatusReserved
+	case "Occupied":
+		return StatusCharging
+	case "OutOfService":
+		return StatusOutOfOrder
+	case "EvseNotFound":
+		return StatusRemoved
+	}
+	return StatusUnknown
+}
+
+// Prefer english, deterministic fallback
+func oicpName(names OICPNameList) string {
+	for _, name := range names {
+		if name.Lang == "en" {
+			return name.Value
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sorted := slices.Clone([]OICPName(names))
+	slices.SortFunc(sorted, func(a, b OICPName) int {
+		return strings.Compare(a.Lang, b.Lang)
+	})
+	return sorted[0].Value
+}
+
+// oicpLocationMetadata returns metadata fields that are common to all EVSEs under a station.
+func oicpLocationMetadata(evse *OICPEVSERecord, operatorID string, operatorName string) map[string]any {
+	metadata := make(map[string]any)
+
+	metadata["chargingStationId"] = evse.ChargingStationId
+	metadata["operatorID"] = operatorID
+	metadata["operatorName"] = operatorName
+
+	if evse.Address != nil {
+		if evse.Address.Street != nil {
+			metadata["street"] = *evse.Address.Street
+		}
+		if evse.Address.City != nil {
+			metadata["city"] = *evse.Address.City
+		}
+		if evse.Address.PostalCode != nil {
+			metadata["postalCode"] = *evse.Address.PostalCode
+		}
+		if evse.Address.Country != nil {
+			metadata["country"] = *evse.Address.Country
+		}
+	}
+
+	if evse.Accessibility != nil {
+		metadata["accessibility"] = *evse.Accessibility
+	}
+	if evse.IsOpen24Hours != nil {
+		metadata["isOpen24Hours"] = *evse.IsOpen24Hours
+	}
+	if evse.HotlinePhoneNumber != nil {
+		metadata["hotlinePhoneNumber"] = *evse.HotlinePhoneNumber
+	}
+
+	return metadata
+}
+
+// oicpEVSEMetadata returns metadata fields that are specific to an individual EVSE/plug.
+func oicpEVSEMetadata(evse *OICPEVSERecord) map[string]any {
+	metadata := make(map[string]any)
+
+	metadata["evseID"] = evse.EvseID
+
+	if len(evse.Plugs) > 0 {
+		metadata["plugs"] = evse.Plugs
+	}
+	if len(evse.ChargingFacilities) > 0 {
+		facilitiesJSON, _ := json.Marshal(evse.ChargingFacilities)
+		metadata["chargingFacilities"] = string(facilitiesJSON)
+	}
+	if len(evse.AuthenticationModes) > 0 {
+		metadata["authenticationModes"] = evse.AuthenticationModes
+	}
+	if len(evse.PaymentOptions) > 0 {
+		metadata["paymentOptions"] = evse.PaymentOptions
+	}
+	if evse.RenewableEnergy != nil {
+		metadata["renewableEnergy"] = *evse.RenewableEnergy
+	}
+
+	return metadata
+}
+
+// oicpPlugs maps the OICP PlugType to the OCPI ConnectorType and ConnectorFormat
+var oicpPlugs = map[string]struct{ standard, format string }{
+	"Type 1 Connector (Cable Attached)": {"IEC_62196_T1", "CABLE"},
+	"Type 2 Outlet":                     {"IEC_62196_T2", "SOCKET"},
+	"Type 2 Connector (Cable Attached)": {"IEC_62196_T2", "CABLE"},
+	"Type 3 Outlet":                     {"IEC_62196_T3C", "SOCKET"},
+	"CCS Combo 1 Plug (Cable Attached)": {"IEC_62196_T1_COMBO", "CABLE"},
+	"CCS Combo 2 Plug (Cable Attached)": {"IEC_62196_T2_COMBO", "CABLE"},
+	"CHAdeMO":                           {"CHADEMO", "CABLE"},
+	"Tesla Connector":                   {"TESLA_S", "CABLE"},
+	"IEC 60309 Single Phase":            {"IEC_60309_2_single_16", "SOCKET"},
+	"IEC 60309 Three Phase":             {"IEC_60309_2_three_16", "SOCKET"},
+	"NEMA 5-20":                         {"NEMA_5_20", "SOCKET"},
+	"Type E French Standard":            {"DOMESTIC_E", "SOCKET"},
+	"Type F Schuko":                     {"DOMESTIC_F", "SOCKET"},
+	"Type G British Standard":           {"DOMESTIC_G", "SOCKET"},
+	"Type J Swiss Standard":             {"DOMESTIC_J", "SOCKET"},
+}
+
+// oicpConnectors has a connector per plug. OICP doesn't tell which charging facility belongs to which plug,
+// a plug gets the most powerful facility of its kind: DC for CCS and CHAdeMO, AC for the others
+func oicpConnectors(evse *OICPEVSERecord) []Connector {
+	var ret []Connector
+	for i, plug := range evse.Plugs {
+		c := Connector{ID: strconv.Itoa(i + 1), Standard: plug}
+		if p, ok := oicpPlugs[plug]; ok {
+			c.Standard, c.Format = p.standard, p.format
+		}
+		dc := c.Standard == "CHADEMO" || strings.HasSuffix(c.Standard, "_COMBO")
+
+		var best *OICPChargingFacility
+		for j := range evse.ChargingFacilities {
+			f := &evse.ChargingFacilities[j]
+			if f.PowerType != nil && (*f.PowerType == "DC") != dc {
+				continue
+			}
+			if best == nil || flexNumber(f.Power) > flexNumber(best.Power) {
+				best = f
+			}
+		}
+		if best != nil {
+			if best.PowerType != nil {
+				c.PowerType = *best.PowerType
+			}
+			c.MaxVoltage = int(flexNumber(best.Voltage))
+			c.MaxAmperage = int(flexNumber(best.Amperage))
+			c.MaxElectricPower = int(math.Round(flexNumber(best.Power) * 1000))
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// flexNumber reads a number which may be a JSON number or a string, 0 if it's neither
+func flexNumber(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f
+	}
+	return 0
+}
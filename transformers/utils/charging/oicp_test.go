@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oicpData = `[{
+	"OperatorID": "CH*CCI",
+	"OperatorName": "Operator One",
+	"EVSEDataRecord": [{
+		"EvseID": "CH*CCI*E1",
+		"ChargingStationId": "ST-1",
+		"ChargingStationNames": [{"lang": "de", "value": "Bahnhof"}, {"lang": "en", "value": "Station"}],
+		"GeoCoordinates": {"Google": "46.4983 11.3548"},
+		"Address": {"City": "Bolzano", "PostalCode": 39100},
+		"Plugs": ["Type 2 Outlet", "CCS Combo 2 Plug (Cable Attached)"],
+		"ChargingFacilities": [
+			{"power": "22.0", "powertype": "AC_3_PHASE", "Amperage": "32", "Voltage": "230"},
+			{"power": 150, "powertype": "DC", "Amperage": 375, "Voltage": 400}
+		]
+	}, {
+		"EvseID": "CH*CCI*E2",
+		"ChargingStationId": "ST-1",
+		"ChargingStationNames": {"lang": "it", "value": "Stazione"},
+		"GeoCoordinates": {"Google": "46.4984 11.3549"},
+		"Plugs": ["Tesla Connector", "Magnetic Plug"],
+		"ChargingFacilities": [{"power": 11}]
+	}, {
+		"EvseID": "CH*CCI*E3",
+		"ChargingStationId": "ST-2",
+		"GeoCoordinates": {"Google": "invalid"}
+	}]
+}]`
+
+const oicpStatus = `[{
+	"OperatorID": "CH*CCI",
+	"EVSEStatusRecord": [{"EvseID": "CH*CCI*E1", "EVSEStatus": "Occupied"}, {"EvseID": "CH*CCI*E3", "EvseStatus": "Available"}]
+}]`
+
+func TestFromOICP(t *testing.T) {
+	var data []OICPOperator
+	require.NoError(t, json.Unmarshal([]byte(oicpData), &data))
+	var status []OICPStatusOperator
+	require.NoError(t, json.Unmarshal([]byte(oicpStatus), &status))
+
+	locs := FromOICP(data, status)
+
+	// ST-2 has no EVSE with valid coordinates
+	require.Len(t, locs, 1)
+	loc := locs[0]
+	assert.Equal(t, "CH*CCI:ST-1", loc.ID)
+	assert.Equal(t, "Station", loc.Name)
+	assert.InDelta(t, 46.4983, loc.Latitude, 0.0001)
+	assert.Equal(t, "Bolzano", loc.MetaData["city"])
+	assert.Equal(t, FlexString("39100"), loc.MetaData["postalCode"])
+
+	require.Len(t, loc.EVSEs, 2)
+	e1, e2 := loc.EVSEs[0], loc.EVSEs[1]
+	assert.Equal(t, "CH*CCI*E1", e1.ID)
+	assert.Equal(t, StatusCharging, e1.Status)
+	assert.Equal(t, "Occupied", e1.RawStatus)
+	assert.Equal(t, []Connector{
+		{ID: "1", Standard: "IEC_62196_T2", Format: "SOCKET", PowerType: "AC_3_PHASE", MaxVoltage: 230, MaxAmperage: 32, MaxElectricPower: 22000},
+		{ID: "2", Standard: "IEC_62196_T2_COMBO", Format: "CABLE", PowerType: "DC", MaxVoltage: 400, MaxAmperage: 375, MaxElectricPower: 150000},
+	}, e1.Connectors)
+	assert.Equal(t, "CH*CCI*E1", e1.MetaData["evseID"])
+
+	assert.Empty(t, e2.Status, "no status record")
+	assert.Equal(t, []Connector{
+		{ID: "1", Standard: "TESLA_S", Format: "CABLE", MaxElectricPower: 11000},
+		{ID: "2", Standard: "Magnetic Plug", MaxElectricPower: 11000},
+	}, e2.Connectors)
+}
+
+func TestOICPStatus(t *testing.T) {
+	for raw, want := range map[string]Status{
+		"Available":    StatusAvailable,
+		"Reserved":     StatusReserved,
+		"Occupied":     StatusCharging,
+		"OutOfService": StatusOutOfOrder,
+		"EvseNotFound": StatusRemoved,
+		"Unknown":      StatusUnknown,
+		"Faulted":      StatusUnknown,
+	} {
+		assert.Equal(t, want, OICPStatus(raw), raw)
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+)
+
+// SessionStats are the sessions of a location ended in a period
+type SessionStats struct {
+	LocationID string
+	// End of the period, the timestamp of the records
+	End time.Time
+	// Energy in kWh
+	Energy    float64
+	Sessions  int
+	Occupancy time.Duration
+}
+
+// SessionAggregator sums up the sessions per location and period, a session counts in the period it ended in.
+// A period is kept open for the delay after its end, as CPOs send charge detail records some time after the session.
+// Periods without sessions have no stats
+type SessionAggregator struct {
+	period time.Duration
+	delay  time.Duration
+
+	mu   sync.Mutex
+	open map[sessionKey]*SessionStats
+	// seen are the ids of the sessions counted in the open periods, by period end
+	seen map[string]time.Time
+	// closed is the end of the last flushed period
+	closed time.Time
+}
+
+type sessionKey struct {
+	location string
+	end      time.Time
+}
+
+func NewSessionAggregator(period time.Duration, delay time.Duration) *SessionAggregator {
+	return &SessionAggregator{period: period, delay: delay, open: map[sessionKey]*SessionStats{}, seen: map[string]time.Time{}}
+}
+
+// Resume closes the periods that were due at now, as they were flushed before a restart, and returns the start
+// of the first open period. The sessions of the open periods were received since then, and are to be added again
+func (a *SessionAggregator) Resume(now time.Time) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if closed := now.Add(-a.delay).Truncate(a.period); closed.After(a.closed) {
+		a.closed = closed
+	}
+	return a.closed
+}
+
+// Add counts a session. It reports false if the session was already counted, or if its period was already flushed
+func (a *SessionAggregator) Add(s Session) bool {
+	end := s.End.Truncate(a.period).Add(a.period)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !end.After(a.closed) {
+		return false
+	}
+	if s.ID != "" {
+		if _, ok := a.seen[s.ID]; ok {
+			return false
+		}
+		a.seen[s.ID] = end
+	}
+	key := sessionKey{s.LocationID, end}
+	stats, ok := a.open[key]
+	if !ok {
+		stats = &SessionStats{LocationID: s.LocationID, End: end}
+		a.open[key] = stats
+	}
+	stats.Energy += s.Energy
+	stats.Sessions++
+	stats.Occupancy += s.Duration
+	return true
+}
+
+// Flush removes and returns the stats of the periods ended at least the delay before now, ordered by period and location
+func (a *SessionAggregator) Flush(now time.Time) []SessionStats {
+	cutoff := now.Add(-a.delay)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ret []SessionStats
+	for key, stats := range a.open {
+		if !key.end.After(cutoff) {
+			ret = append(ret, *stats)
+			delete(a.open, key)
+		}
+	}
+	// sessions of flushed periods are rejected by their end
+	for id, end := range a.seen {
+		if !end.After(cutoff) {
+			delete(a.seen, id)
+		}
+	}
+	if cutoff = cutoff.Truncate(a.period); cutoff.After(a.closed) {
+		a.closed = cutoff
+	}
+	slices.SortFunc(ret, func(x, y SessionStats) int {
+		return cmp.Or(x.End.Compare(y.End), cmp.Compare(x.LocationID, y.LocationID))
+	})
+	return ret
+}
+
+// Restore puts back flushed stats that couldn't be pushed, they are returned again by the next Flush
+func (a *SessionAggregator) Restore(stats []SessionStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range stats {
+		key := sessionKey{s.LocationID, s.End}
+		if open, ok := a.open[key]; ok {
+			open.Energy += s.Energy
+			open.Sessions += s.Sessions
+			open.Occupancy += s.Occupancy
+		} else {
+			a.open[key] = &s
+		}
+	}
+}
+
+// AddSessionRecords adds the energy, session count and occupancy duration records of the stats to stationData
+func AddSessionRecords(stationData *bdplib.DataMap, stats []SessionStats, period uint64) {
+	for _, s := range stats {
+		ts := s.End.UnixMilli()
+		stationData.AddRecord(s.LocationID, DtEnergy.Name, bdplib.CreateRecord(ts, s.Energy, period))
+		stationData.AddRecord(s.LocationID, DtSessions.Name, bdplib.CreateRecord(ts, s.Sessions, period))
+		stationData.AddRecord(s.LocationID, DtOccupancyDuration.Name, bdplib.CreateRecord(ts, int64(s.Occupancy.Seconds()), period))
+	}
+}
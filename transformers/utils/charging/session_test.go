@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/noi-techpark/go-bdp-client/bdplib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromOCPICDR(t *testing.T) {
+	cdr := OCPICDR{ID: "CDR1", StartDateTime: "2026-03-01T10:15:00Z", EndDateTime: "2026-03-01T11:45:00Z", TotalEnergy: 12.5, TotalTime: 1.25}
+	cdr.CdrLocation.ID = "LOC1"
+	cdr.CdrLocation.EvseUID = "E1"
+
+	s, err := FromOCPICDR("NEOGY", cdr)
+	require.NoError(t, err)
+	assert.Equal(t, "NEOGY:LOC1", s.LocationID)
+	assert.Equal(t, "NEOGY:E1", s.EVSEID)
+	assert.Equal(t, 12.5, s.Energy)
+	assert.Equal(t, 75*time.Minute, s.Duration)
+
+	cdr.TotalTime = 0
+	s, err = FromOCPICDR("NEOGY", cdr)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, s.Duration, "falls back to end - start")
+
+	cdr.CdrLocation.ID = ""
+	_, err = FromOCPICDR("NEOGY", cdr)
+	assert.Error(t, err)
+}
+
+func TestSessionAggregator(t *testing.T) {
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	a := NewSessionAggregator(time.Hour, 30*time.Minute)
+
+	assert.True(t, a.Add(Session{LocationID: "B", End: base.Add(10 * time.Minute), Energy: 5, Duration: time.Hour}))
+	assert.True(t, a.Add(Session{LocationID: "A", End: base.Add(20 * time.Minute), Energy: 2, Duration: 10 * time.Minute}))
+	assert.True(t, a.Add(Session{LocationID: "A", End: base.Add(50 * time.Minute), Energy: 3, Duration: 20 * time.Minute}))
+	assert.True(t, a.Add(Session{LocationID: "A", End: base.Add(70 * time.Minute), Energy: 1, Duration: time.Minute}))
+
+	assert.Empty(t, a.Flush(base.Add(80*time.Minute)), "period 10-11 is still open")
+
+	stats := a.Flush(base.Add(95 * time.Minute))
+	require.Len(t, stats, 2)
+	assert.Equal(t, SessionStats{LocationID: "A", End: base.Add(time.Hour), Energy: 5, Sessions: 2, Occupancy: 30 * time.Minute}, stats[0])
+	assert.Equal(t, "B", stats[1].LocationID)
+
+	assert.False(t, a.Add(Session{LocationID: "A", End: base.Add(55 * time.Minute)}), "period already flushed")
+	assert.True(t, a.Add(Session{LocationID: "A", End: base.Add(65 * time.Minute)}))
+
+	stats = a.Flush(base.Add(3 * time.Hour))
+	require.Len(t, stats, 1)
+	assert.Equal(t, 2, stats[0].Sessions)
+
+	dm := bdplib.DataMap{}
+	AddSessionRecords(&dm, stats, 3600)
+	records := dm.Branch["A"].Branch
+	ts := base.Add(2 * time.Hour).UnixMilli()
+	assert.Equal(t, bdplib.CreateRecord(ts, 1.0, 3600), records[DtEnergy.Name].Data[0])
+	assert.Equal(t, bdplib.CreateRecord(ts, 2, 3600), records[DtSessions.Name].Data[0])
+	assert.Equal(t, bdplib.CreateRecord(ts, int64(60), 3600), records[DtOccupancyDuration.Name].Data[0])
+}
+
+func TestSessionAggregatorResume(t *testing.T) {
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	a := NewSessionAggregator(time.Hour, 30*time.Minute)
+
+	// restarted at 11:20, period 9-10 was pushed before and 10-11 is open
+	assert.Equal(t, base, a.Resume(base.Add(80*time.Minute)))
+	assert.False(t, a.Add(Session{ID: "1", LocationID: "A", End: base.Add(-10 * time.Minute)}), "period already flushed")
+	assert.True(t, a.Add(Session{ID: "2", LocationID: "A", End: base.Add(10 * time.Minute), Energy: 2}))
+	// the session read again from the raw data is delivered again by the queue
+	assert.False(t, a.Add(Session{ID: "2", LocationID: "A", End: base.Add(10 * time.Minute), Energy: 2}), "session already counted")
+
+	// a failed push puts the stats back
+	stats := a.Flush(base.Add(95 * time.Minute))
+	require.Len(t, stats, 1)
+	a.Restore(stats)
+	assert.Equal(t, stats, a.Flush(base.Add(96*time.Minute)))
+	assert.Empty(t, a.Flush(base.Add(97*time.Minute)))
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"slices"
+	"sync"
+)
+
+// Tariffs are the tariffs of a CPO by id. OCPI pushes tariffs independently of the locations,
+// Tariffs keeps them to attach them to the EVSEs of every location update
+type Tariffs struct {
+	mu   sync.Mutex
+	byID map[string]Tariff
+}
+
+func NewTariffs() *Tariffs {
+	return &Tariffs{byID: map[string]Tariff{}}
+}
+
+// Put adds or replaces a tariff. It reports false for an update older than the known tariff
+func (t *Tariffs) Put(tariff Tariff) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if known, ok := t.byID[tariff.ID]; ok && tariff.LastUpdated.Before(known.LastUpdated) {
+		return false
+	}
+	t.byID[tariff.ID] = tariff
+	return true
+}
+
+func (t *Tariffs) Delete(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.byID, id)
+}
+
+// Attach sets the tariffs of the EVSEs to the known tariffs referenced by their connectors.
+// It reports whether any of the EVSEs references one of ids
+func (t *Tariffs) Attach(locs []Location, ids ...string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	referenced := false
+	for i := range locs {
+		for j := range locs[i].EVSEs {
+			evse := &locs[i].EVSEs[j]
+			evse.Tariffs = nil
+			var seen []string
+			for _, c := range evse.Connectors {
+				for _, id := range c.TariffIDs {
+					if slices.Contains(seen, id) {
+						continue
+					}
+					seen = append(seen, id)
+					referenced = referenced || slices.Contains(ids, id)
+					if tariff, ok := t.byID[id]; ok {
+						evse.Tariffs = append(evse.Tariffs, tariff)
+					}
+				}
+			}
+		}
+	}
+	return referenced
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package charging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromOCPITariff(t *testing.T) {
+	tariff, err := FromOCPITariff(OCPITariff{
+		ID:            "T1",
+		Currency:      "EUR",
+		StartDateTime: "2026-01-01T00:00:00Z",
+		LastUpdated:   "2026-03-01T10:00:00",
+		Elements:      []TariffElement{{PriceComponents: []PriceComponent{{Type: "ENERGY", Price: 0.45, StepSize: 1}}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "T1", tariff.ID)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), tariff.ValidFrom.UTC())
+	assert.Nil(t, tariff.ValidTo)
+	assert.Equal(t, time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC), tariff.LastUpdated, "zone-less times are UTC")
+	assert.Equal(t, 0.45, tariff.Elements[0].PriceComponents[0].Price)
+
+	_, err = FromOCPITariff(OCPITariff{ID: "T2", EndDateTime: "tomorrow"})
+	assert.Error(t, err)
+}
+
+func TestTariffs(t *testing.T) {
+	tariffs := NewTariffs()
+	updated := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	assert.True(t, tariffs.Put(Tariff{ID: "T1", Currency: "EUR", LastUpdated: updated}))
+	assert.True(t, tariffs.Put(Tariff{ID: "T2", Currency: "EUR", LastUpdated: updated}))
+	assert.False(t, tariffs.Put(Tariff{ID: "T1", Currency: "CHF", LastUpdated: updated.Add(-time.Hour)}), "older update is ignored")
+
+	locs := FromOCPI("NEOGY", ocpiLocations())
+	locs[0].EVSEs[0].Connectors = append(locs[0].EVSEs[0].Connectors, Connector{ID: "2", TariffIDs: []string{"T1", "T3"}})
+
+	assert.True(t, tariffs.Attach(locs, "T1"))
+	require.Len(t, locs[0].EVSEs[0].Tariffs, 1, "referenced twice, T3 is unknown")
+	assert.Equal(t, "EUR", locs[0].EVSEs[0].Tariffs[0].Currency)
+	assert.Empty(t, locs[0].EVSEs[1].Tariffs)
+	assert.False(t, tariffs.Attach(locs, "T2"), "T2 is not referenced")
+
+	_, plugs := BdpStations("NEOGY", locs)
+	assert.Equal(t, locs[0].EVSEs[0].Tariffs, plugs[0].MetaData["tariffs"])
+	assert.NotContains(t, plugs[1].MetaData, "tariffs")
+
+	tariffs.Delete("T1")
+	tariffs.Attach(locs)
+	assert.Empty(t, locs[0].EVSEs[0].Tariffs)
+}
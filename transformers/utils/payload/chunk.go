@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidChunk is returned for chunk envelopes that can't be reassembled
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// Chunk is a part of a payload that was too large for a single message.
+// The data of the chunks of a group, ordered by index, is the encoded payload
+type Chunk struct {
+	GroupID string
+	Index   int
+	Total   int
+	Data    string
+}
+
+// chunkEnvelope accepts the snake and camel case field names the collectors use
+type chunkEnvelope struct {
+	MessageID        string `json:"message_id"`
+	MessageIDCamel   string `json:"messageId"`
+	GroupID          string `json:"group_id"`
+	GroupIDCamel     string `json:"groupId"`
+	ChunkIndex       *int   `json:"chunk_index"`
+	ChunkIndexCamel  *int   `json:"chunkIndex"`
+	TotalChunks      *int   `json:"total_chunks"`
+	TotalChunksCamel *int   `json:"totalChunks"`
+	Data             string `json:"data"`
+	Payload          string `json:"payload"`
+}
+
+func firstNonEmpty[T comparable](v ...T) T {
+	var zero T
+	for _, s := range v {
+		if s != zero {
+			return s
+		}
+	}
+	return zero
+}
+
+// ParseChunk parses a chunk envelope. The group is identified by the message or group id
+func ParseChunk(raw string) (Chunk, error) {
+	var e chunkEnvelope
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &e); err != nil {
+		return Chunk{}, fmt.Errorf("%w: %w", ErrInvalidChunk, err)
+	}
+	index := firstNonEmpty(e.ChunkIndex, e.ChunkIndexCamel)
+	total := firstNonEmpty(e.TotalChunks, e.TotalChunksCamel)
+	c := Chunk{
+		GroupID: firstNonEmpty(e.MessageID, e.MessageIDCamel, e.GroupID, e.GroupIDCamel),
+		Data:    firstNonEmpty(e.Data, e.Payload),
+	}
+	switch {
+	case c.GroupID == "":
+		return c, fmt.Errorf("%w: no message or group id", ErrInvalidChunk)
+	case index == nil || total == nil:
+		return c, fmt.Errorf("%w: chunk index and total chunks are required", ErrInvalidChunk)
+	case *total < 1 || *index < 0 || *index >= *total:
+		return c, fmt.Errorf("%w: chunk index %d out of range of %d chunks", ErrInvalidChunk, *index, *total)
+	}
+	c.Index, c.Total = *index, *total
+	return c, nil
+}
+
+// ChunkConfig bounds the memory used by the incomplete groups
+type ChunkConfig struct {
+	// Timeout after the first chunk, after which an incomplete or unreleased group is evicted
+	Timeout time.Duration
+	// MaxGroups is the number of groups kept, the oldest is evicted first
+	MaxGroups int
+	// MaxBytes is the chunk data kept over all groups, the oldest groups are evicted first
+	MaxBytes int
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// ChunkEnv configures the reassembly of a transformer, embed it into the env
+type ChunkEnv struct {
+	CHUNK_TIMEOUT    time.Duration `default:"10m"`
+	CHUNK_MAX_GROUPS int           `default:"16"`
+	CHUNK_MAX_BYTES  int           `default:"67108864"`
+}
+
+type chunkGroup struct {
+	id      string
+	total   int
+	parts   map[int]string
+	size    int
+	started time.Time
+	// complete groups are kept until released, so that a redelivered chunk completes them again
+	complete bool
+}
+
+// Reassembler buffers the chunks in memory until their group is complete and released. Chunks may arrive
+// out of order and be redelivered. The chunks of unreleased groups are lost on restart
+type Reassembler struct {
+	cfg    ChunkConfig
+	mu     sync.Mutex
+	groups map[string]*chunkGroup
+	// the group ids in the order of their first chunk, for eviction
+	order []string
+	size  int
+}
+
+func NewReassembler(cfg ChunkConfig) *Reassembler {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Reassembler{cfg: cfg, groups: map[string]*chunkGroup{}}
+}
+
+func NewReassemblerFromEnv(env ChunkEnv) *Reassembler {
+	return NewReassembler(ChunkConfig{
+		Timeout:   env.CHUNK_TIMEOUT,
+		MaxGroups: env.CHUNK_MAX_GROUPS,
+		MaxBytes:  env.CHUNK_MAX_BYTES,
+	})
+}
+
+// Add buffers a chunk. When its group is complete, the data of the group is returned. The group is kept
+// until Release, or until it is evicted, so that the data is returned again for a redelivered chunk
+func (r *Reassembler) Add(c Chunk) (data string, complete bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.cfg.Now()
+	r.evictExpired(now)
+
+	g, ok := r.groups[c.GroupID]
+	if !ok {
+		g = &chunkGroup{id: c.GroupID, total: c.Total, parts: map[int]string{}, started: now}
+		r.groups[c.GroupID] = g
+		r.order = append(r.order, c.GroupID)
+	}
+	if g.total != c.Total {
+		r.remove(c.GroupID)
+		return "", false, fmt.Errorf("%w: group %s has %d chunks, chunk %d says %d", ErrInvalidChunk, c.GroupID, g.total, c.Index, c.Total)
+	}
+
+	// a redelivered chunk replaces the buffered one
+	prev := g.parts[c.Index]
+	g.parts[c.Index] = c.Data
+	g.size += len(c.Data) - len(prev)
+	r.size += len(c.Data) - len(prev)
+
+	if len(g.parts) == g.total {
+		var b strings.Builder
+		b.Grow(g.size)
+		for i := range g.total {
+			b.WriteString(g.parts[i])
+		}
+		g.complete = true
+		return b.String(), true, nil
+	}
+
+	r.evictOverflow(c.GroupID)
+	if r.cfg.MaxBytes > 0 && r.size > r.cfg.MaxBytes {
+		r.remove(c.GroupID)
+		return "", false, fmt.Errorf("%w: group %s exceeds the buffer of %d bytes", ErrInvalidChunk, c.GroupID, r.cfg.MaxBytes)
+	}
+	return "", false, nil
+}
+
+// Release removes a group once its data has been handled
+func (r *Reassembler) Release(groupID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(groupID)
+}
+
+// Pending is the number of incomplete groups
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, g := range r.groups {
+		if !g.complete {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Reassembler) evictExpired(now time.Time) {
+	if r.cfg.Timeout <= 0 {
+		return
+	}
+	// the order is by start time, so the expired groups come first
+	for len(r.order) > 0 {
+		g := r.groups[r.order[0]]
+		if now.Sub(g.started) < r.cfg.Timeout {
+			return
+		}
+		r.evict(g, "timeout")
+	}
+}
+
+// evictOverflow evicts the oldest groups until the limits hold, the group currently added to is kept
+func (r *Reassembler) evictOverflow(current string) {
+	for _, id := range append([]string(nil), r.order...) {
+		groupsOk := r.cfg.MaxGroups <= 0 || len(r.groups) <= r.cfg.MaxGroups
+		bytesOk := r.cfg.MaxBytes <= 0 || r.size <= r.cfg.MaxBytes
+		if groupsOk && bytesOk {
+			return
+		}
+		if id != current {
+			r.evict(r.groups[id], "buffer full")
+		}
+	}
+}
+
+func (r *Reassembler) evict(g *chunkGroup, reason string) {
+	slog.Warn("evicting chunk group", "group", g.id, "reason", reason, "chunks", len(g.parts), "total", g.total, "bytes", g.size, "complete", g.complete)
+	r.remove(g.id)
+}
+
+func (r *Reassembler) remove(id string) {
+	g, ok := r.groups[id]
+	if !ok {
+		return
+	}
+	r.size -= g.size
+	delete(r.groups, id)
+	for i, o := range r.order {
+		if o == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitChunks splits the encoded payload into chunk envelopes
+func splitChunks(t *testing.T, group string, data string, n int) []string {
+	t.Helper()
+	size := (len(data) + n - 1) / n
+	var envelopes []string
+	for i := 0; i < n; i++ {
+		end := min((i+1)*size, len(data))
+		b, err := json.Marshal(map[string]any{
+			"message_id":   group,
+			"chunk_index":  i,
+			"total_chunks": n,
+			"data":         data[i*size : end],
+		})
+		require.NoError(t, err)
+		envelopes = append(envelopes, string(b))
+	}
+	return envelopes
+}
+
+func TestParseChunk(t *testing.T) {
+	c, err := ParseChunk(`{"chunk_index": 1, "total_chunks": 3, "message_id": "snap-1", "data": "abc"}`)
+	require.NoError(t, err)
+	assert.Equal(t, Chunk{GroupID: "snap-1", Index: 1, Total: 3, Data: "abc"}, c)
+
+	c, err = ParseChunk(`{"chunkIndex": 0, "totalChunks": 2, "groupId": "snap-2", "payload": "xyz"}`)
+	require.NoError(t, err)
+	assert.Equal(t, Chunk{GroupID: "snap-2", Index: 0, Total: 2, Data: "xyz"}, c)
+
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"no group id", `{"chunk_index": 0, "total_chunks": 3, "data": "..."}`},
+		{"no index", `{"total_chunks": 2, "message_id": "a", "data": "abc"}`},
+		{"index out of range", `{"chunk_index": 2, "total_chunks": 2, "message_id": "a"}`},
+		{"not json", `{"chunk_index": 0,`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseChunk(tc.raw)
+			assert.ErrorIs(t, err, ErrInvalidChunk)
+		})
+	}
+}
+
+func TestReassembler_OutOfOrder(t *testing.T) {
+	r := NewReassembler(ChunkConfig{})
+
+	for _, i := range []int{2, 0, 0} { // the first chunk is redelivered
+		_, complete, err := r.Add(Chunk{GroupID: "a", Index: i, Total: 3, Data: fmt.Sprint(i)})
+		require.NoError(t, err)
+		assert.False(t, complete)
+	}
+	// interleaved with another group
+	_, complete, err := r.Add(Chunk{GroupID: "b", Index: 0, Total: 2, Data: "x"})
+	require.NoError(t, err)
+	assert.False(t, complete)
+
+	data, complete, err := r.Add(Chunk{GroupID: "a", Index: 1, Total: 3, Data: "1"})
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, "012", data)
+	assert.Equal(t, 1, r.Pending())
+}
+
+func TestReassembler_TotalMismatch(t *testing.T) {
+	r := NewReassembler(ChunkConfig{})
+	_, _, err := r.Add(Chunk{GroupID: "a", Index: 0, Total: 3})
+	require.NoError(t, err)
+	_, _, err = r.Add(Chunk{GroupID: "a", Index: 1, Total: 4})
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+	assert.Equal(t, 0, r.Pending())
+}
+
+func TestReassembler_Eviction(t *testing.T) {
+	now := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	r := NewReassembler(ChunkConfig{
+		Timeout:   time.Minute,
+		MaxGroups: 2,
+		MaxBytes:  10,
+		Now:       func() time.Time { return now },
+	})
+
+	add := func(group string, index int, data string) error {
+		_, _, err := r.Add(Chunk{GroupID: group, Index: index, Total: 2, Data: data})
+		return err
+	}
+
+	// timeout
+	require.NoError(t, add("a", 0, "aa"))
+	now = now.Add(time.Minute)
+	require.NoError(t, add("b", 0, "bb"))
+	assert.Equal(t, 1, r.Pending())
+	_, complete, err := r.Add(Chunk{GroupID: "a", Index: 1, Total: 2, Data: "aa"})
+	require.NoError(t, err)
+	assert.False(t, complete, "the first chunk of a was evicted")
+
+	// too many groups, the oldest is evicted
+	require.NoError(t, add("c", 0, "cc"))
+	assert.Equal(t, 2, r.Pending())
+	data, complete, err := r.Add(Chunk{GroupID: "c", Index: 1, Total: 2, Data: "CC"})
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, "ccCC", data)
+
+	// too many bytes, the oldest is evicted
+	require.NoError(t, add("d", 0, "ddddddddd"))
+	assert.Equal(t, 1, r.Pending())
+
+	// a group larger than the buffer is dropped
+	err = add("e", 0, "eeeeeeeeeee")
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+	assert.Equal(t, 0, r.Pending())
+}
+
+func TestMultiFormatMiddleware_Chunked(t *testing.T) {
+	root := sampleRoot()
+	var received []*rdb.Raw[Root]
+	h := MultiFormatMiddleware(NewReassembler(ChunkConfig{}), func(ctx context.Context, r *rdb.Raw[Root]) error {
+		received = append(received, r)
+		return nil
+	})
+
+	t0 := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for name, encoded := range map[string]string{
+		"plain":       encodePlainJSON(t, root),
+		"gzip+base64": encodeGzipBase64JSON(t, root),
+	} {
+		t.Run(name, func(t *testing.T) {
+			received = nil
+			chunks := splitChunks(t, "snapshot-"+name, encoded, 3)
+			for i, c := range []string{chunks[1], chunks[2], chunks[0]} {
+				err := h(context.TODO(), &rdb.Raw[string]{Provider: "parking-ch", Timestamp: t0.Add(time.Duration(i) * time.Second), Rawdata: c})
+				require.NoError(t, err)
+			}
+			require.Len(t, received, 1)
+			assert.Equal(t, "parking-ch", received[0].Provider)
+			assert.Equal(t, t0.Add(2*time.Second), received[0].Timestamp)
+			assert.Equal(t, []string{"bike-1", "car-1"}, []string{received[0].Rawdata.Features[0].ID, received[0].Rawdata.Features[1].ID})
+		})
+	}
+
+	// unchunked payloads pass through
+	received = nil
+	require.NoError(t, h(context.TODO(), &rdb.Raw[string]{Rawdata: encodeBase64JSON(t, root)}))
+	require.Len(t, received, 1)
+
+	// without a reassembler, chunks are rejected
+	h = MultiFormatMiddleware[Root](nil, func(ctx context.Context, r *rdb.Raw[Root]) error { return nil })
+	err := h(context.TODO(), &rdb.Raw[string]{Rawdata: splitChunks(t, "x", "{}", 2)[0]})
+	assert.True(t, errors.Is(err, ErrChunkedPayload), "expected ErrChunkedPayload, got: %v", err)
+}
+
+func TestMultiFormatMiddleware_HandlerError(t *testing.T) {
+	root := sampleRoot()
+	chunks := NewReassembler(ChunkConfig{})
+	var received []*rdb.Raw[Root]
+	fail := true
+	h := MultiFormatMiddleware(chunks, func(ctx context.Context, r *rdb.Raw[Root]) error {
+		if fail {
+			fail = false
+			return errors.New("database unavailable")
+		}
+		received = append(received, r)
+		return nil
+	})
+
+	parts := splitChunks(t, "snapshot", encodePlainJSON(t, root), 3)
+	require.NoError(t, h(context.TODO(), &rdb.Raw[string]{Rawdata: parts[0]}))
+	require.NoError(t, h(context.TODO(), &rdb.Raw[string]{Rawdata: parts[1]}))
+	require.Error(t, h(context.TODO(), &rdb.Raw[string]{Rawdata: parts[2]}))
+	assert.Empty(t, received)
+
+	// the failed last chunk is redelivered, and the group completes again
+	require.NoError(t, h(context.TODO(), &rdb.Raw[string]{Rawdata: parts[2]}))
+	require.Len(t, received, 1)
+	assert.Equal(t, []string{"bike-1", "car-1"}, []string{received[0].Rawdata.Features[0].ID, received[0].Rawdata.Features[1].ID})
+
+	// the handled group is released
+	chunks.mu.Lock()
+	assert.Empty(t, chunks.groups)
+	assert.Zero(t, chunks.size)
+	chunks.mu.Unlock()
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// unmarshalCSV decodes CSV into
+//   - *[][]string: all records, including the header
+//   - *[]map[string]string: a map per record, keyed by the header
+//   - *[]T or *[]*T of a struct T: a struct per record, the header is matched to the field by its csv tag
+//     or case insensitive name, `csv:"-"` skips a field. Empty values leave the field zero.
+//     encoding.TextUnmarshaler fields like time.Time (RFC 3339) are supported
+//
+// The delimiter is the most frequent of comma, semicolon and tab in the header line
+func unmarshalCSV(data []byte, v any) error {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = sniffDelimiter(data)
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return fmt.Errorf("csv: %w", err)
+	}
+
+	switch t := v.(type) {
+	case *[][]string:
+		*t = records
+		return nil
+	case *[]map[string]string:
+		*t = nil
+		if len(records) == 0 {
+			return nil
+		}
+		for _, rec := range records[1:] {
+			m := make(map[string]string, len(rec))
+			for i, h := range records[0] {
+				m[h] = rec[i]
+			}
+			*t = append(*t, m)
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("csv: unsupported target %T", v)
+	}
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: unsupported target %T", v)
+	}
+
+	slice.SetLen(0)
+	if len(records) == 0 {
+		return nil
+	}
+	fields := csvFields(structType, records[0])
+	for line, rec := range records[1:] {
+		elem := reflect.New(structType).Elem()
+		for i, f := range fields {
+			if f == nil || rec[i] == "" {
+				continue
+			}
+			if err := setCSVField(elem.FieldByIndex(f), rec[i]); err != nil {
+				return fmt.Errorf("csv: line %d, column %s: %w", line+2, records[0][i], err)
+			}
+		}
+		if elemType.Kind() == reflect.Pointer {
+			elem = elem.Addr()
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	return nil
+}
+
+func sniffDelimiter(data []byte) rune {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	delimiter, count := ',', bytes.Count(header, []byte(","))
+	for _, d := range []rune{';', '\t'} {
+		if c := bytes.Count(header, []byte(string(d))); c > count {
+			delimiter, count = d, c
+		}
+	}
+	return delimiter
+}
+
+// csvFields maps the header columns to the field indexes of t, nil for unmapped columns
+func csvFields(t reflect.Type, header []string) [][]int {
+	byName := map[string][]int{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Tag.Get("csv")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		byName[strings.ToLower(name)] = f.Index
+	}
+	fields := make([][]int, len(header))
+	for i, h := range header {
+		fields[i] = byName[strings.ToLower(strings.TrimSpace(h))]
+	}
+	return fields
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+func setCSVField(f reflect.Value, s string) error {
+	if f.Kind() == reflect.Pointer {
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+	if f.Addr().Type().Implements(textUnmarshalerType) {
+		return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		// decimal commas are common with semicolon delimited files
+		x, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package payload decodes the raw payloads of the transformers into typed payloads.
+// Collectors deliver JSON, XML, CSV or protobuf, wrapped in base64, gzip or zstd, split large payloads
+// into chunk envelopes and wrap files and MQTT messages into envelopes. Middleware decodes the payload
+// guided by the content type of the raw data before forwarding it to the typed handler:
+//
+//	listener := tr.NewTr[any](ctx, env.Env)
+//	listener.Start(ctx, payload.Middleware(payload.NewDecoderFromEnv(env.DecoderEnv), handler))
+//
+// Envelopes are decoded by using them as payload type, e.g. payload.File[[]Row] for a CSV file
+// published by the sftp-server collector, or payload.Message[Measurement] for a MQTT message.
+//
+// MultiFormatMiddleware is the string handler variant for JSON payloads.
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrChunkedPayload is returned when the payload is a chunk envelope, which has to be reassembled
+// before decoding, see Reassembler.
+var ErrChunkedPayload = errors.New("chunked payload must be reassembled before decoding")
+
+// Decode decodes a raw string payload holding JSON into the target type P.
+// The JSON may be wrapped in base64, gzip+base64 or any other wrapper Unmarshal removes.
+// If the payload looks like a chunked envelope, it returns ErrChunkedPayload.
+func Decode[P any](raw string) (P, error) {
+	v, err := Unmarshal[P](nil, []byte(raw), ContentTypeJSON)
+	if err != nil && !errors.Is(err, ErrChunkedPayload) {
+		return v, fmt.Errorf("unable to decode payload (len=%d): %w", len(raw), err)
+	}
+	return v, err
+}
+
+// IsChunkEnvelope checks whether the payload looks like a chunk envelope
+// by inspecting the first bytes for common chunk metadata field names.
+// This is a lightweight heuristic to avoid full JSON parsing on every message.
+func IsChunkEnvelope(raw string) bool {
+	trimmed := strings.TrimSpace(raw)
+	if !strings.HasPrefix(trimmed, "{") {
+		return false
+	}
+	// Inspect only the beginning of the payload for chunk-related keys
+	peek := trimmed
+	if len(peek) > 512 {
+		peek = peek[:512]
+	}
+	return strings.Contains(peek, `"chunk_index"`) ||
+		strings.Contains(peek, `"total_chunks"`) ||
+		strings.Contains(peek, `"chunkIndex"`) ||
+		strings.Contains(peek, `"totalChunks"`)
+}
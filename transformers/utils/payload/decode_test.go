@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Root is a typed payload, the parking-ch feature collection
+type Root struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// sampleRoot returns a minimal GeoJSON feature collection for testing.
+func sampleRoot() Root {
+	point := json.RawMessage(`{"type": "GeometryCollection", "geometries": [{"type": "Point", "coordinates": [7.4474, 46.948]}]}`)
+
+	return Root{
+		Type: "FeatureCollection",
+		Features: []Feature{
+			{
+				Type:     "Feature",
+				ID:       "bike-1",
+				Geometry: point,
+				Properties: map[string]interface{}{
+					"parkingFacilityCategory": "BIKE",
+					"uic":                     8507000,
+					"displayName":             "Bern Bahnhof",
+				},
+			},
+			{
+				Type:     "Feature",
+				ID:       "car-1",
+				Geometry: point,
+				Properties: map[string]interface{}{
+					"parkingFacilityCategory":        "CAR",
+					"didokId":                        "123456",
+					"displayName":                    "Bern P+R",
+					"currentEstimatedOccupancy":      45.5,
+					"currentEstimatedOccupancyLevel": "MEDIUM",
+				},
+			},
+		},
+	}
+}
+
+// encodePlainJSON returns the raw JSON string of the given value.
+func encodePlainJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(b)
+}
+
+// encodeBase64JSON returns base64(JSON) encoding of the given value.
+func encodeBase64JSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// encodeGzipBase64JSON returns base64(gzip(JSON)) encoding of the given value.
+func encodeGzipBase64JSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestPayloadDecode_PlainJSON(t *testing.T) {
+	root := sampleRoot()
+	raw := encodePlainJSON(t, root)
+
+	decoded, err := Decode[Root](raw)
+	require.NoError(t, err)
+	assert.Equal(t, len(root.Features), len(decoded.Features))
+	assert.Equal(t, "bike-1", decoded.Features[0].ID)
+	assert.Equal(t, "car-1", decoded.Features[1].ID)
+}
+
+func TestPayloadDecode_Base64JSON(t *testing.T) {
+	root := sampleRoot()
+	raw := encodeBase64JSON(t, root)
+
+	decoded, err := Decode[Root](raw)
+	require.NoError(t, err)
+	assert.Equal(t, len(root.Features), len(decoded.Features))
+	assert.Equal(t, "bike-1", decoded.Features[0].ID)
+	assert.Equal(t, "car-1", decoded.Features[1].ID)
+}
+
+func TestPayloadDecode_GzipBase64JSON(t *testing.T) {
+	root := sampleRoot()
+	raw := encodeGzipBase64JSON(t, root)
+
+	decoded, err := Decode[Root](raw)
+	require.NoError(t, err)
+	assert.Equal(t, len(root.Features), len(decoded.Features))
+	assert.Equal(t, "bike-1", decoded.Features[0].ID)
+	assert.Equal(t, "car-1", decoded.Features[1].ID)
+}
+
+func TestPayloadDecode_InvalidPayload(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"empty string", ""},
+		{"random text", "this is not json or base64"},
+		{"truncated JSON", `{"bike_parking": {`},
+		{"invalid base64", "!!!not-base64!!!"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode[Root](tc.raw)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unable to decode payload")
+		})
+	}
+}
+
+func TestPayloadDecode_ChunkedEnvelopeRejected(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"chunk_index field", `{"chunk_index": 0, "total_chunks": 3, "data": "..."}`},
+		{"chunkIndex field", `{"chunkIndex": 1, "totalChunks": 5, "payload": "..."}`},
+		{"total_chunks only", `{"total_chunks": 2, "data": "abc"}`},
+		{"totalChunks only", `{"totalChunks": 4, "payload": "xyz"}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode[Root](tc.raw)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrChunkedPayload),
+				"expected ErrChunkedPayload, got: %v", err)
+		})
+	}
+}
+
+func TestPayloadDecode_AllFormatsProduceSameResult(t *testing.T) {
+	root := sampleRoot()
+
+	plain := encodePlainJSON(t, root)
+	b64 := encodeBase64JSON(t, root)
+	gz64 := encodeGzipBase64JSON(t, root)
+
+	dPlain, err := Decode[Root](plain)
+	require.NoError(t, err)
+
+	dB64, err := Decode[Root](b64)
+	require.NoError(t, err)
+
+	dGz64, err := Decode[Root](gz64)
+	require.NoError(t, err)
+
+	// Re-serialize all three results to compare them cleanly
+	// (avoids issues with floating-point map ordering)
+	jsonPlain, _ := json.Marshal(dPlain)
+	jsonB64, _ := json.Marshal(dB64)
+	jsonGz64, _ := json.Marshal(dGz64)
+
+	assert.JSONEq(t, string(jsonPlain), string(jsonB64), "base64 decode differs from plain")
+	assert.JSONEq(t, string(jsonPlain), string(jsonGz64), "gzip+base64 decode differs from plain")
+}
+
+func TestIsChunkEnvelope(t *testing.T) {
+	assert.False(t, IsChunkEnvelope(`{"bike_parking": {}}`))
+	assert.False(t, IsChunkEnvelope(`not json at all`))
+	assert.False(t, IsChunkEnvelope(`["array", "not", "object"]`))
+	assert.True(t, IsChunkEnvelope(`{"chunk_index": 0, "data": "..."}`))
+	assert.True(t, IsChunkEnvelope(`{"chunkIndex": 0, "data": "..."}`))
+	assert.True(t, IsChunkEnvelope(`{"total_chunks": 3}`))
+	assert.True(t, IsChunkEnvelope(`{"totalChunks": 3}`))
+}
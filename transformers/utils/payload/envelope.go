@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+// File is the envelope of the files published by the sftp-server and s3-poller collectors,
+// with the file content decoded into Content. The content type of the content is the one of the
+// s3 object or else the one of the file extension.
+// Files published in chunks are reassembled, files published by reference are read from the RefDir of the Decoder
+type File[P any] struct {
+	// sftp-server
+	Filename string
+	Dir      string
+	Mtime    time.Time
+	Archive  string
+
+	// s3-poller
+	Bucket       string
+	Key          string
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+
+	Size    int64
+	Content P
+}
+
+type fileChunk struct {
+	Index int
+	Count int
+}
+
+type fileEnvelope struct {
+	Filename     string
+	Dir          string
+	Mtime        time.Time
+	Archive      string
+	Bucket       string
+	Key          string
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+	Size         int64
+	File         []byte
+	Chunk        *fileChunk
+	Ref          string
+}
+
+func (f *File[P]) unmarshalEnvelope(d *Decoder, data []byte) error {
+	var e fileEnvelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("file envelope: %w", err)
+	}
+	*f = File[P]{
+		Filename:     e.Filename,
+		Dir:          e.Dir,
+		Mtime:        e.Mtime,
+		Archive:      e.Archive,
+		Bucket:       e.Bucket,
+		Key:          e.Key,
+		ETag:         e.ETag,
+		LastModified: e.LastModified,
+		ContentType:  e.ContentType,
+		Metadata:     e.Metadata,
+		Size:         e.Size,
+	}
+
+	content := e.File
+	switch {
+	case e.Chunk != nil:
+		if d == nil || d.Chunks == nil {
+			return ErrChunkedPayload
+		}
+		// all chunks of a file version share the name and modification time
+		group := "file:" + path.Join(e.Dir, e.Filename) + "@" + e.Mtime.Format(time.RFC3339Nano)
+		reassembled, complete, err := d.Chunks.Add(Chunk{
+			GroupID: group,
+			Index:   e.Chunk.Index,
+			Total:   e.Chunk.Count,
+			Data:    string(e.File),
+		})
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return errPending
+		}
+		d.completeGroup(group)
+		content = []byte(reassembled)
+	case e.Ref != "":
+		b, err := d.readRef(e.Ref)
+		if err != nil {
+			return err
+		}
+		content = b
+	}
+
+	ct := mediaType(e.ContentType)
+	if ct == "" || ct == "application/octet-stream" || ct == "binary/octet-stream" {
+		ct = contentTypeByName(e.Filename + e.Key)
+	}
+	if err := d.decode(content, ct, &f.Content); err != nil {
+		return fmt.Errorf("file %s: %w", path.Join(e.Dir, e.Filename)+e.Key, err)
+	}
+	return nil
+}
+
+// Message is the envelope of the MQTT messages published by the mqtt-client collector,
+// with the payload decoded into Payload. MQTT v5 messages may carry a content type
+type Message[P any] struct {
+	MsgId          uint16
+	Topic          string
+	QoS            byte
+	Retained       bool
+	ContentType    string
+	UserProperties []UserProperty
+	Payload        P
+}
+
+// UserProperty is a MQTT v5 user property
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+func (m *Message[P]) unmarshalEnvelope(d *Decoder, data []byte) error {
+	var e struct {
+		MsgId          uint16
+		Topic          string
+		Payload        string
+		QoS            byte
+		Retained       bool
+		ContentType    string
+		UserProperties []UserProperty
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("mqtt envelope: %w", err)
+	}
+	*m = Message[P]{
+		MsgId:          e.MsgId,
+		Topic:          e.Topic,
+		QoS:            e.QoS,
+		Retained:       e.Retained,
+		ContentType:    e.ContentType,
+		UserProperties: e.UserProperties,
+	}
+	if err := d.decode([]byte(e.Payload), e.ContentType, &m.Payload); err != nil {
+		return fmt.Errorf("mqtt message %d on %s: %w", e.MsgId, e.Topic, err)
+	}
+	return nil
+}
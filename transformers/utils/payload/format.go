@@ -0,0 +1,347 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types understood by the Decoder. Types with a +json or +xml suffix are decoded as JSON or XML
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeXML      = "application/xml"
+	ContentTypeCSV      = "text/csv"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeGzip     = "application/gzip"
+	ContentTypeZstd     = "application/zstd"
+	ContentTypeBase64   = "application/base64"
+)
+
+// errPending is returned while a chunked payload is incomplete
+var errPending = errors.New("payload chunk buffered")
+
+// maxUnwrap bounds the nesting of base64 and compression wrappers
+const maxUnwrap = 4
+
+// DecoderEnv configures the Decoder of a transformer, embed it into the env
+type DecoderEnv struct {
+	ChunkEnv
+	// PAYLOAD_CONTENT_TYPE is assumed for raw data without content type, empty detects the format
+	PAYLOAD_CONTENT_TYPE string
+	// PAYLOAD_REF_DIR is the volume shared with the collector publishing files by reference
+	PAYLOAD_REF_DIR string
+}
+
+// Decoder decodes raw payloads into typed payloads, see Unmarshal
+type Decoder struct {
+	// ContentType is assumed for raw data without content type, empty detects the format
+	ContentType string
+	// Chunks reassembles chunk envelopes and files published in chunks, with nil they are rejected
+	Chunks *Reassembler
+	// RefDir is the only directory files published by reference are read from, with empty they are rejected
+	RefDir string
+
+	// completed collects the chunk groups completed by a single decode, see unmarshalChunked
+	completed *[]string
+}
+
+func NewDecoderFromEnv(env DecoderEnv) *Decoder {
+	return &Decoder{
+		ContentType: env.PAYLOAD_CONTENT_TYPE,
+		Chunks:      NewReassemblerFromEnv(env.ChunkEnv),
+		RefDir:      env.PAYLOAD_REF_DIR,
+	}
+}
+
+// Unmarshal decodes data into P. The content type is a hint, without it the format is detected:
+//   - base64, gzip and zstd wrappers are removed first, gzip and zstd are detected by their magic bytes,
+//     base64 only if the data doesn't look like JSON or XML
+//   - chunk envelopes are reassembled, Unmarshal returns errPending until the group is complete
+//   - File and Message envelopes decode their content with the content type of the envelope
+//   - JSON, XML, CSV (see unmarshalCSV) and protobuf (P or *P implementing proto.Message) are decoded
+//   - string and []byte get the unwrapped data as is
+//
+// A nil Decoder rejects chunks and files published by reference. The chunk groups are released once
+// complete, the middlewares keep them until the handler succeeded
+func Unmarshal[P any](d *Decoder, data []byte, contentType string) (P, error) {
+	v, groups, err := unmarshalChunked[P](d, data, contentType)
+	d.release(groups)
+	return v, err
+}
+
+// unmarshalChunked is Unmarshal, which returns the chunk groups it completed instead of releasing them
+func unmarshalChunked[P any](d *Decoder, data []byte, contentType string) (P, []string, error) {
+	var v P
+	if d == nil {
+		return v, nil, d.decode(data, contentType, &v)
+	}
+	var groups []string
+	call := *d
+	call.completed = &groups
+	err := call.decode(data, contentType, &v)
+	return v, groups, err
+}
+
+// completeGroup records a chunk group completed by the current decode
+func (d *Decoder) completeGroup(id string) {
+	if d.completed != nil {
+		*d.completed = append(*d.completed, id)
+	}
+}
+
+func (d *Decoder) release(groups []string) {
+	for _, id := range groups {
+		d.Chunks.Release(id)
+	}
+}
+
+// envelope is implemented by the envelope types, which decode their content themselves
+type envelope interface {
+	unmarshalEnvelope(d *Decoder, data []byte) error
+}
+
+func (d *Decoder) decode(data []byte, contentType string, v any) error {
+	if contentType == "" && d != nil {
+		contentType = d.ContentType
+	}
+	data, ct, err := d.unwrap(data, mediaType(contentType))
+	if err != nil {
+		return err
+	}
+
+	switch t := v.(type) {
+	case envelope:
+		return t.unmarshalEnvelope(d, data)
+	case *[]byte:
+		*t = data
+		return nil
+	case *string:
+		*t = string(data)
+		return nil
+	}
+
+	if ct == "" || ct == "text/plain" || ct == "application/octet-stream" {
+		ct = sniff(data, v)
+	}
+	switch {
+	case isJSON(ct):
+		return json.Unmarshal(data, v)
+	case isXML(ct):
+		return xml.Unmarshal(data, v)
+	case ct == ContentTypeCSV || ct == "application/csv":
+		return unmarshalCSV(data, v)
+	case ct == ContentTypeProtobuf || ct == "application/protobuf" || ct == "application/vnd.google.protobuf":
+		m, ok := protoTarget(v)
+		if !ok {
+			return fmt.Errorf("protobuf payload needs a proto.Message target, got %T", v)
+		}
+		return proto.Unmarshal(data, m)
+	case ct == "":
+		return fmt.Errorf("unable to detect the payload format (len=%d)", len(data))
+	}
+	return fmt.Errorf("unsupported content type %s", ct)
+}
+
+// unwrap removes the chunk, base64 and compression wrappers. The content type is kept
+// unless it describes the removed wrapper
+func (d *Decoder) unwrap(data []byte, ct string) ([]byte, string, error) {
+	for range maxUnwrap {
+		switch {
+		case IsChunkEnvelope(string(data)):
+			if d == nil || d.Chunks == nil {
+				return nil, ct, ErrChunkedPayload
+			}
+			c, err := ParseChunk(string(data))
+			if err != nil {
+				return nil, ct, err
+			}
+			reassembled, complete, err := d.Chunks.Add(c)
+			if err != nil {
+				return nil, ct, err
+			}
+			if !complete {
+				return nil, ct, errPending
+			}
+			d.completeGroup(c.GroupID)
+			data = []byte(reassembled)
+		case bytes.HasPrefix(data, gzipMagic) || ct == ContentTypeGzip || ct == "application/x-gzip":
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, ct, fmt.Errorf("gzip: %w", err)
+			}
+			data, err = io.ReadAll(r)
+			if err != nil {
+				return nil, ct, fmt.Errorf("gzip: %w", err)
+			}
+		case bytes.HasPrefix(data, zstdMagic) || ct == ContentTypeZstd:
+			dec, err := zstdDecoder()
+			if err != nil {
+				return nil, ct, err
+			}
+			data, err = dec.DecodeAll(data, nil)
+			if err != nil {
+				return nil, ct, fmt.Errorf("zstd: %w", err)
+			}
+		case ct == ContentTypeBase64 || maybeBase64(data, ct):
+			decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+			if err != nil {
+				return nil, ct, fmt.Errorf("base64: %w", err)
+			}
+			data = decoded
+		default:
+			return data, ct, nil
+		}
+		if isWrapper(ct) {
+			ct = ""
+		}
+	}
+	return nil, ct, fmt.Errorf("payload nested in more than %d wrappers", maxUnwrap)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// zstdDecoder is shared, DecodeAll is safe for concurrent use
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+})
+
+func isWrapper(ct string) bool {
+	switch ct {
+	case ContentTypeGzip, "application/x-gzip", ContentTypeZstd, ContentTypeBase64:
+		return true
+	}
+	return false
+}
+
+// maybeBase64 reports whether text data, which doesn't look like JSON or XML, is valid base64.
+// Binary and CSV payloads are never taken for base64 unless their content type says so
+func maybeBase64(data []byte, ct string) bool {
+	if ct != "" && !isJSON(ct) && !isXML(ct) {
+		return false
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || len(trimmed)%4 != 0 || looksStructured(trimmed) {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(string(trimmed))
+	return err == nil
+}
+
+// looksStructured reports whether data is valid JSON or starts like XML
+func looksStructured(data []byte) bool {
+	return json.Valid(data) || data[0] == '<'
+}
+
+// sniff detects the format of data without content type
+func sniff(data []byte, v any) string {
+	if _, ok := protoTarget(v); ok {
+		return ContentTypeProtobuf
+	}
+	trimmed := bytes.TrimLeft(data, " \t\r\n\uFEFF")
+	if len(trimmed) == 0 {
+		return ""
+	}
+	switch trimmed[0] {
+	case '{', '[', '"':
+		return ContentTypeJSON
+	case '<':
+		return ContentTypeXML
+	}
+	return ""
+}
+
+// mediaType strips the parameters and normalizes the case of a content type
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+		mt = strings.ToLower(strings.TrimSpace(mt))
+	}
+	return mt
+}
+
+func isJSON(ct string) bool {
+	return ct == ContentTypeJSON || ct == "text/json" || strings.HasSuffix(ct, "+json")
+}
+
+func isXML(ct string) bool {
+	return ct == ContentTypeXML || ct == "text/xml" || strings.HasSuffix(ct, "+xml")
+}
+
+// protoTarget returns the message to unmarshal into, for targets *P and **P where *P is a proto.Message
+func protoTarget(v any) (proto.Message, bool) {
+	if m, ok := v.(proto.Message); ok {
+		return m, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		return nil, false
+	}
+	elem := rv.Elem()
+	if !elem.Type().Implements(reflect.TypeFor[proto.Message]()) {
+		return nil, false
+	}
+	if elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+	return elem.Interface().(proto.Message), true
+}
+
+// contentTypeByName maps the extension of a file name to its content type.
+// Compression extensions are skipped, the magic bytes identify the compression
+func contentTypeByName(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		return contentTypeByName(strings.TrimSuffix(name, filepath.Ext(name)))
+	case ".json", ".geojson":
+		return ContentTypeJSON
+	case ".xml":
+		return ContentTypeXML
+	case ".csv":
+		return ContentTypeCSV
+	case ".pb", ".proto", ".protobuf":
+		return ContentTypeProtobuf
+	case ".b64", ".base64":
+		return ContentTypeBase64
+	case "":
+		return ""
+	}
+	return mediaType(mime.TypeByExtension(ext))
+}
+
+// readRef reads a file published by reference, which has to be inside RefDir
+func (d *Decoder) readRef(ref string) ([]byte, error) {
+	if d == nil || d.RefDir == "" {
+		return nil, fmt.Errorf("file %s published by reference, but no reference directory is configured", ref)
+	}
+	rel, err := filepath.Rel(d.RefDir, filepath.Clean(ref))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return nil, fmt.Errorf("file %s is outside of the reference directory %s", ref, d.RefDir)
+	}
+	return os.ReadFile(filepath.Join(d.RefDir, rel))
+}
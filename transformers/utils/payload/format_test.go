@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: 2026 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type measurement struct {
+	Station string    `csv:"station_id" xml:"station,attr"`
+	Value   float64   `csv:"value" xml:"value"`
+	Time    time.Time `csv:"ts" xml:"ts"`
+	Valid   *bool
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer w.Close()
+	return w.EncodeAll(data, nil)
+}
+
+func b64(data []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
+
+func TestUnmarshal_Formats(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	valid := true
+	want := []measurement{{Station: "a", Value: 1.5, Time: ts, Valid: &valid}, {Station: "b", Value: 2, Time: ts}}
+
+	jsonData, err := json.Marshal(want)
+	require.NoError(t, err)
+	csvData := []byte("\uFEFFstation_id;value;ts;valid\na;1,5;2026-03-01T10:00:00Z;true\nb;2;2026-03-01T10:00:00Z;\n")
+
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"json", jsonData, "application/json; charset=utf-8"},
+		{"json detected", jsonData, ""},
+		{"base64 json", b64(jsonData), ContentTypeJSON},
+		{"gzip+base64 json", b64(gzipBytes(t, jsonData)), ""},
+		{"zstd json", zstdBytes(t, jsonData), ContentTypeJSON},
+		{"zstd+base64 json", b64(zstdBytes(t, jsonData)), ContentTypeBase64},
+		{"csv", csvData, "text/csv"},
+		{"gzip csv", gzipBytes(t, csvData), ContentTypeCSV},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Unmarshal[[]measurement](nil, tc.data, tc.contentType)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	t.Run("xml", func(t *testing.T) {
+		type doc struct {
+			Measurements []measurement `xml:"measurement"`
+		}
+		data := []byte(`<?xml version="1.0"?><doc><measurement station="a"><value>1.5</value><ts>2026-03-01T10:00:00Z</ts></measurement></doc>`)
+		for _, ct := range []string{"", "text/xml", "application/vnd.example+xml"} {
+			got, err := Unmarshal[doc](nil, data, ct)
+			require.NoError(t, err)
+			assert.Equal(t, []measurement{{Station: "a", Value: 1.5, Time: ts}}, got.Measurements)
+		}
+	})
+
+	t.Run("csv records", func(t *testing.T) {
+		rows, err := Unmarshal[[][]string](nil, []byte("a,b\n1,2\n"), ContentTypeCSV)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"a", "b"}, {"1", "2"}}, rows)
+
+		maps, err := Unmarshal[[]map[string]string](nil, []byte("a\tb\n1\t2\n"), ContentTypeCSV)
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]string{{"a": "1", "b": "2"}}, maps)
+
+		_, err = Unmarshal[[]measurement](nil, []byte("station_id,value\na,x\n"), ContentTypeCSV)
+		assert.ErrorContains(t, err, "line 2, column value")
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		data, err := proto.Marshal(wrapperspb.String("hello"))
+		require.NoError(t, err)
+
+		got, err := Unmarshal[*wrapperspb.StringValue](nil, data, "")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", got.GetValue())
+
+		got, err = Unmarshal[*wrapperspb.StringValue](nil, gzipBytes(t, data), ContentTypeProtobuf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", got.GetValue())
+
+		_, err = Unmarshal[[]measurement](nil, data, ContentTypeProtobuf)
+		assert.ErrorContains(t, err, "proto.Message")
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		got, err := Unmarshal[string](nil, gzipBytes(t, []byte("a,b")), "")
+		require.NoError(t, err)
+		assert.Equal(t, "a,b", got)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Unmarshal[[]measurement](nil, []byte("just text"), "")
+		assert.ErrorContains(t, err, "unable to detect")
+		_, err = Unmarshal[[]measurement](nil, jsonData, "application/pdf")
+		assert.ErrorContains(t, err, "unsupported content type")
+		_, err = Unmarshal[[]measurement](nil, []byte("not gzip"), ContentTypeGzip)
+		assert.ErrorContains(t, err, "gzip")
+	})
+}
+
+func TestUnmarshal_File(t *testing.T) {
+	csvData := []byte("station_id,value\na,1\n")
+	want := []measurement{{Station: "a", Value: 1}}
+	mtime := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	envelope := func(f map[string]any) []byte {
+		b, err := json.Marshal(f)
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("sftp", func(t *testing.T) {
+		data := envelope(map[string]any{"Filename": "m.csv.gz", "Dir": "in", "Mtime": mtime, "File": gzipBytes(t, csvData)})
+		got, err := Unmarshal[File[[]measurement]](nil, data, ContentTypeJSON)
+		require.NoError(t, err)
+		assert.Equal(t, "m.csv.gz", got.Filename)
+		assert.Equal(t, want, got.Content)
+	})
+
+	t.Run("s3", func(t *testing.T) {
+		data := envelope(map[string]any{"Bucket": "b", "Key": "export/m", "ContentType": "text/csv", "File": csvData})
+		got, err := Unmarshal[File[[]measurement]](nil, data, ContentTypeJSON)
+		require.NoError(t, err)
+		assert.Equal(t, "export/m", got.Key)
+		assert.Equal(t, want, got.Content)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		d := &Decoder{Chunks: NewReassembler(ChunkConfig{})}
+		parts := [][]byte{csvData[:10], csvData[10:]}
+		for i := range parts {
+			data := envelope(map[string]any{"Filename": "m.csv", "Mtime": mtime, "File": parts[1-i], "Chunk": map[string]any{"Index": 1 - i, "Count": 2}})
+			got, err := Unmarshal[File[[]measurement]](d, data, ContentTypeJSON)
+			if i == 0 {
+				assert.ErrorIs(t, err, errPending)
+				continue
+			}
+			require.NoError(t, err)
+			assert.Equal(t, want, got.Content)
+		}
+
+		_, err := Unmarshal[File[[]measurement]](nil, envelope(map[string]any{"Filename": "m.csv", "Chunk": map[string]any{"Index": 0, "Count": 2}}), "")
+		assert.ErrorIs(t, err, ErrChunkedPayload)
+	})
+
+	t.Run("reference", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "m.csv"), csvData, 0o644))
+		d := &Decoder{RefDir: dir}
+
+		got, err := Unmarshal[File[[]measurement]](d, envelope(map[string]any{"Filename": "m.csv", "Ref": filepath.Join(dir, "m.csv")}), "")
+		require.NoError(t, err)
+		assert.Equal(t, want, got.Content)
+
+		_, err = Unmarshal[File[[]measurement]](d, envelope(map[string]any{"Filename": "passwd", "Ref": filepath.Join(dir, "../../etc/passwd")}), "")
+		assert.ErrorContains(t, err, "outside of the reference directory")
+		_, err = Unmarshal[File[[]measurement]](nil, envelope(map[string]any{"Filename": "m.csv", "Ref": filepath.Join(dir, "m.csv")}), "")
+		assert.ErrorContains(t, err, "no reference directory")
+	})
+}
+
+func TestUnmarshal_Message(t *testing.T) {
+	data, err := json.Marshal(map[string]any{
+		"MsgId":   3,
+		"Topic":   "sensors/a",
+		"Payload": `{"station_id": "a", "value": 1}`,
+		"QoS":     1,
+	})
+	require.NoError(t, err)
+
+	got, err := Unmarshal[Message[measurement]](nil, data, ContentTypeJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "sensors/a", got.Topic)
+	assert.Equal(t, uint16(3), got.MsgId)
+	assert.Equal(t, measurement{Value: 1}, got.Payload, "json tags apply, not csv ones")
+
+	data, err = json.Marshal(map[string]any{"Topic": "sensors/a", "ContentType": "text/csv", "Payload": "station_id,value\na,1\n"})
+	require.NoError(t, err)
+	rows, err := Unmarshal[Message[[]measurement]](nil, data, ContentTypeJSON)
+	require.NoError(t, err)
+	assert.Equal(t, []measurement{{Station: "a", Value: 1}}, rows.Payload)
+}
+
+func TestMiddleware(t *testing.T) {
+	var received []*rdb.Raw[Message[measurement]]
+	h := Middleware(&Decoder{}, func(ctx context.Context, r *rdb.Raw[Message[measurement]]) error {
+		received = append(received, r)
+		return nil
+	})
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// structured raw data is a JSON document
+	rawdata := map[string]any{"MsgId": 1, "Topic": "sensors/a", "Payload": `{"Value": 2}`}
+	require.NoError(t, h(context.TODO(), &rdb.Raw[any]{Provider: "mqtt", Timestamp: t0, Rawdata: rawdata}))
+	// string raw data
+	require.NoError(t, h(context.TODO(), &rdb.Raw[any]{Provider: "mqtt", Timestamp: t0, Rawdata: `{"Topic": "sensors/b", "Payload": "{\"Value\": 3}"}`, ContentType: ContentTypeJSON}))
+
+	require.Len(t, received, 2)
+	assert.Equal(t, "mqtt", received[0].Provider)
+	assert.Equal(t, t0, received[0].Timestamp)
+	assert.Equal(t, "sensors/a", received[0].Rawdata.Topic)
+	assert.Equal(t, 2.0, received[0].Rawdata.Payload.Value)
+	assert.Equal(t, 3.0, received[1].Rawdata.Payload.Value)
+
+	err := h(context.TODO(), &rdb.Raw[any]{Rawdata: nil})
+	assert.Error(t, err)
+
+	// the handler error is returned as is
+	handlerErr := errors.New("push failed")
+	h = Middleware(nil, func(ctx context.Context, r *rdb.Raw[Message[measurement]]) error { return handlerErr })
+	assert.ErrorIs(t, h(context.TODO(), &rdb.Raw[any]{Rawdata: rawdata}), handlerErr)
+}
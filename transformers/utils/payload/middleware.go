@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package payload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/rdb"
+	"github.com/noi-techpark/opendatahub-go-sdk/ingest/tr"
+)
+
+// Middleware wraps a typed handler into a handler of raw data of any type, which decodes the raw data
+// with the content type of the raw data as hint, see Unmarshal.
+// Raw data that is not a string or []byte is a JSON document in the raw data table and is encoded as JSON again.
+//
+// Incomplete chunked payloads are acknowledged, the reassembled payload is forwarded with the timestamp of the last chunk.
+// The chunks are kept until the handler succeeded, so that a redelivered last chunk forwards the payload again.
+func Middleware[P any](d *Decoder, h tr.Handler[P]) tr.Handler[any] {
+	return func(ctx context.Context, raw *rdb.Raw[any]) error {
+		var data []byte
+		contentType := raw.ContentType
+		switch r := raw.Rawdata.(type) {
+		case string:
+			data = []byte(r)
+		case []byte:
+			data = r
+		case nil:
+			return fmt.Errorf("decode: empty raw data")
+		default:
+			b, err := json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("decode: %w", err)
+			}
+			data = b
+			if contentType == "" {
+				contentType = ContentTypeJSON
+			}
+		}
+		return forward(ctx, d, data, contentType, raw.Provider, raw.Timestamp, h)
+	}
+}
+
+// MultiFormatMiddleware wraps a typed handler into a string handler that
+// auto-detects and decodes the payload format before forwarding, see Decode.
+//
+// Chunk envelopes are buffered in chunks and acknowledged until their group is complete,
+// the reassembled payload is then decoded and forwarded with the timestamp of the last chunk.
+// The chunks are kept until the handler succeeded, so that a redelivered last chunk forwards the payload again.
+// With nil chunks, chunk envelopes are rejected with ErrChunkedPayload.
+func MultiFormatMiddleware[P any](chunks *Reassembler, h tr.Handler[P]) tr.Handler[string] {
+	d := &Decoder{ContentType: ContentTypeJSON, Chunks: chunks}
+	return func(ctx context.Context, raw *rdb.Raw[string]) error {
+		return forward(ctx, d, []byte(raw.Rawdata), raw.ContentType, raw.Provider, raw.Timestamp, h)
+	}
+}
+
+func forward[P any](ctx context.Context, d *Decoder, data []byte, contentType string, provider string, timestamp time.Time, h tr.Handler[P]) error {
+	decoded, groups, err := unmarshalChunked[P](d, data, contentType)
+	if errors.Is(err, errPending) {
+		d.release(groups)
+		slog.Debug("buffered payload chunk", "provider", provider, "timestamp", timestamp)
+		return nil
+	}
+	if err != nil {
+		// redelivering the chunks doesn't fix the payload
+		d.release(groups)
+		return fmt.Errorf("decode: %w", err)
+	}
+	if err := h(ctx, &rdb.Raw[P]{
+		Provider:  provider,
+		Timestamp: timestamp,
+		Rawdata:   decoded,
+	}); err != nil {
+		return err
+	}
+	d.release(groups)
+	return nil
+}